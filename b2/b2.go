@@ -29,14 +29,21 @@ package b2
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/kurin/blazer/internal/blog"
 )
 
 // Client is a Backblaze B2 client.
@@ -51,12 +58,14 @@ type Client struct {
 }
 
 // NewClient creates and returns a new Client with valid B2 service account
-// tokens.
-func NewClient(ctx context.Context, account, key string, opts ...ClientOption) (*Client, error) {
+// tokens.  The keyID is the ID of an application key, or the account ID when
+// authorizing with the account's master key.
+//
+// Operations that the key's capabilities do not permit, or that touch a bucket
+// other than the one the key is restricted to, fail with ErrCapabilityMissing
+// without contacting B2.
+func NewClient(ctx context.Context, keyID, applicationKey string, opts ...ClientOption) (*Client, error) {
 	c := &Client{
-		backend: &beRoot{
-			b2i: &b2Root{},
-		},
 		sMethods: []methodCounter{
 			newMethodCounter(time.Minute, time.Second),
 			newMethodCounter(time.Minute*5, time.Second),
@@ -68,12 +77,70 @@ func NewClient(ctx context.Context, account, key string, opts ...ClientOption) (
 	for _, f := range opts {
 		f(&c.opts)
 	}
-	if err := c.backend.authorizeAccount(ctx, account, key, c.opts); err != nil {
+	c.opts.transport = c.opts.tunedTransport()
+	c.opts.limiter = newRateLimiter(c.opts.rateLimit, c.opts.rateBurst, c.opts.clock)
+	c.backend = &beRoot{
+		b2i:    &b2Root{},
+		policy: c.opts.backoff,
+		clk:    c.opts.clock,
+	}
+	if err := c.backend.authorizeAccount(ctx, keyID, applicationKey, c.opts); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
+// S3Endpoint returns the URL of the account's S3-compatible API, such as
+// "https://s3.us-west-004.backblazeb2.com".  An S3 client can be pointed at it
+// with the same key ID and application key, as its access key ID and secret
+// access key, to reach the same buckets.
+func (c *Client) S3Endpoint() string {
+	return c.backend.s3Endpoint()
+}
+
+// MinPartSize returns the smallest size, in bytes, that B2 accepts for the
+// parts of a large file other than the last, as it reported on authorization.
+// Writer and copy part sizes below it are raised to it.
+func (c *Client) MinPartSize() int {
+	return c.backend.minPartSize()
+}
+
+// RecommendedPartSize returns the part size, in bytes, that B2 recommended on
+// authorization, currently 100MB.  It is the default ChunkSize of a Writer.
+func (c *Client) RecommendedPartSize() int {
+	return c.backend.recommendedPartSize()
+}
+
+// Allowance describes what the application key a client authorized with may
+// do, as B2 reports it.
+type Allowance struct {
+	// Capabilities lists the operations the key may perform, such as
+	// "listBuckets" or "writeFiles".
+	Capabilities []string
+
+	// BucketID and BucketName are set if the key is restricted to one bucket.
+	BucketID   string
+	BucketName string
+
+	// NamePrefix is set if the key is restricted to objects whose names begin
+	// with it.
+	NamePrefix string
+}
+
+// Allowed returns what the client's key may do.  B2 does not report the
+// account's caps or current usage through its API; those are only shown in
+// the web UI.  Requests refused because a cap was reached fail with an error
+// matching ErrCapExceeded.
+func (c *Client) Allowed() Allowance {
+	a := c.backend.allowed()
+	return Allowance{
+		Capabilities: append([]string(nil), a.caps...),
+		BucketID:     a.bucketID,
+		BucketName:   a.bucketName,
+		NamePrefix:   a.prefix,
+	}
+}
+
 type clientOptions struct {
 	client          *Client
 	transport       http.RoundTripper
@@ -81,8 +148,23 @@ type clientOptions struct {
 	expireTokens    bool
 	capExceeded     bool
 	apiBase         string
+	apiURL          string
 	userAgents      []string
 	writerOpts      []WriterOption
+	logger          Logger
+	backoff         Backoff
+	clock           clock
+	reqHooks        []func(*http.Request)
+	respHooks       []func(*http.Response)
+
+	// Connection tuning, for tunedTransport.
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	disableHTTP2        bool
+
+	rateLimit float64
+	rateBurst int
+	limiter   *rateLimiter // shared by every transport the client builds
 }
 
 // A ClientOption allows callers to adjust various per-client settings.
@@ -100,20 +182,145 @@ func UserAgent(agent string) ClientOption {
 }
 
 // APIBase returns a ClientOption specifying the URL root of API requests.
+// Authorization requests go to APIBase, and B2's reply names the URLs used for
+// everything else.  Together with ForceAPIURL, it lets a client be pointed at
+// a local server, such as one from net/http/httptest, in tests.
 func APIBase(url string) ClientOption {
 	return func(o *clientOptions) {
 		o.apiBase = url
 	}
 }
 
+// ForceAPIURL sends API requests made after authorization to url, instead of
+// to the URL B2 returns on authorization.  Uploads and downloads still use the
+// URLs B2 returns for them.
+func ForceAPIURL(url string) ClientOption {
+	return func(o *clientOptions) {
+		o.apiURL = url
+	}
+}
+
 // Transport sets the underlying HTTP transport mechanism.  If unset,
 // http.DefaultTransport is used.
+//
+// Every request the client makes goes through the transport, including
+// uploads and downloads sent to the URLs that B2 hands out, which makes it
+// suitable for proxies and instrumentation.  Request URLs are left as they are.
 func Transport(rt http.RoundTripper) ClientOption {
 	return func(c *clientOptions) {
 		c.transport = rt
 	}
 }
 
+// MaxIdleConnsPerHost sets the number of idle connections the client keeps
+// open to each host, for reuse.  Uploads go to the hosts of the upload URLs B2
+// hands out, and a Writer with many ConcurrentUploads, or many Writers, may
+// need more than the two that net/http keeps by default to avoid opening a
+// new connection for each request.
+//
+// MaxIdleConnsPerHost, MaxConnsPerHost, and DisableHTTP2 apply to a copy of
+// http.DefaultTransport, or of the transport given with Transport if it is an
+// *http.Transport; they are ignored for other transports, which must be tuned
+// by the caller.
+func MaxIdleConnsPerHost(n int) ClientOption {
+	return func(c *clientOptions) {
+		c.maxIdleConnsPerHost = n
+	}
+}
+
+// MaxConnsPerHost limits the number of connections the client opens to each
+// host, including those in use.  Requests beyond the limit wait for a
+// connection to be freed.  See MaxIdleConnsPerHost.
+func MaxConnsPerHost(n int) ClientOption {
+	return func(c *clientOptions) {
+		c.maxConnsPerHost = n
+	}
+}
+
+// DisableHTTP2 has the client use HTTP/1.1 only.  HTTP/2 carries every request
+// to a host over one connection, which for large uploads to a single host may
+// be slower than several HTTP/1.1 connections.  See MaxIdleConnsPerHost.
+func DisableHTTP2() ClientOption {
+	return func(c *clientOptions) {
+		c.disableHTTP2 = true
+	}
+}
+
+// tunedTransport returns the transport with the connection settings applied,
+// or the transport unchanged if there are none or it can't be tuned.
+func (c clientOptions) tunedTransport() http.RoundTripper {
+	if c.maxIdleConnsPerHost == 0 && c.maxConnsPerHost == 0 && !c.disableHTTP2 {
+		return c.transport
+	}
+	rt := c.transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return c.transport
+	}
+	t = t.Clone()
+	if c.maxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+		if t.MaxIdleConns > 0 && t.MaxIdleConns < c.maxIdleConnsPerHost {
+			t.MaxIdleConns = c.maxIdleConnsPerHost
+		}
+	}
+	if c.maxConnsPerHost > 0 {
+		t.MaxConnsPerHost = c.maxConnsPerHost
+	}
+	if c.disableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		// A non-nil, empty map keeps net/http from enabling HTTP/2.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// WithRequestHook has the client call hook with every HTTP request it sends,
+// just before it is sent: API calls, uploads, and downloads alike, including
+// retries.  The hook may change the request's headers, such as to add tracing
+// or custom authentication headers; it is given a copy, so that the changes
+// don't affect retries.  It must not read the request's body.  Unlike
+// Transport, hooks add to the client's transport instead of replacing it.
+// WithRequestHook may be given more than once; hooks are called in order.
+// Hooks may be called concurrently.
+func WithRequestHook(hook func(*http.Request)) ClientOption {
+	return func(c *clientOptions) {
+		c.reqHooks = append(c.reqHooks, hook)
+	}
+}
+
+// WithResponseHook has the client call hook with the response to every HTTP
+// request it sends, as WithRequestHook does for requests, before the client
+// reads it.  Requests that fail without a response are not reported.  The
+// hook may inspect the response's status and headers, and its Request, but
+// must not read or close its body.
+func WithResponseHook(hook func(*http.Response)) ClientOption {
+	return func(c *clientOptions) {
+		c.respHooks = append(c.respHooks, hook)
+	}
+}
+
+// Logger receives diagnostic output from a Client.  V reports whether messages
+// at the given verbosity level should be logged.  Level 1 messages report
+// errors and retries; level 2 messages report every request and response.
+type Logger interface {
+	V(level int) bool
+	Info(args ...interface{})
+	Error(args ...interface{})
+}
+
+// WithLogger sends the client's diagnostic output to l.  By default, output is
+// written to the standard log package at the level set by the B2_LOG_LEVEL
+// environment variable.
+func WithLogger(l Logger) ClientOption {
+	return func(c *clientOptions) {
+		c.logger = l
+	}
+}
+
 // FailSomeUploads requests intermittent upload failures from the B2 service.
 // This is mostly useful for testing.
 func FailSomeUploads() ClientOption {
@@ -138,6 +345,20 @@ func ForceCapExceeded() ClientOption {
 	}
 }
 
+func (c *Client) v(level int32) blog.Verbose {
+	if c == nil || c.opts.logger == nil {
+		return blog.V(level)
+	}
+	return blog.LV(c.opts.logger, level)
+}
+
+// withClock replaces the clock used for retries.  It is for tests.
+func withClock(c clock) ClientOption {
+	return func(o *clientOptions) {
+		o.clock = c
+	}
+}
+
 func client(cl *Client) ClientOption {
 	return func(c *clientOptions) {
 		c.client = cl
@@ -145,8 +366,11 @@ func client(cl *Client) ClientOption {
 }
 
 type clientTransport struct {
-	client *Client
-	rt     http.RoundTripper
+	client    *Client
+	rt        http.RoundTripper
+	reqHooks  []func(*http.Request)
+	respHooks []func(*http.Response)
+	limiter   *rateLimiter
 }
 
 func (ct *clientTransport) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -155,12 +379,25 @@ func (ct *clientTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	if t == nil {
 		t = http.DefaultTransport
 	}
+	if err := ct.limiter.wait(r.Context()); err != nil {
+		return nil, err
+	}
+	if len(ct.reqHooks) > 0 {
+		// A RoundTripper mustn't change the request it is given.
+		r = r.Clone(r.Context())
+		for _, hook := range ct.reqHooks {
+			hook(r)
+		}
+	}
 	b := time.Now()
 	resp, err := t.RoundTrip(r)
 	e := time.Now()
 	if err != nil {
 		return resp, err
 	}
+	for _, hook := range ct.respHooks {
+		hook(resp)
+	}
 	if m != "" && ct.client != nil {
 		ct.client.slock.Lock()
 		m := method{
@@ -185,30 +422,199 @@ type Bucket struct {
 	urlPool *urlPool
 }
 
+// BucketType controls who may read a bucket's files.
 type BucketType string
 
 const (
 	UnknownType BucketType = ""
-	Private                = "allPrivate"
-	Public                 = "allPublic"
-	Snapshot               = "snapshot"
+	// Private buckets require authorization to download files.
+	Private BucketType = "allPrivate"
+	// Public buckets allow anyone to download files.
+	Public BucketType = "allPublic"
+	// Snapshot buckets hold snapshots made through the B2 web UI.
+	Snapshot BucketType = "snapshot"
 )
 
+func validateBucketType(t BucketType) error {
+	switch t {
+	case Private, Public, Snapshot:
+		return nil
+	}
+	return fmt.Errorf("b2: invalid bucket type %q", t)
+}
+
 // BucketAttrs holds a bucket's metadata attributes.
 type BucketAttrs struct {
 	// Type lists or sets the new bucket type.  If Type is UnknownType during a
 	// bucket.Update, the type is not changed.
 	Type BucketType
 
-	// Info records user data, limited to ten keys.  If nil during a
-	// bucket.Update, the existing bucket info is not modified.  A bucket's
-	// metadata can be removed by updating with an empty map.
+	// Info records user data, such as the bucket's owner or environment.  As
+	// with object info, B2 allows at most ten keys, each of letters, numbers,
+	// '-', '_', and '.'; creating or updating a bucket with more fails with
+	// ErrTooManyInfoKeys, and with other keys, ErrInvalidInfoKey.  If nil
+	// during a bucket.Update, the existing bucket info is not modified.  A
+	// bucket's metadata can be removed by updating with an empty map.
 	Info map[string]string
 
 	// Reports or sets bucket lifecycle rules.  If nil during a bucket.Update,
 	// the rules are not modified.  A bucket's rules can be removed by updating
 	// with an empty slice.
 	LifecycleRules []LifecycleRule
+
+	// CORSRules reports or sets the bucket's CORS rules, which allow browsers
+	// to access it from other origins.  If nil during a bucket.Update, the
+	// rules are not modified.  A bucket's rules can be removed by updating with
+	// an empty slice.
+	CORSRules []CORSRule
+
+	// DefaultServerSideEncryption reports or sets the encryption applied to
+	// objects uploaded without their own setting.  If nil during a
+	// bucket.Update, the default is not changed.
+	DefaultServerSideEncryption *ServerSideEncryption
+
+	// ObjectLock reports whether Object Lock is enabled on the bucket, which
+	// lets objects be given a Retention or a legal hold.  It can be set when
+	// the bucket is created, and cannot be turned off; it is ignored by
+	// bucket.Update.
+	ObjectLock bool
+
+	// DefaultRetention reports or sets the retention given to objects that
+	// are uploaded without one.  The bucket must have Object Lock enabled.  If
+	// nil during a bucket.Update, the default is not changed; an empty
+	// DefaultRetention removes it.
+	DefaultRetention *DefaultRetention
+
+	// Replication reports or sets the bucket's replication configuration.  If
+	// nil during a bucket.Update, the configuration is not changed; an empty
+	// Replication removes it.
+	Replication *Replication
+
+	lockUnknown bool // the key may not read the bucket's Object Lock setting
+}
+
+// A CORSRule allows browsers to make cross-origin requests to a bucket.
+type CORSRule struct {
+	// Name identifies the rule.  It must be unique within the bucket.
+	Name string
+
+	// AllowedOrigins lists the origins, such as "https://example.com", that
+	// may make requests.  "*" allows any origin.
+	AllowedOrigins []string
+
+	// AllowedOperations lists the B2 operations the rule applies to, e.g.
+	// "b2_download_file_by_name" or "b2_upload_file".
+	AllowedOperations []string
+
+	// AllowedHeaders lists the headers browsers may send, and ExposeHeaders
+	// the response headers they may read.
+	AllowedHeaders []string
+	ExposeHeaders  []string
+
+	// MaxAgeSeconds is how long browsers may cache the result of a preflight
+	// request.
+	MaxAgeSeconds int
+}
+
+// corsOperations are the operations a CORSRule may allow.
+var corsOperations = map[string]bool{
+	"b2_download_file_by_name": true,
+	"b2_download_file_by_id":   true,
+	"b2_upload_file":           true,
+	"b2_upload_part":           true,
+	"s3_delete":                true,
+	"s3_get":                   true,
+	"s3_head":                  true,
+	"s3_post":                  true,
+	"s3_put":                   true,
+}
+
+func validateCORS(rules []CORSRule) error {
+	for _, rule := range rules {
+		for _, op := range rule.AllowedOperations {
+			if !corsOperations[op] {
+				return fmt.Errorf("CORS rule %q: unknown operation %q", rule.Name, op)
+			}
+		}
+	}
+	return nil
+}
+
+// Replication configures B2 to copy objects from one bucket to others, which
+// may belong to other accounts.  A bucket can be a source of replication, a
+// destination, or both.
+type Replication struct {
+	// AsSource, if set, lists the rules by which the bucket's objects are
+	// replicated.
+	AsSource *ReplicationSource
+
+	// AsDestination, if set, allows other buckets to replicate into this one.
+	AsDestination *ReplicationDestination
+}
+
+// ReplicationSource describes how a bucket's objects are replicated.
+type ReplicationSource struct {
+	// KeyID is the ID of the application key B2 uses to read the objects.
+	KeyID string
+
+	Rules []ReplicationRule
+}
+
+// A ReplicationRule replicates objects whose names begin with Prefix to
+// another bucket.
+type ReplicationRule struct {
+	// Name identifies the rule.  It must be unique within the bucket, and be
+	// made of 1 to 64 letters, digits, and hyphens.
+	Name string
+
+	// DestinationBucketID is the ID of the bucket objects are copied to.
+	DestinationBucketID string
+
+	// Prefix limits the rule to objects whose names begin with it.
+	Prefix string
+
+	// Priority chooses between rules that apply to the same object.  It must
+	// be between 1 and 2147483647.
+	Priority int
+
+	// IncludeExisting replicates the objects already in the bucket, as well
+	// as those uploaded after the rule is made.
+	IncludeExisting bool
+
+	// Disabled pauses the rule.
+	Disabled bool
+}
+
+// ReplicationDestination lets source buckets replicate into a bucket.
+type ReplicationDestination struct {
+	// KeyMapping maps the IDs of the keys that source buckets read with to
+	// the IDs of keys in this account, which B2 uses to write the replicas.
+	KeyMapping map[string]string
+}
+
+var replicationRuleName = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+func validateReplication(r *Replication) error {
+	if r == nil || r.AsSource == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, rule := range r.AsSource.Rules {
+		if !replicationRuleName.MatchString(rule.Name) {
+			return fmt.Errorf("replication rule %q: invalid name", rule.Name)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("replication rule %q: duplicate name", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.Priority < 1 || rule.Priority > math.MaxInt32 {
+			return fmt.Errorf("replication rule %q: priority %d is not between 1 and %d", rule.Name, rule.Priority, math.MaxInt32)
+		}
+		if rule.DestinationBucketID == "" {
+			return fmt.Errorf("replication rule %q: no destination bucket", rule.Name)
+		}
+	}
+	return nil
 }
 
 // A LifecycleRule describes an object's life cycle, namely how many days after
@@ -240,16 +646,108 @@ func (e b2err) Error() string {
 	return e.err.Error()
 }
 
+// Unwrap returns the error underlying e.
+func (e b2err) Unwrap() error { return e.err }
+
+func (e b2err) Is(target error) bool {
+	return e.isUpdateConflict && target == ErrRevisionConflict
+}
+
 // IsNotExist reports whether a given error indicates that an object or bucket
 // does not exist.
 func IsNotExist(err error) bool {
-	berr, ok := err.(b2err)
-	if !ok {
+	var berr b2err
+	if errors.As(err, &berr) && berr.notFoundErr {
+		return true
+	}
+	var e *Error
+	return errors.As(err, &e) && (e.Status == http.StatusNotFound || e.Code == "file_not_present")
+}
+
+// Error is an error reported by B2.  Use errors.As to find it among the errors
+// returned by this package.
+type Error struct {
+	// Status is the HTTP status of B2's reply.
+	Status int
+
+	// Code is B2's name for the error, such as "bad_bucket_id" or
+	// "duplicate_bucket_name".
+	Code string
+
+	// Message describes the error.
+	Message string
+
+	err error // the underlying error, if any
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("b2: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Unwrap returns the error underlying e.
+func (e *Error) Unwrap() error { return e.err }
+
+// Is reports whether e matches target.  An *Error for a "cap_exceeded",
+// "storage_cap_exceeded", "download_cap_exceeded", or
+// "transaction_cap_exceeded" reply matches ErrCapExceeded.
+func (e *Error) Is(target error) bool {
+	if target != ErrCapExceeded || e.Status != http.StatusForbidden {
 		return false
 	}
-	return berr.notFoundErr
+	return e.Code == "cap_exceeded" || strings.HasSuffix(e.Code, "_cap_exceeded")
+}
+
+// ErrCapExceeded matches errors caused by the account reaching one of the
+// storage, download, or transaction caps set on it.  Use errors.Is to test for
+// it.
+var ErrCapExceeded = errors.New("b2: account cap exceeded")
+
+// IsCapExceeded reports whether an error was caused by the account reaching
+// one of the storage, download, or transaction caps set on it.  It is
+// equivalent to errors.Is(err, ErrCapExceeded).
+func IsCapExceeded(err error) bool {
+	return errors.Is(err, ErrCapExceeded)
 }
 
+// ErrSHA1Mismatch is returned when the SHA1 hash that B2 reports for uploaded
+// data, or for downloaded data with Reader.VerifySHA1, does not match the hash
+// computed locally.  Use errors.Is to test for it.
+var ErrSHA1Mismatch = errors.New("b2: SHA1 mismatch")
+
+// ErrTooManyInfoKeys is returned when an object or bucket would be written with
+// more than the ten Info keys B2 allows.
+var ErrTooManyInfoKeys = errors.New("b2: too many info keys")
+
+// ErrInvalidInfoKey is returned when an object or bucket would be written with
+// an Info key that B2 does not accept.  Keys must be at most 50 characters of letters,
+// numbers, '-', '_', and '.'.
+var ErrInvalidInfoKey = errors.New("b2: invalid info key")
+
+// ErrInvalidObjectName is returned by Writer.Validate for an object name that
+// B2 does not accept.  Names must be valid UTF-8 of at most 1024 bytes, without
+// control characters.
+var ErrInvalidObjectName = errors.New("b2: invalid object name")
+
+// ErrCustomerKey is returned when an object encrypted with SSEC is read or
+// copied without its key, or with the wrong key.  Use errors.Is to test for it.
+var ErrCustomerKey = errors.New("b2: missing or incorrect SSE-C key")
+
+// ErrCapabilityMissing is returned when the client's application key does not
+// allow an operation, either because it lacks the needed capability or because
+// it is restricted to another bucket.  Use errors.Is to test for it.
+var ErrCapabilityMissing = errors.New("b2: application key does not allow this operation")
+
+// ErrObjectLockDisabled is returned when an object is given a retention
+// setting or a legal hold in a bucket that does not have Object Lock enabled.
+var ErrObjectLockDisabled = errors.New("b2: Object Lock is not enabled on the bucket")
+
+// ErrRevisionConflict is returned by Bucket.Update when the bucket has been
+// changed since its revision was read.  Use errors.Is to test for it.
+var ErrRevisionConflict = errors.New("b2: bucket revision conflict")
+
 const uploadURLPoolSize = 100
 
 type urlPool struct {
@@ -282,7 +780,10 @@ func (p *urlPool) put(u beURLInterface) {
 
 // Bucket returns a bucket if it exists.
 func (c *Client) Bucket(ctx context.Context, name string) (*Bucket, error) {
-	buckets, err := c.backend.listBuckets(ctx)
+	if err := c.backend.allow("", name); err != nil {
+		return nil, err
+	}
+	buckets, err := c.backend.listBuckets(ctx, "", name, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -306,7 +807,10 @@ func (c *Client) Bucket(ctx context.Context, name string) (*Bucket, error) {
 // if it does not already exist.  If attrs is nil, it is created as a private
 // bucket with no info metadata and no lifecycle rules.
 func (c *Client) NewBucket(ctx context.Context, name string, attrs *BucketAttrs) (*Bucket, error) {
-	buckets, err := c.backend.listBuckets(ctx)
+	if err := c.backend.allow("", name); err != nil {
+		return nil, err
+	}
+	buckets, err := c.backend.listBuckets(ctx, "", name, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -323,21 +827,172 @@ func (c *Client) NewBucket(ctx context.Context, name string, attrs *BucketAttrs)
 	if attrs == nil {
 		attrs = &BucketAttrs{Type: Private}
 	}
-	b, err := c.backend.createBucket(ctx, name, string(attrs.Type), attrs.Info, attrs.LifecycleRules)
+	if err := validateInfo(name, attrs.Info); err != nil {
+		return nil, err
+	}
+	if err := validateCORS(attrs.CORSRules); err != nil {
+		return nil, err
+	}
+	if err := validateReplication(attrs.Replication); err != nil {
+		return nil, err
+	}
+	if r := attrs.DefaultRetention; r != nil && r.Mode != "" && !attrs.ObjectLock {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectLockDisabled)
+	}
+	b, err := c.backend.createBucket(ctx, name, string(attrs.Type), attrs.Info, attrs.LifecycleRules, attrs.CORSRules, attrs.DefaultServerSideEncryption, attrs.ObjectLock, attrs.Replication)
 	if err != nil {
 		return nil, err
 	}
-	return &Bucket{
+	bucket := &Bucket{
 		b:       b,
 		r:       c.backend,
 		c:       c,
 		urlPool: newURLPool(),
-	}, err
+	}
+	if attrs.DefaultRetention != nil {
+		// B2 only takes a default retention when updating a bucket.
+		if err := bucket.Update(ctx, &BucketAttrs{DefaultRetention: attrs.DefaultRetention}); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
 }
 
-// ListBuckets returns all the available buckets.
-func (c *Client) ListBuckets(ctx context.Context) ([]*Bucket, error) {
-	bs, err := c.backend.listBuckets(ctx)
+// EnsureBucket returns the named bucket, creating it with attrs if it does not
+// exist, as NewBucket does, and updating it if it does but differs from attrs.
+// Only the attributes that attrs sets are compared; ObjectLock, which B2 sets
+// only when a bucket is created, is not.  If the bucket is created by someone
+// else after EnsureBucket looks for it, so that B2 reports
+// "duplicate_bucket_name", EnsureBucket looks again and uses that bucket.
+func (c *Client) EnsureBucket(ctx context.Context, name string, attrs *BucketAttrs) (*Bucket, error) {
+	b, err := c.NewBucket(ctx, name, attrs)
+	var e *Error
+	if errors.As(err, &e) && e.Code == "duplicate_bucket_name" {
+		var lerr error
+		if b, lerr = c.Bucket(ctx, name); lerr == nil {
+			err = nil
+		} else if !IsNotExist(lerr) {
+			err = lerr
+		}
+		// Otherwise the name belongs to another account.
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attrs == nil {
+		return b, nil
+	}
+	if update := bucketChanges(b.b.attrs(), attrs); update != nil {
+		if err := b.Update(ctx, update); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// bucketChanges returns the attributes that want sets and have doesn't match,
+// as an update for Bucket.Update, or nil if there are none.
+func bucketChanges(have, want *BucketAttrs) *BucketAttrs {
+	if have == nil {
+		have = &BucketAttrs{}
+	}
+	u := &BucketAttrs{}
+	var changed bool
+	if want.Type != UnknownType && want.Type != have.Type {
+		u.Type, changed = want.Type, true
+	}
+	if want.Info != nil && differs(want.Info, have.Info) {
+		u.Info, changed = want.Info, true
+	}
+	if want.LifecycleRules != nil && differs(want.LifecycleRules, have.LifecycleRules) {
+		u.LifecycleRules, changed = want.LifecycleRules, true
+	}
+	if want.CORSRules != nil && differs(want.CORSRules, have.CORSRules) {
+		u.CORSRules, changed = want.CORSRules, true
+	}
+	if sse := want.DefaultServerSideEncryption; sse != nil {
+		hsse := have.DefaultServerSideEncryption
+		if hsse == nil {
+			hsse = &ServerSideEncryption{}
+		}
+		if !reflect.DeepEqual(sse, hsse) {
+			u.DefaultServerSideEncryption, changed = sse, true
+		}
+	}
+	if r := want.DefaultRetention; r != nil {
+		var hr DefaultRetention
+		if have.DefaultRetention != nil {
+			hr = *have.DefaultRetention
+		}
+		if *r != hr && (r.Mode != "" || hr.Mode != "") {
+			u.DefaultRetention, changed = r, true
+		}
+	}
+	if r := want.Replication; r != nil {
+		hr := have.Replication
+		if hr == nil {
+			hr = &Replication{}
+		}
+		if !reflect.DeepEqual(r, hr) {
+			u.Replication, changed = r, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return u
+}
+
+// differs reports whether want and have, which are maps or slices of the same
+// type, hold different elements.  Nil and empty are the same.
+func differs(want, have interface{}) bool {
+	if reflect.ValueOf(want).Len() == 0 && reflect.ValueOf(have).Len() == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(want, have)
+}
+
+type listBucketsOptions struct {
+	id    string
+	name  string
+	types []string
+}
+
+// A ListBucketsOption limits the buckets returned by Client.ListBuckets.
+type ListBucketsOption func(*listBucketsOptions)
+
+// ListBucketsName returns only the bucket with the given name.
+func ListBucketsName(name string) ListBucketsOption {
+	return func(l *listBucketsOptions) {
+		l.name = name
+	}
+}
+
+// ListBucketsID returns only the bucket with the given ID.
+func ListBucketsID(id string) ListBucketsOption {
+	return func(l *listBucketsOptions) {
+		l.id = id
+	}
+}
+
+// ListBucketsTypes returns only buckets of the given types.
+func ListBucketsTypes(types ...BucketType) ListBucketsOption {
+	return func(l *listBucketsOptions) {
+		for _, t := range types {
+			l.types = append(l.types, string(t))
+		}
+	}
+}
+
+// ListBuckets returns all the available buckets, or, with options, those that
+// match every filter given.  The filters are applied by B2.  Each Bucket holds
+// the attributes B2 listed for it, which its Attrs method refreshes.
+func (c *Client) ListBuckets(ctx context.Context, opts ...ListBucketsOption) ([]*Bucket, error) {
+	var lo listBucketsOptions
+	for _, o := range opts {
+		o(&lo)
+	}
+	bs, err := c.backend.listBuckets(ctx, lo.id, lo.name, lo.types)
 	if err != nil {
 		return nil, err
 	}
@@ -354,30 +1009,74 @@ func (c *Client) ListBuckets(ctx context.Context) ([]*Bucket, error) {
 }
 
 // IsUpdateConflict reports whether a given error is the result of a bucket
-// update conflict.
+// update conflict.  It is equivalent to errors.Is(err, ErrRevisionConflict).
 func IsUpdateConflict(err error) bool {
-	e, ok := err.(b2err)
-	if !ok {
-		return false
-	}
-	return e.isUpdateConflict
+	return errors.Is(err, ErrRevisionConflict)
 }
 
-// Update modifies the given bucket with new attributes.  It is possible that
-// this method could fail with an update conflict, in which case you should
-// retrieve the latest bucket attributes with Attrs and try again.
+// Update modifies the given bucket with new attributes.  B2 applies the update
+// only if the bucket is still at the revision b last saw (see Revision);
+// otherwise Update fails with ErrRevisionConflict, in which case you should
+// retrieve the latest bucket attributes with Attrs and try again.  On success,
+// b is updated to the new revision.
 func (b *Bucket) Update(ctx context.Context, attrs *BucketAttrs) error {
+	if attrs != nil {
+		if attrs.Type != UnknownType {
+			if err := validateBucketType(attrs.Type); err != nil {
+				return err
+			}
+		}
+		if err := validateInfo(b.Name(), attrs.Info); err != nil {
+			return err
+		}
+		if err := validateCORS(attrs.CORSRules); err != nil {
+			return err
+		}
+		if err := validateReplication(attrs.Replication); err != nil {
+			return err
+		}
+		if r := attrs.DefaultRetention; r != nil && r.Mode != "" {
+			if err := b.checkObjectLock(b.Name()); err != nil {
+				return err
+			}
+		}
+	}
 	return b.b.updateBucket(ctx, attrs)
 }
 
-// Attrs retrieves and returns the current bucket's attributes.
+// SetType changes the bucket's type to Private, Public, or Snapshot.  Like
+// Update, it fails with ErrRevisionConflict if the bucket has changed since b
+// last saw it.
+func (b *Bucket) SetType(ctx context.Context, t BucketType) error {
+	if err := validateBucketType(t); err != nil {
+		return err
+	}
+	return b.Update(ctx, &BucketAttrs{Type: t})
+}
+
+// Attrs retrieves and returns the current bucket's attributes, including its
+// type, info, lifecycle and CORS rules, as Reload does.  If the bucket no
+// longer exists, the error satisfies IsNotExist.
 func (b *Bucket) Attrs(ctx context.Context) (*BucketAttrs, error) {
+	if err := b.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return b.b.attrs(), nil
+}
+
+// Reload fetches the bucket's current attributes from B2, replacing those that
+// b has cached, such as its type and its Revision.  A Bucket keeps what B2
+// reported when it was found or last updated through it; Reload picks up
+// changes made since by other clients, so that a following Update doesn't
+// fail with ErrRevisionConflict.  If the bucket no longer exists, the error
+// satisfies IsNotExist.
+func (b *Bucket) Reload(ctx context.Context) error {
 	bucket, err := b.c.Bucket(ctx, b.Name())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	b.b = bucket.b
-	return b.b.attrs(), nil
+	return nil
 }
 
 var bNotExist = regexp.MustCompile("Bucket.*does not exist")
@@ -413,6 +1112,12 @@ func (b *Bucket) Name() string {
 	return b.b.name()
 }
 
+// Revision returns the revision of the bucket as of the last time b was
+// retrieved or updated.  B2 increments it on every change to the bucket.
+func (b *Bucket) Revision() int {
+	return b.b.revision()
+}
+
 // Object represents a B2 object.
 type Object struct {
 	attrs *Attrs
@@ -431,6 +1136,11 @@ type Attrs struct {
 	SHA1            string            // Can be "none" for large files.  If set on upload, will be used for large files.
 	LastModified    time.Time         // If present, and there are fewer than 10 keys in the Info field, this is saved on upload.
 	Info            map[string]string // Save arbitrary metadata on upload, but limited to 10 keys.
+
+	// ServerSideEncryption reports how the object is encrypted at rest.  It is
+	// nil for unencrypted objects.  Not used on upload; see
+	// Writer.ServerSideEncryption.
+	ServerSideEncryption *ServerSideEncryption
 }
 
 // Name returns an object's name
@@ -438,6 +1148,16 @@ func (o *Object) Name() string {
 	return o.name
 }
 
+// ID returns the ID of the object's file version.  It is empty for objects
+// returned by Bucket.Object until the current version has been looked up,
+// e.g. by Attrs.
+func (o *Object) ID() string {
+	if o.f == nil {
+		return ""
+	}
+	return o.f.id()
+}
+
 // Attrs returns an object's attributes.
 func (o *Object) Attrs(ctx context.Context) (*Attrs, error) {
 	if err := o.ensure(ctx); err != nil {
@@ -448,6 +1168,74 @@ func (o *Object) Attrs(ctx context.Context) (*Attrs, error) {
 		return nil, err
 	}
 	name, sha, size, ct, info, st, stamp := fi.stats()
+	return newAttrs(name, sha, size, ct, info, st, stamp, fi.encryption())
+}
+
+// BatchAttrs returns the attributes of each of the named objects, fetching up
+// to concurrency of them at once, as Attrs would one at a time.  Each name is
+// in exactly one of the returned maps: attrs if it was fetched, or errs if not.
+// Objects that don't exist are in errs, with errors that satisfy IsNotExist.
+// errs is nil if every name was fetched.  A concurrency less than 1 is treated
+// as 1.
+func (b *Bucket) BatchAttrs(ctx context.Context, names []string, concurrency int) (map[string]*Attrs, map[string]error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	attrs := make(map[string]*Attrs)
+	var errs map[string]error
+	var mu sync.Mutex // guards attrs and errs
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range ch {
+				a, err := b.Object(name).Attrs(ctx)
+				mu.Lock()
+				if err != nil {
+					if errs == nil {
+						errs = make(map[string]error)
+					}
+					errs[name] = err
+				} else {
+					attrs[name] = a
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		ch <- name
+	}
+	close(ch)
+	wg.Wait()
+	return attrs, errs
+}
+
+// Exists reports whether an object of this name is currently visible in the
+// bucket: uploaded, and not hidden or deleted since.  It lists the bucket
+// rather than fetching the object, so no content is downloaded, and it checks
+// B2 each time it is called, even if o came from a listing or a Writer.  A
+// missing object is reported as false with a nil error; any other failure is
+// returned.
+func (o *Object) Exists(ctx context.Context) (bool, error) {
+	f, err := o.b.listObject(ctx, o.name)
+	if IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if o.f == nil {
+		o.f = f.f
+	}
+	return true, nil
+}
+
+// newAttrs builds Attrs from the details B2 reports for a file.  It removes
+// the entries of info that become other fields.
+func newAttrs(name, sha string, size int64, ct string, info map[string]string, st string, stamp time.Time, sse *ServerSideEncryption) (*Attrs, error) {
 	var state ObjectState
 	switch st {
 	case "upload":
@@ -472,17 +1260,186 @@ func (o *Object) Attrs(ctx context.Context) (*Attrs, error) {
 		sha = v
 	}
 	return &Attrs{
-		Name:            name,
-		Size:            size,
-		ContentType:     ct,
-		UploadTimestamp: stamp,
-		SHA1:            sha,
-		Info:            info,
-		Status:          state,
-		LastModified:    mtime,
+		Name:                 name,
+		Size:                 size,
+		ContentType:          ct,
+		UploadTimestamp:      stamp,
+		SHA1:                 sha,
+		Info:                 info,
+		Status:               state,
+		LastModified:         mtime,
+		ServerSideEncryption: sse,
 	}, nil
 }
 
+// Server-side encryption modes.
+const (
+	// SSEB2 is the mode in which B2 manages the encryption keys.
+	SSEB2 = "SSE-B2"
+
+	// SSEC is the mode in which the caller supplies a 256-bit AES key with
+	// every request that reads or writes the object.  B2 does not store the
+	// key; an object whose key is lost cannot be read.
+	SSEC = "SSE-C"
+)
+
+// ServerSideEncryption describes how an object is encrypted at rest.
+type ServerSideEncryption struct {
+	Mode      string // SSEB2 or SSEC
+	Algorithm string // If empty, "AES256" is used.
+
+	// Key is the raw (not base64-encoded) key for SSEC.  It is sent to B2
+	// along with its MD5 sum, which B2 uses to check the key in transit.  The
+	// key is never logged, and is omitted when the ServerSideEncryption is
+	// formatted.
+	Key []byte
+}
+
+// String returns the mode and algorithm of e, but never its key.
+func (e ServerSideEncryption) String() string {
+	if e.Algorithm == "" {
+		return e.Mode
+	}
+	return e.Mode + "/" + e.Algorithm
+}
+
+// GoString is like String, so that %#v does not print the key either.
+func (e ServerSideEncryption) GoString() string {
+	return fmt.Sprintf("b2.ServerSideEncryption{Mode: %q, Algorithm: %q}", e.Mode, e.Algorithm)
+}
+
+// Object Lock retention modes.
+const (
+	Governance = "governance"
+	Compliance = "compliance"
+)
+
+// Units of a DefaultRetention period.
+const (
+	Days  = "days"
+	Years = "years"
+)
+
+// DefaultRetention is a bucket's default Object Lock retention setting.
+// Objects uploaded without a Retention of their own are retained for Period
+// Units (Days or Years) from when they are uploaded.
+type DefaultRetention struct {
+	Mode   string // Governance or Compliance
+	Period int
+	Unit   string
+}
+
+// Retention is an object's Object Lock retention setting.  Objects under
+// retention cannot be deleted or overwritten until RetainUntil.
+type Retention struct {
+	Mode        string // Governance or Compliance
+	RetainUntil time.Time
+}
+
+// checkObjectLock returns ErrObjectLockDisabled if the bucket is known not to
+// have Object Lock enabled.
+func (b *Bucket) checkObjectLock(name string) error {
+	attrs := b.b.attrs()
+	if attrs == nil || attrs.ObjectLock || attrs.lockUnknown {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", name, ErrObjectLockDisabled)
+}
+
+// Retention returns the object's Object Lock retention setting, or nil if it
+// has none.  It is also nil if the client's key may not read it.
+func (o *Object) Retention(ctx context.Context) (*Retention, error) {
+	if err := o.ensure(ctx); err != nil {
+		return nil, err
+	}
+	fi, err := o.f.getFileInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fi.retention(), nil
+}
+
+// LegalHold reports whether the object is under an Object Lock legal hold,
+// which keeps it from being deleted regardless of its retention setting.
+func (o *Object) LegalHold(ctx context.Context) (bool, error) {
+	if err := o.ensure(ctx); err != nil {
+		return false, err
+	}
+	fi, err := o.f.getFileInfo(ctx)
+	if err != nil {
+		return false, err
+	}
+	return fi.legalHold(), nil
+}
+
+// A RetentionOption changes how SetRetention updates an object's retention.
+type RetentionOption func(*retentionOptions)
+
+type retentionOptions struct {
+	bypass bool
+}
+
+// BypassGovernance lets SetRetention shorten or remove a Governance mode
+// retention, which B2 otherwise refuses.  The client's key must have the
+// bypassGovernance capability.
+func BypassGovernance() RetentionOption {
+	return func(r *retentionOptions) {
+		r.bypass = true
+	}
+}
+
+// SetRetention changes the object's Object Lock retention to the given mode,
+// Governance or Compliance, until retainUntil.  An empty mode and a zero
+// retainUntil remove the object's retention.
+//
+// B2 allows a retention to be extended, or changed from Governance to
+// Compliance, at any time.  A Governance retention may be shortened or removed
+// only with BypassGovernance, and a Compliance retention can never be.  B2
+// refuses such changes, and SetRetention returns its error, naming the change
+// that was attempted.
+func (o *Object) SetRetention(ctx context.Context, mode string, retainUntil time.Time, opts ...RetentionOption) error {
+	var r *Retention
+	switch mode {
+	case "":
+		if !retainUntil.IsZero() {
+			return fmt.Errorf("%s: retention until %v has no mode", o.name, retainUntil)
+		}
+	case Governance, Compliance:
+		if retainUntil.IsZero() {
+			return fmt.Errorf("%s: %s retention has no end", o.name, mode)
+		}
+		r = &Retention{Mode: mode, RetainUntil: retainUntil}
+	default:
+		return fmt.Errorf("%s: unknown retention mode %q", o.name, mode)
+	}
+	ro := &retentionOptions{}
+	for _, opt := range opts {
+		opt(ro)
+	}
+	if err := o.ensure(ctx); err != nil {
+		return err
+	}
+	if err := o.f.updateFileRetention(ctx, r, ro.bypass); err != nil {
+		if r == nil {
+			return fmt.Errorf("%s: removing retention: %w", o.name, err)
+		}
+		return fmt.Errorf("%s: setting %s retention until %v: %w", o.name, mode, retainUntil, err)
+	}
+	return nil
+}
+
+// SetLegalHold places the object under an Object Lock legal hold, or, if on is
+// false, lifts it.
+func (o *Object) SetLegalHold(ctx context.Context, on bool) error {
+	if err := o.ensure(ctx); err != nil {
+		return err
+	}
+	if err := o.f.updateFileLegalHold(ctx, on); err != nil {
+		return fmt.Errorf("%s: setting legal hold %v: %w", o.name, on, err)
+	}
+	return nil
+}
+
 // ObjectState represents the various states an object can be in.
 type ObjectState int
 
@@ -512,9 +1469,14 @@ func (b *Bucket) Object(name string) *Object {
 	}
 }
 
-// URL returns the full URL to the given object.
+// URL returns the full URL to the given object, under the download URL the
+// client was given on authorization.  The object's name is percent-encoded as
+// B2 requires, except for slashes, which separate the parts of the path as
+// they do the parts of the name.  Anyone may download objects in an allPublic
+// bucket from this URL; objects in private buckets need a token, which AuthURL
+// adds.
 func (o *Object) URL() string {
-	return fmt.Sprintf("%s/file/%s/%s", o.b.BaseURL(), o.b.Name(), o.name)
+	return fmt.Sprintf("%s/file/%s/%s", o.b.BaseURL(), o.b.Name(), encodeName(o.name))
 }
 
 // NewWriter returns a new writer for the given object.  Objects that are
@@ -569,7 +1531,10 @@ func (o *Object) ensure(ctx context.Context) error {
 	return nil
 }
 
-// Delete removes the given object.
+// Delete removes one version of the object: the version o refers to if it came
+// from a listing or from a Writer, and the current version otherwise.  The name
+// is not hidden; if there is an older version, it becomes current.  If the
+// version is already gone, IsNotExist reports true for the error.
 func (o *Object) Delete(ctx context.Context) error {
 	if err := o.ensure(ctx); err != nil {
 		return err
@@ -677,6 +1642,35 @@ func (b *Bucket) ListCurrentObjects(ctx context.Context, count int, c *Cursor) (
 	return objects, next, rtnErr
 }
 
+// CancelUnfinishedOlderThan cancels the bucket's unfinished large file uploads
+// that were started more than d ago, discarding the parts sent for them, and
+// returns the number it cancelled.  Uploads that are finished or cancelled by
+// someone else in the meantime are skipped.  To see the unfinished uploads
+// first, call List with ListUnfinished.
+func (b *Bucket) CancelUnfinishedOlderThan(ctx context.Context, d time.Duration) (int, error) {
+	cutoff := b.r.clock().Now().Add(-d)
+	var n int
+	iter := b.List(ctx, ListUnfinished())
+	for iter.Next() {
+		o := iter.Object()
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			return n, err
+		}
+		if !attrs.UploadTimestamp.Before(cutoff) {
+			continue
+		}
+		if err := o.f.compileParts(0, nil).cancelLargeFile(ctx); err != nil {
+			if IsNotExist(err) {
+				continue
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, iter.Err()
+}
+
 // ListUnfinishedLargeFiles lists any objects that correspond to large file uploads that haven't been completed.
 // This can happen for example when an upload is interrupted.
 //
@@ -739,6 +1733,89 @@ func (b *Bucket) Reveal(ctx context.Context, name string) error {
 	return obj.Delete(ctx)
 }
 
+// deleteWorkers bounds the number of concurrent deletions DeleteAllVersions
+// and PruneVersions make.
+const deleteWorkers = 10
+
+// DeleteAllVersions removes every version of the named object, including the
+// markers that hide it, so that nothing under the name remains.  Versions are
+// deleted concurrently; versions that are deleted by someone else in the
+// meantime are not an error.  If any deletion fails, DeleteAllVersions returns
+// the errors joined together, after trying the rest.
+func (b *Bucket) DeleteAllVersions(ctx context.Context, name string) error {
+	return b.deleteVersions(ctx, name, 0)
+}
+
+// PruneVersions deletes all but the newest keep versions of the named object.
+// Markers that hide the object count as versions.  As with DeleteAllVersions,
+// versions are deleted concurrently, and versions already deleted by someone
+// else are not an error.  A keep less than 1 is an error; use
+// DeleteAllVersions to remove every version.
+//
+// Writing an object whose name already exists adds a new version and leaves
+// the old ones in place; PruneVersions, or a Writer's KeepVersions, bounds
+// that history.
+func (b *Bucket) PruneVersions(ctx context.Context, name string, keep int) error {
+	if keep < 1 {
+		return fmt.Errorf("%s: cannot keep %d versions", name, keep)
+	}
+	return b.deleteVersions(ctx, name, keep)
+}
+
+// deleteVersions deletes the versions of the named object after the newest
+// keep, which B2 lists first.
+func (b *Bucket) deleteVersions(ctx context.Context, name string, keep int) error {
+	ch := make(chan *Object)
+	errc := make(chan error)
+	var wg sync.WaitGroup
+	for i := 0; i < deleteWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range ch {
+				if err := o.Delete(ctx); err != nil && !IsNotExist(err) {
+					errc <- fmt.Errorf("%s (%s): %w", name, o.ID(), err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+		iter := b.List(ctx, ListHidden(), ListPrefix(name))
+		var seen int
+		for iter.Next() {
+			o := iter.Object()
+			if o.Name() != name {
+				break
+			}
+			if seen++; seen <= keep {
+				continue
+			}
+			select {
+			case ch <- o:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+		if err := iter.Err(); err != nil {
+			errc <- err
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(errc)
+	}()
+
+	var errs []error
+	for err := range errc {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 // I don't want to import all of ioutil for this.
 type discard struct{}
 
@@ -747,7 +1824,15 @@ func (discard) Write(p []byte) (int, error) {
 }
 
 func (b *Bucket) getObject(ctx context.Context, name string) (*Object, error) {
-	fr, err := b.b.downloadFileByName(ctx, name, 0, 1)
+	fr, err := b.b.downloadFileByName(ctx, name, 0, 1, nil)
+	if errors.Is(err, ErrCustomerKey) {
+		// SSE-C objects can't be read without their key, but can be listed.
+		return b.listObject(ctx, name)
+	}
+	if err == errNoMoreContent {
+		// Empty objects have no first byte to fetch.
+		return b.listObject(ctx, name)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -760,16 +1845,59 @@ func (b *Bucket) getObject(ctx context.Context, name string) (*Object, error) {
 	}, nil
 }
 
+// listObject finds the named object by listing the bucket.
+func (b *Bucket) listObject(ctx context.Context, name string) (*Object, error) {
+	fs, _, err := b.b.listFileNames(ctx, 1, name, name, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(fs) < 1 || fs[0].name() != name {
+		return nil, b2err{err: fmt.Errorf("%s: not found", name), notFoundErr: true}
+	}
+	return &Object{
+		name: name,
+		f:    fs[0],
+		b:    b,
+	}, nil
+}
+
+type authTokenOptions struct {
+	b2cd string
+}
+
+// An AuthTokenOption changes the token returned by Bucket.AuthToken.
+type AuthTokenOption func(*authTokenOptions)
+
+// TokenContentDisposition requires that downloads made with the token ask B2
+// to send the given Content-Disposition header, by passing it as the
+// b2ContentDisposition query parameter.
+func TokenContentDisposition(b2cd string) AuthTokenOption {
+	return func(a *authTokenOptions) {
+		a.b2cd = b2cd
+	}
+}
+
 // AuthToken returns an authorization token that can be used to access objects
 // in a private bucket.  Only objects that begin with prefix can be accessed.
-// The token expires after the given duration.
-func (b *Bucket) AuthToken(ctx context.Context, prefix string, valid time.Duration) (string, error) {
-	return b.b.getDownloadAuthorization(ctx, prefix, valid, "")
+// The token expires after the given duration, which B2 counts in whole
+// seconds.
+//
+// The token is sent as the Authorization header or query parameter of a
+// download by name, which lets callers build their own URLs, for instance to
+// route downloads through a CDN.
+func (b *Bucket) AuthToken(ctx context.Context, prefix string, valid time.Duration, opts ...AuthTokenOption) (string, error) {
+	var ao authTokenOptions
+	for _, o := range opts {
+		o(&ao)
+	}
+	return b.b.getDownloadAuthorization(ctx, prefix, valid, ao.b2cd)
 }
 
 // AuthURL returns a URL for the given object with embedded token and,
 // possibly, b2ContentDisposition arguments.  Leave b2cd blank for no content
-// disposition.
+// disposition.  The token is good for the given duration, and grants access to
+// every object whose name begins with this one's, so the URL can be handed to
+// browsers that lack the account's credentials.
 func (o *Object) AuthURL(ctx context.Context, valid time.Duration, b2cd string) (*url.URL, error) {
 	token, err := o.b.b.getDownloadAuthorization(ctx, o.name, valid, b2cd)
 	if err != nil {
@@ -16,16 +16,28 @@ package b2
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -37,11 +49,74 @@ const (
 
 var gmux = &sync.Mutex{}
 
+// fakeClock is a clock whose time moves only when Advance is called, or, if
+// auto is set, whenever something waits on it.
+type fakeClock struct {
+	auto    bool
+	waiting chan time.Duration // if non-nil, receives every wait as it begins
+
+	mu      sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	history []time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	f.mu.Lock()
+	f.history = append(f.history, d)
+	f.timers = append(f.timers, fakeTimer{at: f.now.Add(d), ch: ch})
+	f.mu.Unlock()
+	if f.auto {
+		f.Advance(d)
+	}
+	if f.waiting != nil {
+		f.waiting <- d
+	}
+	return ch
+}
+
+// Advance moves the clock forward by d, firing every timer that comes due.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	var pending []fakeTimer
+	for _, t := range f.timers {
+		if t.at.After(f.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- f.now
+	}
+	f.timers = pending
+}
+
+// waits returns the duration of every wait so far, in order.
+func (f *fakeClock) waits() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var w []time.Duration
+	return append(w, f.history...)
+}
+
 type testError struct {
 	retry    bool
 	backoff  time.Duration
 	reauth   bool
 	reupload bool
+	status   int
 }
 
 func (t testError) Error() string {
@@ -49,73 +124,236 @@ func (t testError) Error() string {
 }
 
 type errCont struct {
-	errMap map[string]map[int]error
-	opMap  map[string]int
+	errMap  map[string]map[int]error
+	opMap   map[string]int
+	badSHA  bool          // report the wrong SHA1 for uploaded data
+	expired bool          // fail every call with an expired token until reauthorized
+	lag     time.Duration // delay every reply by this much
+	parts   map[int]error // fail every upload of these part numbers
+	stalls  map[int]int   // hang this many uploads of these part numbers
+
+	ranges    map[int64]error         // fail every download from these offsets
+	rangeLags map[int64]time.Duration // delay downloads from these offsets
 }
 
-func (e *errCont) getError(name string) error {
-	if e.errMap == nil {
-		return nil
+// rangeError delays a download from offset, and returns its error, if the
+// offset has either.
+func (e *errCont) rangeError(offset int64) error {
+	gmux.Lock()
+	lag, err := e.rangeLags[offset], e.ranges[offset]
+	gmux.Unlock()
+	time.Sleep(lag)
+	return err
+}
+
+func (e *errCont) sha1(b []byte) string {
+	if e.badSHA {
+		b = append([]byte("corrupt"), b...)
 	}
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
+
+func (e *errCont) getError(name string) error {
+	err := e.nextError(name)
+	time.Sleep(e.lag)
+	return err
+}
+
+func (e *errCont) nextError(name string) error {
+	gmux.Lock()
+	defer gmux.Unlock()
 	if e.opMap == nil {
 		e.opMap = make(map[string]int)
 	}
 	i := e.opMap[name]
 	e.opMap[name]++
+	if e.expired {
+		return testError{reauth: true}
+	}
+	if e.errMap == nil {
+		return nil
+	}
 	return e.errMap[name][i]
 }
 
+func (e *errCont) count(name string) int {
+	gmux.Lock()
+	defer gmux.Unlock()
+	return e.opMap[name]
+}
+
 type testRoot struct {
-	errs      *errCont
-	auths     int
-	bucketMap map[string]map[string]string
+	errs        *errCont
+	auths       int
+	partSize    int
+	recPartSize int
+	bucketMap   map[string]map[string]string
+	lfs         map[string]*testLargeFile
+	metaMap     map[string]*testFile
+	attrMap     map[string]*BucketAttrs
+	keyMap      map[string]*testKey
+	noteMap     map[string][]NotificationRule
+	keyCount    int
+	allowance   allowance // what the authorizing key may do
+}
+
+func (t *testRoot) largeFiles() map[string]*testLargeFile {
+	if t.lfs == nil {
+		t.lfs = make(map[string]*testLargeFile)
+	}
+	return t.lfs
+}
+
+// metas holds the most recently written version of each file, by name.
+func (t *testRoot) metas() map[string]*testFile {
+	if t.metaMap == nil {
+		t.metaMap = make(map[string]*testFile)
+	}
+	return t.metaMap
+}
+
+// bucketAttrs holds the attributes of each bucket, by name.
+func (t *testRoot) bucketAttrs() map[string]*BucketAttrs {
+	if t.attrMap == nil {
+		t.attrMap = make(map[string]*BucketAttrs)
+	}
+	return t.attrMap
+}
+
+// notifications holds the event notification rules of each bucket, by name.
+func (t *testRoot) notifications() map[string][]NotificationRule {
+	if t.noteMap == nil {
+		t.noteMap = make(map[string][]NotificationRule)
+	}
+	return t.noteMap
+}
+
+// keys holds every application key that has been created, by ID.
+func (t *testRoot) keys() map[string]*testKey {
+	if t.keyMap == nil {
+		t.keyMap = make(map[string]*testKey)
+	}
+	return t.keyMap
 }
 
 func (t *testRoot) authorizeAccount(context.Context, string, string, clientOptions) error {
+	gmux.Lock()
+	defer gmux.Unlock()
 	t.auths++
+	if t.errs != nil {
+		t.errs.expired = false
+	}
 	return nil
 }
 
 func (t *testRoot) backoff(err error) time.Duration {
-	e, ok := err.(testError)
-	if !ok {
+	var e testError
+	if !errors.As(err, &e) {
 		return 0
 	}
 	return e.backoff
 }
 
 func (t *testRoot) reauth(err error) bool {
-	e, ok := err.(testError)
-	if !ok {
+	var e testError
+	if !errors.As(err, &e) {
 		return false
 	}
 	return e.reauth
 }
 
 func (t *testRoot) reupload(err error) bool {
-	e, ok := err.(testError)
-	if !ok {
+	var e testError
+	if !errors.As(err, &e) {
 		return false
 	}
 	return e.reupload
 }
 
+func (t *testRoot) statusCode(err error) int {
+	var e testError
+	if !errors.As(err, &e) {
+		return 0
+	}
+	return e.status
+}
+
+func (t *testRoot) b2Error(err error) error {
+	var e testError
+	if !errors.As(err, &e) || e.status == 0 {
+		return err
+	}
+	return &Error{Status: e.status, err: err}
+}
+
+func (t *testRoot) minPartSize() int         { return t.partSize }
+func (t *testRoot) recommendedPartSize() int { return t.recPartSize }
+func (t *testRoot) allowed() allowance       { return t.allowance }
+func (t *testRoot) s3Endpoint() string       { return "" }
+
 func (t *testRoot) transient(err error) bool {
-	e, ok := err.(testError)
-	if !ok {
+	var e testError
+	if !errors.As(err, &e) {
 		return false
 	}
-	return e.retry || e.reupload || e.backoff > 0
+	return e.retry || e.backoff > 0
 }
 
-func (t *testRoot) createKey(context.Context, string, []string, time.Duration, string, string) (b2KeyInterface, error) {
-	return nil, nil
+func (t *testRoot) createKey(_ context.Context, name string, caps []string, valid time.Duration, bucketID, prefix string) (b2KeyInterface, error) {
+	if err := t.errs.getError("createKey"); err != nil {
+		return nil, err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	t.keyCount++
+	k := &testKey{
+		r:      t,
+		n:      name,
+		i:      fmt.Sprintf("key%04d", t.keyCount),
+		s:      fmt.Sprintf("secret%04d", t.keyCount),
+		cs:     caps,
+		bucket: bucketID,
+		pfx:    prefix,
+	}
+	if valid > 0 {
+		k.exp = time.Now().Add(valid)
+	}
+	t.keys()[k.i] = k
+	return k, nil
 }
-func (t *testRoot) listKeys(context.Context, int, string) ([]b2KeyInterface, string, error) {
-	return nil, "", nil
+
+// listKeys returns keys in ID order, starting at cursor, without their secrets.
+func (t *testRoot) listKeys(_ context.Context, count int, cursor string) ([]b2KeyInterface, string, error) {
+	if err := t.errs.getError("listKeys"); err != nil {
+		return nil, "", err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if count == 0 {
+		count = 100
+	}
+	var ids []string
+	for id := range t.keys() {
+		if id >= cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var next string
+	if len(ids) > count {
+		next = ids[count]
+		ids = ids[:count]
+	}
+	var keys []b2KeyInterface
+	for _, id := range ids {
+		k := *t.keyMap[id]
+		k.s = ""
+		keys = append(keys, &k)
+	}
+	return keys, next, nil
 }
 
-func (t *testRoot) createBucket(_ context.Context, name, _ string, _ map[string]string, _ []LifecycleRule) (b2BucketInterface, error) {
+func (t *testRoot) createBucket(_ context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *ServerSideEncryption, lock bool, repl *Replication) (b2BucketInterface, error) {
 	if err := t.errs.getError("createBucket"); err != nil {
 		return nil, err
 	}
@@ -124,37 +362,194 @@ func (t *testRoot) createBucket(_ context.Context, name, _ string, _ map[string]
 	}
 	m := make(map[string]string)
 	t.bucketMap[name] = m
+	attrs := &BucketAttrs{
+		Type:                        BucketType(btype),
+		Info:                        info,
+		LifecycleRules:              rules,
+		CORSRules:                   cors,
+		DefaultServerSideEncryption: sse,
+		ObjectLock:                  lock,
+		Replication:                 repl,
+	}
+	t.bucketAttrs()[name] = attrs
 	return &testBucket{
 		n:     name,
 		errs:  t.errs,
 		files: m,
+		all:   t.bucketMap,
+		lfs:   t.largeFiles(),
+		meta:  t.metas(),
+		ba:    attrs,
+		notes: t.notifications(),
 	}, nil
 }
 
-func (t *testRoot) listBuckets(context.Context) ([]b2BucketInterface, error) {
+func (t *testRoot) listBuckets(_ context.Context, id, name string, types []string) ([]b2BucketInterface, error) {
+	if err := t.errs.getError("listBuckets"); err != nil {
+		return nil, err
+	}
 	var b []b2BucketInterface
 	for k, v := range t.bucketMap {
+		// A test bucket's ID is its name.
+		if (id != "" && k != id) || (name != "" && k != name) {
+			continue
+		}
+		if len(types) > 0 {
+			var btype string
+			if ba := t.bucketAttrs()[k]; ba != nil {
+				btype = string(ba.Type)
+			}
+			var ok bool
+			for _, typ := range types {
+				ok = ok || typ == btype || typ == "all"
+			}
+			if !ok {
+				continue
+			}
+		}
 		b = append(b, &testBucket{
 			n:     k,
 			errs:  t.errs,
 			files: v,
+			all:   t.bucketMap,
+			lfs:   t.largeFiles(),
+			meta:  t.metas(),
+			ba:    t.bucketAttrs()[k],
+			notes: t.notifications(),
 		})
 	}
 	return b, nil
 }
 
+type testKey struct {
+	r      *testRoot
+	n      string
+	i      string
+	s      string
+	cs     []string
+	exp    time.Time
+	bucket string
+	pfx    string
+}
+
+func (t *testKey) del(context.Context) error {
+	if err := t.r.errs.getError("deleteKey"); err != nil {
+		return err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if _, ok := t.r.keys()[t.i]; !ok {
+		return fmt.Errorf("%s: no such key", t.i)
+	}
+	delete(t.r.keys(), t.i)
+	return nil
+}
+
+func (t *testKey) caps() []string     { return t.cs }
+func (t *testKey) name() string       { return t.n }
+func (t *testKey) expires() time.Time { return t.exp }
+func (t *testKey) secret() string     { return t.s }
+func (t *testKey) id() string         { return t.i }
+func (t *testKey) bucketID() string   { return t.bucket }
+func (t *testKey) prefix() string     { return t.pfx }
+
 type testBucket struct {
 	n     string
 	errs  *errCont
 	files map[string]string
+	all   map[string]map[string]string
+	lfs   map[string]*testLargeFile
+	meta  map[string]*testFile
+	ba    *BucketAttrs
+	notes map[string][]NotificationRule
+}
+
+func (t *testBucket) getNotificationRules(context.Context) ([]NotificationRule, error) {
+	if err := t.errs.getError("getNotificationRules"); err != nil {
+		return nil, err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	return append([]NotificationRule(nil), t.notes[t.n]...), nil
+}
+
+func (t *testBucket) setNotificationRules(_ context.Context, rules []NotificationRule) ([]NotificationRule, error) {
+	if err := t.errs.getError("setNotificationRules"); err != nil {
+		return nil, err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	t.notes[t.n] = append([]NotificationRule(nil), rules...)
+	return append([]NotificationRule(nil), rules...), nil
+}
+
+// find returns the contents of the complete file with the given ID, which, in
+// the fake, is its name.
+func (t *testBucket) find(id string) (string, bool) {
+	for _, files := range t.all {
+		if f, ok := files[id]; ok {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+func (t *testBucket) name() string                       { return t.n }
+func (t *testBucket) btype() string                      { return "allPrivate" }
+func (t *testBucket) deleteBucket(context.Context) error { return nil }
+func (t *testBucket) id() string                         { return t.n }
+func (t *testBucket) revision() int                      { return 0 }
+
+func (t *testBucket) attrs() *BucketAttrs {
+	gmux.Lock()
+	defer gmux.Unlock()
+	if t.ba == nil {
+		return nil
+	}
+	a := *t.ba
+	return &a
 }
 
-func (t *testBucket) name() string                                     { return t.n }
-func (t *testBucket) btype() string                                    { return "allPrivate" }
-func (t *testBucket) attrs() *BucketAttrs                              { return nil }
-func (t *testBucket) deleteBucket(context.Context) error               { return nil }
-func (t *testBucket) updateBucket(context.Context, *BucketAttrs) error { return nil }
-func (t *testBucket) id() string                                       { return "" }
+// updateBucket changes the bucket as b2Bucket.updateBucket does.
+func (t *testBucket) updateBucket(_ context.Context, attrs *BucketAttrs) error {
+	if err := t.errs.getError("updateBucket"); err != nil {
+		return err
+	}
+	if attrs == nil || t.ba == nil {
+		return nil
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if attrs.Type != UnknownType {
+		t.ba.Type = attrs.Type
+	}
+	if attrs.Info != nil {
+		t.ba.Info = attrs.Info
+	}
+	if attrs.LifecycleRules != nil {
+		t.ba.LifecycleRules = attrs.LifecycleRules
+	}
+	if attrs.CORSRules != nil {
+		t.ba.CORSRules = attrs.CORSRules
+	}
+	if attrs.DefaultServerSideEncryption != nil {
+		t.ba.DefaultServerSideEncryption = attrs.DefaultServerSideEncryption
+	}
+	if r := attrs.Replication; r != nil {
+		t.ba.Replication = nil
+		if r.AsSource != nil || r.AsDestination != nil {
+			t.ba.Replication = r
+		}
+	}
+	if r := attrs.DefaultRetention; r != nil {
+		t.ba.DefaultRetention = nil
+		if r.Mode != "" {
+			dr := *r
+			t.ba.DefaultRetention = &dr
+		}
+	}
+	return nil
+}
 
 func (t *testBucket) getUploadURL(context.Context) (b2URLInterface, error) {
 	if err := t.errs.getError("getUploadURL"); err != nil {
@@ -162,23 +557,87 @@ func (t *testBucket) getUploadURL(context.Context) (b2URLInterface, error) {
 	}
 	return &testURL{
 		files: t.files,
+		meta:  t.meta,
+		errs:  t.errs,
 	}, nil
 }
 
-func (t *testBucket) startLargeFile(_ context.Context, name, _ string, _ map[string]string) (b2LargeFileInterface, error) {
-	return &testLargeFile{
+func (t *testBucket) startLargeFile(_ context.Context, name, ct string, info map[string]string, sse *ServerSideEncryption, ret *Retention, hold bool) (b2LargeFileInterface, error) {
+	gmux.Lock()
+	defer gmux.Unlock()
+	lf := &testLargeFile{
+		fid:   fmt.Sprintf("%s-%d", name, len(t.lfs)),
 		name:  name,
+		ct:    ct,
+		info:  info,
+		sse:   sse,
+		ret:   ret,
+		hold:  hold,
 		parts: make(map[int][]byte),
 		files: t.files,
+		meta:  t.meta,
+		bkt:   t,
 		errs:  t.errs,
-	}, nil
+
+		started: time.Now(),
+	}
+	t.lfs[lf.fid] = lf
+	return lf, nil
 }
 
-func (t *testBucket) listFileNames(ctx context.Context, count int, cont, pfx, del string) ([]b2FileInterface, string, error) {
-	var f []string
+func (t *testBucket) listFileNames(_ context.Context, count int, cont, pfx, del string) ([]b2FileInterface, string, error) {
+	if err := t.errs.getError("listFileNames"); err != nil {
+		return nil, "", err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	b, next := t.list(count, cont, pfx, del, false)
+	return b, next, nil
+}
+
+// listFileVersions lists every version of each file, newest first.  The fake
+// pages by name, so a page may hold more than count versions.
+func (t *testBucket) listFileVersions(_ context.Context, count int, name, _, pfx, del string) ([]b2FileInterface, string, string, error) {
 	gmux.Lock()
 	defer gmux.Unlock()
+	fs, next := t.list(count, name, pfx, del, true)
+	var vs []b2FileInterface
+	for _, f := range fs {
+		m, ok := t.meta[f.name()]
+		if !ok || f.status() == "folder" {
+			vs = append(vs, f)
+			continue
+		}
+		for ; m != nil; m = m.prev {
+			vs = append(vs, m)
+		}
+	}
+	return vs, next, next, nil
+}
+
+// list returns a page of file names starting at cont.  gmux must be held.
+func (t *testBucket) list(count int, cont, pfx, del string, hidden bool) ([]b2FileInterface, string) {
+	if count == 0 {
+		count = 100 // B2's default
+	}
+	var f []string
+	// As B2 does, collapse names that continue past the delimiter into a
+	// single "folder" entry.
+	folders := make(map[string]bool)
 	for name := range t.files {
+		if !strings.HasPrefix(name, pfx) {
+			continue
+		}
+		if m, ok := t.meta[name]; ok && m.a == "hide" && !hidden {
+			continue
+		}
+		if i := strings.Index(name[len(pfx):], del); del != "" && i >= 0 {
+			name = name[:len(pfx)+i+len(del)]
+			if folders[name] {
+				continue
+			}
+			folders[name] = true
+		}
 		f = append(f, name)
 	}
 	sort.Strings(f)
@@ -186,11 +645,19 @@ func (t *testBucket) listFileNames(ctx context.Context, count int, cont, pfx, de
 	var b []b2FileInterface
 	var next string
 	for i := idx; i < len(f) && i-idx < count; i++ {
-		b = append(b, &testFile{
+		tf := &testFile{
 			n:     f[i],
 			s:     int64(len(t.files[f[i]])),
+			fid:   f[i],
 			files: t.files,
-		})
+		}
+		if folders[f[i]] {
+			tf.a = "folder"
+		}
+		if m, ok := t.meta[f[i]]; ok {
+			tf.sha, tf.ct, tf.info = m.sha, m.ct, m.info
+		}
+		b = append(b, tf)
 		if i+1 < len(f) {
 			next = f[i+1]
 		}
@@ -198,87 +665,335 @@ func (t *testBucket) listFileNames(ctx context.Context, count int, cont, pfx, de
 			next = ""
 		}
 	}
-	return b, next, nil
+	return b, next
+}
+
+// listUnfinishedLargeFiles lists the large files started in t, in order of
+// ID, from cont on.
+func (t *testBucket) listUnfinishedLargeFiles(_ context.Context, count int, cont string) ([]b2FileInterface, string, error) {
+	if err := t.errs.getError("listUnfinishedLargeFiles"); err != nil {
+		return nil, "", err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	var ids []string
+	for id, lf := range t.lfs {
+		if lf.bkt.n == t.n && !lf.ended && id >= cont {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if count < 1 {
+		count = 100 // B2's default
+	}
+	var next string
+	if len(ids) > count {
+		ids, next = ids[:count], ids[count]
+	}
+	var fs []b2FileInterface
+	for _, id := range ids {
+		lf := t.lfs[id]
+		fs = append(fs, &testFile{n: lf.name, fid: lf.fid, t: lf.started, a: "start", ct: lf.ct, info: lf.info, lf: lf})
+	}
+	return fs, next, nil
 }
 
-func (t *testBucket) listFileVersions(ctx context.Context, count int, a, b, c, d string) ([]b2FileInterface, string, string, error) {
-	x, y, z := t.listFileNames(ctx, count, a, c, d)
-	return x, y, "", z
+// The fake uses object names as the IDs of complete files.
+func (t *testBucket) downloadFileByID(ctx context.Context, id string, offset, size int64, sse *ServerSideEncryption) (b2FileReaderInterface, error) {
+	if err := t.errs.getError("downloadFileByID"); err != nil {
+		return nil, err
+	}
+	if err := t.errs.rangeError(offset); err != nil {
+		return nil, err
+	}
+	return t.download(id, offset, size, sse)
 }
 
-func (t *testBucket) listUnfinishedLargeFiles(ctx context.Context, count int, cont string) ([]b2FileInterface, string, error) {
-	return nil, "", fmt.Errorf("testBucket.listUnfinishedLargeFiles(ctx, %d, %q): not implemented", count, cont)
+func (t *testBucket) downloadFileByName(_ context.Context, name string, offset, size int64, sse *ServerSideEncryption) (b2FileReaderInterface, error) {
+	if err := t.errs.getError("downloadFileByName"); err != nil {
+		return nil, err
+	}
+	if err := t.errs.rangeError(offset); err != nil {
+		return nil, err
+	}
+	return t.download(name, offset, size, sse)
 }
 
-func (t *testBucket) downloadFileByName(_ context.Context, name string, offset, size int64) (b2FileReaderInterface, error) {
+func (t *testBucket) download(name string, offset, size int64, sse *ServerSideEncryption) (b2FileReaderInterface, error) {
 	gmux.Lock()
 	defer gmux.Unlock()
-	f := t.files[name]
+	if err := t.checkKey(name, sse); err != nil {
+		return nil, err
+	}
+	f, ok := t.files[name]
+	if !ok {
+		return nil, b2err{err: fmt.Errorf("%s: not found", name), notFoundErr: true}
+	}
 	end := int(offset + size)
-	if end >= len(f) {
+	if size == 0 || end >= len(f) {
 		end = len(f)
 	}
 	if int(offset) >= len(f) {
 		return nil, errNoMoreContent
 	}
-	return &testFileReader{
-		b: ioutil.NopCloser(bytes.NewBufferString(f[offset:end])),
-		s: end - int(offset),
-		n: name,
-	}, nil
+	fr := &testFileReader{
+		b:   ioutil.NopCloser(bytes.NewBufferString(f[offset:end])),
+		s:   end - int(offset),
+		n:   name,
+		tot: int64(len(f)),
+	}
+	if m, ok := t.meta[name]; ok {
+		fr.sha, fr.ct, fr.info, fr.t, fr.sse = m.sha, m.ct, m.info, m.t, m.sse
+	}
+	return fr, nil
+}
+
+// checkKey fails, as B2 would, if the named file was written with SSE-C and
+// sse does not hold the same key.  gmux must be held.
+func (t *testBucket) checkKey(name string, sse *ServerSideEncryption) error {
+	m, ok := t.meta[name]
+	if !ok || m.sse == nil || m.sse.Mode != SSEC {
+		return nil
+	}
+	if sse == nil || !bytes.Equal(sse.Key, m.sse.Key) {
+		return fmt.Errorf("%s: 400: file is encrypted with SSE-C: %w", name, ErrCustomerKey)
+	}
+	return nil
+}
+
+func (t *testBucket) hideFile(_ context.Context, name string) (b2FileInterface, error) {
+	gmux.Lock()
+	defer gmux.Unlock()
+	f := &testFile{
+		n:     name,
+		t:     time.Now(),
+		a:     "hide",
+		fid:   name,
+		files: t.files,
+		meta:  t.meta,
+		prev:  t.meta[name],
+	}
+	t.meta[name] = f
+	return f, nil
+}
+
+func (t *testBucket) copyFile(_ context.Context, srcID, name string, replace bool, ct string, info map[string]string, ret *Retention, srcSSE, dstSSE *ServerSideEncryption) (b2FileInterface, error) {
+	if err := t.errs.getError("copyFile"); err != nil {
+		return nil, err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if err := t.checkKey(srcID, srcSSE); err != nil {
+		return nil, err
+	}
+	src, ok := t.find(srcID)
+	if !ok {
+		return nil, fmt.Errorf("copyFile(%q): not found", srcID)
+	}
+	t.files[name] = src
+	f := &testFile{
+		n:     name,
+		s:     int64(len(src)),
+		data:  src,
+		t:     time.Now(),
+		a:     "upload",
+		fid:   name,
+		ct:    ct,
+		info:  info,
+		sse:   dstSSE,
+		ret:   ret,
+		files: t.files,
+		meta:  t.meta,
+		prev:  t.meta[name],
+	}
+	if m, ok := t.meta[srcID]; ok {
+		f.sha = m.sha
+		if !replace {
+			f.ct, f.info = m.ct, m.info
+		}
+	}
+	t.meta[name] = f
+	return f, nil
 }
+func (t *testBucket) getDownloadAuthorization(_ context.Context, prefix string, valid time.Duration, cd string) (string, error) {
+	if err := t.errs.getError("getDownloadAuthorization"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%d/%s", t.n, prefix, int(valid.Seconds()), cd), nil
+}
+func (t *testBucket) baseURL() string { return "" }
 
-func (t *testBucket) hideFile(context.Context, string) (b2FileInterface, error) { return nil, nil }
-func (t *testBucket) getDownloadAuthorization(context.Context, string, time.Duration, string) (string, error) {
-	return "", nil
+func (t *testBucket) file(id, name string) b2FileInterface {
+	gmux.Lock()
+	defer gmux.Unlock()
+	f := &testFile{
+		n:     name,
+		fid:   id,
+		lf:    t.lfs[id],
+		files: t.files,
+	}
+	if m, ok := t.meta[id]; ok && f.lf == nil {
+		f.s, f.t, f.a, f.sha, f.ct, f.info, f.sse = m.s, m.t, m.a, m.sha, m.ct, m.info, m.sse
+	}
+	return f
 }
-func (t *testBucket) baseURL() string                      { return "" }
-func (t *testBucket) file(id, name string) b2FileInterface { return nil }
 
 type testURL struct {
 	files map[string]string
+	meta  map[string]*testFile
+	errs  *errCont
 }
 
 func (t *testURL) reload(context.Context) error { return nil }
 
-func (t *testURL) uploadFile(_ context.Context, r io.Reader, _ int, name, _, _ string, _ map[string]string) (b2FileInterface, error) {
+func (t *testURL) uploadFile(_ context.Context, r io.Reader, _ int, name, ct, sha string, info map[string]string, sse *ServerSideEncryption, ret *Retention, hold bool) (b2FileInterface, error) {
+	if err := t.errs.getError("uploadFile"); err != nil {
+		return nil, err
+	}
 	buf := &bytes.Buffer{}
 	if _, err := io.Copy(buf, r); err != nil {
 		return nil, err
 	}
+	if sha == "hex_digits_at_end" {
+		if err := trimHexSHA1(buf); err != nil {
+			return nil, err
+		}
+	}
 	gmux.Lock()
 	defer gmux.Unlock()
 	t.files[name] = buf.String()
-	return &testFile{
+	f := &testFile{
 		n:     name,
 		s:     int64(len(t.files[name])),
+		data:  t.files[name],
+		t:     time.Now(),
+		a:     "upload",
+		sha:   t.errs.sha1(buf.Bytes()),
+		fid:   name,
+		ct:    ct,
+		info:  info,
+		sse:   sse,
+		ret:   ret,
+		hold:  hold,
 		files: t.files,
-	}, nil
+		meta:  t.meta,
+		prev:  t.meta[name],
+	}
+	t.meta[name] = f
+	return f, nil
+}
+
+// trimHexSHA1 removes the hex SHA1 that follows data sent with
+// "hex_digits_at_end", and checks it, as B2 would.
+func trimHexSHA1(buf *bytes.Buffer) error {
+	n := buf.Len() - 40
+	if n < 0 {
+		return errors.New("sha1 missing from end of data")
+	}
+	if got := fmt.Sprintf("%x", sha1.Sum(buf.Bytes()[:n])); got != string(buf.Bytes()[n:]) {
+		return errors.New("sha1 mismatch")
+	}
+	buf.Truncate(n)
+	return nil
 }
 
 type testLargeFile struct {
+	fid   string
 	name  string
+	ct    string
+	info  map[string]string
+	sse   *ServerSideEncryption
+	ret   *Retention
+	hold  bool
 	parts map[int][]byte
 	files map[string]string
+	meta  map[string]*testFile
+	bkt   *testBucket
 	errs  *errCont
+
+	started time.Time
+	ended   bool // finished or cancelled
+}
+
+func (t *testLargeFile) id() string { return t.fid }
+
+func (t *testLargeFile) hashes() map[int]string {
+	gmux.Lock()
+	defer gmux.Unlock()
+	h := make(map[int]string)
+	for i, p := range t.parts {
+		h[i] = t.errs.sha1(p)
+	}
+	return h
 }
 
 func (t *testLargeFile) finishLargeFile(context.Context) (b2FileInterface, error) {
+	if err := t.errs.nextError("finishLargeFile"); err != nil {
+		return nil, err
+	}
 	var total []byte
 	gmux.Lock()
 	defer gmux.Unlock()
+	t.ended = true
 	for i := 1; i <= len(t.parts); i++ {
 		total = append(total, t.parts[i]...)
 	}
 	t.files[t.name] = string(total)
-	return &testFile{
+	f := &testFile{
 		n:     t.name,
 		s:     int64(len(total)),
+		data:  string(total),
+		t:     time.Now(),
+		a:     "upload",
+		sha:   "none",
+		fid:   t.name,
+		ct:    t.ct,
+		info:  t.info,
+		sse:   t.sse,
+		ret:   t.ret,
+		hold:  t.hold,
 		files: t.files,
-	}, nil
+		meta:  t.meta,
+		prev:  t.meta[t.name],
+	}
+	t.meta[t.name] = f
+	return f, nil
+}
+
+func (t *testLargeFile) cancelLargeFile(context.Context) error {
+	if err := t.errs.getError("cancelLargeFile"); err != nil {
+		return err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if t.ended {
+		return b2err{err: fmt.Errorf("%s: not found", t.fid), notFoundErr: true}
+	}
+	t.ended = true
+	return nil
+}
+
+func (t *testLargeFile) copyPart(_ context.Context, srcID string, part int, offset, size int64, srcSSE, _ *ServerSideEncryption) error {
+	if err := t.errs.getError("copyPart"); err != nil {
+		return err
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	if err := t.bkt.checkKey(srcID, srcSSE); err != nil {
+		return err
+	}
+	src, ok := t.bkt.find(srcID)
+	if !ok || offset+size > int64(len(src)) {
+		return fmt.Errorf("copyPart(%q, %d, %d, %d): no such range", srcID, part, offset, size)
+	}
+	t.parts[part] = []byte(src[offset : offset+size])
+	return nil
 }
 
 func (t *testLargeFile) getUploadPartURL(context.Context) (b2FileChunkInterface, error) {
+	if err := t.errs.nextError("getUploadPartURL"); err != nil {
+		return nil, err
+	}
 	gmux.Lock()
 	defer gmux.Unlock()
 	return &testFileChunk{
@@ -294,15 +1009,37 @@ type testFileChunk struct {
 
 func (t *testFileChunk) reload(context.Context) error { return nil }
 
-func (t *testFileChunk) uploadPart(_ context.Context, r io.Reader, _ string, _, index int) (int, error) {
+func (t *testFileChunk) uploadPart(ctx context.Context, r io.Reader, sha string, _, index int, _ *ServerSideEncryption) (int, error) {
 	if err := t.errs.getError("uploadPart"); err != nil {
 		return 0, err
 	}
+	gmux.Lock()
+	perr := t.errs.parts[index]
+	stall := t.errs.stalls[index] > 0
+	if stall {
+		t.errs.stalls[index]--
+	}
+	gmux.Unlock()
+	if stall {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	if perr != nil {
+		return 0, perr
+	}
 	buf := &bytes.Buffer{}
 	i, err := io.Copy(buf, r)
 	if err != nil {
 		return int(i), err
 	}
+	if sha == "hex_digits_at_end" {
+		if err := trimHexSHA1(buf); err != nil {
+			return int(i), fmt.Errorf("part %d: %w", index, err)
+		}
+	} else if sha != fmt.Sprintf("%x", sha1.Sum(buf.Bytes())) {
+		// As B2 would, reject parts that don't match their hash.
+		return int(i), fmt.Errorf("part %d: sha1 mismatch", index)
+	}
 	gmux.Lock()
 	defer gmux.Unlock()
 	t.parts[index] = buf.Bytes()
@@ -314,43 +1051,185 @@ type testFile struct {
 	s     int64
 	t     time.Time
 	a     string
+	sha   string
+	ct    string
+	info  map[string]string
+	sse   *ServerSideEncryption
+	ret   *Retention
+	hold  bool
+	fid   string
+	data  string // the contents of this version
+	lf    *testLargeFile
 	files map[string]string
+	meta  map[string]*testFile
+	prev  *testFile // the version this one replaced
 }
 
+func (t *testFile) id() string { return t.fid }
+
 func (t *testFile) name() string         { return t.n }
 func (t *testFile) size() int64          { return t.s }
+func (t *testFile) sha1() string         { return t.sha }
 func (t *testFile) timestamp() time.Time { return t.t }
 func (t *testFile) status() string       { return t.a }
 
 func (t *testFile) compileParts(int64, map[int]string) b2LargeFileInterface {
-	panic("not implemented")
+	if t.lf == nil {
+		panic("not implemented")
+	}
+	return t.lf
 }
 
 func (t *testFile) getFileInfo(context.Context) (b2FileInfoInterface, error) {
-	return nil, nil
+	info := make(map[string]string)
+	for k, v := range t.info {
+		info[k] = v
+	}
+	status := t.a
+	if status == "" {
+		status = "upload"
+	}
+	return &testFileInfo{
+		name:   t.n,
+		status: status,
+		sha:    t.sha,
+		size:   t.s,
+		ct:     t.ct,
+		info:   info,
+		sse:    t.sse,
+		ret:    t.ret,
+		hold:   t.hold,
+		stamp:  t.t,
+	}, nil
 }
 
-func (t *testFile) listParts(context.Context, int, int) ([]b2FilePartInterface, int, error) {
-	return nil, 0, nil
+type testFileInfo struct {
+	name, sha, ct string
+	status        string
+	size          int64
+	info          map[string]string
+	sse           *ServerSideEncryption
+	ret           *Retention
+	hold          bool
+	stamp         time.Time
 }
 
-func (t *testFile) deleteFileVersion(context.Context) error {
+func (t *testFileInfo) encryption() *ServerSideEncryption { return t.sse }
+func (t *testFileInfo) retention() *Retention             { return t.ret }
+func (t *testFileInfo) legalHold() bool                   { return t.hold }
+
+func (t *testFileInfo) stats() (string, string, int64, string, map[string]string, string, time.Time) {
+	return t.name, t.sha, t.size, t.ct, t.info, t.status, t.stamp
+}
+
+func (t *testFile) listParts(_ context.Context, next, count int) ([]b2FilePartInterface, int, error) {
+	if t.lf == nil {
+		return nil, 0, nil
+	}
+	gmux.Lock()
+	defer gmux.Unlock()
+	var ids []int
+	for i := range t.lf.parts {
+		if i >= next {
+			ids = append(ids, i)
+		}
+	}
+	sort.Ints(ids)
+	var parts []b2FilePartInterface
+	var n int
+	for i, id := range ids {
+		if i == count {
+			n = id
+			break
+		}
+		p := t.lf.parts[id]
+		parts = append(parts, &testFilePart{
+			n:   id,
+			sha: fmt.Sprintf("%x", sha1.Sum(p)),
+			s:   int64(len(p)),
+		})
+	}
+	return parts, n, nil
+}
+
+type testFilePart struct {
+	n   int
+	sha string
+	s   int64
+}
+
+func (t *testFilePart) number() int  { return t.n }
+func (t *testFilePart) sha1() string { return t.sha }
+func (t *testFilePart) size() int64  { return t.s }
+
+func (t *testFile) updateFileRetention(_ context.Context, r *Retention, _ bool) error {
+	gmux.Lock()
+	defer gmux.Unlock()
+	t.ret = r
+	return nil
+}
+
+func (t *testFile) updateFileLegalHold(_ context.Context, on bool) error {
 	gmux.Lock()
 	defer gmux.Unlock()
-	delete(t.files, t.n)
+	t.hold = on
 	return nil
 }
 
+func (t *testFile) deleteFileVersion(context.Context) error {
+	gmux.Lock()
+	defer gmux.Unlock()
+	cur, ok := t.meta[t.n]
+	if !ok {
+		return b2err{err: fmt.Errorf("%s: not found", t.fid), notFoundErr: true}
+	}
+	if cur == t {
+		if t.prev == nil {
+			delete(t.meta, t.n)
+			delete(t.files, t.n)
+			return nil
+		}
+		t.meta[t.n] = t.prev
+		if t.prev.a == "upload" {
+			t.files[t.n] = t.prev.data
+		}
+		return nil
+	}
+	for v := cur; v.prev != nil; v = v.prev {
+		if v.prev == t {
+			v.prev = t.prev
+			return nil
+		}
+	}
+	return b2err{err: fmt.Errorf("%s: not found", t.fid), notFoundErr: true}
+}
+
 type testFileReader struct {
-	b io.ReadCloser
-	s int
-	n string
+	b    io.ReadCloser
+	s    int
+	n    string
+	tot  int64
+	sha  string
+	ct   string
+	info map[string]string
+	t    time.Time
+	sse  *ServerSideEncryption
 }
 
-func (t *testFileReader) Read(p []byte) (int, error)                      { return t.b.Read(p) }
-func (t *testFileReader) Close() error                                    { return nil }
-func (t *testFileReader) stats() (int, string, string, map[string]string) { return t.s, "", "", nil }
-func (t *testFileReader) id() string                                      { return t.n }
+func (t *testFileReader) Read(p []byte) (int, error) { return t.b.Read(p) }
+func (t *testFileReader) Close() error               { return nil }
+func (t *testFileReader) stats() (int, string, string, map[string]string) {
+	info := make(map[string]string)
+	for k, v := range t.info {
+		info[k] = v
+	}
+	return t.s, t.ct, t.sha, info
+}
+func (t *testFileReader) id() string                        { return t.n }
+func (t *testFileReader) name() string                      { return t.n }
+func (t *testFileReader) size() int64                       { return t.tot }
+func (t *testFileReader) timestamp() time.Time              { return t.t }
+func (t *testFileReader) encryption() *ServerSideEncryption { return t.sse }
 
 type zReader struct{}
 
@@ -465,18 +1344,69 @@ func TestReauth(t *testing.T) {
 	}
 }
 
-func TestBackoff(t *testing.T) {
+func TestReauthConcurrent(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	var calls []time.Duration
-	ch := make(chan time.Time)
-	close(ch)
-	after = func(d time.Duration) <-chan time.Time {
-		calls = append(calls, d)
-		return ch
+	// Slow every call down so that the requests overlap, and all of them are
+	// made with the expired token.
+	root.errs.lag = 20 * time.Millisecond
+	gmux.Lock()
+	root.errs.expired = true
+	auths := root.auths
+	gmux.Unlock()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := client.ListBuckets(ctx)
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, _, err := writeFile(ctx, bucket, fmt.Sprintf("file%d", i), 1e3, 1e8)
+			errs <- err
+		}(i)
 	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if root.auths != auths+1 {
+		t.Errorf("got %d reauthorizations, want 1", root.auths-auths)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	clk := &fakeClock{auto: true}
 
 	table := []struct {
 		root *testRoot
@@ -516,6 +1446,7 @@ func TestBackoff(t *testing.T) {
 		client := &Client{
 			backend: &beRoot{
 				b2i: ent.root,
+				clk: clk,
 			},
 		}
 		b, err := client.NewBucket(ctx, "fun", &BucketAttrs{Type: Private})
@@ -532,8 +1463,8 @@ func TestBackoff(t *testing.T) {
 		}
 		total += ent.want
 	}
-	if len(calls) != total {
-		t.Errorf("got %d calls, wanted %d", len(calls), total)
+	if len(clk.waits()) != total {
+		t.Errorf("got %d calls, wanted %d", len(clk.waits()), total)
 	}
 }
 
@@ -542,13 +1473,7 @@ func TestBackoffWithoutRetryAfter(t *testing.T) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var calls []time.Duration
-	ch := make(chan time.Time)
-	close(ch)
-	after = func(d time.Duration) <-chan time.Time {
-		calls = append(calls, d)
-		return ch
-	}
+	clk := &fakeClock{auto: true}
 
 	root := &testRoot{
 		bucketMap: make(map[string]map[string]string),
@@ -564,123 +1489,7330 @@ func TestBackoffWithoutRetryAfter(t *testing.T) {
 	client := &Client{
 		backend: &beRoot{
 			b2i: root,
+			clk: clk,
 		},
 	}
 	if _, err := client.NewBucket(ctx, "fun", &BucketAttrs{Type: Private}); err != nil {
 		t.Errorf("bucket should not err, got %v", err)
 	}
-	if len(calls) != 2 {
-		t.Errorf("wrong number of backoff calls; got %d, want 2", len(calls))
+	if len(clk.waits()) != 2 {
+		t.Errorf("wrong number of backoff calls; got %d, want 2", len(clk.waits()))
 	}
 }
 
-type badTransport struct{}
+func TestWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-func (badTransport) RoundTrip(r *http.Request) (*http.Response, error) {
-	return &http.Response{
-		Status:     "700 What",
-		StatusCode: 700,
-		Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
-		Request:    r,
-	}, nil
+	unavailable := testError{retry: true, status: 503}
+	policy := &ExponentialBackoff{
+		MaxAttempts: 8,
+		Base:        100 * time.Millisecond,
+		Max:         time.Second,
+		Retryable:   []int{503},
+	}
+	table := []struct {
+		desc  string
+		errs  map[int]error
+		want  []time.Duration
+		fails bool
+	}{
+		{
+			desc: "exponential growth and cap",
+			errs: map[int]error{0: unavailable, 1: unavailable, 2: unavailable, 3: unavailable, 4: unavailable, 5: unavailable},
+			want: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			desc:  "max attempts",
+			errs:  map[int]error{0: unavailable, 1: unavailable, 2: unavailable, 3: unavailable, 4: unavailable, 5: unavailable, 6: unavailable, 7: unavailable},
+			want:  []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second, time.Second},
+			fails: true,
+		},
+		{
+			desc:  "status not retryable",
+			errs:  map[int]error{0: testError{status: 400}},
+			fails: true,
+		},
+		{
+			desc: "server asks for longer",
+			errs: map[int]error{0: testError{backoff: 5 * time.Second, status: 503}},
+			want: []time.Duration{5 * time.Second},
+		},
+	}
+	for _, e := range table {
+		clk := &fakeClock{auto: true}
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs: &errCont{
+				errMap: map[string]map[int]error{
+					"createBucket": e.errs,
+				},
+			},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i:    root,
+				policy: policy,
+				clk:    clk,
+			},
+		}
+		_, err := client.NewBucket(ctx, "fun", &BucketAttrs{Type: Private})
+		if (err != nil) != e.fails {
+			t.Errorf("%s: NewBucket: got %v, want error %v", e.desc, err, e.fails)
+		}
+		if got := clk.waits(); !reflect.DeepEqual(got, e.want) {
+			t.Errorf("%s: got waits %v, want %v", e.desc, got, e.want)
+		}
+	}
 }
 
-func TestCustomTransport(t *testing.T) {
+func TestWithBackoffRestartsUploads(t *testing.T) {
 	ctx := context.Background()
-	// Sorta fragile but...
-	_, err := NewClient(ctx, "abcd", "efgh", Transport(badTransport{}))
-	if err == nil {
-		t.Error("NewClient returned successfully, expected an error")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		desc string
+		err  error
+	}{
+		{desc: "expired upload token", err: testError{reupload: true, status: 401}},
+		{desc: "auth token in use", err: testError{reupload: true, status: 400}},
+		{desc: "no reply", err: testError{retry: true}},
 	}
-	if !strings.Contains(err.Error(), "700") {
-		t.Errorf("Expected nonsense error code 700, got %v", err)
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs: &errCont{
+				errMap: map[string]map[int]error{
+					"uploadPart": {0: e.err},
+				},
+			},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i:    root,
+				policy: &ExponentialBackoff{},
+				clk:    &fakeClock{auto: true},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object(largeFileName).NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.ConcurrentUploads = 1
+		_, err = io.Copy(w, bytes.NewReader(bytes.Repeat([]byte("a"), 2e4)))
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+		}
+		if n := root.errs.count("uploadPart"); n != 3 {
+			t.Errorf("%s: got %d calls to uploadPart, want 3", e.desc, n)
+		}
 	}
 }
 
-func TestReaderDoubleClose(t *testing.T) {
+func TestWithBackoffReupload(t *testing.T) {
 	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-	client := &Client{
-		backend: &beRoot{
-			b2i: &testRoot{
-				bucketMap: make(map[string]map[string]string),
-				errs:      &errCont{},
-			},
-		},
-	}
-	bucket, err := client.NewBucket(ctx, "bucket", &BucketAttrs{Type: Private})
-	if err != nil {
-		t.Fatal(err)
-	}
-	o, _, err := writeFile(ctx, bucket, "file", 10, 10)
-	if err != nil {
-		t.Fatal(err)
+	unavailable := testError{reupload: true, status: 503}
+	policy := &ExponentialBackoff{
+		MaxAttempts: 3,
+		Base:        time.Millisecond,
+		Retryable:   []int{503},
 	}
-	r := o.NewReader(ctx)
-	// Read to EOF, and then read some more.
-	if _, err := io.Copy(ioutil.Discard, r); err != nil {
-		t.Fatal(err)
+	table := []struct {
+		errs  map[int]error
+		parts int
+		fails bool
+	}{
+		{
+			errs:  map[int]error{0: unavailable, 1: unavailable},
+			parts: 4,
+		},
+		{
+			errs:  map[int]error{0: unavailable, 1: unavailable, 2: unavailable},
+			parts: 3,
+			fails: true,
+		},
 	}
-	if _, err := io.Copy(ioutil.Discard, r); err != nil {
-		t.Fatal(err)
+	for _, e := range table {
+		clk := &fakeClock{auto: true}
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs: &errCont{
+				errMap: map[string]map[int]error{
+					"uploadPart": e.errs,
+				},
+			},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i:    root,
+				policy: policy,
+				clk:    clk,
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object(largeFileName).NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.ConcurrentUploads = 1
+		_, err = io.Copy(w, bytes.NewReader(bytes.Repeat([]byte("a"), 2e4)))
+		if err == nil {
+			err = w.Close()
+		}
+		if (err != nil) != e.fails {
+			t.Errorf("%d failed uploads: got %v, want error %v", len(e.errs), err, e.fails)
+		}
+		if n := root.errs.count("uploadPart"); n != e.parts {
+			t.Errorf("%d failed uploads: got %d calls to uploadPart, want %d", len(e.errs), n, e.parts)
+		}
 	}
 }
 
-func TestReadWrite(t *testing.T) {
+func TestRetrySchedule(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	unavailable := testError{retry: true, status: 503}
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			errMap: map[string]map[int]error{
+				"createBucket": {0: unavailable, 1: unavailable, 2: unavailable, 3: unavailable, 4: unavailable},
+			},
+		},
+	}
+	clk := &fakeClock{waiting: make(chan time.Duration)}
 	client := &Client{
 		backend: &beRoot{
-			b2i: &testRoot{
-				bucketMap: make(map[string]map[string]string),
-				errs:      &errCont{},
+			b2i: root,
+			policy: &ExponentialBackoff{
+				Base: time.Second,
+				Max:  4 * time.Second,
 			},
+			clk: clk,
 		},
 	}
 
-	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer func() {
-		if err := bucket.Delete(ctx); err != nil {
-			t.Error(err)
-		}
+	start := clk.Now()
+	done := make(chan error)
+	go func() {
+		_, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		done <- err
 	}()
-
-	sobj, wsha, err := writeFile(ctx, bucket, smallFileName, 1e6+42, 1e8)
-	if err != nil {
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second} {
+		select {
+		case got := <-clk.waiting:
+			if got != want {
+				t.Errorf("wait %d: got %v, want %v", i, got, want)
+			}
+			if n := root.errs.count("createBucket"); n != i+1 {
+				t.Errorf("wait %d: createBucket called %d times, want %d", i, n, i+1)
+			}
+			clk.Advance(got)
+		case err := <-done:
+			t.Fatalf("wait %d: NewBucket returned early: %v", i, err)
+		}
+	}
+	if err := <-done; err != nil {
 		t.Fatal(err)
 	}
+	if got, want := clk.Now().Sub(start), 15*time.Second; got != want {
+		t.Errorf("retries took %v, want %v", got, want)
+	}
+}
 
-	defer func() {
-		if err := sobj.Delete(ctx); err != nil {
-			t.Error(err)
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := &ExponentialBackoff{
+		Base:   time.Second,
+		Max:    4 * time.Second,
+		Jitter: 0.1,
+	}
+	for attempt := 1; attempt < 10; attempt++ {
+		want := time.Second << uint(attempt-1)
+		if want > 4*time.Second {
+			want = 4 * time.Second
+		}
+		d, ok := b.Retry(attempt, 500)
+		if !ok {
+			t.Fatalf("Retry(%d, 500): got no retry", attempt)
+		}
+		if lo, hi := want-want/10, want+want/10; d < lo || d > hi {
+			t.Errorf("Retry(%d, 500): got %v, want between %v and %v", attempt, d, lo, hi)
 		}
-	}()
-
-	if err := readFile(ctx, sobj, wsha, 1e5, 10); err != nil {
-		t.Error(err)
+	}
+	if _, ok := b.Retry(1, 401); ok {
+		t.Error("Retry(1, 401): got retry, want none")
+	}
+}
+
+type badTransport struct{}
+
+func (badTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "700 What",
+		StatusCode: 700,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+		Request:    r,
+	}, nil
+}
+
+func TestCustomTransport(t *testing.T) {
+	ctx := context.Background()
+	// Sorta fragile but...
+	_, err := NewClient(ctx, "abcd", "efgh", Transport(badTransport{}))
+	if err == nil {
+		t.Error("NewClient returned successfully, expected an error")
+	}
+	if !strings.Contains(err.Error(), "700") {
+		t.Errorf("Expected nonsense error code 700, got %v", err)
+	}
+}
+
+// retryAfterTransport authorizes any account, and fails the first call to
+// b2_list_buckets with 503, asking the client to retry after a while.
+type retryAfterTransport struct {
+	header http.Header
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func (rt *retryAfterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	reply := func(code int, h http.Header, body string) (*http.Response, error) {
+		return &http.Response{
+			Status:     http.StatusText(code),
+			StatusCode: code,
+			Header:     h,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Request:    r,
+		}, nil
+	}
+	switch r.Header.Get("X-Blazer-Method") {
+	case "b2_authorize_account":
+		return reply(200, http.Header{}, `{"accountId": "abcd", "authorizationToken": "token", "apiUrl": "https://api.example.com"}`)
+	case "b2_list_buckets":
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		if !rt.failed {
+			rt.failed = true
+			return reply(503, rt.header, `{"status": 503, "code": "service_unavailable", "message": "try again later"}`)
+		}
+		return reply(200, http.Header{}, `{"buckets": []}`)
+	}
+	return reply(400, http.Header{}, `{"status": 400, "code": "bad_request", "message": "unexpected call"}`)
+}
+
+func TestRetryAfter(t *testing.T) {
+	date := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	table := []struct {
+		desc    string
+		header  http.Header
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{
+			desc:   "seconds",
+			header: http.Header{"Retry-After": {"5"}},
+			want:   5 * time.Second,
+		},
+		{
+			desc: "HTTP date",
+			header: http.Header{
+				"Date":        {date.Format(http.TimeFormat)},
+				"Retry-After": {date.Add(7 * time.Second).Format(http.TimeFormat)},
+			},
+			want: 7 * time.Second,
+		},
+		{
+			desc:    "past the deadline",
+			header:  http.Header{"Retry-After": {"60"}},
+			timeout: 2 * time.Second,
+			want:    2 * time.Second,
+		},
+	}
+	for _, e := range table {
+		clk := &fakeClock{auto: true}
+		timeout := 10 * time.Second
+		if e.timeout > 0 {
+			timeout = e.timeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		client, err := NewClient(ctx, "abcd", "efgh", Transport(&retryAfterTransport{header: e.header}), withClock(clk))
+		if err != nil {
+			t.Fatalf("%s: NewClient: %v", e.desc, err)
+		}
+		if _, err := client.ListBuckets(ctx); err != nil {
+			t.Errorf("%s: ListBuckets: %v", e.desc, err)
+		}
+		cancel()
+		waits := clk.waits()
+		if len(waits) != 1 {
+			t.Errorf("%s: got waits %v, want one", e.desc, waits)
+			continue
+		}
+		// Allow for the time taken by the test itself.
+		if got := waits[0]; got > e.want || got < e.want-time.Second {
+			t.Errorf("%s: waited %v, want %v", e.desc, got, e.want)
+		}
+	}
+}
+
+// pacedTransport authorizes any account and lists no buckets, noting the time
+// of every request by clk.
+type pacedTransport struct {
+	clk clock
+
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (pt *pacedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	pt.mu.Lock()
+	pt.times = append(pt.times, pt.clk.Now())
+	pt.mu.Unlock()
+	body := `{"buckets": []}`
+	if r.Header.Get("X-Blazer-Method") == "b2_authorize_account" {
+		body = `{"accountId": "abcd", "authorizationToken": "token", "apiUrl": "https://api.example.com"}`
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	table := []struct {
+		rps   float64
+		burst int
+		want  []time.Duration // the time of each request after the first
+	}{
+		{
+			rps:   2,
+			burst: 1,
+			want:  []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 2 * time.Second, 2500 * time.Millisecond},
+		},
+		{
+			rps:   4,
+			burst: 3,
+			want:  []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond, 750 * time.Millisecond},
+		},
+		{
+			rps:  0,
+			want: []time.Duration{0, 0, 0, 0, 0},
+		},
+	}
+	for _, e := range table {
+		ctx := context.Background()
+		clk := &fakeClock{auto: true}
+		pt := &pacedTransport{clk: clk}
+		client, err := NewClient(ctx, "abcd", "efgh", Transport(pt), withClock(clk), WithRateLimit(e.rps, e.burst))
+		if err != nil {
+			t.Fatalf("WithRateLimit(%v, %d): NewClient: %v", e.rps, e.burst, err)
+		}
+		for range e.want {
+			if _, err := client.ListBuckets(ctx); err != nil {
+				t.Fatalf("WithRateLimit(%v, %d): ListBuckets: %v", e.rps, e.burst, err)
+			}
+		}
+		var got []time.Duration
+		for _, tm := range pt.times[1:] {
+			got = append(got, tm.Sub(pt.times[0]))
+		}
+		if !reflect.DeepEqual(got, e.want) {
+			t.Errorf("WithRateLimit(%v, %d): requests sent at %v, want %v", e.rps, e.burst, got, e.want)
+		}
+	}
+}
+
+func TestRateLimitCancel(t *testing.T) {
+	clk := &fakeClock{}
+	l := newRateLimiter(1, 1, clk)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("wait with cancelled context: got %v, want %v", err, context.Canceled)
+	}
+	// The cancelled wait must not hold on to its token.
+	clk.Advance(time.Second)
+	if err := l.wait(ctx); err != nil {
+		t.Errorf("wait after a second: got %v, want none", err)
+	}
+}
+
+// recordingTransport plays the part of B2 for a single bucket, and records the
+// method and URL of every request it sees.
+type recordingTransport struct {
+	mu    sync.Mutex
+	reqs  []string
+	files map[string]string // file info replies, by file ID
+	large string            // the name of the large file being written
+	lock  string            // the Object Lock settings of the large file
+	parts int               // the bytes sent in parts of the large file
+	hdrs  http.Header       // the headers of the last upload
+
+	created   string          // the name of a bucket created through rt
+	lockOn    bool            // whether it has Object Lock enabled
+	defaultRe json.RawMessage // its default retention
+	repl      json.RawMessage // its replication configuration
+	binfo     json.RawMessage // its info
+	rev       int             // its revision
+
+	size     int64    // if set, the size reported for uploaded files
+	allowed  string   // if set, the allowed field of the authorization reply
+	partSize int      // if set, the recommendedPartSize of the authorization reply
+	copied   []string // the part number and range of each b2_copy_part
+	finished []string // the part SHA1s of the last b2_finish_large_file
+	listed   []string // the bucketName filter of each b2_list_buckets
+	notes    string   // the eventNotificationRules last set
+}
+
+// createdBucket returns the bucket reply for rt.created.
+func (rt *recordingTransport) createdBucket() string {
+	def := string(rt.defaultRe)
+	if def == "" {
+		def = `{"mode": null, "period": null}`
+	}
+	repl := string(rt.repl)
+	if repl == "" {
+		repl = `{}`
+	}
+	info := string(rt.binfo)
+	if info == "" {
+		info = `{}`
+	}
+	return fmt.Sprintf(`{"bucketId": "created", "bucketName": %q, "bucketType": "allPrivate", "bucketInfo": %s, "revision": %d, "fileLockConfiguration": {"isClientAuthorizedToRead": true, "value": {"isFileLockEnabled": %v, "defaultRetention": %s}}, "replicationConfiguration": {"isClientAuthorizedToRead": true, "value": %s}}`, rt.created, info, rt.rev, rt.lockOn, def, repl)
+}
+
+// lockReply returns the Object Lock fields of a file info reply.
+func lockReply(mode, until, hold string) string {
+	if mode == "" {
+		mode, until = "null", "null"
+	} else {
+		mode = strconv.Quote(mode)
+	}
+	if hold == "" {
+		hold = "off"
+	}
+	return fmt.Sprintf(`"fileRetention": {"isClientAuthorizedToRead": true, "value": {"mode": %s, "retainUntilTimestamp": %s}}, "legalHold": {"isClientAuthorizedToRead": true, "value": %q}`, mode, until, hold)
+}
+
+const (
+	testUploadURL = "https://pod-000-1000-00.backblaze.example/b2api/v1/b2_upload_file/bucket/c000"
+	testPartURL   = "https://pod-000-1000-01.backblaze.example/b2api/v1/b2_upload_part/large/c000"
+)
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var data []byte
+	if r.Body != nil {
+		data, _ = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+	}
+	method := r.Header.Get("X-Blazer-Method")
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.reqs = append(rt.reqs, method+" "+r.URL.String())
+	if rt.files == nil {
+		rt.files = make(map[string]string)
+	}
+	var req struct {
+		ID        string `json:"fileId"`
+		Name      string `json:"fileName"`
+		Retention *struct {
+			Mode  string `json:"mode"`
+			Until int64  `json:"retainUntilTimestamp"`
+		} `json:"fileRetention"`
+		LegalHold string `json:"legalHold"`
+		Bypass    bool   `json:"bypassGovernance"`
+
+		Bucket           string          `json:"bucketName"`
+		FileLock         bool            `json:"fileLockEnabled"`
+		DefaultRetention json.RawMessage `json:"defaultRetention"`
+		Replication      json.RawMessage `json:"replicationConfiguration"`
+		Info             json.RawMessage `json:"bucketInfo"`
+		Notifications    json.RawMessage `json:"eventNotificationRules"`
+		IfRevisionIs     int             `json:"ifRevisionIs"`
+
+		Part  int    `json:"partNumber"`
+		Range string `json:"range"`
+
+		PartSHA1s []string `json:"partSha1Array"`
+	}
+	json.Unmarshal(data, &req)
+
+	sha := r.Header.Get("X-Bz-Content-Sha1")
+	if r.Header.Get("X-Bz-Test-Mode") == "force_cap_exceeded" && method != "b2_authorize_account" && method != "b2_list_buckets" {
+		return b2ErrorResponse(r, 403, "storage_cap_exceeded", "Cannot upload files, storage cap exceeded."), nil
+	}
+	if (method == "b2_upload_file" || method == "b2_upload_part") && sha != "hex_digits_at_end" && sha != fmt.Sprintf("%x", sha1.Sum(data)) {
+		return b2ErrorResponse(r, 400, "bad_request", "Sha1 did not match data received"), nil
+	}
+	var body string
+	switch method {
+	case "b2_authorize_account":
+		body = `{"accountId": "abcd", "authorizationToken": "token", "apiUrl": "https://api.backblaze.example", "s3ApiUrl": "https://s3.us-west-000.backblaze.example", "absoluteMinimumPartSize": 5}`
+		if rt.allowed != "" {
+			body = strings.TrimSuffix(body, "}") + `, "allowed": ` + rt.allowed + "}"
+		}
+		if rt.partSize != 0 {
+			body = strings.TrimSuffix(body, "}") + fmt.Sprintf(`, "recommendedPartSize": %d}`, rt.partSize)
+		}
+	case "b2_list_buckets":
+		rt.listed = append(rt.listed, req.Bucket)
+		body = `{"buckets": [{"bucketId": "bucket", "bucketName": "b2-tests", "bucketType": "allPrivate"}]}`
+		if rt.created != "" {
+			body = fmt.Sprintf(`{"buckets": [{"bucketId": "bucket", "bucketName": "b2-tests", "bucketType": "allPrivate"}, %s]}`, rt.createdBucket())
+		}
+	case "b2_create_bucket":
+		rt.created, rt.lockOn, rt.repl, rt.binfo, rt.rev = req.Bucket, req.FileLock, req.Replication, req.Info, 1
+		body = rt.createdBucket()
+	case "b2_update_bucket":
+		if rt.created != "" {
+			if req.IfRevisionIs != 0 && req.IfRevisionIs != rt.rev {
+				return b2ErrorResponse(r, 409, "conflict", "ifRevisionIs does not match"), nil
+			}
+			rt.rev++
+			if req.DefaultRetention != nil {
+				rt.defaultRe = req.DefaultRetention
+			}
+			if req.Replication != nil {
+				rt.repl = req.Replication
+			}
+			if req.Info != nil {
+				rt.binfo = req.Info
+			}
+			body = rt.createdBucket()
+		}
+	case "b2_get_upload_url":
+		body = fmt.Sprintf(`{"uploadUrl": %q, "authorizationToken": "upload"}`, testUploadURL)
+	case "b2_upload_file":
+		name, _ := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+		rt.hdrs = r.Header
+		lock := lockReply(r.Header.Get("X-Bz-File-Retention-Mode"), r.Header.Get("X-Bz-File-Retention-Retain-Until-Timestamp"), r.Header.Get("X-Bz-File-Legal-Hold"))
+		size := int64(len(data))
+		if rt.size != 0 {
+			size = rt.size
+		}
+		body = fmt.Sprintf(`{"fileId": "small", "fileName": %q, "contentLength": %d, "contentSha1": %q, "contentType": %q, "fileInfo": {}, "action": "upload", "uploadTimestamp": 1500000000000, %s}`, name, size, sha, r.Header.Get("Content-Type"), lock)
+		rt.files["small"] = body
+	case "b2_start_large_file":
+		rt.large = req.Name
+		rt.lock = lockReply("", "", req.LegalHold)
+		if req.Retention != nil {
+			rt.lock = lockReply(req.Retention.Mode, strconv.FormatInt(req.Retention.Until, 10), req.LegalHold)
+		}
+		body = `{"fileId": "large"}`
+	case "b2_get_upload_part_url":
+		body = fmt.Sprintf(`{"uploadUrl": %q, "authorizationToken": "part"}`, testPartURL)
+	case "b2_upload_part":
+		rt.parts += len(data)
+		body = fmt.Sprintf(`{"fileId": "large", "partNumber": %s, "contentSha1": %q}`, r.Header.Get("X-Bz-Part-Number"), sha)
+	case "b2_cancel_large_file":
+		body = fmt.Sprintf(`{"fileId": %q, "fileName": %q}`, req.ID, rt.large)
+	case "b2_update_file_retention", "b2_update_file_legal_hold":
+		var file map[string]interface{}
+		if err := json.Unmarshal([]byte(rt.files[req.ID]), &file); err != nil {
+			return b2ErrorResponse(r, 400, "bad_request", "File not present: "+req.ID), nil
+		}
+		if method == "b2_update_file_legal_hold" {
+			file["legalHold"] = map[string]interface{}{"isClientAuthorizedToRead": true, "value": req.LegalHold}
+			body = fmt.Sprintf(`{"fileId": %q, "fileName": %q, "legalHold": %q}`, req.ID, req.Name, req.LegalHold)
+		} else {
+			var mode string
+			var until int64
+			if req.Retention != nil {
+				mode, until = req.Retention.Mode, req.Retention.Until
+			}
+			var cur map[string]interface{}
+			if fr, ok := file["fileRetention"].(map[string]interface{}); ok {
+				cur, _ = fr["value"].(map[string]interface{})
+			}
+			curMode, _ := cur["mode"].(string)
+			curUntil, _ := cur["retainUntilTimestamp"].(float64)
+			// As B2 does, refuse to shorten a retention, except a governance
+			// one when asked to bypass it.
+			shorter := mode == "" || until < int64(curUntil)
+			if curMode == Compliance && (mode != Compliance || shorter) || curMode == Governance && shorter && !req.Bypass {
+				return b2ErrorResponse(r, 403, "access_denied", "cannot shorten "+curMode+" retention"), nil
+			}
+			value := map[string]interface{}{"mode": nil, "retainUntilTimestamp": nil}
+			if mode != "" {
+				value = map[string]interface{}{"mode": mode, "retainUntilTimestamp": until}
+			}
+			file["fileRetention"] = map[string]interface{}{"isClientAuthorizedToRead": true, "value": value}
+			v, _ := json.Marshal(value)
+			body = fmt.Sprintf(`{"fileId": %q, "fileName": %q, "fileRetention": %s}`, req.ID, req.Name, v)
+		}
+		b, _ := json.Marshal(file)
+		rt.files[req.ID] = string(b)
+	case "b2_set_bucket_notification_rules":
+		rt.notes = string(req.Notifications)
+		fallthrough
+	case "b2_get_bucket_notification_rules":
+		notes := rt.notes
+		if notes == "" {
+			notes = "[]"
+		}
+		body = fmt.Sprintf(`{"bucketId": "bucket", "eventNotificationRules": %s}`, notes)
+	case "b2_copy_part":
+		rt.copied = append(rt.copied, fmt.Sprintf("%d %s", req.Part, req.Range))
+		body = fmt.Sprintf(`{"fileId": "large", "partNumber": %d, "contentSha1": "none"}`, req.Part)
+	case "b2_finish_large_file":
+		rt.finished = req.PartSHA1s
+		body = fmt.Sprintf(`{"fileId": "large", "fileName": %q, "contentLength": %d, "contentSha1": "none", "contentType": "application/octet-stream", "fileInfo": {}, "action": "upload", "uploadTimestamp": 1500000000000, %s}`, rt.large, rt.parts, rt.lock)
+		rt.files["large"] = body
+	case "b2_get_file_info":
+		body = rt.files[req.ID]
+		if body == "" {
+			return b2ErrorResponse(r, 404, "not_found", "File not present: "+req.ID), nil
+		}
+	case "b2_delete_file_version":
+		if _, ok := rt.files[req.ID]; !ok {
+			return b2ErrorResponse(r, 400, "file_not_present", "File not present: "+req.Name), nil
+		}
+		delete(rt.files, req.ID)
+		body = fmt.Sprintf(`{"fileId": %q, "fileName": %q}`, req.ID, req.Name)
+	}
+	if body == "" {
+		return b2ErrorResponse(r, 400, "bad_request", "unexpected call"), nil
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    r,
+	}, nil
+}
+
+// b2ErrorResponse is a reply carrying the JSON error body B2 sends.
+func b2ErrorResponse(r *http.Request, status int, code, msg string) *http.Response {
+	body := fmt.Sprintf(`{"status": %d, "code": %q, "message": %q}`, status, code, msg)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    r,
+	}
+}
+
+func TestTransportCarriesUploads(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		name string
+		size int
+	}{
+		{name: smallFileName, size: 5},
+		{name: largeFileName, size: 30},
+	} {
+		w := bucket.Object(e.name).NewWriter(ctx)
+		w.ChunkSize = 10
+		w.ConcurrentUploads = 1
+		if _, err := w.Write(bytes.Repeat([]byte("a"), e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("writing %s: %v", e.name, err)
+		}
+	}
+
+	got := make(map[string]int)
+	for _, req := range rt.reqs {
+		got[req]++
+	}
+	want := map[string]int{
+		"b2_authorize_account https://api.backblazeb2.com/b2api/v1/b2_authorize_account":       1,
+		"b2_list_buckets https://api.backblaze.example/b2api/v1/b2_list_buckets":               1,
+		"b2_get_upload_url https://api.backblaze.example/b2api/v1/b2_get_upload_url":           1,
+		"b2_upload_file " + testUploadURL:                                                      1,
+		"b2_start_large_file https://api.backblaze.example/b2api/v1/b2_start_large_file":       1,
+		"b2_get_upload_part_url https://api.backblaze.example/b2api/v1/b2_get_upload_part_url": 1,
+		"b2_upload_part " + testPartURL:                                                        3,
+		"b2_finish_large_file https://api.backblaze.example/b2api/v1/b2_finish_large_file":     1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want %v", got, want)
+	}
+}
+
+func TestWriterGivenSHA1(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("a"), 30)
+	sum := func(b []byte) string { return fmt.Sprintf("%x", sha1.Sum(b)) }
+	wrong := sum([]byte("b"))
+
+	table := []struct {
+		desc  string
+		size  int
+		sha   string
+		parts map[int]string
+		seek  bool
+		ok    bool
+	}{
+		{desc: "small, right", size: 5, sha: sum(data[:5]), ok: true},
+		{desc: "small, wrong", size: 5, sha: wrong},
+		{desc: "large, right", size: 30, parts: map[int]string{1: sum(data[:10]), 3: sum(data[20:])}, ok: true},
+		{desc: "large, wrong", size: 30, parts: map[int]string{2: wrong}},
+		{desc: "small seeker, right", size: 5, sha: sum(data[:5]), seek: true, ok: true},
+		{desc: "small seeker, wrong", size: 5, sha: wrong, seek: true},
+		{desc: "large seeker, right", size: 30, parts: map[int]string{1: sum(data[:10]), 3: sum(data[20:])}, seek: true, ok: true},
+		{desc: "large seeker, wrong", size: 30, parts: map[int]string{2: wrong}, seek: true},
+	}
+	for _, e := range table {
+		w := bucket.Object(e.desc).NewWriter(ctx)
+		w.ChunkSize = 10
+		w.SHA1 = e.sha
+		w.PartSHA1s = e.parts
+		var err error
+		if e.seek {
+			_, err = w.ReadFrom(bytes.NewReader(data[:e.size]))
+		} else {
+			_, err = w.Write(data[:e.size])
+		}
+		if err != nil && e.ok {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		err = w.Close()
+		if e.ok && err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+		}
+		if !e.ok && !errors.Is(err, ErrSHA1Mismatch) {
+			t.Errorf("%s: got %v, want ErrSHA1Mismatch", e.desc, err)
+		}
+		if e.ok && e.sha != "" {
+			rt.mu.Lock()
+			got := rt.hdrs.Get("X-Bz-Content-Sha1")
+			rt.mu.Unlock()
+			if got != e.sha {
+				t.Errorf("%s: sent SHA1 %q, want %q", e.desc, got, e.sha)
+			}
+		}
+	}
+
+	// A large object stores the given SHA1 as its large_file_sha1.
+	fake := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	fb, err := fake.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := fb.Object("large")
+	w := obj.NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.SHA1 = sum(make([]byte, 1e5))
+	if _, err := w.Write(make([]byte, 1e5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.SHA1 != w.SHA1 {
+		t.Errorf("large object: got SHA1 %q, want %q", attrs.SHA1, w.SHA1)
+	}
+}
+
+func TestWriterRecommendedPartSize(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	for _, e := range []struct {
+		rec, want int
+	}{
+		{rec: 20, want: 20},
+		{rec: 3, want: 5}, // raised to absoluteMinimumPartSize
+	} {
+		rt := &recordingTransport{partSize: e.rec}
+		client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := client.RecommendedPartSize(); got != e.rec {
+			t.Errorf("RecommendedPartSize: got %d, want %d", got, e.rec)
+		}
+		if got := client.MinPartSize(); got != 5 {
+			t.Errorf("MinPartSize: got %d, want 5", got)
+		}
+		bucket, err := client.Bucket(ctx, bucketName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object(largeFileName).NewWriter(ctx)
+		if _, err := w.Write(bytes.Repeat([]byte("a"), 50)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rt.mu.Lock()
+		var parts int
+		for _, r := range rt.reqs {
+			if strings.HasPrefix(r, "b2_upload_part ") {
+				parts++
+			}
+		}
+		rt.mu.Unlock()
+		if want := (50 + e.want - 1) / e.want; parts != want {
+			t.Errorf("recommended part size %d: got %d parts of 50 bytes, want %d", e.rec, parts, want)
+		}
+	}
+}
+
+func TestWriterObjectAfterClose(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		name, id string
+		size     int
+		sha      string
+	}{
+		{name: smallFileName, id: "small", size: 5, sha: fmt.Sprintf("%x", sha1.Sum([]byte("aaaaa")))},
+		{name: largeFileName, id: "large", size: 30, sha: "none"},
+	} {
+		obj := bucket.Object(e.name)
+		w := obj.NewWriter(ctx)
+		w.ChunkSize = 10
+		if _, err := w.Write(bytes.Repeat([]byte("a"), e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("writing %s: %v", e.name, err)
+		}
+		if obj.ID() != e.id {
+			t.Errorf("%s: after Close, got ID %q, want %q", e.name, obj.ID(), e.id)
+		}
+		rt.mu.Lock()
+		sent := len(rt.reqs)
+		rt.mu.Unlock()
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt.mu.Lock()
+		extra := rt.reqs[sent:]
+		rt.mu.Unlock()
+		if len(extra) > 0 {
+			t.Errorf("%s: Attrs after Close made requests %v, want none", e.name, extra)
+		}
+		if attrs.Name != e.name || attrs.Size != int64(e.size) || attrs.SHA1 != e.sha {
+			t.Errorf("%s: after Close, got attrs %+v", e.name, attrs)
+		}
+
+		// Look the file up again by its ID.
+		fresh := &Object{name: e.name, f: bucket.b.file(obj.ID(), e.name), b: bucket}
+		got, err := fresh.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, attrs) {
+			t.Errorf("%s: b2_get_file_info: got %+v, want %+v", e.name, got, attrs)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			errMap: map[string]map[int]error{
+				"createBucket": {0: testError{reauth: true}},
+				"getUploadURL": {0: testError{retry: true}},
+				"uploadPart":   {1: testError{reupload: true}},
+			},
+		},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+			clk: &fakeClock{auto: true},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writeFile(ctx, bucket, smallFileName, 1e3, 1e4); err != nil {
+		t.Fatal(err)
+	}
+	large, sha, err := writeFile(ctx, bucket, largeFileName, 45e3, 1e4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readFile(ctx, large, sha, 1e4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Metrics{
+		BytesUploaded:   46e3,
+		BytesDownloaded: 45e3,
+		Parts:           5,
+		Retries:         2,
+		Reauths:         1,
+
+		// One each for the small object, the large object's first part, and
+		// the part sent again; the other parts reuse the first part's URL.
+		UploadURLFetches: 3,
+		UploadURLReuses:  4,
+	}
+	if got := client.Metrics(); got != want {
+		t.Errorf("Metrics: got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriterUploadStats(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 6e4)
+	zReader{}.Read(data)
+	write := func(name string, size, threads int) UploadStats {
+		t.Helper()
+		w := bucket.Object(name).NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.ConcurrentUploads = threads
+		if _, err := w.Write(data[:size]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return w.UploadStats()
+	}
+
+	// A small object fetches an upload URL, and the next one reuses it.
+	want := UploadStats{URLFetches: 1}
+	if got := write("small1", 1e3, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("first small object: got %+v, want %+v", got, want)
+	}
+	want = UploadStats{URLReuses: 1}
+	if got := write("small2", 1e3, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("second small object: got %+v, want %+v", got, want)
+	}
+
+	// One thread fetches a part URL and reuses it for every other part.
+	want = UploadStats{URLFetches: 1, URLReuses: 5, ThreadParts: []int64{6}}
+	if got := write("large1", 6e4, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("one thread: got %+v, want %+v", got, want)
+	}
+
+	// More threads share the parts, and fetch a URL each at most.
+	got := write("large3", 6e4, 3)
+	if got.URLFetches < 1 || got.URLFetches > 3 || got.URLFetches+got.URLReuses != 6 {
+		t.Errorf("three threads: got %+v, want 1 to 3 fetches and 6 in all", got)
+	}
+	var parts int64
+	for _, n := range got.ThreadParts {
+		parts += n
+	}
+	if len(got.ThreadParts) != 3 || parts != 6 {
+		t.Errorf("three threads: got thread parts %v, want 6 among 3", got.ThreadParts)
+	}
+
+	m := client.Metrics()
+	if m.UploadURLFetches+m.UploadURLReuses != 14 {
+		t.Errorf("Metrics: got %d fetches and %d reuses, want 14 in all", m.UploadURLFetches, m.UploadURLReuses)
+	}
+}
+
+func TestReaderDoubleClose(t *testing.T) {
+	ctx := context.Background()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _, err := writeFile(ctx, bucket, "file", 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := o.NewReader(ctx)
+	// Read to EOF, and then read some more.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := bucket.Delete(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	sobj, wsha, err := writeFile(ctx, bucket, smallFileName, 1e6+42, 1e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := sobj.Delete(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err := readFile(ctx, sobj, wsha, 1e5, 10); err != nil {
+		t.Error(err)
+	}
+
+	lobj, wshaL, err := writeFile(ctx, bucket, largeFileName, 1e6-1e5, 1e4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := lobj.Delete(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err := readFile(ctx, lobj, wshaL, 1e7, 10); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadRangeReturnsRight(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := bucket.Delete(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	obj, _, err := writeFile(ctx, bucket, "file", 1e6+42, 1e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := obj.NewRangeReader(ctx, 200, 1400)
+	r.ChunkSize = 1000
+
+	i, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		t.Error(err)
+	}
+	if i != 1400 {
+		t.Errorf("NewRangeReader(_, 200, 1400): want 1400, got %d", i)
+	}
+}
+
+func TestWriterReturnsError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs: &errCont{
+					errMap: map[string]map[int]error{
+						"uploadPart": {
+							0: testError{},
+							1: testError{},
+							2: testError{},
+							3: testError{},
+							4: testError{},
+							5: testError{},
+							6: testError{},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("test").NewWriter(ctx)
+	r := io.LimitReader(zReader{}, 1e7)
+	w.ChunkSize = 1e4
+	w.ConcurrentUploads = 4
+	if _, err := io.Copy(w, r); err == nil {
+		t.Fatalf("io.Copy: should have returned an error")
+	}
+}
+
+func TestWriterCanceled(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wctx, wcancel := context.WithCancel(ctx)
+	w := bucket.Object("file").NewWriter(wctx)
+	w.ChunkSize = 1e6
+	if _, err := w.Write(make([]byte, 1e3)); err != nil {
+		t.Fatal(err)
+	}
+	wcancel()
+	if _, err := w.Write(make([]byte, 1e3)); err != context.Canceled {
+		t.Errorf("Write after cancel: got %v, want %v", err, context.Canceled)
+	}
+	if err := w.Close(); err != context.Canceled {
+		t.Errorf("Close after cancel: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReaderConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	data := make([]byte, 3e7)
+	rand.New(rand.NewSource(1)).Read(data)
+	boom := errors.New("boom")
+
+	table := []struct {
+		errMap map[string]map[int]error
+		want   error
+	}{
+		{},
+		{
+			errMap: map[string]map[int]error{
+				"downloadFileByID": {5: boom},
+			},
+			want: boom,
+		},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx)
+		w.ChunkSize = 1e7
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		root.errs.errMap = e.errMap
+		r := o.NewReader(ctx)
+		r.ConcurrentDownloads = 4
+		r.ChunkSize = 1e6
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if !errors.Is(err, e.want) {
+			t.Errorf("ReadAll: got error %v, want %v", err, e.want)
+			continue
+		}
+		if e.want != nil {
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("ReadAll: read %d bytes that differ from the %d written", len(got), len(data))
+		}
+		if root.errs.count("downloadFileByID") == 0 {
+			t.Error("ReadAll: no ranges were fetched by ID")
+		}
+	}
+}
+
+func TestReaderObjectChanged(t *testing.T) {
+	r := &Reader{name: "file"}
+	if err := r.pin("one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.pin("one"); err != nil {
+		t.Errorf("pin: got %v for the same file version", err)
+	}
+	if err := r.pin("two"); err == nil {
+		t.Error("pin: got no error for a different file version")
+	}
+}
+
+func TestReaderAt(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1e5)
+	rand.New(rand.NewSource(1)).Read(data)
+	o := bucket.Object("file")
+	w := o.NewWriter(ctx)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		off  int64
+		size int
+		want int
+		err  error
+	}{
+		{off: 0, size: 100, want: 100},
+		{off: 12345, size: 1e4, want: 1e4},
+		{off: 1e5 - 10, size: 10, want: 10},
+		{off: 1e5 - 10, size: 100, want: 10, err: io.EOF},
+		{off: 1e5, size: 100, want: 0, err: io.EOF},
+		{off: 2e5, size: 100, want: 0, err: io.EOF},
+	}
+
+	r := o.NewReader(ctx)
+	defer r.Close()
+	var wg sync.WaitGroup
+	for _, e := range table {
+		wg.Add(1)
+		go func(off int64, size, want int, werr error) {
+			defer wg.Done()
+			p := make([]byte, size)
+			n, err := r.ReadAt(p, off)
+			if n != want || err != werr {
+				t.Errorf("ReadAt(%d bytes, %d): got (%d, %v), want (%d, %v)", size, off, n, err, want, werr)
+				return
+			}
+			if n > 0 && !bytes.Equal(p[:n], data[off:off+int64(n)]) {
+				t.Errorf("ReadAt(%d bytes, %d): wrong bytes", size, off)
+			}
+		}(e.off, e.size, e.want, e.err)
+	}
+	wg.Wait()
+}
+
+func TestReaderSeek(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1e4+17)
+	rand.New(rand.NewSource(65)).Read(data)
+	o := bucket.Object("file")
+	w := o.NewWriter(ctx)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type step struct {
+		off    int64
+		whence int
+		pos    int64 // the position Seek returns
+		n      int   // bytes to read after seeking, or -1 to read the rest
+	}
+	table := []struct {
+		desc        string
+		start, size int64 // the Reader's range
+		src         []byte
+		steps       []step
+	}{
+		{
+			desc:  "whole object",
+			start: 0,
+			size:  -1,
+			src:   data,
+			steps: []step{
+				{off: 100, whence: io.SeekStart, pos: 100, n: 2500},
+				{off: -100, whence: io.SeekEnd, pos: 1e4 - 83, n: -1},
+				{off: 0, whence: io.SeekStart, pos: 0, n: 10},
+				{off: 5, whence: io.SeekCurrent, pos: 15, n: 3000},
+				{off: -3000, whence: io.SeekCurrent, pos: 15, n: 10},
+				{off: 0, whence: io.SeekEnd, pos: 1e4 + 17, n: -1},
+				{off: 1e5, whence: io.SeekStart, pos: 1e5, n: -1},
+				{off: -1, whence: io.SeekEnd, pos: 1e4 + 16, n: -1},
+			},
+		},
+		{
+			desc:  "range",
+			start: 1000,
+			size:  5000,
+			src:   data[1000:6000],
+			steps: []step{
+				{off: -10, whence: io.SeekEnd, pos: 4990, n: -1},
+				{off: 1234, whence: io.SeekStart, pos: 1234, n: 100},
+				{off: 5000, whence: io.SeekStart, pos: 5000, n: -1},
+			},
+		},
+		{
+			desc:  "range past the end",
+			start: 9000,
+			size:  5000,
+			src:   data[9000:],
+			steps: []step{
+				{off: -17, whence: io.SeekEnd, pos: 1000, n: -1},
+			},
+		},
+	}
+	for _, e := range table {
+		r := o.NewRangeReader(ctx, e.start, e.size)
+		r.ChunkSize = 1000
+		r.ConcurrentDownloads = 4
+		for i, s := range e.steps {
+			pos, err := r.Seek(s.off, s.whence)
+			if err != nil {
+				t.Fatalf("%s, step %d: Seek(%d, %d): %v", e.desc, i, s.off, s.whence, err)
+			}
+			if pos != s.pos {
+				t.Errorf("%s, step %d: Seek(%d, %d): got %d, want %d", e.desc, i, s.off, s.whence, pos, s.pos)
+			}
+			want := []byte{}
+			if pos < int64(len(e.src)) {
+				want = e.src[pos:]
+			}
+			var got []byte
+			if s.n < 0 {
+				got, err = ioutil.ReadAll(r)
+			} else {
+				want = want[:s.n]
+				got = make([]byte, s.n)
+				_, err = io.ReadFull(r, got)
+			}
+			if err != nil {
+				t.Fatalf("%s, step %d: %v", e.desc, i, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s, step %d: got %d bytes, want %d bytes of source from %d", e.desc, i, len(got), len(want), pos)
+			}
+		}
+		if _, err := r.Seek(-1, io.SeekStart); err == nil {
+			t.Errorf("%s: Seek to -1: got nil error", e.desc)
+		}
+		r.Close()
+	}
+}
+
+func TestObjectExists(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", "hidden"} {
+		w := bucket.Object(name).NewWriter(ctx)
+		if _, err := io.WriteString(w, name); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bucket.Object("hidden").Hide(ctx); err != nil {
+		t.Fatal(err)
+	}
+	downloads := root.errs.count("downloadFileByName")
+
+	for _, e := range []struct {
+		name string
+		want bool
+	}{
+		{name: "file", want: true},
+		{name: "fil", want: false},
+		{name: "missing", want: false},
+		{name: "hidden", want: false},
+	} {
+		got, err := bucket.Object(e.name).Exists(ctx)
+		if err != nil {
+			t.Errorf("Exists(%q): %v", e.name, err)
+		}
+		if got != e.want {
+			t.Errorf("Exists(%q): got %v, want %v", e.name, got, e.want)
+		}
+	}
+	if got := root.errs.count("downloadFileByName"); got != downloads {
+		t.Errorf("Exists made %d downloads, want none", got-downloads)
+	}
+
+	n := root.errs.count("listFileNames")
+	root.errs.errMap = map[string]map[int]error{
+		"listFileNames": {n: errors.New("connection reset")},
+	}
+	if got, err := bucket.Object("file").Exists(ctx); err == nil {
+		t.Errorf("Exists with a failed listing: got (%v, nil), want an error", got)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		desc     string
+		size     int64
+		partSize int64
+		workers  int
+		attrs    *Attrs
+		meta     bool // attrs are given with CopyMetadata
+		parts    int
+		wantCT   string
+		wantInfo map[string]string
+	}{
+		{
+			desc:     "small copy keeps metadata",
+			size:     1e4,
+			wantCT:   "text/plain",
+			wantInfo: map[string]string{"color": "blue"},
+		},
+		{
+			desc:     "small copy replaces metadata",
+			size:     1e4,
+			attrs:    &Attrs{ContentType: "text/html", Info: map[string]string{"color": "red"}},
+			wantCT:   "text/html",
+			wantInfo: map[string]string{"color": "red"},
+		},
+		{
+			desc:     "small copy replaces content type and info",
+			size:     1e4,
+			meta:     true,
+			attrs:    &Attrs{ContentType: "text/csv", Info: map[string]string{"shape": "square", "size": "big"}},
+			wantCT:   "text/csv",
+			wantInfo: map[string]string{"shape": "square", "size": "big"},
+		},
+		{
+			desc:     "large copy keeps metadata",
+			size:     1e5 + 42,
+			partSize: 1e4,
+			parts:    11,
+			wantCT:   "text/plain",
+			wantInfo: map[string]string{"color": "blue"},
+		},
+		{
+			desc:     "large copy replaces metadata",
+			size:     1e5,
+			partSize: 1e4,
+			parts:    10,
+			attrs:    &Attrs{ContentType: "text/html", Info: map[string]string{"color": "red"}},
+			wantCT:   "text/html",
+			wantInfo: map[string]string{"color": "red"},
+		},
+		{
+			desc:     "large copy replaces content type and info",
+			size:     1e5,
+			partSize: 1e4,
+			parts:    10,
+			meta:     true,
+			attrs:    &Attrs{Info: map[string]string{"shape": "round"}},
+			wantCT:   "application/octet-stream",
+			wantInfo: map[string]string{"shape": "round"},
+		},
+		{
+			desc:     "large copy in parallel",
+			size:     1e5 + 42,
+			partSize: 1e4,
+			workers:  4,
+			parts:    11,
+			wantCT:   "text/plain",
+			wantInfo: map[string]string{"color": "blue"},
+		},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+			},
+		}
+		src, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := client.NewBucket(ctx, bucketName+"-dst", &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := src.Object("src").NewWriter(ctx, WithAttrsOption(&Attrs{
+			ContentType: "text/plain",
+			Info:        map[string]string{"color": "blue"},
+		}))
+		h := sha1.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(zReader{}, e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		uploads := root.errs.count("uploadPart") + root.errs.count("getUploadURL")
+		opts := []CopyOption{CopyPartSize(e.partSize), CopyConcurrency(e.workers)}
+		switch {
+		case e.meta:
+			opts = append(opts, CopyMetadata(e.attrs.ContentType, e.attrs.Info))
+		case e.attrs != nil:
+			opts = append(opts, CopyAttrs(e.attrs))
+		}
+		// Copy from a fresh Object, as a caller would, rather than the one
+		// that was written.
+		obj, err := src.Object("src").CopyTo(ctx, dst, "dst", opts...)
+		if err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+			continue
+		}
+		if got := root.errs.count("copyPart"); got != e.parts {
+			t.Errorf("%s: got %d copied parts, want %d", e.desc, got, e.parts)
+		}
+		if got := root.errs.count("uploadPart") + root.errs.count("getUploadURL"); got != uploads {
+			t.Errorf("%s: copy uploaded data", e.desc)
+		}
+		if err := readFile(ctx, dst.Object("dst"), fmt.Sprintf("%x", h.Sum(nil)), 1e4, 2); err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+		}
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentType != e.wantCT {
+			t.Errorf("%s: got content type %q, want %q", e.desc, attrs.ContentType, e.wantCT)
+		}
+		if !reflect.DeepEqual(attrs.Info, e.wantInfo) {
+			t.Errorf("%s: got info %v, want %v", e.desc, attrs.Info, e.wantInfo)
+		}
+	}
+}
+
+func TestCopyToPartError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writeFile(ctx, bucket, "src", 1e5, 1e4); err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("copy failed")
+	root.errs.errMap = map[string]map[int]error{"copyPart": {2: fail}}
+	_, err = bucket.Object("src").CopyTo(ctx, bucket, "dst", CopyPartSize(1e4), CopyConcurrency(3))
+	if !errors.Is(err, fail) {
+		t.Errorf("CopyTo: got %v, want %v", err, fail)
+	}
+	if _, err := bucket.Object("dst").Attrs(ctx); !IsNotExist(err) {
+		t.Errorf("after a failed copy, Attrs of the destination: got %v, want not found", err)
+	}
+}
+
+func TestCopyMetadataInvalid(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writeFile(ctx, bucket, "src", 1e4, 1e4); err != nil {
+		t.Fatal(err)
+	}
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	for _, e := range []struct {
+		info map[string]string
+		want error
+	}{
+		{info: tooMany, want: ErrTooManyInfoKeys},
+		{info: map[string]string{"bad key!": "v"}, want: ErrInvalidInfoKey},
+	} {
+		before := root.errs.count("downloadFileByName") + root.errs.count("copyFile")
+		_, err := bucket.Object("src").CopyTo(ctx, bucket, "dst", CopyMetadata("text/plain", e.info))
+		if !errors.Is(err, e.want) {
+			t.Errorf("CopyMetadata(%v): got %v, want %v", e.info, err, e.want)
+		}
+		if root.errs.count("downloadFileByName")+root.errs.count("copyFile") != before {
+			t.Errorf("CopyMetadata(%v): CopyTo made requests before checking the info", e.info)
+		}
+	}
+	if _, err := bucket.Object("dst").Attrs(ctx); !IsNotExist(err) {
+		t.Errorf("after failed copies, Attrs of the destination: got %v, want not found", err)
+	}
+}
+
+func TestCopyToLargeParts(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// The uploaded source is reported to hold 12GB.
+	rt := &recordingTransport{size: 12e9}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("big").NewWriter(ctx)
+	if _, err := io.Copy(w, strings.NewReader("placeholder")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy from an Object that knows only the ID, as one from a listing would,
+	// so that its size comes from B2.
+	src := &Object{name: "big", f: bucket.b.file("small", "big"), b: bucket}
+	if _, err := src.CopyTo(ctx, bucket, "copy", CopyConcurrency(3)); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	got := append([]string(nil), rt.copied...)
+	large := rt.large
+	rt.mu.Unlock()
+	sort.Strings(got)
+	want := []string{
+		"1 bytes=0-4999999999",
+		"2 bytes=5000000000-9999999999",
+		"3 bytes=10000000000-11999999999",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copied parts: got %q, want %q", got, want)
+	}
+	if large != "copy" {
+		t.Errorf("started large file %q, want %q", large, "copy")
+	}
+}
+
+func TestWriterChunkSize(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		size  int64
+		csize int
+		parts int
+	}{
+		{
+			size:  45e6,
+			csize: 1e7,
+			parts: 5,
+		},
+		{
+			// Chunk sizes below the minimum are raised to the minimum.
+			size:  12e6,
+			csize: 1e6,
+			parts: 3,
+		},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+			partSize:  5e6,
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := writeFile(ctx, bucket, largeFileName, e.size, e.csize); err != nil {
+			t.Fatal(err)
+		}
+		if got := root.errs.count("uploadPart"); got != e.parts {
+			t.Errorf("writeFile(%d, %d): got %d parts, want %d", e.size, e.csize, got, e.parts)
+		}
+	}
+}
+
+func TestWriterOneLargeWrite(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 500e6
+	w := bucket.Object("big").NewWriter(ctx)
+	w.ChunkSize = 1e6
+	w.ConcurrentUploads = 4
+	n, err := w.Write(make([]byte, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size {
+		t.Errorf("Write: got %d bytes, want %d", n, int(size))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var parts int
+	for _, r := range rt.reqs {
+		if strings.HasPrefix(r, "b2_upload_part ") {
+			parts++
+		}
+	}
+	if parts != 500 {
+		t.Errorf("got %d parts, want 500", parts)
+	}
+	if rt.parts != size {
+		t.Errorf("uploaded %d bytes, want %d", rt.parts, int(size))
+	}
+}
+
+func TestWriterExactChunk(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const csize = 1e4
+	w := bucket.Object("exact").NewWriter(ctx)
+	w.ChunkSize = csize
+	n, err := w.Write(make([]byte, csize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != csize {
+		t.Errorf("Write: got %d bytes, want %d", n, int(csize))
+	}
+	// The full chunk is sent by Write, not held for Close.
+	if w.cidx != 1 || w.w.Len() != 0 {
+		t.Errorf("after Write: got %d chunks sent and %d bytes buffered, want 1 and 0", w.cidx, w.w.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var parts int
+	for _, r := range rt.reqs {
+		if strings.HasPrefix(r, "b2_upload_part ") {
+			parts++
+		}
+	}
+	if parts != 1 || rt.parts != csize {
+		t.Errorf("got %d parts of %d bytes, want 1 part of %d bytes", parts, rt.parts, int(csize))
+	}
+}
+
+func TestWriterPartHashes(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 10
+	w.ConcurrentUploads = 3
+	if got := w.PartHashes(); got != nil {
+		t.Errorf("before Write: got %v, want nil", got)
+	}
+	data := "aaaaaaaaaabbbbbbbbbbccccc"
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range []string{data[:10], data[10:20], data[20:]} {
+		want = append(want, fmt.Sprintf("%x", sha1.Sum([]byte(p))))
+	}
+	got := w.PartHashes()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PartHashes: got %v, want %v", got, want)
+	}
+	rt.mu.Lock()
+	finished := rt.finished
+	rt.mu.Unlock()
+	if !reflect.DeepEqual(got, finished) {
+		t.Errorf("PartHashes: got %v, but b2_finish_large_file was sent %v", got, finished)
+	}
+
+	// A small object has no parts.
+	w = bucket.Object(smallFileName).NewWriter(ctx)
+	if _, err := io.WriteString(w, "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.PartHashes(); got != nil {
+		t.Errorf("small object: got %v, want nil", got)
+	}
+}
+
+func TestPartURLReuse(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := func() int {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		var n int
+		for _, r := range rt.reqs {
+			if strings.HasPrefix(r, "b2_get_upload_part_url ") {
+				n++
+			}
+		}
+		rt.reqs = nil
+		return n
+	}
+
+	// Parts uploaded one after another share one URL.
+	pw, err := bucket.Object(largeFileName).NewPartWriter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 3; n++ {
+		p, err := pw.Part(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(p, "aaaaaaaaaa"); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got := urls(); got != 1 {
+		t.Errorf("PartWriter: got %d b2_get_upload_part_url calls for 3 parts, want 1", got)
+	}
+
+	// Threads that are never given a part don't ask for a URL.
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 10
+	w.ConcurrentUploads = 4
+	if _, err := w.Write(bytes.Repeat([]byte("a"), 30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := urls(); got < 1 || got > 3 {
+		t.Errorf("Writer: got %d b2_get_upload_part_url calls for 3 parts, want 1 to 3", got)
+	}
+}
+
+func TestPartWriter(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bucket.Object("regions")
+	pw, err := obj.NewPartWriter(ctx, WithAttrsOption(&Attrs{ContentType: "text/plain"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pw.Part(0); err == nil {
+		t.Error("Part(0): got no error")
+	}
+	regions := map[int]string{
+		1: strings.Repeat("a", 1e4),
+		2: strings.Repeat("b", 1e4),
+		3: "the end",
+	}
+	for _, n := range []int{3, 1, 2} {
+		p, err := pw.Part(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(p, regions[n]); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("part %d: %v", n, err)
+		}
+	}
+	if err := pw.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	want := regions[1] + regions[2] + regions[3]
+	r := bucket.Object("regions").NewReader(ctx)
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %d bytes beginning %.10q, want %d bytes beginning %.10q", len(got), got, len(want), want)
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" {
+		t.Errorf("got content type %q, want text/plain", attrs.ContentType)
+	}
+	if _, err := pw.Part(4); err == nil {
+		t.Error("Part after Finish: got no error")
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("abandoned").NewWriter(ctx)
+	w.ChunkSize = 10
+	w.ConcurrentUploads = 2
+	if _, err := w.Write(make([]byte, 25)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("more")); !errors.Is(err, ErrAborted) {
+		t.Errorf("Write after Abort: got %v, want ErrAborted", err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrAborted) {
+		t.Errorf("Close after Abort: got %v, want ErrAborted", err)
+	}
+	rt.mu.Lock()
+	var cancels, finishes int
+	for _, r := range rt.reqs {
+		switch {
+		case strings.HasPrefix(r, "b2_cancel_large_file "):
+			cancels++
+		case strings.HasPrefix(r, "b2_finish_large_file "):
+			finishes++
+		}
+	}
+	rt.mu.Unlock()
+	if cancels != 1 || finishes != 0 {
+		t.Errorf("got %d cancels and %d finishes, want 1 and 0", cancels, finishes)
+	}
+
+	// A Writer that never started a large file has nothing to cancel.
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client = &Client{backend: &beRoot{b2i: root}}
+	bucket, err = client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = bucket.Object("small").NewWriter(ctx)
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrAborted) {
+		t.Errorf("Close after Abort: got %v, want ErrAborted", err)
+	}
+	if n := root.errs.count("cancelLargeFile"); n != 0 {
+		t.Errorf("got %d cancelLargeFile calls, want 0", n)
+	}
+	if _, err := bucket.Object("small").Attrs(ctx); !IsNotExist(err) {
+		t.Errorf("Attrs of an aborted object: got %v, want not found", err)
+	}
+}
+
+func TestCancelUnfinished(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := func(name string) *PartWriter {
+		pw, err := bucket.Object(name).NewPartWriter(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pw
+	}
+	old := start("old")
+	start("new")
+	done := start("done")
+	if err := done.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	gmux.Lock()
+	root.lfs[old.ID()].started = time.Now().Add(-48 * time.Hour)
+	gmux.Unlock()
+
+	unfinished := func() []string {
+		var names []string
+		iter := bucket.List(ctx, ListUnfinished(), ListPageSize(1))
+		for iter.Next() {
+			names = append(names, iter.Object().Name())
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got, want := unfinished(), []string{"new", "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfinished: got %v, want %v", got, want)
+	}
+
+	n, err := bucket.CancelUnfinishedOlderThan(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("CancelUnfinishedOlderThan(24h): cancelled %d, want 1", n)
+	}
+	if got, want := unfinished(), []string{"new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfinished: got %v, want %v", got, want)
+	}
+
+	n, err = bucket.CancelUnfinishedOlderThan(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("CancelUnfinishedOlderThan(0): cancelled %d, want 1", n)
+	}
+	if got := unfinished(); len(got) != 0 {
+		t.Errorf("unfinished: got %v, want none", got)
+	}
+	if n := root.errs.count("cancelLargeFile"); n != 2 {
+		t.Errorf("got %d cancelLargeFile calls, want 2", n)
+	}
+}
+
+func TestWriterLargeFileSHA1(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1e5+17)
+	rand.New(rand.NewSource(57)).Read(data)
+	want := fmt.Sprintf("%x", sha1.Sum(data))
+
+	table := []struct {
+		desc string
+		r    io.Reader
+		want string
+	}{
+		{
+			desc: "seekable",
+			r:    bytes.NewReader(data),
+			want: want,
+		},
+		{
+			// The hash isn't known until the upload has begun.
+			desc: "not seekable",
+			r:    io.MultiReader(bytes.NewReader(data)),
+			want: "none",
+		},
+	}
+	for _, e := range table {
+		obj := bucket.Object(e.desc)
+		w := obj.NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.LargeFileSHA1 = true
+		if _, err := w.ReadFrom(e.r); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if attrs.SHA1 != e.want {
+			t.Errorf("%s: got SHA1 %q, want %q", e.desc, attrs.SHA1, e.want)
+		}
+	}
+
+	// A SHA1 given by the caller is kept.
+	obj := bucket.Object("given")
+	w := obj.NewWriter(ctx, WithAttrsOption(&Attrs{SHA1: "given"}))
+	w.ChunkSize = 1e4
+	w.LargeFileSHA1 = true
+	if _, err := w.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.SHA1 != "given" {
+		t.Errorf("got SHA1 %q, want the given one", attrs.SHA1)
+	}
+}
+
+func TestResumeWriterByID(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			errMap: map[string]map[int]error{
+				"uploadPart": {3: testError{}},
+			},
+		},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after three of five parts.  The data must not depend on how it is
+	// read, since the two writers read it differently.
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	if _, err := io.Copy(w, struct{ io.Reader }{&zReadSeeker{size: 5e4}}); err == nil {
+		w.Close()
+		t.Fatal("io.Copy: should have returned an error")
+	}
+	id := w.FileID()
+	if id == "" {
+		t.Fatal("FileID(): got empty ID")
+	}
+	if got := len(w.UploadedParts()); got != 3 {
+		t.Errorf("UploadedParts(): got %d parts, want 3", got)
+	}
+	sent := root.errs.count("uploadPart")
+
+	rw := bucket.ResumeWriter(ctx, largeFileName, id)
+	rw.ChunkSize = 1e4
+	h := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(rw, h), struct{ io.Reader }{&zReadSeeker{size: 5e4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := root.errs.count("uploadPart") - sent; got != 2 {
+		t.Errorf("resumed upload sent %d parts, want 2", got)
+	}
+	if err := readFile(ctx, bucket.Object(largeFileName), fmt.Sprintf("%x", h.Sum(nil)), 1e4, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		size  int64
+		csize int
+		total int64
+	}{
+		{
+			size:  1e5 + 42,
+			csize: 1e6,
+			total: 1e5 + 42,
+		},
+		{
+			size:  1e6 + 42,
+			csize: 1e4,
+			total: -1,
+		},
+	}
+
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object("file").NewWriter(ctx)
+		w.ChunkSize = e.csize
+		w.ConcurrentUploads = 4
+		var last, total int64
+		w.OnProgress = func(complete, tot int64) {
+			if complete < last {
+				t.Errorf("OnProgress: complete went from %d to %d", last, complete)
+			}
+			last, total = complete, tot
+		}
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if last != e.size {
+			t.Errorf("OnProgress: got %d bytes complete, want %d", last, e.size)
+		}
+		if total != e.total {
+			t.Errorf("OnProgress: got total %d, want %d", total, e.total)
+		}
+	}
+}
+
+type testLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) V(int) bool { return true }
+
+func (l *testLogger) Info(a ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprint(a...))
+}
+
+func (l *testLogger) Error(a ...interface{}) { l.Info(a...) }
+
+func (l *testLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.msgs {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	logger := &testLogger{}
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs: &errCont{
+					errMap: map[string]map[int]error{
+						"uploadPart": {1: testError{reupload: true}},
+					},
+				},
+			},
+		},
+	}
+	WithLogger(logger)(&client.opts)
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writeFile(ctx, bucket, largeFileName, 3e4, 1e4); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.contains("retrying") {
+		t.Errorf("logger did not record a retry; got %q", logger.msgs)
+	}
+}
+
+func TestWriterLargeFileThreshold(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	yes, no := true, false
+	table := []struct {
+		desc      string
+		size      int64
+		csize     int
+		threshold int64
+		large     *bool
+		parts     int
+	}{
+		{
+			desc:  "force large file",
+			size:  2e4,
+			csize: 1e5,
+			large: &yes,
+			parts: 1,
+		},
+		{
+			desc:  "disable large file",
+			size:  3e4,
+			csize: 1e4,
+			large: &no,
+		},
+		{
+			desc:      "below raised threshold",
+			size:      2e4,
+			csize:     1e4,
+			threshold: 2.5e4,
+		},
+		{
+			desc:      "above raised threshold",
+			size:      5e4,
+			csize:     1e4,
+			threshold: 2.5e4,
+			parts:     4,
+		},
+		{
+			desc:      "above lowered threshold",
+			size:      2e4,
+			csize:     1e5,
+			threshold: 1e4,
+			parts:     1,
+		},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx)
+		w.ChunkSize = e.csize
+		w.ConcurrentUploads = 3
+		w.LargeFileThreshold = e.threshold
+		w.UseLargeFile = e.large
+		h := sha1.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(zReader{}, e.size)); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if got := root.errs.count("uploadPart"); got != e.parts {
+			t.Errorf("%s: got %d parts, want %d", e.desc, got, e.parts)
+		}
+		simple := 0
+		if e.parts == 0 {
+			simple = 1
+		}
+		if got := root.errs.count("getUploadURL"); got != simple {
+			t.Errorf("%s: got %d simple uploads, want %d", e.desc, got, simple)
+		}
+		if err := readFile(ctx, o, fmt.Sprintf("%x", h.Sum(nil)), 1e4, 2); err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+		}
+	}
+}
+
+func TestWriterSHA1Mismatch(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		size   int64
+		csize  int
+		badSHA bool
+	}{
+		{size: 1e4, csize: 1e5},
+		{size: 1e4, csize: 1e5, badSHA: true},
+		{size: 1e5 + 42, csize: 1e4},
+		{size: 1e5 + 42, csize: 1e4, badSHA: true},
+	}
+
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{badSHA: e.badSHA},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = writeFile(ctx, bucket, "file", e.size, e.csize)
+		if got := errors.Is(err, ErrSHA1Mismatch); got != e.badSHA {
+			t.Errorf("writeFile(%d, %d) with bad SHA1 %v: got %v, want ErrSHA1Mismatch: %v", e.size, e.csize, e.badSHA, err, e.badSHA)
+		}
+	}
+}
+
+func TestWriterHeaders(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	expires := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	want := map[string]string{
+		"b2-content-disposition": "attachment; filename=\"file.txt\"",
+		"b2-content-language":    "en",
+		"b2-cache-control":       "max-age=3600",
+		"b2-expires":             "Wed, 02 Jan 2030 03:04:05 GMT",
+		"custom":                 "value",
+	}
+
+	table := []struct {
+		size  int64
+		info  int
+		fails bool
+	}{
+		{size: 1e4},
+		{size: 1e5 + 42},
+		{size: 1e4, info: 6},
+		{size: 1e4, info: 7, fails: true},
+		{size: 1e5 + 42, info: 7, fails: true},
+	}
+
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := map[string]string{"custom": "value"}
+		for i := 1; i < e.info; i++ {
+			info[fmt.Sprintf("key%d", i)] = "value"
+		}
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx, WithAttrsOption(&Attrs{Info: info}))
+		w.ChunkSize = 1e4
+		w.ContentDisposition = want["b2-content-disposition"]
+		w.ContentLanguage = want["b2-content-language"]
+		w.CacheControl = want["b2-cache-control"]
+		w.Expires = expires.In(time.FixedZone("EST", -5*3600))
+		// A failed Writer cancels its context, so io.Copy may not report the
+		// cause; Close will.
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, e.size)); err != nil && !e.fails {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if e.fails {
+			if !errors.Is(err, ErrTooManyInfoKeys) {
+				t.Errorf("writing %d bytes with %d info keys: got %v, want ErrTooManyInfoKeys", e.size, e.info+4, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("writing %d bytes with %d info keys: %v", e.size, e.info+4, err)
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range want {
+			if got := attrs.Info[k]; got != v {
+				t.Errorf("Attrs().Info[%q]: got %q, want %q", k, got, v)
+			}
+		}
+	}
+}
+
+func TestWriterLastModified(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// B2 keeps the time to the millisecond.
+	mtime := time.Date(2009, time.November, 10, 23, 4, 5, 678901234, time.UTC)
+	want := mtime.Truncate(time.Millisecond)
+
+	for _, size := range []int64{1e4, 1e5 + 42} {
+		o := bucket.Object(fmt.Sprintf("file-%d", size))
+		w := o.NewWriter(ctx, WithAttrsOption(&Attrs{LastModified: time.Now()}))
+		w.ChunkSize = 1e4
+		w.LastModified = mtime
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.LastModified.Equal(want) {
+			t.Errorf("%d bytes: got LastModified %v, want %v", size, attrs.LastModified, want)
+		}
+		if v, ok := attrs.Info["src_last_modified_millis"]; ok {
+			t.Errorf("%d bytes: src_last_modified_millis left in Info as %q", size, v)
+		}
+	}
+}
+
+func TestBatchAttrs(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("obj/%02d", i)
+		names = append(names, name)
+		w := bucket.Object(name).NewWriter(ctx, WithAttrsOption(&Attrs{
+			ContentType: "text/plain",
+			Info:        map[string]string{"n": strconv.Itoa(i)},
+		}))
+		if _, err := io.WriteString(w, strings.Repeat("x", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attrs, errs := bucket.BatchAttrs(ctx, names, 8)
+	if errs != nil {
+		t.Fatalf("BatchAttrs: %v", errs)
+	}
+	if len(attrs) != len(names) {
+		t.Errorf("BatchAttrs: got %d attrs, want %d", len(attrs), len(names))
+	}
+	for i, name := range names {
+		a, ok := attrs[name]
+		if !ok {
+			t.Errorf("%s: no attrs", name)
+			continue
+		}
+		if a.Name != name || a.Size != int64(i) || a.ContentType != "text/plain" || a.Info["n"] != strconv.Itoa(i) {
+			t.Errorf("%s: got %+v", name, a)
+		}
+	}
+
+	// Missing objects are reported by name.
+	attrs, errs = bucket.BatchAttrs(ctx, []string{"obj/00", "missing"}, 0)
+	if _, ok := attrs["obj/00"]; !ok || len(attrs) != 1 {
+		t.Errorf("BatchAttrs with a missing object: got attrs %v", attrs)
+	}
+	if err := errs["missing"]; !IsNotExist(err) || len(errs) != 1 {
+		t.Errorf("BatchAttrs with a missing object: got errs %v, want missing not to exist", errs)
+	}
+}
+
+func TestReaderVerifySHA1(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, size int, large, largeSHA1 bool) {
+		w := bucket.Object(name).NewWriter(ctx)
+		if large {
+			w.ChunkSize = 1e4
+		}
+		w.LargeFileSHA1 = largeSHA1
+		data := make([]byte, size)
+		zReader{}.Read(data)
+		if _, err := w.ReadFrom(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("small", 1e3, false, false)
+	write("large", 5e4, true, true)
+	write("unhashed", 5e4, true, false)
+
+	for _, name := range []string{"small", "large", "unhashed"} {
+		r := bucket.Object(name).NewReader(ctx)
+		r.VerifySHA1 = true
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Errorf("%s: read: %v", name, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: close: %v", name, err)
+		}
+	}
+
+	// Corrupt the stored bytes, as if they had been damaged in transit.
+	corrupt := func(name string) {
+		gmux.Lock()
+		defer gmux.Unlock()
+		f := []byte(root.bucketMap[bucketName][name])
+		f[len(f)/2] ^= 0xff
+		root.bucketMap[bucketName][name] = string(f)
+	}
+	corrupt("small")
+	corrupt("large")
+	corrupt("unhashed")
+
+	for _, name := range []string{"small", "large"} {
+		r := bucket.Object(name).NewReader(ctx)
+		r.VerifySHA1 = true
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrSHA1Mismatch) {
+			t.Errorf("%s: read: got %v, want ErrSHA1Mismatch", name, err)
+		}
+		if err := r.Close(); !errors.Is(err, ErrSHA1Mismatch) {
+			t.Errorf("%s: close: got %v, want ErrSHA1Mismatch", name, err)
+		}
+
+		r = bucket.Object(name).NewReader(ctx)
+		r.VerifySHA1 = true
+		if _, err := io.Copy(ioutil.Discard, r); !errors.Is(err, ErrSHA1Mismatch) {
+			t.Errorf("%s: WriteTo: got %v, want ErrSHA1Mismatch", name, err)
+		}
+		r.Close()
+
+		// Without VerifySHA1, nothing is checked.
+		r = bucket.Object(name).NewReader(ctx)
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Errorf("%s: read without VerifySHA1: %v", name, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: close without VerifySHA1: %v", name, err)
+		}
+	}
+
+	// A large object without a SHA1 can't be checked.
+	r := bucket.Object("unhashed").NewReader(ctx)
+	r.VerifySHA1 = true
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("unhashed: read: %v", err)
+	}
+	r.Close()
+
+	// Nor can part of an object.
+	r = bucket.Object("small").NewRangeReader(ctx, 10, 100)
+	r.VerifySHA1 = true
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("range: read: %v", err)
+	}
+	r.Close()
+}
+
+func TestReaderAutoDecompress(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var orig bytes.Buffer
+	for i := 0; orig.Len() < 2e5; i++ {
+		fmt.Fprintf(&orig, "line %d of a compressible object\n", i)
+	}
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	if _, err := zw.Write(orig.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, enc string, data []byte) *Object {
+		o := bucket.Object(name)
+		w := o.NewWriter(ctx)
+		w.ContentEncoding = enc
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	gzObj := write("zipped", "gzip", zipped.Bytes())
+	plain := write("plain", "", orig.Bytes())
+
+	attrs, err := gzObj.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attrs.Info["b2-content-encoding"]; got != "gzip" {
+		t.Errorf("b2-content-encoding: got %q, want gzip", got)
+	}
+
+	table := []struct {
+		o    *Object
+		auto bool
+		want []byte
+	}{
+		{o: gzObj, auto: true, want: orig.Bytes()},
+		{o: gzObj, auto: false, want: zipped.Bytes()},
+		{o: plain, auto: true, want: orig.Bytes()},
+	}
+	for _, e := range table {
+		for _, writeTo := range []bool{false, true} {
+			r := e.o.NewReader(ctx)
+			r.ChunkSize = 1e4
+			r.ConcurrentDownloads = 3
+			r.AutoDecompress = e.auto
+			var got bytes.Buffer
+			var err error
+			if writeTo {
+				_, err = io.Copy(&got, r)
+			} else {
+				_, err = io.Copy(&got, struct{ io.Reader }{r}) // hide WriteTo
+			}
+			r.Close()
+			if err != nil {
+				t.Errorf("%s (auto %v, WriteTo %v): %v", e.o.Name(), e.auto, writeTo, err)
+				continue
+			}
+			if !bytes.Equal(got.Bytes(), e.want) {
+				t.Errorf("%s (auto %v, WriteTo %v): got %d bytes, want %d", e.o.Name(), e.auto, writeTo, got.Len(), len(e.want))
+			}
+		}
+	}
+
+	r := gzObj.NewReader(ctx)
+	r.AutoDecompress = true
+	defer r.Close()
+	if _, err := r.Seek(10, io.SeekStart); err == nil {
+		t.Error("Seek with AutoDecompress: got no error")
+	}
+}
+
+func TestBucketUpload(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 5e4)
+	zReader{}.Read(data)
+	chunks := WriterOption(func(w *Writer) { w.ChunkSize = 1e4 })
+	table := []struct {
+		desc   string
+		size   int64
+		src    []byte
+		seeker bool
+		opts   []WriterOption
+		files  int // single requests sent
+		parts  int // large file parts sent
+		fail   bool
+	}{
+		{desc: "small", size: 5e3, src: data[:5e3], files: 1},
+		{desc: "small, seeker", size: 5e3, src: data[:5e3], seeker: true, files: 1},
+		{desc: "empty", size: 0, files: 1},
+		{desc: "large", size: 5e4, src: data, opts: []WriterOption{chunks}, parts: 5},
+		{desc: "large, seeker", size: 5e4, src: data, seeker: true, opts: []WriterOption{chunks}, parts: 5},
+		{desc: "short", size: 5e3, src: data[:4e3], fail: true},
+		{desc: "long", size: 5e3, src: data[:6e3], fail: true},
+		{desc: "long, empty", size: 0, src: data[:1], fail: true},
+		{desc: "large, short", size: 5e4, src: data[:4e4], opts: []WriterOption{chunks}, fail: true},
+	}
+	for i, e := range table {
+		name := fmt.Sprintf("obj%d", i)
+		files, parts := root.errs.count("uploadFile"), root.errs.count("uploadPart")
+		var r io.Reader = bytes.NewReader(e.src)
+		if !e.seeker {
+			r = struct{ io.Reader }{r}
+		}
+		o, err := bucket.Upload(ctx, name, r, e.size, e.opts...)
+		if e.fail {
+			if !errors.Is(err, ErrSizeMismatch) {
+				t.Errorf("%s: got %v, want ErrSizeMismatch", e.desc, err)
+			}
+			if ok, err := bucket.Object(name).Exists(ctx); err != nil || ok {
+				t.Errorf("%s: exists: %v, %v; want the object not written", e.desc, ok, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", e.desc, err)
+			continue
+		}
+		if n := root.errs.count("uploadFile") - files; n != e.files {
+			t.Errorf("%s: sent %d single requests, want %d", e.desc, n, e.files)
+		}
+		if n := root.errs.count("uploadPart") - parts; n != e.parts {
+			t.Errorf("%s: sent %d parts, want %d", e.desc, n, e.parts)
+		}
+		rd := o.NewReader(ctx)
+		got, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, e.src) {
+			t.Errorf("%s: read back %d bytes that differ from the %d uploaded", e.desc, len(got), len(e.src))
+		}
+	}
+}
+
+func TestWriterConcurrentClose(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, large := range []bool{false, true} {
+		o := bucket.Object(fmt.Sprintf("large-%v", large))
+		w := o.NewWriter(ctx)
+		if large {
+			w.ChunkSize = 1e4
+			w.ConcurrentUploads = 3
+		}
+		p := make([]byte, 1e3)
+		zReader{}.Read(p)
+		started := make(chan struct{})
+		type result struct {
+			n   int64
+			err error
+		}
+		done := make(chan result)
+		go func() {
+			var n int64
+			for i := 0; ; i++ {
+				if i == 10 {
+					close(started)
+				}
+				k, err := w.Write(p)
+				n += int64(k)
+				if err != nil {
+					done <- result{n, err}
+					return
+				}
+			}
+		}()
+		<-started
+		if err := w.Close(); err != nil {
+			t.Fatalf("large=%v: Close: %v", large, err)
+		}
+		res := <-done
+		if !errors.Is(res.err, ErrWriterClosed) {
+			t.Errorf("large=%v: Write after Close: got %v, want ErrWriterClosed", large, res.err)
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Size != res.n {
+			t.Errorf("large=%v: wrote %d bytes before Close, but the object holds %d", large, res.n, attrs.Size)
+		}
+		if _, err := w.ReadFrom(bytes.NewReader(p)); !errors.Is(err, ErrWriterClosed) {
+			t.Errorf("large=%v: ReadFrom after Close: got %v, want ErrWriterClosed", large, err)
+		}
+		if err := w.Flush(); !errors.Is(err, ErrWriterClosed) {
+			t.Errorf("large=%v: Flush after Close: got %v, want ErrWriterClosed", large, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("large=%v: second Close: %v", large, err)
+		}
+	}
+}
+
+func TestWriterExpectedSize(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 5e4)
+	zReader{}.Read(data)
+	table := []struct {
+		desc     string
+		expected int64
+		n        int  // bytes written
+		large    bool // with the large file API
+		stream   bool // from a ReadSeeker
+		compress bool
+		fail     bool
+	}{
+		{desc: "exact", expected: 1e3, n: 1e3},
+		{desc: "short", expected: 1e3, n: 999, fail: true},
+		{desc: "long", expected: 1e3, n: 1001, fail: true},
+		{desc: "unset", n: 1e3},
+		{desc: "large, exact", expected: 5e4, n: 5e4, large: true},
+		{desc: "large, short", expected: 5e4, n: 4e4, large: true, fail: true},
+		{desc: "streamed, exact", expected: 5e4, n: 5e4, large: true, stream: true},
+		{desc: "streamed, short", expected: 5e4, n: 4e4, large: true, stream: true, fail: true},
+		{desc: "compressed, exact", expected: 5e4, n: 5e4, compress: true},
+		{desc: "compressed, short", expected: 5e4, n: 4e4, compress: true, fail: true},
+	}
+	for i, e := range table {
+		o := bucket.Object(fmt.Sprintf("obj%d", i))
+		w := o.NewWriter(ctx)
+		w.ExpectedSize = e.expected
+		w.Compress = e.compress
+		if e.large {
+			w.ChunkSize = 1e4
+		}
+		var werr error
+		if e.stream {
+			_, werr = w.ReadFrom(bytes.NewReader(data[:e.n]))
+		} else {
+			_, werr = io.Copy(w, struct{ io.Reader }{bytes.NewReader(data[:e.n])})
+		}
+		cerr := w.Close()
+		if !e.fail {
+			if werr != nil || cerr != nil {
+				t.Errorf("%s: write: %v, close: %v", e.desc, werr, cerr)
+			}
+			if ok, err := o.Exists(ctx); err != nil || !ok {
+				t.Errorf("%s: exists: %v, %v", e.desc, ok, err)
+			}
+			continue
+		}
+		if e.stream && !errors.Is(werr, ErrSizeMismatch) {
+			t.Errorf("%s: ReadFrom: got %v, want ErrSizeMismatch", e.desc, werr)
+		}
+		if !errors.Is(cerr, ErrSizeMismatch) {
+			t.Errorf("%s: Close: got %v, want ErrSizeMismatch", e.desc, cerr)
+		}
+		if ok, err := o.Exists(ctx); err != nil || ok {
+			t.Errorf("%s: exists: %v, %v; want the object not written", e.desc, ok, err)
+		}
+	}
+
+	// Large files cut short are cancelled.
+	iter := bucket.List(ctx, ListUnfinished())
+	for iter.Next() {
+		t.Errorf("unfinished large file %s", iter.Object().Name())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterAutoContentType(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 5e4)...)
+	for i := 8; i < len(png); i++ {
+		png[i] = byte(i)
+	}
+	table := []struct {
+		desc, name, ct string
+		auto           bool
+		data           []byte
+		large, stream  bool // write with the large file API, from a ReadSeeker
+		want           string
+	}{
+		{desc: "by extension", name: "data.json", auto: true, data: []byte(`{"a": 1}`), want: "application/json"},
+		{desc: "sniffed", name: "image", auto: true, data: png, want: "image/png"},
+		{desc: "sniffed, large", name: "image-large", auto: true, data: png, large: true, want: "image/png"},
+		{desc: "sniffed, large, streamed", name: "image-stream", auto: true, data: png, large: true, stream: true, want: "image/png"},
+		{desc: "sniffed, small, streamed", name: "image-small-stream", auto: true, data: png[:100], stream: true, want: "image/png"},
+		{desc: "given", name: "data.json", ct: "text/x-given", auto: true, data: []byte(`{}`), want: "text/x-given"},
+		{desc: "off", name: "data.json", data: []byte(`{}`), want: "application/octet-stream"},
+		{desc: "empty", name: "empty", auto: true, want: "application/octet-stream"},
+	}
+	for _, e := range table {
+		o := bucket.Object(e.name)
+		w := o.NewWriter(ctx, WithAttrsOption(&Attrs{ContentType: e.ct}))
+		w.AutoContentType = e.auto
+		if e.large {
+			w.ChunkSize = 1e4
+		}
+		var err error
+		if e.stream {
+			_, err = w.ReadFrom(bytes.NewReader(e.data))
+		} else {
+			_, err = w.Write(e.data)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if attrs.ContentType != e.want {
+			t.Errorf("%s: got content type %q, want %q", e.desc, attrs.ContentType, e.want)
+		}
+		// Sniffing must not disturb the data sent.
+		r := o.NewReader(ctx)
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if !bytes.Equal(got, e.data) {
+			t.Errorf("%s: read back %d bytes that differ from the %d written", e.desc, len(got), len(e.data))
+		}
+	}
+}
+
+func TestWriterCompress(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hex digits compress to about half their size, so the large object still
+	// spans several parts.
+	rng := rand.New(rand.NewSource(42))
+	var text bytes.Buffer
+	for text.Len() < 5e5 {
+		fmt.Fprintf(&text, "%x\n", rng.Int63())
+	}
+
+	table := []struct {
+		name     string
+		data     []byte
+		readFrom bool
+	}{
+		{name: "empty"},
+		{name: "small", data: []byte("hello, world\n")},
+		{name: "large", data: text.Bytes()},
+		{name: "large-readfrom", data: text.Bytes(), readFrom: true},
+	}
+	for _, e := range table {
+		o := bucket.Object(e.name)
+		w := o.NewWriter(ctx)
+		w.ChunkSize = 5e4
+		w.ConcurrentUploads = 3
+		w.Compress = true
+		var err error
+		if e.readFrom {
+			_, err = w.ReadFrom(bytes.NewReader(e.data))
+		} else {
+			_, err = io.Copy(w, struct{ io.Reader }{bytes.NewReader(e.data)})
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := attrs.Info["b2-content-encoding"]; got != "gzip" {
+			t.Errorf("%s: b2-content-encoding: got %q, want gzip", e.name, got)
+		}
+
+		// The stored bytes are a gzip stream of the data, and B2 was given their
+		// size and hash.
+		raw := o.NewReader(ctx)
+		stored, err := ioutil.ReadAll(raw)
+		raw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Size != int64(len(stored)) {
+			t.Errorf("%s: got size %d, want %d", e.name, attrs.Size, len(stored))
+		}
+		if e.name == "large" && len(stored) <= int(w.ChunkSize) {
+			t.Errorf("%s: compressed to %d bytes, which doesn't span parts", e.name, len(stored))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(stored))
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if got, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(got, e.data) {
+			t.Errorf("%s: decompressing stored bytes: got %d bytes, %v; want %d bytes", e.name, len(got), err, len(e.data))
+		}
+
+		r := o.NewReader(ctx)
+		r.ChunkSize = 1e4
+		r.AutoDecompress = true
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if !bytes.Equal(got, e.data) {
+			t.Errorf("%s: AutoDecompress: got %d bytes, want %d", e.name, len(got), len(e.data))
+		}
+	}
+}
+
+func TestBucketSync(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	put := func(name string, data []byte) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a.txt", []byte("alpha"))
+	put("sub/b.txt", []byte("beta"))
+	put("sub/deep/c.bin", bytes.Repeat([]byte{7}, 3e4)) // a large object
+
+	// An object outside the prefix, which Sync must not touch.
+	outside, _, err := writeFile(ctx, bucket, "elsewhere", 10, 1e4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []SyncOption{
+		SyncPrefix("backup/"),
+		SyncConcurrency(2),
+		SyncWriterOptions(func(w *Writer) { w.ChunkSize = 1e4 }),
+	}
+	check := func(want *SyncResult, extra ...SyncOption) {
+		t.Helper()
+		got, err := bucket.Sync(ctx, dir, append(opts, extra...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Sync: got %+v, want %+v", got, want)
+		}
+	}
+
+	all := []string{"backup/a.txt", "backup/sub/b.txt", "backup/sub/deep/c.bin"}
+	check(&SyncResult{Uploaded: all})
+	check(&SyncResult{Unchanged: 3})
+
+	put("sub/b.txt", []byte("BETA")) // the same size, but new content
+	check(&SyncResult{Uploaded: []string{"backup/sub/b.txt"}, Unchanged: 2})
+
+	r := bucket.Object("backup/sub/b.txt").NewReader(ctx)
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "BETA" {
+		t.Errorf("backup/sub/b.txt: got %q, want BETA", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	check(&SyncResult{Unchanged: 2}) // without SyncDelete, the object stays
+	check(&SyncResult{Deleted: []string{"backup/a.txt"}, Unchanged: 2}, SyncDelete())
+	if ok, err := bucket.Object("backup/a.txt").Exists(ctx); err != nil || ok {
+		t.Errorf("backup/a.txt: Exists = %v, %v; want false", ok, err)
+	}
+	if ok, err := outside.Exists(ctx); err != nil || !ok {
+		t.Errorf("elsewhere: Exists = %v, %v; want true", ok, err)
+	}
+}
+
+func TestWriterValidate(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	table := []struct {
+		desc  string
+		name  string
+		attrs *Attrs
+		set   func(*Writer)
+		want  error // nil if any error will do
+	}{
+		{desc: "bad info key", attrs: &Attrs{Info: map[string]string{"bad key": "value"}}, want: ErrInvalidInfoKey},
+		{desc: "too many info keys", attrs: &Attrs{Info: tooMany}, want: ErrTooManyInfoKeys},
+		{desc: "control character", name: "file\x01", want: ErrInvalidObjectName},
+		{desc: "long name", name: strings.Repeat("a", 1025), want: ErrInvalidObjectName},
+		{desc: "bad content type", attrs: &Attrs{ContentType: "text/plain; ="}},
+		{desc: "bad SHA1", set: func(w *Writer) { w.SHA1 = "abc" }},
+		{desc: "bad retention mode", set: func(w *Writer) { w.Retention = &Retention{Mode: "forever", RetainUntil: time.Now().Add(time.Hour)} }},
+		{desc: "past retention", set: func(w *Writer) { w.Retention = &Retention{Mode: Governance, RetainUntil: time.Now().Add(-time.Hour)} }},
+		{desc: "no object lock", set: func(w *Writer) { w.LegalHold = true }, want: ErrObjectLockDisabled},
+	}
+	for _, e := range table {
+		name := e.name
+		if name == "" {
+			name = "file"
+		}
+		var opts []WriterOption
+		if e.attrs != nil {
+			opts = append(opts, WithAttrsOption(e.attrs))
+		}
+		w := bucket.Object(name).NewWriter(ctx, opts...)
+		if e.set != nil {
+			e.set(w)
+		}
+		err := w.Validate(ctx)
+		if err == nil || e.want != nil && !errors.Is(err, e.want) {
+			t.Errorf("%s: Validate: got %v, want %v", e.desc, err, e.want)
+		}
+	}
+
+	w := bucket.Object("file").NewWriter(ctx, WithAttrsOption(&Attrs{
+		ContentType: "text/plain; charset=utf-8",
+		Info:        map[string]string{"custom": "value"},
+	}))
+	w.SHA1 = fmt.Sprintf("%x", sha1.Sum([]byte("data")))
+	if err := w.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := root.errs.count("uploadFile"); got != 0 {
+		t.Errorf("Validate made %d uploads, want none", got)
+	}
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The upload used the URL Validate got.
+	if got := root.errs.count("getUploadURL"); got != 1 {
+		t.Errorf("got %d getUploadURL calls, want 1", got)
+	}
+}
+
+func TestListDelimiter(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/1", "a/2", "a/c/3", "b/4", "root1", "root2"} {
+		w := bucket.Object(name).NewWriter(ctx)
+		if _, err := io.WriteString(w, name); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table := []struct {
+		opts []ListOption
+		want []string
+	}{
+		{
+			want: []string{"a/1", "a/2", "a/c/3", "b/4", "root1", "root2"},
+		},
+		{
+			opts: []ListOption{ListDelimiter("/")},
+			want: []string{"a/", "b/", "root1", "root2"},
+		},
+		{
+			opts: []ListOption{ListDelimiter("/"), ListPrefix("a/")},
+			want: []string{"a/1", "a/2", "a/c/"},
+		},
+		{
+			opts: []ListOption{ListDelimiter("/"), ListPageSize(1)},
+			want: []string{"a/", "b/", "root1", "root2"},
+		},
+		{
+			opts: []ListOption{ListPrefix("a/")},
+			want: []string{"a/1", "a/2", "a/c/3"},
+		},
+	}
+
+	for _, e := range table {
+		var got []string
+		iter := bucket.List(ctx, e.opts...)
+		for iter.Next() {
+			got = append(got, iter.Object().Name())
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, e.want) {
+			t.Errorf("List(): got %v, want %v", got, e.want)
+		}
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"one", "two"} {
+		w := bucket.Object("file").NewWriter(ctx)
+		if _, err := io.WriteString(w, body); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bucket.Object("file").Hide(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	iter := bucket.List(ctx)
+	for iter.Next() {
+		t.Errorf("List(): hidden object %q was listed", iter.Object().Name())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []ObjectState
+	var sizes []int64
+	var last time.Time
+	iter = bucket.List(ctx, ListHidden())
+	for iter.Next() {
+		o := iter.Object()
+		if o.ID() == "" {
+			t.Errorf("List(ListHidden()): %q has no ID", o.Name())
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !last.IsZero() && attrs.UploadTimestamp.After(last) {
+			t.Errorf("List(ListHidden()): versions are not newest first")
+		}
+		last = attrs.UploadTimestamp
+		got = append(got, attrs.Status)
+		sizes = append(sizes, attrs.Size)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []ObjectState{Hider, Uploaded, Uploaded}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(ListHidden()): got states %v, want %v", got, want)
+	}
+	if want := []int64{0, 3, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("List(ListHidden()): got sizes %v, want %v", sizes, want)
+	}
+}
+
+func TestHide(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		w := bucket.Object(name).NewWriter(ctx)
+		if _, err := io.WriteString(w, name); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type version struct {
+		name  string
+		state ObjectState
+	}
+	list := func(l func(context.Context, int, *Cursor) ([]*Object, *Cursor, error)) []version {
+		objs, _, err := l(ctx, 10, nil)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		var vs []version
+		for _, o := range objs {
+			attrs, err := o.Attrs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vs = append(vs, version{o.Name(), attrs.Status})
+		}
+		return vs
+	}
+
+	if err := bucket.Object("a").Hide(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := list(bucket.ListCurrentObjects), []version{{"b", Uploaded}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after Hide, ListCurrentObjects: got %v, want %v", got, want)
+	}
+	want := []version{{"a", Hider}, {"a", Uploaded}, {"b", Uploaded}}
+	if got := list(bucket.ListObjects); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Hide, ListObjects: got %v, want %v", got, want)
+	}
+
+	if err := bucket.Reveal(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	want = []version{{"a", Uploaded}, {"b", Uploaded}}
+	if got := list(bucket.ListCurrentObjects); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Reveal, ListCurrentObjects: got %v, want %v", got, want)
+	}
+}
+
+func TestDeleteVersion(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objs []*Object
+	for _, data := range []string{"older", "newer"} {
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx)
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, o)
+	}
+	newer := objs[1]
+	if err := newer.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bucket.Object("file").NewReader(ctx)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "older" {
+		t.Errorf("after deleting the newer version, got %q, want %q", got, "older")
+	}
+	attrs, err := bucket.Object("file").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Status != Uploaded {
+		t.Errorf("after deleting the newer version, got status %v, want %v", attrs.Status, Uploaded)
+	}
+
+	if err := newer.Delete(ctx); !IsNotExist(err) {
+		t.Errorf("deleting a deleted version: got %v, want a not-found error", err)
+	}
+}
+
+func TestDeleteAllVersions(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", "file", "file", "file2"} {
+		w := bucket.Object(name).NewWriter(ctx)
+		if _, err := io.WriteString(w, name); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bucket.Object("file").Hide(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two callers racing to delete the same versions should both succeed.
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- bucket.DeleteAllVersions(ctx, "file") }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("DeleteAllVersions: %v", err)
+		}
+	}
+
+	var got []string
+	iter := bucket.List(ctx, ListHidden())
+	for iter.Next() {
+		got = append(got, iter.Object().Name())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"file2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after DeleteAllVersions, got versions %v, want %v", got, want)
+	}
+
+	if err := bucket.DeleteAllVersions(ctx, "file"); err != nil {
+		t.Errorf("DeleteAllVersions of a missing name: %v", err)
+	}
+}
+
+func TestPruneVersions(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := func(name string) []int64 {
+		var got []int64
+		iter := bucket.List(ctx, ListHidden(), ListPrefix(name))
+		for iter.Next() {
+			attrs, err := iter.Object().Attrs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, attrs.Size)
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// Each version's size tells it apart; the newest is listed first.
+	for i := 1; i <= 4; i++ {
+		w := bucket.Object("file").NewWriter(ctx)
+		w.KeepVersions = 2
+		if _, err := w.Write(bytes.Repeat([]byte("a"), i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := sizes("file"), []int64{4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with KeepVersions 2, got versions of sizes %v, want %v", got, want)
+	}
+
+	if err := bucket.PruneVersions(ctx, "file", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sizes("file"), []int64{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after PruneVersions(1), got versions of sizes %v, want %v", got, want)
+	}
+	if err := bucket.PruneVersions(ctx, "file", 0); err == nil {
+		t.Error("PruneVersions(0): got nil error")
+	}
+}
+
+func TestBucketDefaultRetention(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	def := &DefaultRetention{Mode: Governance, Period: 30, Unit: Days}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private, ObjectLock: true, DefaultRetention: def})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.ObjectLock || !reflect.DeepEqual(attrs.DefaultRetention, def) {
+		t.Errorf("after NewBucket: got lock %v, default %+v; want true, %+v", attrs.ObjectLock, attrs.DefaultRetention, def)
+	}
+
+	if err := bucket.Update(ctx, &BucketAttrs{DefaultRetention: &DefaultRetention{}}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.DefaultRetention != nil {
+		t.Errorf("after removing the default, got %+v, want nil", attrs.DefaultRetention)
+	}
+
+	plain, err := client.NewBucket(ctx, "plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Update(ctx, &BucketAttrs{DefaultRetention: def}); !errors.Is(err, ErrObjectLockDisabled) {
+		t.Errorf("Update without Object Lock: got %v, want ErrObjectLockDisabled", err)
+	}
+	if _, err := client.NewBucket(ctx, "plain2", &BucketAttrs{DefaultRetention: def}); !errors.Is(err, ErrObjectLockDisabled) {
+		t.Errorf("NewBucket without Object Lock: got %v, want ErrObjectLockDisabled", err)
+	}
+	if n := root.errs.count("createBucket"); n != 2 {
+		t.Errorf("createBucket called %d times, want 2", n)
+	}
+}
+
+func TestBucketSetType(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bt := range []BucketType{Public, Private, Snapshot} {
+		if err := bucket.SetType(ctx, bt); err != nil {
+			t.Fatalf("SetType(%q): %v", bt, err)
+		}
+		attrs, err := bucket.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Type != bt {
+			t.Errorf("after SetType(%q), got type %q", bt, attrs.Type)
+		}
+	}
+	for _, bt := range []BucketType{UnknownType, "allPublik"} {
+		if err := bucket.SetType(ctx, bt); err == nil {
+			t.Errorf("SetType(%q): got no error", bt)
+		}
+	}
+	if err := bucket.Update(ctx, &BucketAttrs{Type: "allPublik"}); err == nil {
+		t.Error("Update with an invalid type: got no error")
+	}
+	if n := root.errs.count("updateBucket"); n != 3 {
+		t.Errorf("got %d updateBucket calls, want 3", n)
+	}
+}
+
+func TestBucketReplication(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := ReplicationRule{
+		Name:                "to-backup",
+		DestinationBucketID: "backup",
+		Priority:            1,
+	}
+	repl := &Replication{
+		AsSource:      &ReplicationSource{KeyID: "source-key", Rules: []ReplicationRule{rule}},
+		AsDestination: &ReplicationDestination{KeyMapping: map[string]string{"other-key": "dest-key"}},
+	}
+	if err := bucket.Update(ctx, &BucketAttrs{Replication: repl}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.Replication, repl) {
+		t.Errorf("after Update, got replication %+v, want %+v", attrs.Replication, repl)
+	}
+
+	for _, e := range []struct {
+		desc string
+		edit func(*ReplicationRule)
+	}{
+		{desc: "empty name", edit: func(r *ReplicationRule) { r.Name = "" }},
+		{desc: "bad name", edit: func(r *ReplicationRule) { r.Name = "to backup" }},
+		{desc: "long name", edit: func(r *ReplicationRule) { r.Name = strings.Repeat("a", 65) }},
+		{desc: "zero priority", edit: func(r *ReplicationRule) { r.Priority = 0 }},
+		{desc: "negative priority", edit: func(r *ReplicationRule) { r.Priority = -1 }},
+		{desc: "no destination", edit: func(r *ReplicationRule) { r.DestinationBucketID = "" }},
+	} {
+		bad := rule
+		e.edit(&bad)
+		attrs := &BucketAttrs{Replication: &Replication{AsSource: &ReplicationSource{Rules: []ReplicationRule{bad}}}}
+		if err := bucket.Update(ctx, attrs); err == nil {
+			t.Errorf("%s: Update succeeded, want error", e.desc)
+		}
+	}
+	dup := &Replication{AsSource: &ReplicationSource{Rules: []ReplicationRule{rule, rule}}}
+	if err := bucket.Update(ctx, &BucketAttrs{Replication: dup}); err == nil {
+		t.Error("duplicate rule names: Update succeeded, want error")
+	}
+
+	if err := bucket.Update(ctx, &BucketAttrs{Replication: &Replication{}}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Replication != nil {
+		t.Errorf("after removing replication, got %+v, want nil", attrs.Replication)
+	}
+}
+
+func TestObjectLockDisabled(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	ret := Retention{Mode: Governance, RetainUntil: time.Now().Add(time.Hour)}
+	for _, lock := range []bool{false, true} {
+		bucket, err := client.NewBucket(ctx, fmt.Sprintf("lock-%v", lock), &BucketAttrs{Type: Private, ObjectLock: lock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := bucket.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ObjectLock != lock {
+			t.Errorf("bucket ObjectLock: got %v, want %v", attrs.ObjectLock, lock)
+		}
+		for _, large := range []bool{false, true} {
+			obj := bucket.Object(fmt.Sprintf("file-%v", large))
+			w := obj.NewWriter(ctx)
+			w.ChunkSize = 10
+			w.UseLargeFile = &large
+			w.Retention = &ret
+			w.LegalHold = true
+			if _, err := io.WriteString(w, "data"); err != nil {
+				t.Fatal(err)
+			}
+			err := w.Close()
+			if !lock {
+				if !errors.Is(err, ErrObjectLockDisabled) {
+					t.Errorf("large=%v: Close without Object Lock: got %v, want ErrObjectLockDisabled", large, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("large=%v: %v", large, err)
+			}
+			got, err := obj.Retention(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || *got != ret {
+				t.Errorf("large=%v: Retention: got %v, want %v", large, got, ret)
+			}
+			hold, err := obj.LegalHold(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hold {
+				t.Errorf("large=%v: LegalHold: got false, want true", large)
+			}
+		}
+	}
+
+	bucket, err := client.Bucket(ctx, "lock-false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("plain").NewWriter(ctx)
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.Object("plain").CopyTo(ctx, bucket, "copy", CopyRetention(ret)); !errors.Is(err, ErrObjectLockDisabled) {
+		t.Errorf("CopyTo without Object Lock: got %v, want ErrObjectLockDisabled", err)
+	}
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	rules := []LifecycleRule{
+		{Prefix: "logs/", DaysNewUntilHidden: 30, DaysHiddenUntilDeleted: 7},
+		{Prefix: "tmp/", DaysHiddenUntilDeleted: 1},
+	}
+	info := map[string]string{"owner": "ops"}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{
+		Type:           Private,
+		Info:           info,
+		LifecycleRules: rules,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.LifecycleRules, rules) {
+		t.Errorf("after NewBucket: got rules %+v, want %+v", attrs.LifecycleRules, rules)
+	}
+
+	rules = []LifecycleRule{
+		{Prefix: "logs/", DaysNewUntilHidden: 90, DaysHiddenUntilDeleted: 30},
+		{Prefix: "cache/", DaysNewUntilHidden: 1, DaysHiddenUntilDeleted: 1},
+	}
+	if err := bucket.Update(ctx, &BucketAttrs{LifecycleRules: rules}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.LifecycleRules, rules) {
+		t.Errorf("after Update: got rules %+v, want %+v", attrs.LifecycleRules, rules)
+	}
+	if !reflect.DeepEqual(attrs.Info, info) {
+		t.Errorf("after Update: got info %v, want %v", attrs.Info, info)
+	}
+
+	if err := bucket.Update(ctx, &BucketAttrs{LifecycleRules: []LifecycleRule{}}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs.LifecycleRules) != 0 {
+		t.Errorf("after clearing: got rules %+v, want none", attrs.LifecycleRules)
+	}
+}
+
+func TestBucketCORS(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bad := []CORSRule{{Name: "bad", AllowedOrigins: []string{"*"}, AllowedOperations: []string{"b2_delete_file"}}}
+	if _, err := client.NewBucket(ctx, "bad", &BucketAttrs{CORSRules: bad}); err == nil {
+		t.Error("NewBucket with an unknown CORS operation: got no error")
+	}
+	if n := root.errs.count("createBucket"); n != 0 {
+		t.Errorf("NewBucket with an unknown CORS operation: createBucket called %d times", n)
+	}
+
+	cors := []CORSRule{
+		{
+			Name:              "downloads",
+			AllowedOrigins:    []string{"https://example.com"},
+			AllowedOperations: []string{"b2_download_file_by_name", "b2_download_file_by_id"},
+			AllowedHeaders:    []string{"range"},
+			ExposeHeaders:     []string{"x-bz-content-sha1"},
+			MaxAgeSeconds:     3600,
+		},
+		{
+			Name:              "uploads",
+			AllowedOrigins:    []string{"*"},
+			AllowedOperations: []string{"b2_upload_file", "b2_upload_part"},
+			MaxAgeSeconds:     60,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private, CORSRules: cors})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.CORSRules, cors) {
+		t.Errorf("after NewBucket: got CORS rules %+v, want %+v", attrs.CORSRules, cors)
+	}
+
+	if err := bucket.Update(ctx, &BucketAttrs{CORSRules: bad}); err == nil {
+		t.Error("Update with an unknown CORS operation: got no error")
+	}
+	if n := root.errs.count("updateBucket"); n != 0 {
+		t.Errorf("Update with an unknown CORS operation: updateBucket called %d times", n)
+	}
+
+	if err := bucket.Update(ctx, &BucketAttrs{CORSRules: []CORSRule{}}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs.CORSRules) != 0 {
+		t.Errorf("after clearing: got CORS rules %+v, want none", attrs.CORSRules)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	if _, err := client.CreateKey(ctx, "global", Prefix("foo/")); err == nil {
+		t.Error("CreateKey with Prefix: got no error")
+	}
+	if n := root.errs.count("createKey"); n != 0 {
+		t.Errorf("CreateKey with Prefix: createKey called %d times", n)
+	}
+
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, err := client.CreateKey(ctx, "global", Capabilities("listBuckets"), Lifetime(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.BucketID() != "" || global.Prefix() != "" {
+		t.Errorf("global key: got bucket %q, prefix %q; want neither", global.BucketID(), global.Prefix())
+	}
+	if global.Expires().IsZero() {
+		t.Error("global key: got no expiration, want one")
+	}
+	scoped, err := bucket.CreateKey(ctx, "scoped", Capabilities("readFiles", "writeFiles"), Prefix("foo/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scoped.Secret() == "" {
+		t.Error("scoped key: got no secret")
+	}
+	if scoped.BucketID() != bucket.b.id() {
+		t.Errorf("scoped key: got bucket %q, want %q", scoped.BucketID(), bucket.b.id())
+	}
+	if scoped.Prefix() != "foo/" {
+		t.Errorf("scoped key: got prefix %q, want %q", scoped.Prefix(), "foo/")
+	}
+	if want := []string{"readFiles", "writeFiles"}; !reflect.DeepEqual(scoped.Capabilities(), want) {
+		t.Errorf("scoped key: got capabilities %v, want %v", scoped.Capabilities(), want)
+	}
+	if _, err := bucket.CreateKey(ctx, "another"); err != nil {
+		t.Fatal(err)
+	}
+
+	listKeys := func() map[string]*Key {
+		got := make(map[string]*Key)
+		var cursor string
+		for {
+			keys, next, err := client.ListKeys(ctx, 2, cursor)
+			for _, k := range keys {
+				got[k.ID()] = k
+			}
+			if err == io.EOF || (err == nil && next == "") {
+				return got
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor = next
+		}
+	}
+	keys := listKeys()
+	if len(keys) != 3 {
+		t.Errorf("ListKeys: got %d keys, want 3", len(keys))
+	}
+	if n := root.errs.count("listKeys"); n != 2 {
+		t.Errorf("ListKeys: got %d calls, want 2", n)
+	}
+	k, ok := keys[scoped.ID()]
+	if !ok {
+		t.Fatalf("ListKeys: key %s not listed", scoped.ID())
+	}
+	if k.Name() != "scoped" || k.BucketID() != scoped.BucketID() || k.Prefix() != "foo/" {
+		t.Errorf("ListKeys: got key %q on bucket %q with prefix %q, want %q on %q with %q", k.Name(), k.BucketID(), k.Prefix(), "scoped", scoped.BucketID(), "foo/")
+	}
+	if k.Secret() != "" {
+		t.Error("ListKeys: got a secret, want none")
+	}
+
+	if err := k.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	keys = listKeys()
+	if _, ok := keys[scoped.ID()]; ok {
+		t.Errorf("ListKeys: deleted key %s still listed", scoped.ID())
+	}
+	if len(keys) != 2 {
+		t.Errorf("ListKeys after Delete: got %d keys, want 2", len(keys))
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := client.NewBucket(ctx, "other", &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Authorize with a key that can only read and write files in one bucket.
+	root.allowance = allowance{
+		caps:       []string{"listBuckets", "listFiles", "readFiles", "writeFiles"},
+		bucketName: bucketName,
+	}
+	if _, _, err := writeFile(ctx, bucket, smallFileName, 1e3, 1e8); err != nil {
+		t.Fatalf("writing to the allowed bucket: %v", err)
+	}
+	if _, err := client.Bucket(ctx, bucketName); err != nil {
+		t.Errorf("Bucket(%q): %v", bucketName, err)
+	}
+
+	lb := root.errs.count("listBuckets")
+	uploads := root.errs.count("getUploadURL")
+	table := []struct {
+		desc string
+		f    func() error
+	}{
+		{
+			desc: "Bucket on another bucket",
+			f: func() error {
+				_, err := client.Bucket(ctx, "other")
+				return err
+			},
+		},
+		{
+			desc: "NewBucket on another bucket",
+			f: func() error {
+				_, err := client.NewBucket(ctx, "other", nil)
+				return err
+			},
+		},
+		{
+			desc: "writing to another bucket",
+			f: func() error {
+				_, _, err := writeFile(ctx, other, smallFileName, 1e3, 1e8)
+				return err
+			},
+		},
+		{
+			desc: "reading from another bucket",
+			f: func() error {
+				_, err := other.Object(smallFileName).Attrs(ctx)
+				return err
+			},
+		},
+		{
+			desc: "deleting the allowed bucket",
+			f: func() error {
+				return bucket.Delete(ctx)
+			},
+		},
+		{
+			desc: "updating the allowed bucket",
+			f: func() error {
+				return bucket.Update(ctx, &BucketAttrs{Type: Public})
+			},
+		},
+		{
+			desc: "listing keys",
+			f: func() error {
+				_, _, err := client.ListKeys(ctx, 10, "")
+				return err
+			},
+		},
+	}
+	for _, e := range table {
+		if err := e.f(); !errors.Is(err, ErrCapabilityMissing) {
+			t.Errorf("%s: got %v, want ErrCapabilityMissing", e.desc, err)
+		}
+	}
+	if n := root.errs.count("listBuckets"); n != lb {
+		t.Errorf("listBuckets called %d times, want %d", n, lb)
+	}
+	if n := root.errs.count("getUploadURL"); n != uploads {
+		t.Errorf("getUploadURL called %d times, want %d", n, uploads)
+	}
+	if n := root.errs.count("updateBucket"); n != 0 {
+		t.Errorf("updateBucket called %d times, want 0", n)
+	}
+	if n := root.errs.count("listKeys"); n != 0 {
+		t.Errorf("listKeys called %d times, want 0", n)
+	}
+}
+
+func TestObjectAttrs(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mtime := time.Unix(1500000000, 123e6)
+	info := map[string]string{"color": "blue", "shape": "round"}
+	table := []struct {
+		size  int64
+		large bool
+	}{
+		{size: 1e3},
+		{size: 1e5 + 42, large: true},
+	}
+
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := bytes.Repeat([]byte{'x'}, int(e.size))
+		sha := fmt.Sprintf("%x", sha1.Sum(data))
+		if e.large {
+			sha = "none"
+		}
+		start := time.Now()
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx, WithAttrsOption(&Attrs{
+			ContentType:  "text/plain",
+			Info:         info,
+			LastModified: mtime,
+		}))
+		w.ChunkSize = 1e4
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bucket.Object("file").Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UploadTimestamp.Before(start) || got.UploadTimestamp.After(time.Now()) {
+			t.Errorf("%d bytes: UploadTimestamp %v is not during the upload", e.size, got.UploadTimestamp)
+		}
+		want := &Attrs{
+			Name:            "file",
+			Size:            e.size,
+			ContentType:     "text/plain",
+			Status:          Uploaded,
+			UploadTimestamp: got.UploadTimestamp,
+			SHA1:            sha,
+			LastModified:    mtime,
+			Info:            info,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d bytes: got %+v, want %+v", e.size, got, want)
+		}
+	}
+}
+
+func TestWriterEncryption(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sse := &ServerSideEncryption{Mode: SSEB2, Algorithm: "AES256"}
+	table := []struct {
+		size int64
+		sse  *ServerSideEncryption
+	}{
+		{size: 1e4},
+		{size: 1e4, sse: sse},
+		{size: 1e5 + 42},
+		{size: 1e5 + 42, sse: sse},
+	}
+
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.ServerSideEncryption = e.sse
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(attrs.ServerSideEncryption, e.sse) {
+			t.Errorf("writing %d bytes: got encryption %+v, want %+v", e.size, attrs.ServerSideEncryption, e.sse)
+		}
+	}
+}
+
+func TestCustomerKey(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	sse := &ServerSideEncryption{Mode: SSEC, Key: key}
+	wrong := &ServerSideEncryption{Mode: SSEC, Key: bytes.Repeat([]byte{0x24}, 32)}
+
+	for _, f := range []string{"%v", "%+v", "%#v", "%s"} {
+		if got := fmt.Sprintf(f, sse); strings.Contains(got, fmt.Sprint(key)) {
+			t.Errorf("Sprintf(%q): key was formatted: %s", f, got)
+		}
+	}
+
+	for _, size := range []int64{1e4, 1e5 + 42} {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := bucket.Object("file")
+		w := o.NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.ServerSideEncryption = sse
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range []struct {
+			desc string
+			sse  *ServerSideEncryption
+			ok   bool
+		}{
+			{desc: "no key"},
+			{desc: "wrong key", sse: wrong},
+			{desc: "right key", sse: sse, ok: true},
+		} {
+			r := bucket.Object("file").NewReader(ctx)
+			r.ServerSideEncryption = e.sse
+			n, err := io.Copy(ioutil.Discard, r)
+			r.Close()
+			if !e.ok {
+				if !errors.Is(err, ErrCustomerKey) {
+					t.Errorf("reading %d bytes with %s: got %v, want ErrCustomerKey", size, e.desc, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("reading %d bytes with %s: %v", size, e.desc, err)
+			} else if n != size {
+				t.Errorf("reading %d bytes with %s: got %d bytes", size, e.desc, n)
+			}
+		}
+
+		// Attrs works without the key.
+		if _, err := bucket.Object("file").Attrs(ctx); err != nil {
+			t.Errorf("Attrs() of %d-byte object: %v", size, err)
+		}
+
+		if _, err := o.CopyTo(ctx, bucket, "nokey", CopyPartSize(1e4)); !errors.Is(err, ErrCustomerKey) {
+			t.Errorf("copying %d bytes without key: got %v, want ErrCustomerKey", size, err)
+		}
+		c, err := o.CopyTo(ctx, bucket, "copy", CopyPartSize(1e4), CopyEncryption(sse, sse))
+		if err != nil {
+			t.Fatalf("copying %d bytes with key: %v", size, err)
+		}
+		r := c.NewReader(ctx)
+		r.ServerSideEncryption = sse
+		n, err := io.Copy(ioutil.Discard, r)
+		r.Close()
+		if err != nil || n != size {
+			t.Errorf("reading copy of %d bytes: got %d bytes, %v", size, n, err)
+		}
+	}
+}
+
+func TestWriterInvalidInfo(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	eleven := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		eleven[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	table := []struct {
+		size int64
+		info map[string]string
+		want error
+	}{
+		{size: 1e4, info: eleven, want: ErrTooManyInfoKeys},
+		{size: 1e5 + 42, info: eleven, want: ErrTooManyInfoKeys},
+		{size: 1e4, info: map[string]string{"bad key": "value"}, want: ErrInvalidInfoKey},
+		{size: 1e5 + 42, info: map[string]string{"bad key": "value"}, want: ErrInvalidInfoKey},
+		{size: 1e4, info: map[string]string{strings.Repeat("k", 51): "value"}, want: ErrInvalidInfoKey},
+		{size: 1e4, info: map[string]string{"good_key-1.0": "value"}},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object("file").NewWriter(ctx, WithAttrsOption(&Attrs{Info: e.info}))
+		w.ChunkSize = 1e4
+		if _, err := io.Copy(w, io.LimitReader(zReader{}, e.size)); err != nil && e.want == nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if e.want == nil {
+			if err != nil {
+				t.Errorf("writing %d bytes with info %v: %v", e.size, e.info, err)
+			}
+			continue
+		}
+		if !errors.Is(err, e.want) {
+			t.Errorf("writing %d bytes with info %v: got %v, want %v", e.size, e.info, err, e.want)
+		}
+		if n := root.errs.count("getUploadURL") + root.errs.count("uploadPart"); n != 0 {
+			t.Errorf("writing %d bytes with info %v: made %d upload calls, want 0", e.size, e.info, n)
+		}
+	}
+}
+
+func TestFileBuffer(t *testing.T) {
+	r := io.LimitReader(zReader{}, 1e8)
+	w, err := newFileBuffer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	bReader, err := w.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsh := sha1.New()
+	if _, err := io.Copy(hsh, bReader); err != nil {
+		t.Fatal(err)
+	}
+	hshText := fmt.Sprintf("%x", hsh.Sum(nil))
+	if hshText != w.Hash() {
+		t.Errorf("hashes are not equal: bufferWriter is %q, read buffer is %q", w.Hash(), hshText)
+	}
+}
+
+func TestWriterBufferReuse(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake rejects parts whose contents don't match their hash, which
+	// they wouldn't if a buffer were reused while its part was in flight.
+	data := make([]byte, 1e6+42)
+	rand.New(rand.NewSource(1)).Read(data)
+	o := bucket.Object("file")
+	w := o.NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.ConcurrentUploads = 8
+	if _, err := io.Copy(w, io.MultiReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := readFile(ctx, o, fmt.Sprintf("%x", sha1.Sum(data)), 1e5, 4); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryBufferDoubleClose(t *testing.T) {
+	mb := newMemoryBuffer(0)
+	if _, err := mb.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := mb.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The buffer must only have been returned to the pool once.
+	a, b := newMemoryBuffer(0), newMemoryBuffer(0)
+	defer a.Close()
+	defer b.Close()
+	if a.buf == b.buf {
+		t.Error("two memoryBuffers share the same underlying buffer")
+	}
+	if a.Len() != 0 || b.Len() != 0 {
+		t.Errorf("pooled buffers not reset: got lengths %d and %d", a.Len(), b.Len())
+	}
+}
+
+func BenchmarkMemoryBuffer(b *testing.B) {
+	const size = 1e6
+	data := make([]byte, 32*1024)
+	fill := func(w io.Writer) {
+		for n := 0; n < size; n += len(data) {
+			w.Write(data)
+		}
+	}
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mb := newMemoryBuffer(size)
+			fill(mb)
+			mb.Close()
+		}
+	})
+	b.Run("Unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fill(&bytes.Buffer{})
+		}
+	})
+}
+
+func TestNonBuffer(t *testing.T) {
+	table := []struct {
+		str  string
+		off  int64
+		len  int64
+		want string
+	}{
+		{
+			str:  "a string",
+			off:  0,
+			len:  3,
+			want: "a s",
+		},
+		{
+			str:  "a string",
+			off:  3,
+			len:  1,
+			want: "t",
+		},
+		{
+			str:  "a string",
+			off:  3,
+			len:  5,
+			want: "tring",
+		},
+	}
+
+	for _, e := range table {
+		nb := newNonBuffer(strings.NewReader(e.str), e.off, e.len)
+		want := fmt.Sprintf("%s%x", e.want, sha1.Sum([]byte(e.str[int(e.off):int(e.off+e.len)])))
+		r, err := nb.Reader()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("ioutil.ReadAll(%#v): %v", e, err)
+			continue
+		}
+		if want != string(got) {
+			t.Errorf("ioutil.ReadAll(%#v): got %q, want %q", e, string(got), want)
+		}
+	}
+}
+
+func writeFile(ctx context.Context, bucket *Bucket, name string, size int64, csize int) (*Object, string, error) {
+	r := io.LimitReader(zReader{}, size)
+	o := bucket.Object(name)
+	f := o.NewWriter(ctx)
+	h := sha1.New()
+	w := io.MultiWriter(f, h)
+	f.ConcurrentUploads = 5
+	f.ChunkSize = csize
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	if n != size {
+		return nil, "", fmt.Errorf("io.Copy(): wrote %d bytes; wanted %d bytes", n, size)
+	}
+	if err := f.Close(); err != nil {
+		return nil, "", err
+	}
+	return o, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func readFile(ctx context.Context, obj *Object, sha string, chunk, concur int) error {
+	r := obj.NewReader(ctx)
+	r.ChunkSize = chunk
+	r.ConcurrentDownloads = concur
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+	rsha := fmt.Sprintf("%x", h.Sum(nil))
+	if sha != rsha {
+		return fmt.Errorf("bad hash: got %s, want %s", rsha, sha)
+	}
+	return nil
+}
+
+func TestDownloadByName(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const body = "Twas brillig, and the slithy toves"
+	w := bucket.Object("jabberwocky").NewWriter(ctx, WithAttrsOption(&Attrs{
+		ContentType: "text/plain",
+		Info:        map[string]string{"poet": "carroll"},
+	}))
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, attrs, err := bucket.DownloadByName(ctx, "jabberwocky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("DownloadByName: got %q, want %q", got, body)
+	}
+	want := &Attrs{
+		Name:        "jabberwocky",
+		Size:        int64(len(body)),
+		ContentType: "text/plain",
+		SHA1:        fmt.Sprintf("%x", sha1.Sum([]byte(body))),
+		Info:        map[string]string{"poet": "carroll"},
+		Status:      Uploaded,
+	}
+	attrs.UploadTimestamp = time.Time{}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("DownloadByName attrs: got %+v, want %+v", attrs, want)
+	}
+
+	rc, attrs, err = bucket.DownloadByName(ctx, "jabberwocky", DownloadRange(5, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body[5:12] {
+		t.Errorf("DownloadByName with range: got %q, want %q", got, body[5:12])
+	}
+	if attrs.Size != int64(len(body)) {
+		t.Errorf("DownloadByName with range: got size %d, want %d", attrs.Size, len(body))
+	}
+
+	if _, _, err := bucket.DownloadByName(ctx, "jabberwocky", DownloadRange(10, 0)); err != nil {
+		t.Errorf("DownloadByName to end: %v", err)
+	}
+}
+
+func TestReaderSetRange(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	obj := bucket.Object("file")
+	w := obj.NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mid := int64(len(data)/2 - 512)
+	for _, chunk := range []int{1 << 10, 256, 300, 1 << 12} {
+		r := obj.NewReader(ctx)
+		r.SetRange(mid, 1<<10)
+		r.ChunkSize = int(chunk)
+		r.ConcurrentDownloads = 3
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("chunk size %d: %v", chunk, err)
+			continue
+		}
+		if !bytes.Equal(got, data[mid:mid+1<<10]) {
+			t.Errorf("chunk size %d: got %d bytes, want the middle 1KB", chunk, len(got))
+		}
+	}
+
+	r := obj.NewReader(ctx)
+	defer r.Close()
+	r.SetRange(int64(len(data))+10, 100)
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Read past the end: got (%d, %v), want (0, EOF)", n, err)
+	}
+}
+
+// downloadURLTransport authorizes any account, with a download URL of
+// https://f000.example.com, and lists a single public bucket.
+type downloadURLTransport struct{}
+
+func (downloadURLTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := fmt.Sprintf(`{"buckets": [{"bucketId": "id", "bucketName": %q, "bucketType": "allPublic"}]}`, bucketName)
+	if r.Header.Get("X-Blazer-Method") == "b2_authorize_account" {
+		body = `{"accountId": "abcd", "authorizationToken": "token", "apiUrl": "https://api.example.com", "downloadUrl": "https://f000.example.com"}`
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestObjectURL(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(downloadURLTransport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		name, want string
+	}{
+		{name: "plain.txt", want: "plain.txt"},
+		{name: "a file with spaces", want: "a%20file%20with%20spaces"},
+		{name: "dir/sub dir/file", want: "dir/sub%20dir/file"},
+		{name: "1+1=2?&#%", want: "1%2B1=2%3F%26%23%25"},
+		{name: "~!$'()*;=:@", want: "~!$'()*;=:@"},
+		{name: "ünïcødé/日本", want: "%C3%BCn%C3%AFc%C3%B8d%C3%A9/%E6%97%A5%E6%9C%AC"},
+	}
+	for _, e := range table {
+		want := "https://f000.example.com/file/" + bucketName + "/" + e.want
+		got := bucket.Object(e.name).URL()
+		if got != want {
+			t.Errorf("URL(%q): got %q, want %q", e.name, got, want)
+			continue
+		}
+		u, err := url.Parse(got)
+		if err != nil {
+			t.Errorf("URL(%q): %v", e.name, err)
+			continue
+		}
+		if path := "/file/" + bucketName + "/" + e.name; u.Path != path {
+			t.Errorf("URL(%q): got path %q, want %q", e.name, u.Path, path)
+		}
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		name string
+		d    time.Duration
+		b2cd string
+		want string
+	}{
+		{
+			name: "foo/bar",
+			d:    time.Hour,
+			want: "/file/" + bucketName + "/foo/bar?Authorization=" + bucketName + "%2Ffoo%2Fbar%2F3600%2F",
+		},
+		{
+			name: "a file?",
+			d:    90 * time.Second,
+			b2cd: "attachment",
+			want: "/file/" + bucketName + "/a%20file%3F?Authorization=" + bucketName + "%2Fa+file%3F%2F90%2Fattachment&b2ContentDisposition=attachment",
+		},
+		{
+			name: "dir/1+1 ünï",
+			d:    time.Minute,
+			want: "/file/" + bucketName + "/dir/1%2B1%20%C3%BCn%C3%AF?Authorization=" + bucketName + "%2Fdir%2F1%2B1+%C3%BCn%C3%AF%2F60%2F",
+		},
+	}
+	for _, e := range table {
+		u, err := bucket.Object(e.name).AuthURL(ctx, e.d, e.b2cd)
+		if err != nil {
+			t.Errorf("AuthURL(%q): %v", e.name, err)
+			continue
+		}
+		if u.String() != e.want {
+			t.Errorf("AuthURL(%q): got %q, want %q", e.name, u, e.want)
+		}
+		token := fmt.Sprintf("%s/%s/%d/%s", bucketName, e.name, int(e.d.Seconds()), e.b2cd)
+		if got := u.Query().Get("Authorization"); got != token {
+			t.Errorf("AuthURL(%q): got token %q, want %q", e.name, got, token)
+		}
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		prefix string
+		d      time.Duration
+		opts   []AuthTokenOption
+		want   string
+	}{
+		{
+			prefix: "photos/",
+			d:      10 * time.Minute,
+			want:   bucketName + "/photos//600/",
+		},
+		{
+			prefix: "",
+			d:      7 * 24 * time.Hour,
+			opts:   []AuthTokenOption{TokenContentDisposition("attachment; filename=x.jpg")},
+			want:   bucketName + "//604800/attachment; filename=x.jpg",
+		},
+	}
+	for _, e := range table {
+		got, err := bucket.AuthToken(ctx, e.prefix, e.d, e.opts...)
+		if err != nil {
+			t.Errorf("AuthToken(%q, %v): %v", e.prefix, e.d, err)
+			continue
+		}
+		if got != e.want {
+			t.Errorf("AuthToken(%q, %v): got %q, want %q", e.prefix, e.d, got, e.want)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"dir/b.txt":     "bravo",
+		"dir/sub/c.txt": "charlie",
+		"dir/sub/d.txt": "delta",
+		"e/f.txt":       "echo foxtrot",
+		"empty":         "",
+	}
+	for name, body := range files {
+		w := bucket.Object(name).NewWriter(ctx)
+		if _, err := io.WriteString(w, body); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := bucket.FS(ctx)
+
+	var walked []string
+	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "a.txt", "dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "dir/sub/d.txt", "e", "e/f.txt", "empty"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("WalkDir: got %v, want %v", walked, want)
+	}
+
+	if err := fstest.TestFS(fsys, "a.txt", "dir/sub/c.txt", "e/f.txt", "empty"); err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"nope", "dir/nope", "di"} {
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q): got %v, want ErrNotExist", name, err)
+		}
+	}
+	fi, err := fsys.Stat("dir/sub/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, ok := fi.Sys().(*Attrs); !ok || attrs.Name != "dir/sub/c.txt" || fi.Size() != int64(len(files["dir/sub/c.txt"])) {
+		t.Errorf("Stat(dir/sub/c.txt): got %v, %v", fi.Size(), fi.Sys())
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/e/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != files["e/f.txt"] {
+		t.Errorf("GET /e/f.txt: got %d %q, want 200 %q", resp.StatusCode, body, files["e/f.txt"])
+	}
+}
+
+// chunkWriter records the size of every write.
+type chunkWriter struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.sizes = append(c.sizes, len(p))
+	return c.Buffer.Write(p)
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, sha, err := writeFile(ctx, bucket, "file", 1e6+42, 1e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concur := range []int{1, 4} {
+		r := obj.NewReader(ctx)
+		r.ChunkSize = 1e5
+		r.ConcurrentDownloads = concur
+		want, err := ioutil.ReadAll(struct{ io.Reader }{r}) // hide WriteTo
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+
+		r = obj.NewReader(ctx)
+		r.ChunkSize = 1e5
+		r.ConcurrentDownloads = concur
+		got := &chunkWriter{}
+		n, err := io.Copy(got, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(want)) || !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%d downloads: WriteTo wrote %d bytes that differ from a read loop", concur, n)
+		}
+		for i, size := range got.sizes[:len(got.sizes)-1] {
+			if size != 1e5 {
+				t.Errorf("%d downloads: write %d was %d bytes, want a whole chunk", concur, i, size)
+			}
+		}
+		if err, ok := r.Verify(); err != nil || !ok {
+			t.Errorf("%d downloads: Verify: %v, %v", concur, err, ok)
+		}
+		if rsha := fmt.Sprintf("%x", sha1.Sum(got.Bytes())); rsha != sha {
+			t.Errorf("%d downloads: got sha %s, want %s", concur, rsha, sha)
+		}
+		r.Close()
+	}
+
+	// WriteTo continues where Read left off.
+	r := obj.NewRangeReader(ctx, 10, 1000)
+	r.ChunkSize = 300
+	p := make([]byte, 50)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	var rest bytes.Buffer
+	if _, err := r.WriteTo(&rest); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if got := len(p) + rest.Len(); got != 1000 {
+		t.Errorf("Read then WriteTo: got %d bytes, want 1000", got)
+	}
+}
+
+func benchmarkReader(b *testing.B, copy func(io.Writer, *Reader) error) {
+	ctx := context.Background()
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		b.Fatal(err)
+	}
+	obj, _, err := writeFile(ctx, bucket, "file", 1e7, 1e8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(1e7)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := obj.NewReader(ctx)
+		r.ChunkSize = 1e6
+		r.ConcurrentDownloads = 4
+		if err := copy(ioutil.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+		r.Close()
+	}
+}
+
+func BenchmarkReaderRead(b *testing.B) {
+	benchmarkReader(b, func(w io.Writer, r *Reader) error {
+		_, err := io.Copy(w, struct{ io.Reader }{r})
+		return err
+	})
+}
+
+func BenchmarkReaderWriteTo(b *testing.B) {
+	benchmarkReader(b, func(w io.Writer, r *Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+func TestWriterFlush(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+		partSize:  5e6,
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bucket.Object("log").NewWriter(ctx)
+	w.ChunkSize = 1e7
+	w.ConcurrentUploads = 2
+	h := sha1.New()
+	out := io.MultiWriter(w, h)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush with nothing written: %v", err)
+	}
+	if _, err := io.CopyN(out, zReader{}, 4e6); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if parts := w.UploadedParts(); parts != nil {
+		t.Errorf("Flush below the minimum part size: got parts %v, want none", parts)
+	}
+	if _, err := io.CopyN(out, zReader{}, 2e6); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if parts := w.UploadedParts(); len(parts) != 1 {
+		t.Errorf("after Flush: got %d parts, want 1", len(parts))
+	}
+	if _, err := bucket.Object("log").Attrs(ctx); !IsNotExist(err) {
+		t.Errorf("after Flush: object exists before Close (err %v)", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(out, zReader{}, 13e6); err != nil {
+		t.Fatal(err)
+	}
+	fid := w.FileID()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gmux.Lock()
+	parts := root.lfs[fid].parts
+	var sizes []int
+	for i := 1; i <= len(parts); i++ {
+		sizes = append(sizes, len(parts[i]))
+	}
+	gmux.Unlock()
+	if want := []int{6e6, 1e7, 3e6}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("part sizes: got %v, want %v", sizes, want)
+	}
+	if err := readFile(ctx, bucket.Object("log"), fmt.Sprintf("%x", h.Sum(nil)), 1e7, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriterFlushMismatchedResume(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			errMap: map[string]map[int]error{
+				"uploadPart": {3: testError{}},
+			},
+		},
+		partSize: 1e3,
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after three of five parts.
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	if _, err := io.Copy(w, struct{ io.Reader }{&zReadSeeker{size: 5e4}}); err == nil {
+		w.Close()
+		t.Fatal("io.Copy: should have returned an error")
+	}
+
+	// Resume with a first part that differs from the one sent, which only
+	// Flush sends.  It must report the mismatch rather than wait for the part
+	// forever.
+	rw := bucket.ResumeWriter(ctx, largeFileName, w.FileID())
+	rw.ChunkSize = 1e4
+	if _, err := rw.Write(bytes.Repeat([]byte("x"), 5e3)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- rw.Flush() }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "chunks don't match") {
+			t.Errorf("Flush: got %v, want an error for the mismatched part", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Flush: still waiting after 3s")
+	}
+	rw.Close()
+}
+
+func TestReaderFromUnseekable(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		size       int64
+		fileBuffer bool
+		parts      int
+	}{
+		{size: 0},
+		{size: 100},
+		{size: 1e4, parts: 1},
+		{size: 1e4 + 1, parts: 2},
+		{size: 35e3, parts: 4},
+		{size: 35e3, fileBuffer: true, parts: 4},
+	}
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs:      &errCont{},
+			partSize:  1e4,
+		}
+		client := &Client{backend: &beRoot{b2i: root}}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha1.New()
+		io.Copy(h, &zReadSeeker{size: e.size})
+
+		w := bucket.Object("writer").NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.UseFileBuffer = e.fileBuffer
+		n, err := io.Copy(w, struct{ io.Reader }{&zReadSeeker{size: e.size}})
+		if err != nil {
+			t.Errorf("ReadFrom(%d): %v", e.size, err)
+		}
+		if n != e.size {
+			t.Errorf("ReadFrom(%d): got %d bytes", e.size, n)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := root.errs.count("uploadPart"); got != e.parts {
+			t.Errorf("ReadFrom(%d): got %d parts, want %d", e.size, got, e.parts)
+		}
+		if err := readFile(ctx, bucket.Object("writer"), fmt.Sprintf("%x", h.Sum(nil)), 1e4, 1); err != nil && e.size > 0 {
+			t.Errorf("ReadFrom(%d): %v", e.size, err)
+		}
+	}
+}
+
+func benchmarkWriter(b *testing.B, copy func(*Writer, io.Reader) error) {
+	ctx := context.Background()
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+				partSize:  1e6,
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(1e7)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := bucket.Object("file").NewWriter(ctx)
+		w.ChunkSize = 1e6
+		if err := copy(w, struct{ io.Reader }{&zReadSeeker{size: 1e7}}); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriterWrite(b *testing.B) {
+	benchmarkWriter(b, func(w *Writer, r io.Reader) error {
+		_, err := io.Copy(struct{ io.Writer }{w}, r)
+		return err
+	})
+}
+
+func BenchmarkWriterReadFrom(b *testing.B) {
+	benchmarkWriter(b, func(w *Writer, r io.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+func TestUploadAttempts(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			errMap: map[string]map[int]error{
+				"uploadFile": {0: testError{reupload: true}, 1: testError{reupload: true}, 2: testError{reupload: true}},
+			},
+			parts: map[int]error{2: testError{reupload: true}},
+		},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+			clk: &fakeClock{auto: true},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.UploadAttempts = 3
+	io.Copy(w, io.LimitReader(zReader{}, 3e4))
+	err = w.Close()
+	if err == nil {
+		t.Fatal("Close: got no error for a part that always fails")
+	}
+	if !strings.Contains(err.Error(), "part 2: giving up after 3 attempts") {
+		t.Errorf("Close: got %v, want an error naming part 2 and 3 attempts", err)
+	}
+	if got := root.errs.count("uploadPart"); got != 4 {
+		t.Errorf("got %d uploadPart calls, want 4", got)
+	}
+
+	w = bucket.Object(smallFileName).NewWriter(ctx)
+	w.UploadAttempts = 2
+	if _, err := io.WriteString(w, "small"); err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 attempts") {
+		t.Errorf("Close: got %v, want an error after 2 attempts", err)
+	}
+	if got := root.errs.count("uploadFile"); got != 2 {
+		t.Errorf("got %d uploadFile calls, want 2", got)
+	}
+}
+
+func TestDisableRetry(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	for _, disable := range []bool{false, true} {
+		// The first small upload, and the second part sent, fail once.
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs: &errCont{
+				errMap: map[string]map[int]error{
+					"uploadFile": {0: testError{reupload: true}},
+					"uploadPart": {1: testError{reupload: true}},
+				},
+			},
+		}
+		client := &Client{
+			backend: &beRoot{
+				b2i: root,
+				clk: &fakeClock{auto: true},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := bucket.Object(smallFileName).NewWriter(ctx)
+		w.DisableRetry = disable
+		if _, err := io.WriteString(w, "small"); err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		want := 2
+		if disable {
+			want = 1
+			if err == nil || strings.Contains(err.Error(), "giving up") {
+				t.Errorf("small, DisableRetry: got %v, want the upload's own error", err)
+			}
+		} else if err != nil {
+			t.Errorf("small: %v", err)
+		}
+		if got := root.errs.count("uploadFile"); got != want {
+			t.Errorf("small, DisableRetry %v: got %d uploadFile calls, want %d", disable, got, want)
+		}
+		if got := root.errs.count("getUploadURL"); got != want {
+			t.Errorf("small, DisableRetry %v: got %d upload URLs, want %d", disable, got, want)
+		}
+
+		w = bucket.Object(largeFileName).NewWriter(ctx)
+		w.ChunkSize = 1e4
+		w.DisableRetry = disable
+		io.Copy(w, io.LimitReader(zReader{}, 3e4))
+		err = w.Close()
+		want = 4
+		if disable {
+			want = 2
+			if err == nil {
+				t.Error("large, DisableRetry: Close succeeded, want the part's error")
+			}
+		} else if err != nil {
+			t.Errorf("large: %v", err)
+		}
+		if got := root.errs.count("uploadPart"); got != want {
+			t.Errorf("large, DisableRetry %v: got %d uploadPart calls, want %d", disable, got, want)
+		}
+	}
+}
+
+func TestWriterErrorPhase(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	fail := errors.New("B2 fell over")
+	table := []struct {
+		desc  string
+		errs  map[string]map[int]error
+		size  int64
+		phase string // in the error, after the object's name
+	}{
+		{
+			desc:  "finish",
+			errs:  map[string]map[int]error{"finishLargeFile": {0: fail}},
+			size:  3e4,
+			phase: "finishing large file",
+		},
+		{
+			desc:  "part",
+			errs:  map[string]map[int]error{"uploadPart": {1: fail}},
+			size:  3e4,
+			phase: "part 2",
+		},
+		{
+			desc:  "part URL",
+			errs:  map[string]map[int]error{"getUploadPartURL": {0: fail}},
+			size:  3e4,
+			phase: "part 1: getting an upload URL",
+		},
+		{
+			desc:  "small upload",
+			errs:  map[string]map[int]error{"uploadFile": {0: fail}},
+			size:  10,
+			phase: "uploading",
+		},
+	}
+	for _, e := range table {
+		client := &Client{
+			backend: &beRoot{
+				b2i: &testRoot{
+					bucketMap: make(map[string]map[string]string),
+					errs:      &errCont{errMap: e.errs},
+				},
+			},
+		}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bucket.Object(largeFileName).NewWriter(ctx)
+		w.ChunkSize = 1e4
+		io.Copy(w, io.LimitReader(zReader{}, e.size))
+		err = w.Close()
+		if !errors.Is(err, fail) {
+			t.Errorf("%s: got %v, want an error wrapping %v", e.desc, err, fail)
+			continue
+		}
+		if want := largeFileName + ": " + e.phase + ": "; !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: got %q, want it to begin %q", e.desc, err, want)
+		}
+	}
+}
+
+// retryErr is an error that classifies itself with RetryableError.
+type retryErr struct {
+	ok   bool
+	kind RetryKind
+}
+
+func (e retryErr) Error() string                { return fmt.Sprintf("retryable %v (%d)", e.ok, e.kind) }
+func (e retryErr) Retryable() (bool, RetryKind) { return e.ok, e.kind }
+
+func TestRetryableError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	table := []struct {
+		desc  string
+		op    string
+		err   error
+		fail  bool // whether the error is returned
+		calls int  // of op
+		auths int  // reauthorizations
+		urls  int  // upload URLs fetched
+	}{
+		{
+			desc:  "retry the same request",
+			op:    "listFileNames",
+			err:   retryErr{true, RetrySame},
+			calls: 2,
+		},
+		{
+			desc:  "reauthorize",
+			op:    "listFileNames",
+			err:   retryErr{true, RetryReauth},
+			calls: 2,
+			auths: 1,
+		},
+		{
+			desc:  "reauthorize, wrapped",
+			op:    "listFileNames",
+			err:   fmt.Errorf("wrapped: %w", retryErr{true, RetryReauth}),
+			calls: 2,
+			auths: 1,
+		},
+		{
+			desc:  "not retryable",
+			op:    "listFileNames",
+			err:   retryErr{false, RetrySame},
+			fail:  true,
+			calls: 1,
+		},
+		{
+			desc:  "RetryNone overrides B2's reply",
+			op:    "listFileNames",
+			err:   fmt.Errorf("%w: %w", retryErr{true, RetryNone}, testError{retry: true}),
+			fail:  true,
+			calls: 1,
+		},
+		{
+			desc:  "new upload URL",
+			op:    "uploadFile",
+			err:   retryErr{true, RetryNewUploadURL},
+			calls: 2,
+			urls:  2,
+		},
+		{
+			desc:  "upload not retryable",
+			op:    "uploadFile",
+			err:   retryErr{false, RetryNewUploadURL},
+			fail:  true,
+			calls: 1,
+			urls:  1,
+		},
+	}
+
+	for _, e := range table {
+		root := &testRoot{
+			bucketMap: make(map[string]map[string]string),
+			errs: &errCont{
+				errMap: map[string]map[int]error{e.op: {0: e.err}},
+			},
+		}
+		client := &Client{backend: &beRoot{b2i: root}}
+		bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+		if err != nil {
+			t.Fatal(err)
+		}
+		auths := root.auths
+
+		if e.op == "uploadFile" {
+			w := bucket.Object(smallFileName).NewWriter(ctx)
+			if _, err := io.WriteString(w, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			err = w.Close()
+		} else {
+			iter := bucket.List(ctx)
+			for iter.Next() {
+			}
+			err = iter.Err()
+		}
+		if got := err != nil; got != e.fail {
+			t.Errorf("%s: got error %v, want failure %v", e.desc, err, e.fail)
+		}
+		if e.fail && !errors.Is(err, e.err) {
+			t.Errorf("%s: got %v, want it to wrap %v", e.desc, err, e.err)
+		}
+		if got := root.errs.count(e.op); got != e.calls {
+			t.Errorf("%s: %s called %d times, want %d", e.desc, e.op, got, e.calls)
+		}
+		if got := root.auths - auths; got != e.auths {
+			t.Errorf("%s: reauthorized %d times, want %d", e.desc, got, e.auths)
+		}
+		if got := root.errs.count("getUploadURL"); got != e.urls {
+			t.Errorf("%s: fetched %d upload URLs, want %d", e.desc, got, e.urls)
+		}
+	}
+}
+
+func TestPartTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs: &errCont{
+			stalls: map[int]int{2: 1},
+		},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+			clk: &fakeClock{auto: true},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.PartTimeout = 50 * time.Millisecond
+	if _, err := io.Copy(w, io.LimitReader(zReader{}, 3e4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := root.errs.count("uploadPart"); got != 4 {
+		t.Errorf("got %d uploadPart calls, want 4", got)
+	}
+	// One URL for the upload thread, and a fresh one for the retried part.
+	if got := root.errs.count("getUploadPartURL"); got != 2 {
+		t.Errorf("got %d getUploadPartURL calls, want 2", got)
+	}
+
+	// A part that always stalls fails once its attempts run out.
+	root.errs.stalls = map[int]int{1: 2}
+	w = bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.PartTimeout = 50 * time.Millisecond
+	w.UploadAttempts = 2
+	io.Copy(w, io.LimitReader(zReader{}, 3e4))
+	err = w.Close()
+	if err == nil || !strings.Contains(err.Error(), "part 1: giving up after 2 attempts") {
+		t.Errorf("Close: got %v, want an error naming part 1 and 2 attempts", err)
+	}
+
+	// A client ExponentialBackoff doesn't stop a timed out part being retried.
+	root.errs.stalls = map[int]int{2: 1}
+	root.errs.opMap = nil
+	client.backend.(*beRoot).policy = &ExponentialBackoff{}
+	w = bucket.Object(largeFileName).NewWriter(ctx)
+	w.ChunkSize = 1e4
+	w.PartTimeout = 50 * time.Millisecond
+	if _, err := io.Copy(w, io.LimitReader(zReader{}, 3e4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close with ExponentialBackoff: %v", err)
+	}
+	if got := root.errs.count("uploadPart"); got != 4 {
+		t.Errorf("with ExponentialBackoff: got %d uploadPart calls, want 4", got)
+	}
+}
+
+func TestErrorFromB2(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(&recordingTransport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &Object{name: "missing", f: bucket.b.file("missing", "missing"), b: bucket}
+	_, err = obj.Attrs(ctx)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Attrs: got error %v (%T), want *Error", err, err)
+	}
+	if e.Status != 404 || e.Code != "not_found" || e.Message != "File not present: missing" {
+		t.Errorf("Attrs: got %+v, want 404 not_found", e)
+	}
+	if !IsNotExist(err) {
+		t.Errorf("IsNotExist(%v): got false, want true", err)
+	}
+	if IsCapExceeded(err) {
+		t.Errorf("IsCapExceeded(%v): got true, want false", err)
+	}
+	if err := obj.Delete(ctx); !IsNotExist(err) {
+		t.Errorf("Delete: got error %v, want a not-found error", err)
+	}
+
+	client, err = NewClient(ctx, "abcd", "efgh", Transport(&recordingTransport{}), ForceCapExceeded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err = client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object(smallFileName).NewWriter(ctx)
+	if _, err := w.Write([]byte("aaaaa")); err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if !errors.As(err, &e) || e.Status != 403 || e.Code != "storage_cap_exceeded" {
+		t.Errorf("Close: got error %v, want 403 storage_cap_exceeded", err)
+	}
+	if !IsCapExceeded(err) {
+		t.Errorf("IsCapExceeded(%v): got false, want true", err)
+	}
+	if !errors.Is(err, ErrCapExceeded) {
+		t.Errorf("errors.Is(%v, ErrCapExceeded): got false, want true", err)
+	}
+	if IsNotExist(err) {
+		t.Errorf("IsNotExist(%v): got true, want false", err)
+	}
+
+	for _, e := range []struct {
+		status int
+		code   string
+		want   bool
+	}{
+		{status: 403, code: "cap_exceeded", want: true},
+		{status: 403, code: "storage_cap_exceeded", want: true},
+		{status: 403, code: "download_cap_exceeded", want: true},
+		{status: 403, code: "transaction_cap_exceeded", want: true},
+		{status: 403, code: "access_denied"},
+		{status: 403, code: "xcap_exceeded"},
+		{status: 400, code: "cap_exceeded"},
+	} {
+		err := fmt.Errorf("wrapped: %w", &Error{Status: e.status, Code: e.code})
+		if got := IsCapExceeded(err); got != e.want {
+			t.Errorf("IsCapExceeded(%d %s): got %v, want %v", e.status, e.code, got, e.want)
+		}
+	}
+}
+
+func TestObjectLockHeaders(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := Retention{Mode: Compliance, RetainUntil: time.Unix(1600000000, 0)}
+	for _, e := range []struct {
+		name string
+		size int
+	}{
+		{name: smallFileName, size: 5},
+		{name: largeFileName, size: 30},
+	} {
+		obj := bucket.Object(e.name)
+		w := obj.NewWriter(ctx)
+		w.ChunkSize = 10
+		w.Retention = &ret
+		w.LegalHold = true
+		if _, err := w.Write(bytes.Repeat([]byte("a"), e.size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("writing %s: %v", e.name, err)
+		}
+		got, err := obj.Retention(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.Mode != ret.Mode || !got.RetainUntil.Equal(ret.RetainUntil) {
+			t.Errorf("%s: Retention: got %v, want %v", e.name, got, ret)
+		}
+		hold, err := obj.LegalHold(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hold {
+			t.Errorf("%s: LegalHold: got false, want true", e.name)
+		}
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for k, want := range map[string]string{
+		"X-Bz-File-Retention-Mode":                   "compliance",
+		"X-Bz-File-Retention-Retain-Until-Timestamp": "1600000000000",
+		"X-Bz-File-Legal-Hold":                       "on",
+	} {
+		if got := rt.hdrs.Get(k); got != want {
+			t.Errorf("upload header %s: got %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestObjectSetRetention(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bucket.Object(smallFileName)
+	w := obj.NewWriter(ctx)
+	if _, err := io.WriteString(w, "aaaaa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// check asserts the object's state both as obj, which holds what B2
+	// reported on upload, and as B2 now reports it.
+	check := func(desc string, want *Retention, hold bool) {
+		t.Helper()
+		fresh := &Object{name: obj.name, f: bucket.b.file(obj.ID(), obj.name), b: bucket}
+		for _, o := range []*Object{obj, fresh} {
+			got, err := o.Retention(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (want == nil) || got != nil && (got.Mode != want.Mode || !got.RetainUntil.Equal(want.RetainUntil)) {
+				t.Errorf("%s: Retention: got %v, want %v", desc, got, want)
+			}
+			gotHold, err := o.LegalHold(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotHold != hold {
+				t.Errorf("%s: LegalHold: got %v, want %v", desc, gotHold, hold)
+			}
+		}
+	}
+	check("uploaded", nil, false)
+
+	if err := obj.SetLegalHold(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	check("hold on", nil, true)
+	if err := obj.SetLegalHold(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	check("hold off", nil, false)
+
+	until := time.Unix(1600000000, 0)
+	if err := obj.SetRetention(ctx, Governance, until); err != nil {
+		t.Fatal(err)
+	}
+	check("governance", &Retention{Mode: Governance, RetainUntil: until}, false)
+	err = obj.SetRetention(ctx, "", time.Time{})
+	var b2e *Error
+	if !errors.As(err, &b2e) || b2e.Status != 403 {
+		t.Errorf("removing governance retention without bypass: got %v, want a 403", err)
+	}
+	if err := obj.SetRetention(ctx, "", time.Time{}, BypassGovernance()); err != nil {
+		t.Fatal(err)
+	}
+	check("removed", nil, false)
+
+	if err := obj.SetRetention(ctx, Compliance, until); err != nil {
+		t.Fatal(err)
+	}
+	err = obj.SetRetention(ctx, Compliance, until.Add(-time.Hour), BypassGovernance())
+	if !errors.As(err, &b2e) || !strings.Contains(err.Error(), "setting compliance retention") {
+		t.Errorf("shortening compliance retention: got %v, want a B2 error naming the change", err)
+	}
+	check("compliance", &Retention{Mode: Compliance, RetainUntil: until}, false)
+
+	rt.mu.Lock()
+	sent := len(rt.reqs)
+	rt.mu.Unlock()
+	for _, e := range []struct {
+		mode  string
+		until time.Time
+	}{
+		{mode: "forever", until: until},
+		{mode: Governance},
+		{until: until},
+	} {
+		if err := obj.SetRetention(ctx, e.mode, e.until); err == nil {
+			t.Errorf("SetRetention(%q, %v): got nil error", e.mode, e.until)
+		}
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if extra := rt.reqs[sent:]; len(extra) > 0 {
+		t.Errorf("invalid SetRetention calls made requests %v, want none", extra)
+	}
+}
+
+func TestBucketDefaultRetentionJSON(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := &DefaultRetention{Mode: Compliance, Period: 30, Unit: Days}
+	if _, err := client.NewBucket(ctx, "locked", &BucketAttrs{ObjectLock: true, DefaultRetention: def}); err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.ObjectLock || !reflect.DeepEqual(attrs.DefaultRetention, def) {
+		t.Errorf("got lock %v, default %+v; want true, %+v", attrs.ObjectLock, attrs.DefaultRetention, def)
+	}
+	rt.mu.Lock()
+	got := string(rt.defaultRe)
+	rt.mu.Unlock()
+	if want := `{"mode":"compliance","period":{"duration":30,"unit":"days"}}`; got != want {
+		t.Errorf("sent default retention %s, want %s", got, want)
+	}
+}
+
+func TestBucketReplicationJSON(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repl := &Replication{
+		AsSource: &ReplicationSource{
+			KeyID: "source-key",
+			Rules: []ReplicationRule{{
+				Name:                "to-backup",
+				DestinationBucketID: "backup",
+				Prefix:              "logs/",
+				Priority:            1,
+				IncludeExisting:     true,
+			}},
+		},
+	}
+	if _, err := client.NewBucket(ctx, "replicated", &BucketAttrs{Replication: repl}); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	got := string(rt.repl)
+	rt.mu.Unlock()
+	want := `{"asReplicationSource":{"replicationRules":[{"replicationRuleName":"to-backup","destinationBucketId":"backup","fileNamePrefix":"logs/","priority":1,"includeExistingFiles":true,"isEnabled":true}],"sourceApplicationKeyId":"source-key"}}`
+	if got != want {
+		t.Errorf("sent replication configuration %s, want %s", got, want)
+	}
+	bucket, err := client.Bucket(ctx, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.Replication, repl) {
+		t.Errorf("got replication %+v, want %+v", attrs.Replication, repl)
+	}
+}
+
+func TestErrorHelpersSeeThroughError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs: &errCont{
+					errMap: map[string]map[int]error{
+						"updateBucket": {
+							0: b2err{err: testError{status: 409}, isUpdateConflict: true},
+							1: b2err{err: testError{status: 404}, notFoundErr: true},
+						},
+					},
+				},
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	err = bucket.Update(ctx, &BucketAttrs{Info: map[string]string{"a": "b"}})
+	if !errors.As(err, &e) || e.Status != 409 {
+		t.Errorf("Update: got %v, want a 409 *Error", err)
+	}
+	if !IsUpdateConflict(err) {
+		t.Errorf("IsUpdateConflict(%v): got false, want true", err)
+	}
+	err = bucket.Update(ctx, &BucketAttrs{Info: map[string]string{"a": "b"}})
+	if !errors.As(err, &e) || e.Status != 404 {
+		t.Errorf("Update: got %v, want a 404 *Error", err)
+	}
+	if !IsNotExist(err) {
+		t.Errorf("IsNotExist(%v): got false, want true", err)
+	}
+}
+
+func TestBucketRevisionConflict(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(&recordingTransport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := client.NewBucket(ctx, "revised", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.Bucket(ctx, "revised")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Revision() != 1 || b.Revision() != 1 {
+		t.Fatalf("got revisions %d and %d, want 1", a.Revision(), b.Revision())
+	}
+	if err := a.Update(ctx, &BucketAttrs{Info: map[string]string{"owner": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Revision() != 2 {
+		t.Errorf("after Update, got revision %d, want 2", a.Revision())
+	}
+
+	// b's revision is now stale.
+	err = b.Update(ctx, &BucketAttrs{Info: map[string]string{"owner": "b"}})
+	if !errors.Is(err, ErrRevisionConflict) || !IsUpdateConflict(err) {
+		t.Fatalf("Update with a stale revision: got %v, want ErrRevisionConflict", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Status != 409 {
+		t.Errorf("Update with a stale revision: got %v, want a 409 *Error", err)
+	}
+	if wrapped := fmt.Errorf("updating: %w", err); !IsUpdateConflict(wrapped) {
+		t.Errorf("IsUpdateConflict(%v): got false, want true", wrapped)
+	}
+	if _, err := b.Attrs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b.Revision() != 2 {
+		t.Errorf("after Attrs, got revision %d, want 2", b.Revision())
+	}
+	if err := b.Update(ctx, &BucketAttrs{Info: map[string]string{"owner": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Revision() != 3 {
+		t.Errorf("after Update, got revision %d, want 3", b.Revision())
+	}
+}
+
+func TestBucketInfo(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := map[string]string{"owner": "ops", "env": "staging"}
+	bucket, err := client.NewBucket(ctx, "tagged", &BucketAttrs{Type: Private, Info: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want map[string]string) {
+		t.Helper()
+		b, err := client.Bucket(ctx, "tagged")
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := b.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(attrs.Info, want) {
+			t.Errorf("got info %v, want %v", attrs.Info, want)
+		}
+	}
+	check(info)
+
+	info = map[string]string{"owner": "ops", "env": "prod"}
+	if err := bucket.Update(ctx, &BucketAttrs{Info: info}); err != nil {
+		t.Fatal(err)
+	}
+	check(info)
+
+	// Updating other attributes leaves the info alone.
+	if err := bucket.Update(ctx, &BucketAttrs{Type: Public}); err != nil {
+		t.Fatal(err)
+	}
+	check(info)
+
+	if err := bucket.Update(ctx, &BucketAttrs{Info: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{})
+
+	// Info that B2 would refuse isn't sent.
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	rt.mu.Lock()
+	sent := len(rt.reqs)
+	rt.mu.Unlock()
+	if err := bucket.Update(ctx, &BucketAttrs{Info: tooMany}); !errors.Is(err, ErrTooManyInfoKeys) {
+		t.Errorf("Update with 11 info keys: got %v, want ErrTooManyInfoKeys", err)
+	}
+	if err := bucket.Update(ctx, &BucketAttrs{Info: map[string]string{"bad key": "v"}}); !errors.Is(err, ErrInvalidInfoKey) {
+		t.Errorf("Update with a bad info key: got %v, want ErrInvalidInfoKey", err)
+	}
+	if _, err := client.NewBucket(ctx, "too-many", &BucketAttrs{Info: tooMany}); !errors.Is(err, ErrTooManyInfoKeys) {
+		t.Errorf("NewBucket with 11 info keys: got %v, want ErrTooManyInfoKeys", err)
+	}
+	rt.mu.Lock()
+	var writes int
+	for _, r := range rt.reqs[sent:] {
+		if strings.HasPrefix(r, "b2_update_bucket ") || strings.HasPrefix(r, "b2_create_bucket ") {
+			writes++
+		}
+	}
+	rt.mu.Unlock()
+	if writes != 0 {
+		t.Errorf("sent %d bucket updates with invalid info", writes)
+	}
+}
+
+func TestNotificationRules(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	lobj, wshaL, err := writeFile(ctx, bucket, largeFileName, 1e6-1e5, 1e4)
+	rule := NotificationRule{
+		Name:          "uploads",
+		EventTypes:    []string{"b2:ObjectCreated:*"},
+		Prefix:        "incoming/",
+		URL:           "https://hooks.example.com/b2",
+		SigningSecret: "0123456789abcdefghijABCDEFGHIJ01",
+		CustomHeaders: map[string]string{"X-Team": "ops"},
+	}
+	if err := bucket.SetNotificationRules(ctx, []NotificationRule{rule}); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	sent := rt.notes
+	last := rt.reqs[len(rt.reqs)-1]
+	rt.mu.Unlock()
+	if want := "b2_set_bucket_notification_rules https://api.backblaze.example/b2api/v3/b2_set_bucket_notification_rules"; last != want {
+		t.Errorf("sent %q, want %q", last, want)
+	}
+	want := `[{"name":"uploads","eventTypes":["b2:ObjectCreated:*"],"objectNamePrefix":"incoming/","isEnabled":true,"targetConfiguration":{"targetType":"webhook","url":"https://hooks.example.com/b2","hmacSha256SigningSecret":"0123456789abcdefghijABCDEFGHIJ01","customHeaders":[{"name":"X-Team","value":"ops"}]}}]`
+	if sent != want {
+		t.Errorf("sent rules %s, want %s", sent, want)
+	}
+	got, err := bucket.NotificationRules(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := lobj.Delete(ctx); err != nil {
-			t.Error(err)
+	if !reflect.DeepEqual(got, []NotificationRule{rule}) {
+		t.Errorf("got rules %+v, want %+v", got, []NotificationRule{rule})
+	}
+
+	if err := bucket.ClearNotificationRules(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	sent = rt.notes
+	rt.mu.Unlock()
+	if sent != "[]" {
+		t.Errorf("clearing sent rules %s, want []", sent)
+	}
+	got, err = bucket.NotificationRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("after clearing, got rules %+v", got)
+	}
+
+	// Unknown event types aren't sent.
+	rt.mu.Lock()
+	rt.reqs = nil
+	rt.mu.Unlock()
+	for _, events := range [][]string{nil, {"b2:ObjectCreated:*", "b2:ObjectUpdated"}} {
+		bad := rule
+		bad.EventTypes = events
+		if err := bucket.SetNotificationRules(ctx, []NotificationRule{bad}); err == nil {
+			t.Errorf("event types %q: got no error", events)
 		}
-	}()
+	}
+	rt.mu.Lock()
+	reqs := rt.reqs
+	rt.mu.Unlock()
+	if len(reqs) != 0 {
+		t.Errorf("invalid rules sent requests %v", reqs)
+	}
+}
+
+func TestEventSignature(t *testing.T) {
+	const (
+		secret = "0123456789abcdefghijABCDEFGHIJ01"
+		body   = `{"events":[{"accountId":"abcd","bucketId":"bucket","bucketName":"b2-tests","eventId":"ev-1","eventTimestamp":1684793309123,"eventType":"b2:ObjectCreated:Upload","eventVersion":1,"matchedRuleName":"uploads","objectName":"incoming/report.csv","objectSize":1024,"objectVersionId":"file-id-1"}]}`
+		sig    = "v1=10b3ae3bf1a9adff05ada088f265c196ce6320b3ce382365bbbaaabcc6733b8e"
+	)
+
+	table := []struct {
+		desc   string
+		secret string
+		header string
+		body   string
+		ok     bool
+	}{
+		{desc: "valid", secret: secret, header: sig, body: body, ok: true},
+		{desc: "one of several", secret: secret, header: "v1=00, " + sig, body: body, ok: true},
+		{desc: "tampered body", secret: secret, header: sig, body: strings.Replace(body, "1024", "2048", 1)},
+		{desc: "wrong secret", secret: "another-secret", header: sig, body: body},
+		{desc: "missing", secret: secret, body: body},
+		{desc: "unknown version", secret: secret, header: "v2" + strings.TrimPrefix(sig, "v1"), body: body},
+		{desc: "not hex", secret: secret, header: "v1=not-hex", body: body},
+	}
+	for _, e := range table {
+		err := VerifyEventSignature(e.secret, e.header, []byte(e.body))
+		if e.ok && err != nil {
+			t.Errorf("%s: got %v, want nil", e.desc, err)
+		}
+		if !e.ok && !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: got %v, want ErrBadSignature", e.desc, err)
+		}
+	}
+
+	events, err := ParseEvents([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{
+		ID:         "ev-1",
+		Type:       "b2:ObjectCreated:Upload",
+		Time:       time.Unix(1684793309, 123e6),
+		Version:    1,
+		RuleName:   "uploads",
+		AccountID:  "abcd",
+		BucketID:   "bucket",
+		BucketName: "b2-tests",
+		ObjectName: "incoming/report.csv",
+		ObjectSize: 1024,
+		ObjectID:   "file-id-1",
+	}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("ParseEvents: got %+v, want %+v", events, want)
+	}
+	if _, err := ParseEvents([]byte("{")); err == nil {
+		t.Error("ParseEvents of bad JSON: got no error")
+	}
+}
+
+// racingRoot creates each bucket just before the client can, as another
+// client might.
+type racingRoot struct {
+	*testRoot
+}
+
+func (r racingRoot) createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *ServerSideEncryption, lock bool, repl *Replication) (b2BucketInterface, error) {
+	if _, err := r.testRoot.createBucket(ctx, name, btype, info, rules, cors, sse, lock, repl); err != nil {
+		return nil, err
+	}
+	return nil, &Error{Status: 400, Code: "duplicate_bucket_name", Message: "Bucket name is already in use."}
+}
+
+func TestEnsureBucket(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{
+		backend: &beRoot{
+			b2i: root,
+		},
+	}
+	attrs := &BucketAttrs{
+		Type:           Public,
+		Info:           map[string]string{"owner": "ops"},
+		LifecycleRules: []LifecycleRule{{Prefix: "logs/", DaysHiddenUntilDeleted: 7}},
+	}
+
+	// Create.
+	bucket, err := client.EnsureBucket(ctx, bucketName, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != Public || got.Info["owner"] != "ops" || len(got.LifecycleRules) != 1 {
+		t.Errorf("created bucket: got attrs %+v", got)
+	}
+	if n := root.errs.count("createBucket"); n != 1 {
+		t.Errorf("created bucket: created %d times, want 1", n)
+	}
+	if n := root.errs.count("updateBucket"); n != 0 {
+		t.Errorf("created bucket: updated %d times, want 0", n)
+	}
+
+	// Already exists, unchanged.
+	if _, err := client.EnsureBucket(ctx, bucketName, attrs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.EnsureBucket(ctx, bucketName, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := root.errs.count("createBucket"); n != 1 {
+		t.Errorf("existing bucket: created %d times, want 1", n)
+	}
+	if n := root.errs.count("updateBucket"); n != 0 {
+		t.Errorf("existing bucket: updated %d times, want 0", n)
+	}
+
+	// Drifted.
+	if err := bucket.Update(ctx, &BucketAttrs{Type: Private, Info: map[string]string{"owner": "someone"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.EnsureBucket(ctx, bucketName, attrs); err != nil {
+		t.Fatal(err)
+	}
+	got, err = bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != Public || got.Info["owner"] != "ops" || len(got.LifecycleRules) != 1 {
+		t.Errorf("drifted bucket: got attrs %+v", got)
+	}
+	if n := root.errs.count("updateBucket"); n != 2 {
+		t.Errorf("drifted bucket: updated %d times, want 2", n)
+	}
+
+	// Created by someone else in the meantime.
+	racer := racingRoot{&testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}}
+	client = &Client{
+		backend: &beRoot{
+			b2i: racer,
+		},
+	}
+	bucket, err = client.EnsureBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatalf("racing: %v", err)
+	}
+	if bucket.Name() != bucketName {
+		t.Errorf("racing: got bucket %q, want %q", bucket.Name(), bucketName)
+	}
+	if _, err := client.NewBucket(ctx, "other", nil); err == nil {
+		t.Errorf("racing: NewBucket succeeded, want duplicate_bucket_name")
+	}
+}
+
+func TestListBucketsFilters(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      &errCont{},
+			},
+		},
+	}
+	if _, err := client.NewBucket(ctx, "private", &BucketAttrs{Type: Private}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NewBucket(ctx, "public", &BucketAttrs{Type: Public, Info: map[string]string{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(opts ...ListBucketsOption) []string {
+		t.Helper()
+		bs, err := client.ListBuckets(ctx, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n []string
+		for _, b := range bs {
+			n = append(n, b.Name())
+		}
+		sort.Strings(n)
+		return n
+	}
+	table := []struct {
+		desc string
+		opts []ListBucketsOption
+		want []string
+	}{
+		{desc: "all", want: []string{"private", "public"}},
+		{desc: "public", opts: []ListBucketsOption{ListBucketsTypes(Public)}, want: []string{"public"}},
+		{desc: "private", opts: []ListBucketsOption{ListBucketsTypes(Private)}, want: []string{"private"}},
+		{desc: "both types", opts: []ListBucketsOption{ListBucketsTypes(Private, Public)}, want: []string{"private", "public"}},
+		{desc: "snapshot", opts: []ListBucketsOption{ListBucketsTypes(Snapshot)}},
+		{desc: "name", opts: []ListBucketsOption{ListBucketsName("public")}, want: []string{"public"}},
+		{desc: "ID", opts: []ListBucketsOption{ListBucketsID("private")}, want: []string{"private"}},
+		{desc: "name and other type", opts: []ListBucketsOption{ListBucketsName("public"), ListBucketsTypes(Private)}},
+	}
+	for _, e := range table {
+		if got := names(e.opts...); !reflect.DeepEqual(got, e.want) {
+			t.Errorf("%s: got %v, want %v", e.desc, got, e.want)
+		}
+	}
+
+	// Listed buckets carry their attributes.
+	bs, err := client.ListBuckets(ctx, ListBucketsTypes(Public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bs[0].Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Type != Public || attrs.Info["k"] != "v" {
+		t.Errorf("public bucket: got attrs %+v", attrs)
+	}
+}
+
+func TestBucketReload(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	root := &testRoot{
+		bucketMap: make(map[string]map[string]string),
+		errs:      &errCont{},
+	}
+	client := &Client{backend: &beRoot{b2i: root}}
+	want := &BucketAttrs{
+		Type: Private,
+		Info: map[string]string{"owner": "ops", "env": "test"},
+		LifecycleRules: []LifecycleRule{{
+			Prefix:                 "logs/",
+			DaysNewUntilHidden:     30,
+			DaysHiddenUntilDeleted: 7,
+		}},
+		CORSRules: []CORSRule{{
+			Name:              "downloads",
+			AllowedOrigins:    []string{"https://example.com"},
+			AllowedOperations: []string{"b2_download_file_by_name"},
+			MaxAgeSeconds:     3600,
+		}},
+	}
+	if _, err := client.NewBucket(ctx, bucketName, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NewBucket(ctx, "other-bucket", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bucket.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != want.Type || !reflect.DeepEqual(got.Info, want.Info) || !reflect.DeepEqual(got.LifecycleRules, want.LifecycleRules) || !reflect.DeepEqual(got.CORSRules, want.CORSRules) {
+		t.Errorf("Attrs: got %+v, want %+v", got, want)
+	}
+
+	// Changes made through another handle are seen after Reload.
+	other, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Update(ctx, &BucketAttrs{Type: Public}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := bucket.b.attrs().Type; got != Public {
+		t.Errorf("after Reload, got type %q, want %q", got, Public)
+	}
+
+	// The bucket is deleted by someone else.
+	gmux.Lock()
+	delete(root.bucketMap, bucketName)
+	gmux.Unlock()
+	if err := bucket.Reload(ctx); !IsNotExist(err) {
+		t.Errorf("Reload of a deleted bucket: got %v, want not found", err)
+	}
+	if _, err := bucket.Attrs(ctx); !IsNotExist(err) {
+		t.Errorf("Attrs of a deleted bucket: got %v, want not found", err)
+	}
+
+	// B2 is asked for just the one bucket.
+	rt := &recordingTransport{}
+	rclient, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := rclient.NewBucket(ctx, "revised", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := rclient.Bucket(ctx, "revised")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Update(ctx, &BucketAttrs{Info: map[string]string{"owner": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	rt.mu.Lock()
+	rt.listed = nil
+	rt.mu.Unlock()
+	if err := b.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b.Revision() != 2 {
+		t.Errorf("after Reload, got revision %d, want 2", b.Revision())
+	}
+	rt.mu.Lock()
+	listed := rt.listed
+	rt.mu.Unlock()
+	if !reflect.DeepEqual(listed, []string{"revised"}) {
+		t.Errorf("Reload listed buckets named %q, want [revised]", listed)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rt := &recordingTransport{
+		allowed: `{"capabilities": ["listBuckets", "readFiles"], "bucketId": "bucket", "bucketName": "b2-tests", "namePrefix": "logs/"}`,
+	}
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Allowance{
+		Capabilities: []string{"listBuckets", "readFiles"},
+		BucketID:     "bucket",
+		BucketName:   "b2-tests",
+		NamePrefix:   "logs/",
+	}
+	got := client.Allowed()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Allowed: got %+v, want %+v", got, want)
+	}
+	// The returned capabilities are the caller's to change.
+	got.Capabilities[0] = "writeFiles"
+	if c := client.Allowed().Capabilities[0]; c != "listBuckets" {
+		t.Errorf("after changing a returned capability, got %q", c)
+	}
+}
+
+func TestS3Endpoint(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient(ctx, "abcd", "efgh", Transport(&recordingTransport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.S3Endpoint(), "https://s3.us-west-000.backblaze.example"; got != want {
+		t.Errorf("S3Endpoint: got %q, want %q", got, want)
+	}
+}
+
+func TestAPIBase(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var paths []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		var body string
+		switch r.URL.Path {
+		case "/b2api/v1/b2_authorize_account":
+			body = fmt.Sprintf(`{"accountId": "abcd", "authorizationToken": "token", "apiUrl": "https://unreachable.example", "downloadUrl": %q}`, srv.URL)
+		case "/b2api/v1/b2_list_buckets":
+			body = `{"buckets": [{"bucketId": "bucket", "bucketName": "b2-tests", "bucketType": "allPrivate"}]}`
+		case "/b2api/v1/b2_get_upload_url":
+			body = fmt.Sprintf(`{"uploadUrl": %q, "authorizationToken": "upload"}`, srv.URL+"/upload")
+		case "/upload":
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			name, _ := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+			body = fmt.Sprintf(`{"fileId": "small", "fileName": %q, "contentLength": %d, "contentSha1": %q, "fileInfo": {}, "action": "upload", "uploadTimestamp": 1500000000000}`, name, len(data), r.Header.Get("X-Bz-Content-Sha1"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			body = `{"status": 400, "code": "bad_request", "message": "unexpected call"}`
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(ctx, "abcd", "efgh", APIBase(srv.URL), ForceAPIURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("file").NewWriter(ctx)
+	if _, err := io.Copy(w, strings.NewReader("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/b2api/v1/b2_authorize_account", "/b2api/v1/b2_list_buckets", "/b2api/v1/b2_get_upload_url", "/upload"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("requests: got %v, want %v", paths, want)
+	}
+}
+
+func TestRequestHooks(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	const data = "hello, world"
+	var mu sync.Mutex
+	var untraced []string // paths of requests without the hook's header
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.Header.Get("X-Trace-Id") != "trace-1" {
+			untraced = append(untraced, r.URL.Path)
+		}
+		mu.Unlock()
+		var body string
+		switch r.URL.Path {
+		case "/b2api/v1/b2_authorize_account":
+			body = fmt.Sprintf(`{"accountId": "abcd", "authorizationToken": "token", "apiUrl": %q, "downloadUrl": %q}`, srv.URL, srv.URL)
+		case "/b2api/v1/b2_list_buckets":
+			body = `{"buckets": [{"bucketId": "bucket", "bucketName": "b2-tests", "bucketType": "allPrivate"}]}`
+		case "/b2api/v1/b2_get_upload_url":
+			body = fmt.Sprintf(`{"uploadUrl": %q, "authorizationToken": "upload"}`, srv.URL+"/upload")
+		case "/upload":
+			io.Copy(ioutil.Discard, r.Body)
+			body = fmt.Sprintf(`{"fileId": "small", "fileName": "file", "contentLength": %d, "contentSha1": %q, "fileInfo": {}, "action": "upload", "uploadTimestamp": 1500000000000}`, len(data), r.Header.Get("X-Bz-Content-Sha1"))
+		case "/b2api/v1/b2_list_file_names":
+			body = fmt.Sprintf(`{"files": [{"fileId": "small", "fileName": "file", "contentLength": %d, "contentSha1": "none", "fileInfo": {}, "action": "upload", "uploadTimestamp": 1500000000000}], "nextFileName": null}`, len(data))
+		case "/file/b2-tests/file", "/b2api/v1/b2_download_file_by_id":
+			if !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				body = `{"status": 416, "code": "range_not_satisfiable", "message": "past the end"}`
+				break
+			}
+			w.Header().Set("X-Bz-File-Id", "small")
+			w.Header().Set("X-Bz-File-Name", "file")
+			w.Header().Set("X-Bz-Content-Sha1", "none")
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			body = data
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			body = `{"status": 400, "code": "bad_request", "message": "unexpected call"}`
+			t.Log(r.Method, r.URL.String(), r.Header.Get("Range"))
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	var seen []string // the method, path, and status of each response
+	reqHook := func(r *http.Request) { r.Header.Set("X-Trace-Id", "trace-1") }
+	respHook := func(r *http.Response) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("%s %s %d", r.Request.Method, r.Request.URL.Path, r.StatusCode))
+	}
+	client, err := NewClient(ctx, "abcd", "efgh", APIBase(srv.URL), WithRequestHook(reqHook), WithResponseHook(respHook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.Bucket(ctx, "b2-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bucket.Object("file").NewWriter(ctx)
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	iter := bucket.List(ctx)
+	for iter.Next() {
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	r := bucket.Object("file").NewReader(ctx)
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("read %q, want %q", got, data)
+	}
 
-	if err := readFile(ctx, lobj, wshaL, 1e7, 10); err != nil {
-		t.Error(err)
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"GET /b2api/v1/b2_authorize_account 200",
+		"POST /b2api/v1/b2_list_buckets 200",
+		"POST /b2api/v1/b2_get_upload_url 200",
+		"POST /upload 200",
+		"POST /b2api/v1/b2_list_file_names 200",
+		"GET /file/b2-tests/file 206",
+		"GET /b2api/v1/b2_download_file_by_id 416", // the reader looks past the end
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("responses: got %q, want %q", seen, want)
+	}
+	if len(untraced) > 0 {
+		t.Errorf("requests to %v were sent without the request hook's header", untraced)
 	}
 }
 
-func TestReadRangeReturnsRight(t *testing.T) {
+func TestIfNotExists(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -693,34 +8825,56 @@ func TestReadRangeReturnsRight(t *testing.T) {
 			},
 		},
 	}
-
 	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := bucket.Delete(ctx); err != nil {
-			t.Error(err)
+	write := func(name, body string, large bool) error {
+		w := bucket.Object(name).NewWriter(ctx)
+		w.IfNotExists = true
+		w.UseLargeFile = &large
+		if _, err := io.WriteString(w, body); err != nil {
+			w.Close()
+			return err
 		}
-	}()
+		return w.Close()
+	}
+	read := func(name string) string {
+		r := bucket.Object(name).NewReader(ctx)
+		defer r.Close()
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		return string(b)
+	}
 
-	obj, _, err := writeFile(ctx, bucket, "file", 1e6+42, 1e8)
-	if err != nil {
-		t.Fatal(err)
+	for _, large := range []bool{false, true} {
+		name := fmt.Sprintf("unique-%v", large)
+		if err := write(name, "first", large); err != nil {
+			t.Errorf("large=%v: writing a new object: %v", large, err)
+		}
+		if err := write(name, "second", large); !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("large=%v: overwriting: got %v, want ErrAlreadyExists", large, err)
+		}
+		if got := read(name); got != "first" {
+			t.Errorf("large=%v: got %q, want the first version", large, got)
+		}
 	}
-	r := obj.NewRangeReader(ctx, 200, 1400)
-	r.ChunkSize = 1000
 
-	i, err := io.Copy(ioutil.Discard, r)
-	if err != nil {
-		t.Error(err)
+	// A hidden object has no current version, and may be written again.
+	if err := bucket.Object("unique-false").Hide(ctx); err != nil {
+		t.Fatal(err)
 	}
-	if i != 1400 {
-		t.Errorf("NewRangeReader(_, 200, 1400): want 1400, got %d", i)
+	if err := write("unique-false", "third", false); err != nil {
+		t.Errorf("writing over a hidden object: %v", err)
+	}
+	if got := read("unique-false"); got != "third" {
+		t.Errorf("got %q after writing over a hidden object, want the third version", got)
 	}
 }
 
-func TestWriterReturnsError(t *testing.T) {
+func TestTags(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -729,141 +8883,374 @@ func TestWriterReturnsError(t *testing.T) {
 		backend: &beRoot{
 			b2i: &testRoot{
 				bucketMap: make(map[string]map[string]string),
-				errs: &errCont{
-					errMap: map[string]map[int]error{
-						"uploadPart": {
-							0: testError{},
-							1: testError{},
-							2: testError{},
-							3: testError{},
-							4: testError{},
-							5: testError{},
-							6: testError{},
-						},
-					},
-				},
+				errs:      &errCont{},
 			},
 		},
 	}
-
 	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
 	if err != nil {
 		t.Fatal(err)
 	}
-	w := bucket.Object("test").NewWriter(ctx)
-	r := io.LimitReader(zReader{}, 1e7)
-	w.ChunkSize = 1e4
-	w.ConcurrentUploads = 4
-	if _, err := io.Copy(w, r); err == nil {
-		t.Fatalf("io.Copy: should have returned an error")
+
+	table := []struct {
+		desc string
+		tags []string
+		info map[string]string // given with WithAttrs
+		want []string
+	}{
+		{desc: "plain", tags: []string{"red", "green"}, want: []string{"red", "green"}},
+		{desc: "special characters", tags: []string{"a,b", "100%", "%2C", "x y/z", "é"}, want: []string{"a,b", "100%", "%2C", "x y/z", "é"}},
+		{desc: "empty tags dropped", tags: []string{"", "only", ""}, want: []string{"only"}},
+		{desc: "none", want: nil},
+		{desc: "replacing attrs", tags: []string{"new"}, info: map[string]string{TagsInfoKey: "old", "other": "kept"}, want: []string{"new"}},
+		{desc: "from attrs", info: map[string]string{TagsInfoKey: "old,older"}, want: []string{"old", "older"}},
+	}
+	for i, e := range table {
+		o := bucket.Object(fmt.Sprintf("tagged-%d", i))
+		w := o.NewWriter(ctx, WithAttrsOption(&Attrs{Info: e.info}))
+		if e.tags != nil {
+			w.SetTags(e.tags)
+		}
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", e.desc, err)
+		}
+		got, err := o.Tags(ctx)
+		if err != nil {
+			t.Errorf("%s: Tags: %v", e.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.want) {
+			t.Errorf("%s: got tags %q, want %q", e.desc, got, e.want)
+		}
+		if e.info["other"] != "" {
+			attrs, err := o.Attrs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Info["other"] != e.info["other"] {
+				t.Errorf("%s: other info lost: %v", e.desc, attrs.Info)
+			}
+		}
 	}
 }
 
-func TestFileBuffer(t *testing.T) {
-	r := io.LimitReader(zReader{}, 1e8)
-	w, err := newFileBuffer("")
+func TestReaderRangeError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	const chunk int64 = 1e4
+	errs := &errCont{}
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      errs,
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer w.Close()
-	if _, err := io.Copy(w, r); err != nil {
+	data := make([]byte, 4*chunk)
+	for i := range data {
+		data[i] = byte(i / 100)
+	}
+	o := bucket.Object("four-ranges")
+	w := o.NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
 		t.Fatal(err)
 	}
-	bReader, err := w.Reader()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := data[:2*chunk]
+
+	// The third range fails before the first two arrive, whose bytes must
+	// still be delivered, and nothing from the fourth.
+	failure := errors.New("range three failed")
+	gmux.Lock()
+	errs.ranges = map[int64]error{2 * chunk: failure}
+	errs.rangeLags = map[int64]time.Duration{0: 50 * time.Millisecond, chunk: 50 * time.Millisecond}
+	gmux.Unlock()
+
+	for _, e := range []struct {
+		desc string
+		read func(*Reader, io.Writer) (int64, error)
+	}{
+		{desc: "Read", read: func(r *Reader, w io.Writer) (int64, error) { return io.Copy(w, struct{ io.Reader }{r}) }},
+		{desc: "WriteTo", read: func(r *Reader, w io.Writer) (int64, error) { return r.WriteTo(w) }},
+	} {
+		r := o.NewReader(ctx)
+		r.ChunkSize = int(chunk)
+		r.ConcurrentDownloads = 4
+		var got bytes.Buffer
+		n, err := e.read(r, &got)
+		r.Close()
+		if n != 2*chunk || !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: read %d bytes (reported %d), want the %d bytes of the first two ranges", e.desc, got.Len(), n, 2*chunk)
+		}
+		var re *RangeError
+		if !errors.As(err, &re) {
+			t.Errorf("%s: got error %v, want a *RangeError", e.desc, err)
+			continue
+		}
+		if re.Offset != 2*chunk || re.Length != chunk || !errors.Is(err, failure) {
+			t.Errorf("%s: got %v, for bytes %d+%d; want the third range's failure", e.desc, err, re.Offset, re.Length)
+		}
+	}
+}
+
+func TestDownloadTo(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	const chunk int64 = 1e4
+	errs := &errCont{}
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      errs,
+			},
+		},
+	}
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
 	if err != nil {
 		t.Fatal(err)
 	}
-	hsh := sha1.New()
-	if _, err := io.Copy(hsh, bReader); err != nil {
+	data := make([]byte, 4*chunk+chunk/2)
+	for i := range data {
+		data[i] = byte(i / 100)
+	}
+	o := bucket.Object("sparse")
+	w := o.NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
 		t.Fatal(err)
 	}
-	hshText := fmt.Sprintf("%x", hsh.Sum(nil))
-	if hshText != w.Hash() {
-		t.Errorf("hashes are not equal: bufferWriter is %q, read buffer is %q", w.Hash(), hshText)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestNonBuffer(t *testing.T) {
+	const fill = 0xaa
 	table := []struct {
-		str  string
-		off  int64
-		len  int64
-		want string
+		desc        string
+		opts        []DownloadOption
+		start, want int64 // the bytes of data that should be written
 	}{
-		{
-			str:  "a string",
-			off:  0,
-			len:  3,
-			want: "a s",
-		},
-		{
-			str:  "a string",
-			off:  3,
-			len:  1,
-			want: "t",
-		},
-		{
-			str:  "a string",
-			off:  3,
-			len:  5,
-			want: "tring",
-		},
+		{desc: "whole", want: int64(len(data))},
+		{desc: "concurrent", opts: []DownloadOption{DownloadConcurrency(3), DownloadChunkSize(chunk)}, want: int64(len(data))},
+		{desc: "one byte chunks", opts: []DownloadOption{DownloadConcurrency(8), DownloadChunkSize(1), DownloadRange(chunk-50, 100)}, start: chunk - 50, want: 100},
+		{desc: "range", opts: []DownloadOption{DownloadConcurrency(2), DownloadChunkSize(chunk), DownloadRange(chunk+17, 2*chunk)}, start: chunk + 17, want: 2 * chunk},
+		{desc: "to the end", opts: []DownloadOption{DownloadConcurrency(2), DownloadChunkSize(chunk), DownloadRange(3*chunk, 0)}, start: 3 * chunk, want: int64(len(data)) - 3*chunk},
 	}
-
 	for _, e := range table {
-		nb := newNonBuffer(strings.NewReader(e.str), e.off, e.len)
-		want := fmt.Sprintf("%s%x", e.want, sha1.Sum([]byte(e.str[int(e.off):int(e.off+e.len)])))
-		r, err := nb.Reader()
+		f, err := os.Create(filepath.Join(t.TempDir(), "download"))
 		if err != nil {
-			t.Error(err)
-			continue
+			t.Fatal(err)
 		}
-		got, err := ioutil.ReadAll(r)
+		if _, err := f.Write(bytes.Repeat([]byte{fill}, len(data))); err != nil {
+			t.Fatal(err)
+		}
+		n, err := o.DownloadTo(ctx, f, e.opts...)
 		if err != nil {
-			t.Errorf("ioutil.ReadAll(%#v): %v", e, err)
-			continue
+			t.Errorf("%s: DownloadTo: %v", e.desc, err)
 		}
-		if want != string(got) {
-			t.Errorf("ioutil.ReadAll(%#v): got %q, want %q", e, string(got), want)
+		if n != e.want {
+			t.Errorf("%s: DownloadTo wrote %d bytes, want %d", e.desc, n, e.want)
+		}
+		got := make([]byte, len(data))
+		if _, err := f.ReadAt(got, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		for i := range got {
+			want := byte(fill)
+			if in := int64(i) - e.start; in >= 0 && in < e.want {
+				want = data[i]
+			}
+			if got[i] != want {
+				t.Errorf("%s: byte %d: got %#x, want %#x", e.desc, i, got[i], want)
+				break
+			}
 		}
 	}
-}
 
-func writeFile(ctx context.Context, bucket *Bucket, name string, size int64, csize int) (*Object, string, error) {
-	r := io.LimitReader(zReader{}, size)
-	o := bucket.Object(name)
-	f := o.NewWriter(ctx)
-	h := sha1.New()
-	w := io.MultiWriter(f, h)
-	f.ConcurrentUploads = 5
-	f.ChunkSize = csize
-	n, err := io.Copy(w, r)
+	failure := errors.New("range three failed")
+	gmux.Lock()
+	errs.ranges = map[int64]error{2 * chunk: failure}
+	gmux.Unlock()
+	f, err := os.Create(filepath.Join(t.TempDir(), "download"))
 	if err != nil {
-		return nil, "", err
+		t.Fatal(err)
 	}
-	if n != size {
-		return nil, "", fmt.Errorf("io.Copy(): wrote %d bytes; wanted %d bytes", n, size)
+	defer f.Close()
+	n, err := o.DownloadTo(ctx, f, DownloadConcurrency(2), DownloadChunkSize(chunk))
+	var re *RangeError
+	if !errors.As(err, &re) {
+		t.Fatalf("failed range: got error %v, want a *RangeError", err)
 	}
-	if err := f.Close(); err != nil {
-		return nil, "", err
+	if re.Offset != 2*chunk || re.Length != chunk || !errors.Is(err, failure) {
+		t.Errorf("failed range: got %v, for bytes %d+%d; want the third range's failure", err, re.Offset, re.Length)
+	}
+	if n >= int64(len(data)) {
+		t.Errorf("failed range: DownloadTo wrote %d bytes, want fewer than %d", n, len(data))
 	}
-	return o, fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
-func readFile(ctx context.Context, obj *Object, sha string, chunk, concur int) error {
-	r := obj.NewReader(ctx)
-	r.ChunkSize = chunk
-	r.ConcurrentDownloads = concur
-	h := sha1.New()
-	if _, err := io.Copy(h, r); err != nil {
-		return err
+func TestDownloadResume(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	const chunk int64 = 1e4
+	errs := &errCont{}
+	client := &Client{
+		backend: &beRoot{
+			b2i: &testRoot{
+				bucketMap: make(map[string]map[string]string),
+				errs:      errs,
+			},
+		},
 	}
-	if err := r.Close(); err != nil {
-		return err
+	bucket, err := client.NewBucket(ctx, bucketName, &BucketAttrs{Type: Private})
+	if err != nil {
+		t.Fatal(err)
 	}
-	rsha := fmt.Sprintf("%x", h.Sum(nil))
-	if sha != rsha {
-		return fmt.Errorf("bad hash: got %s, want %s", rsha, sha)
+	data := make([]byte, 4*chunk)
+	for i := range data {
+		data[i] = byte(i / 100)
+	}
+	o := bucket.Object("resumable")
+	w := o.NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "download")
+
+	// The first attempt "crashes" halfway, when the third range fails.
+	gmux.Lock()
+	errs.ranges = map[int64]error{2 * chunk: errors.New("crash")}
+	gmux.Unlock()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewDownloadProgress()
+	n, err := o.DownloadTo(ctx, f, DownloadChunkSize(chunk), DownloadResume(p))
+	f.Close()
+	if err == nil {
+		t.Fatal("first attempt: DownloadTo succeeded, want an error")
+	}
+	if n != 2*chunk || p.Offset() != 2*chunk {
+		t.Fatalf("first attempt: wrote %d bytes, to offset %d; want %d", n, p.Offset(), 2*chunk)
+	}
+	offset := p.Offset() // as persisted by the caller
+
+	// The second resumes from the persisted offset, in a new process.
+	gmux.Lock()
+	errs.ranges = nil
+	gmux.Unlock()
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	before := errs.count("downloadFileByID")
+	p = NewDownloadProgress(ByteRange{Length: offset})
+	n, err = o.DownloadTo(ctx, f, DownloadConcurrency(2), DownloadChunkSize(chunk), DownloadResume(p))
+	if err != nil {
+		t.Fatalf("resumed: DownloadTo: %v", err)
+	}
+	if n != 2*chunk {
+		t.Errorf("resumed: wrote %d bytes, want %d", n, 2*chunk)
+	}
+	if got := errs.count("downloadFileByID") - before; got != 2 {
+		t.Errorf("resumed: made %d requests, want 2", got)
+	}
+	if got, want := p.Done(), []ByteRange{{Offset: 0, Length: 4 * chunk}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed: got done ranges %v, want %v", got, want)
+	}
+	got := make([]byte, len(data))
+	if _, err := f.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("resumed: the file does not hold the object")
+	}
+
+	// Ranges that were already written, in any order, are skipped, and only
+	// the gaps between them fetched.
+	p = NewDownloadProgress(ByteRange{Offset: chunk + 10, Length: 20}, ByteRange{Offset: 5, Length: chunk - 5}, ByteRange{Offset: chunk, Length: 10})
+	if got, want := p.Done(), []ByteRange{{Offset: 5, Length: chunk + 25}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged ranges: got %v, want %v", got, want)
+	}
+	before = errs.count("downloadFileByID")
+	n, err = o.DownloadTo(ctx, f, DownloadChunkSize(chunk), DownloadRange(0, 2*chunk), DownloadResume(p))
+	if err != nil {
+		t.Fatalf("gaps: DownloadTo: %v", err)
+	}
+	if n != chunk-25 {
+		t.Errorf("gaps: wrote %d bytes, want %d", n, chunk-25)
+	}
+	if got := errs.count("downloadFileByID") - before; got != 2 {
+		t.Errorf("gaps: made %d requests, want 2", got)
+	}
+	if got, want := p.Offset(), 2*chunk; got != want {
+		t.Errorf("gaps: got offset %d, want %d", got, want)
+	}
+}
+
+func TestTunedTransport(t *testing.T) {
+	custom := &http.Transport{MaxIdleConns: 4}
+	table := []struct {
+		desc      string
+		opts      []ClientOption
+		same      bool // the transport given is used as it is
+		idle, max int
+		http2     bool // ForceAttemptHTTP2 is set
+		disabled  bool // HTTP/2 is disabled
+	}{
+		{desc: "untuned", same: true},
+		{desc: "idle", opts: []ClientOption{MaxIdleConnsPerHost(16)}, idle: 16, http2: true},
+		{desc: "max", opts: []ClientOption{MaxConnsPerHost(8)}, max: 8, http2: true},
+		{desc: "no http2", opts: []ClientOption{DisableHTTP2()}, disabled: true},
+		{desc: "given transport", opts: []ClientOption{Transport(custom), MaxIdleConnsPerHost(16)}, idle: 16},
+		{desc: "given round tripper", opts: []ClientOption{Transport(&recordingTransport{}), MaxIdleConnsPerHost(16)}, same: true},
+	}
+	for _, e := range table {
+		var c clientOptions
+		for _, opt := range e.opts {
+			opt(&c)
+		}
+		rt := c.tunedTransport()
+		if e.same {
+			if rt != c.transport {
+				t.Errorf("%s: got a new transport, want the one given", e.desc)
+			}
+			continue
+		}
+		tr, ok := rt.(*http.Transport)
+		if !ok || tr == http.DefaultTransport || tr == custom {
+			t.Errorf("%s: got %T %p, want a tuned copy", e.desc, rt, rt)
+			continue
+		}
+		if tr.MaxIdleConnsPerHost != e.idle || tr.MaxConnsPerHost != e.max || tr.ForceAttemptHTTP2 != e.http2 {
+			t.Errorf("%s: got idle %d, max %d, HTTP/2 %v; want %d, %d, %v", e.desc, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, tr.ForceAttemptHTTP2, e.idle, e.max, e.http2)
+		}
+		if tr.MaxIdleConns != 0 && tr.MaxIdleConns < tr.MaxIdleConnsPerHost {
+			t.Errorf("%s: MaxIdleConns %d is below MaxIdleConnsPerHost %d", e.desc, tr.MaxIdleConns, tr.MaxIdleConnsPerHost)
+		}
+		if e.disabled && tr.TLSNextProto == nil {
+			t.Errorf("%s: HTTP/2 is not disabled", e.desc)
+		}
 	}
-	return nil
 }
@@ -0,0 +1,1246 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package b2test provides an in-memory B2 service, for testing code that uses
+// package b2 without a Backblaze account or a network.
+//
+// A Server answers the parts of the B2 API that package b2 uses to manage
+// buckets and to write, list, read, hide, copy, and delete objects, including
+// large objects.  It keeps everything in memory, and is deterministic: IDs
+// are assigned in sequence, and upload timestamps come from a clock that
+// starts at the beginning of 2018 and advances one millisecond per upload.
+// Encryption, Object Lock, keys, and notification rules are not supported.
+//
+// ServerOptions have a Server simulate failures, so that clients' handling of
+// them can be tested.
+//
+//	srv := b2test.NewServer()
+//	client, err := srv.NewClient(ctx)
+//
+// A Server is an http.RoundTripper, which NewClient installs with
+// b2.Transport, and an http.Handler, so that it can also be run with
+// net/http/httptest and reached with b2.APIBase.
+package b2test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kurin/blazer/b2"
+	"github.com/kurin/blazer/internal/b2types"
+)
+
+const (
+	accountID   = "b2test-account"
+	uploadToken = "b2test-upload-token"
+
+	// tokenUses is the number of requests an account authorization token is
+	// good for, with ExpireSomeAuthTokens.
+	tokenUses = 3
+
+	// Part sizes, as B2 reports them.
+	recommendedPartSize = 100 * 1e6
+	minimumPartSize     = 5 * 1e6
+
+	// epoch is the first upload timestamp, in milliseconds.
+	epoch = 1514764800000 // 2018-01-01T00:00:00Z
+)
+
+// A Server is an in-memory B2 service.  It is safe for concurrent use.
+type Server struct {
+	mu         sync.Mutex
+	seq        int
+	clock      int64
+	buckets    map[string]*bucket // by ID
+	files      map[string]*file   // every version and unfinished file, by ID
+	unfinished []*file            // large files, in the order they were started
+
+	failUploads  bool
+	expireTokens bool
+	capExceeded  bool
+	auths        int
+	tokens       map[string]int  // requests made with each account token
+	failed       map[string]bool // uploads that have been failed once
+}
+
+type bucket struct {
+	id, name, btype string
+	info            map[string]string
+	lifecycle       []b2types.LifecycleRule
+	cors            []b2types.CORSRule
+	revision        int
+	versions        map[string][]*file // by name, newest first
+}
+
+type file struct {
+	id, name, bucketID string
+	action             string // "upload", "hide", or "start" if unfinished
+	ctype, sha1        string
+	info               map[string]string
+	data               []byte
+	stamp              int64
+	parts              map[int][]byte // of an unfinished large file
+}
+
+// NewServer returns an empty Server.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		clock:   epoch,
+		buckets: make(map[string]*bucket),
+		files:   make(map[string]*file),
+		tokens:  make(map[string]int),
+		failed:  make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// A ServerOption has a Server simulate one of the failures that B2 can be
+// asked for in its X-Bz-Test-Mode header.  The client options
+// b2.FailSomeUploads, b2.ExpireSomeAuthTokens, and b2.ForceCapExceeded send
+// that header, and have the same effect as these for that client alone.
+type ServerOption func(*Server)
+
+// FailSomeUploads fails the first attempt to upload each object, and each part
+// of each large object, with a 503 Service Unavailable.  Where B2 fails
+// uploads at random, these failures are predictable, and a client that
+// retries as B2 asks it to will still succeed.
+func FailSomeUploads() ServerOption {
+	return func(s *Server) {
+		s.failUploads = true
+	}
+}
+
+// ExpireSomeAuthTokens expires each account authorization token after three
+// requests, so that clients must reauthorize.
+func ExpireSomeAuthTokens() ServerOption {
+	return func(s *Server) {
+		s.expireTokens = true
+	}
+}
+
+// ForceCapExceeded refuses every upload and copy, and every download, as
+// though the account had reached its storage and download caps.
+func ForceCapExceeded() ServerOption {
+	return func(s *Server) {
+		s.capExceeded = true
+	}
+}
+
+// Option returns a ClientOption that sends a client's requests to s.
+func (s *Server) Option() b2.ClientOption {
+	return b2.Transport(s)
+}
+
+// NewClient returns a client of s, with the given options applied after
+// Option.  Any key is accepted.
+func (s *Server) NewClient(ctx context.Context, opts ...b2.ClientOption) (*b2.Client, error) {
+	return b2.NewClient(ctx, "b2test", "b2test", append([]b2.ClientOption{s.Option()}, opts...)...)
+}
+
+// RoundTrip answers req itself, whatever its host.
+func (s *Server) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// ServeHTTP answers B2 API requests.  The URLs that s returns for further
+// requests are on the same scheme and host as r.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/file/"):
+		s.downloadByName(rw, r)
+		return
+	case strings.HasPrefix(p, "/b2test/upload/"):
+		s.uploadFile(rw, r, strings.TrimPrefix(p, "/b2test/upload/"))
+		return
+	case strings.HasPrefix(p, "/b2test/upload_part/"):
+		s.uploadPart(rw, r, strings.TrimPrefix(p, "/b2test/upload_part/"))
+		return
+	case !strings.HasPrefix(p, "/b2api/"):
+		writeError(rw, http.StatusNotFound, "not_found", "no such endpoint: "+p)
+		return
+	}
+	method := path.Base(p)
+	if method == "b2_authorize_account" {
+		s.authorize(rw, r)
+		return
+	}
+	if err := s.checkAuth(r); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	if method == "b2_download_file_by_id" {
+		s.downloadByID(rw, r)
+		return
+	}
+	if method == "b2_start_large_file" || method == "b2_copy_file" {
+		if err := s.capped(r, "storage"); err != nil {
+			writeAPIError(rw, err)
+			return
+		}
+	}
+	h, ok := apiMethods[method]
+	if !ok {
+		writeError(rw, http.StatusBadRequest, "bad_request", "b2test does not support "+method)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	s.mu.Lock()
+	reply, err := h(s, baseURL(r), body)
+	s.mu.Unlock()
+	if err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	writeJSON(rw, reply)
+}
+
+// testMode reports whether the named test mode is on, either for the server
+// or for r, which may ask for it in an X-Bz-Test-Mode header.
+func testMode(r *http.Request, mode string, on bool) bool {
+	if on {
+		return true
+	}
+	for _, v := range r.Header.Values("X-Bz-Test-Mode") {
+		if v == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAuth checks r's account authorization token, and counts its use.
+func (s *Server) checkAuth(r *http.Request) error {
+	tok := r.Header.Get("Authorization")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uses, ok := s.tokens[tok]
+	if !ok {
+		return &apiError{http.StatusUnauthorized, "bad_auth_token", "invalid authorization token"}
+	}
+	if uses >= tokenUses && testMode(r, "expire_some_account_authorization_tokens", s.expireTokens) {
+		delete(s.tokens, tok)
+		return &apiError{http.StatusUnauthorized, "expired_auth_token", "authorization token has expired"}
+	}
+	s.tokens[tok] = uses + 1
+	return nil
+}
+
+// checkUploadAuth checks the authorization token of an upload URL.
+func checkUploadAuth(r *http.Request) error {
+	if r.Header.Get("Authorization") != uploadToken {
+		return &apiError{http.StatusUnauthorized, "bad_auth_token", "invalid authorization token"}
+	}
+	return nil
+}
+
+// capped returns the error B2 gives when the account has reached its cap of
+// the given kind, "storage" or "download", if the cap is being forced.
+func (s *Server) capped(r *http.Request, kind string) error {
+	if !testMode(r, "force_cap_exceeded", s.capExceeded) {
+		return nil
+	}
+	return &apiError{http.StatusForbidden, kind + "_cap_exceeded", kind + " cap exceeded"}
+}
+
+// failUpload fails the first attempt at the upload named by key, if uploads
+// are to fail.
+func (s *Server) failUpload(r *http.Request, key string) error {
+	if !testMode(r, "fail_some_uploads", s.failUploads) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed[key] {
+		return nil
+	}
+	s.failed[key] = true
+	return &apiError{http.StatusServiceUnavailable, "service_unavailable", "simulated upload failure"}
+}
+
+// An apiMethod answers a JSON API call.  s.mu is held.
+type apiMethod func(s *Server, base string, body []byte) (interface{}, error)
+
+var apiMethods map[string]apiMethod
+
+func init() {
+	apiMethods = map[string]apiMethod{
+		"b2_create_bucket":               (*Server).createBucket,
+		"b2_delete_bucket":               (*Server).deleteBucket,
+		"b2_update_bucket":               (*Server).updateBucket,
+		"b2_list_buckets":                (*Server).listBuckets,
+		"b2_get_upload_url":              (*Server).getUploadURL,
+		"b2_list_file_names":             (*Server).listFileNames,
+		"b2_list_file_versions":          (*Server).listFileVersions,
+		"b2_get_file_info":               (*Server).getFileInfo,
+		"b2_delete_file_version":         (*Server).deleteFileVersion,
+		"b2_hide_file":                   (*Server).hideFile,
+		"b2_copy_file":                   (*Server).copyFile,
+		"b2_start_large_file":            (*Server).startLargeFile,
+		"b2_get_upload_part_url":         (*Server).getUploadPartURL,
+		"b2_list_parts":                  (*Server).listParts,
+		"b2_finish_large_file":           (*Server).finishLargeFile,
+		"b2_cancel_large_file":           (*Server).cancelLargeFile,
+		"b2_list_unfinished_large_files": (*Server).listUnfinishedLargeFiles,
+	}
+}
+
+type apiError struct {
+	status    int
+	code, msg string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{http.StatusNotFound, "not_found", fmt.Sprintf(format, args...)}
+}
+
+func badRequest(code, format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, code, fmt.Sprintf(format, args...)}
+}
+
+func writeError(rw http.ResponseWriter, status int, code, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(&b2types.ErrorMessage{Status: status, Code: code, Msg: msg})
+}
+
+func writeAPIError(rw http.ResponseWriter, err error) {
+	e := asAPIError(err).(*apiError)
+	writeError(rw, e.status, e.code, e.msg)
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	rw.Write(buf)
+}
+
+// baseURL returns the scheme and host that r was sent to.
+func baseURL(r *http.Request) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	return scheme + "://" + host
+}
+
+func (s *Server) nextID(kind string) string {
+	s.seq++
+	return fmt.Sprintf("b2test-%s-%d", kind, s.seq)
+}
+
+func (s *Server) tick() int64 {
+	s.clock++
+	return s.clock
+}
+
+func (s *Server) authorize(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.auths++
+	tok := fmt.Sprintf("b2test-token-%d", s.auths)
+	s.tokens[tok] = 0
+	s.mu.Unlock()
+	base := baseURL(r)
+	writeJSON(rw, &b2types.AuthorizeAccountResponse{
+		AccountID:      accountID,
+		AuthToken:      tok,
+		URI:            base,
+		DownloadURI:    base,
+		MinPartSize:    minimumPartSize,
+		PartSize:       recommendedPartSize,
+		AbsMinPartSize: minimumPartSize,
+		Allowed: b2types.Allowance{
+			Capabilities: []string{
+				"listKeys", "writeKeys", "deleteKeys", "listBuckets", "writeBuckets",
+				"deleteBuckets", "listFiles", "readFiles", "shareFiles", "writeFiles",
+				"deleteFiles",
+			},
+		},
+	})
+}
+
+func (b *bucket) reply() b2types.CreateBucketResponse {
+	return b2types.CreateBucketResponse{
+		BucketID:       b.id,
+		Name:           b.name,
+		Type:           b.btype,
+		Info:           b.info,
+		LifecycleRules: b.lifecycle,
+		CORSRules:      b.cors,
+		Revision:       b.revision,
+	}
+}
+
+func (s *Server) bucket(id string) (*bucket, error) {
+	b, ok := s.buckets[id]
+	if !ok {
+		return nil, badRequest("bad_bucket_id", "no such bucket: %s", id)
+	}
+	return b, nil
+}
+
+func (s *Server) createBucket(_ string, body []byte) (interface{}, error) {
+	var req b2types.CreateBucketRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, badRequest("bad_request", "bucketName is required")
+	}
+	for _, b := range s.buckets {
+		if b.name == req.Name {
+			return nil, badRequest("duplicate_bucket_name", "bucket name is already in use")
+		}
+	}
+	b := &bucket{
+		id:        s.nextID("bucket"),
+		name:      req.Name,
+		btype:     req.Type,
+		info:      req.Info,
+		lifecycle: req.LifecycleRules,
+		cors:      req.CORSRules,
+		revision:  1,
+		versions:  make(map[string][]*file),
+	}
+	s.buckets[b.id] = b
+	return b.reply(), nil
+}
+
+func (s *Server) deleteBucket(_ string, body []byte) (interface{}, error) {
+	var req b2types.DeleteBucketRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.versions) > 0 {
+		return nil, badRequest("cannot_delete_non_empty_bucket", "bucket %s is not empty", b.name)
+	}
+	for _, f := range s.unfinished {
+		if f.bucketID == b.id {
+			return nil, badRequest("cannot_delete_non_empty_bucket", "bucket %s has unfinished large files", b.name)
+		}
+	}
+	delete(s.buckets, b.id)
+	return b.reply(), nil
+}
+
+func (s *Server) updateBucket(_ string, body []byte) (interface{}, error) {
+	var req b2types.UpdateBucketRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if req.IfRevisionIs != 0 && req.IfRevisionIs != b.revision {
+		return nil, &apiError{http.StatusConflict, "conflict", "ifRevisionIs does not match"}
+	}
+	if req.Type != "" {
+		b.btype = req.Type
+	}
+	if req.Info != nil {
+		b.info = req.Info
+	}
+	if req.LifecycleRules != nil {
+		b.lifecycle = req.LifecycleRules
+	}
+	if req.CORSRules != nil {
+		b.cors = req.CORSRules
+	}
+	b.revision++
+	return b.reply(), nil
+}
+
+func (s *Server) listBuckets(_ string, body []byte) (interface{}, error) {
+	var req b2types.ListBucketsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	types := make(map[string]bool)
+	for _, t := range req.Types {
+		types[t] = true
+	}
+	resp := &b2types.ListBucketsResponse{Buckets: []b2types.CreateBucketResponse{}}
+	for _, b := range s.buckets {
+		if req.Bucket != "" && b.id != req.Bucket {
+			continue
+		}
+		if req.Name != "" && b.name != req.Name {
+			continue
+		}
+		if len(types) > 0 && !types["all"] && !types[b.btype] {
+			continue
+		}
+		resp.Buckets = append(resp.Buckets, b.reply())
+	}
+	sort.Slice(resp.Buckets, func(i, j int) bool { return resp.Buckets[i].Name < resp.Buckets[j].Name })
+	return resp, nil
+}
+
+func (s *Server) getUploadURL(base string, body []byte) (interface{}, error) {
+	var req b2types.GetUploadURLRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if _, err := s.bucket(req.BucketID); err != nil {
+		return nil, err
+	}
+	return &b2types.GetUploadURLResponse{
+		URI:   base + "/b2test/upload/" + url.PathEscape(req.BucketID),
+		Token: uploadToken,
+	}, nil
+}
+
+func (f *file) reply() b2types.GetFileInfoResponse {
+	return b2types.GetFileInfoResponse{
+		FileID:      f.id,
+		Name:        f.name,
+		AccountID:   accountID,
+		BucketID:    f.bucketID,
+		Size:        int64(len(f.data)),
+		SHA1:        f.sha1,
+		ContentType: f.ctype,
+		Info:        f.info,
+		Action:      f.action,
+		Timestamp:   f.stamp,
+	}
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	return io.ReadAll(r.Body)
+}
+
+// readContent reads the body of an upload, and checks it against the SHA1 in
+// its X-Bz-Content-Sha1 header, which may instead follow the content.  It
+// returns the content and its SHA1.
+func readContent(r *http.Request) ([]byte, string, error) {
+	data, err := readBody(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.ContentLength >= 0 && r.ContentLength != int64(len(data)) {
+		return nil, "", badRequest("bad_request", "read %d bytes, Content-Length is %d", len(data), r.ContentLength)
+	}
+	want := r.Header.Get("X-Bz-Content-Sha1")
+	if want == "hex_digits_at_end" {
+		if len(data) < 40 {
+			return nil, "", badRequest("bad_request", "content is too short to end with a SHA1")
+		}
+		want = string(data[len(data)-40:])
+		data = data[:len(data)-40]
+	}
+	got := fmt.Sprintf("%x", sha1.Sum(data))
+	if want != "do_not_verify" && want != got {
+		return nil, "", badRequest("bad_request", "sha1 did not match data received")
+	}
+	return data, got, nil
+}
+
+// fileInfo returns the X-Bz-Info-* headers of r, by their lower-cased names.
+func fileInfo(r *http.Request) (map[string]string, error) {
+	var info map[string]string
+	for key := range r.Header {
+		if !strings.HasPrefix(key, "X-Bz-Info-") {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimPrefix(key, "X-Bz-Info-"))
+		if err != nil {
+			return nil, err
+		}
+		val, err := url.QueryUnescape(r.Header.Get(key))
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			info = make(map[string]string)
+		}
+		info[strings.ToLower(name)] = val
+	}
+	return info, nil
+}
+
+// contentType resolves B2's "b2/x-auto" by the extension of name.
+func contentType(ctype, name string) string {
+	if ctype != "" && ctype != "b2/x-auto" {
+		return ctype
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// addVersion makes f the newest version of its name.  s.mu is held.
+func (s *Server) addVersion(b *bucket, f *file) {
+	f.stamp = s.tick()
+	s.files[f.id] = f
+	b.versions[f.name] = append([]*file{f}, b.versions[f.name]...)
+}
+
+func (s *Server) uploadFile(rw http.ResponseWriter, r *http.Request, bucketID string) {
+	reply, err := s.upload(r, bucketID)
+	if err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	writeJSON(rw, reply)
+}
+
+func (s *Server) upload(r *http.Request, bucketID string) (interface{}, error) {
+	if err := checkUploadAuth(r); err != nil {
+		return nil, err
+	}
+	if err := s.capped(r, "storage"); err != nil {
+		return nil, err
+	}
+	if err := s.failUpload(r, "file "+bucketID+"/"+r.Header.Get("X-Bz-File-Name")); err != nil {
+		return nil, err
+	}
+	data, sum, err := readContent(r)
+	if err != nil {
+		return nil, asAPIError(err)
+	}
+	name, err := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+	if err != nil || name == "" {
+		return nil, badRequest("bad_request", "bad file name %q", r.Header.Get("X-Bz-File-Name"))
+	}
+	info, err := fileInfo(r)
+	if err != nil {
+		return nil, asAPIError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		id:       s.nextID("file"),
+		name:     name,
+		bucketID: b.id,
+		action:   "upload",
+		ctype:    contentType(r.Header.Get("Content-Type"), name),
+		sha1:     sum,
+		info:     info,
+		data:     data,
+	}
+	s.addVersion(b, f)
+	return f.reply(), nil
+}
+
+func asAPIError(err error) error {
+	if _, ok := err.(*apiError); ok {
+		return err
+	}
+	return badRequest("bad_request", "%v", err)
+}
+
+// An entry is a line of a listing: a file version, or a folder if f is nil.
+type entry struct {
+	name string
+	f    *file
+}
+
+func (e entry) reply() b2types.GetFileInfoResponse {
+	if e.f == nil {
+		return b2types.GetFileInfoResponse{Name: e.name, Action: "folder"}
+	}
+	return e.f.reply()
+}
+
+// list returns, in order, the files of b under prefix, or their every version
+// if all is set, with names that continue past the delimiter collapsed into
+// folders.
+func (b *bucket) list(prefix, delimiter string, all bool) []entry {
+	var names []string
+	for name := range b.versions {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var es []entry
+	for _, name := range names {
+		if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			folder := name[:len(prefix)+i+len(delimiter)]
+			if len(es) == 0 || es[len(es)-1].name != folder || es[len(es)-1].f != nil {
+				es = append(es, entry{name: folder})
+			}
+			continue
+		}
+		vs := b.versions[name]
+		if !all {
+			if vs[0].action == "hide" {
+				continue
+			}
+			vs = vs[:1]
+		}
+		for _, f := range vs {
+			es = append(es, entry{name: name, f: f})
+		}
+	}
+	return es
+}
+
+// page returns up to count entries of es, from the first at or after start,
+// and the entry after them, if any.
+func page(es []entry, start, startID string, count int) ([]b2types.GetFileInfoResponse, *entry) {
+	if count <= 0 {
+		count = 100
+	}
+	i := sort.Search(len(es), func(i int) bool { return es[i].name >= start })
+	if startID != "" {
+		for j := i; j < len(es) && es[j].name == start; j++ {
+			if es[j].f != nil && es[j].f.id == startID {
+				i = j
+				break
+			}
+		}
+	}
+	files := []b2types.GetFileInfoResponse{}
+	for ; i < len(es) && len(files) < count; i++ {
+		files = append(files, es[i].reply())
+	}
+	if i < len(es) {
+		return files, &es[i]
+	}
+	return files, nil
+}
+
+func (s *Server) listFileNames(_ string, body []byte) (interface{}, error) {
+	var req b2types.ListFileNamesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	files, next := page(b.list(req.Prefix, req.Delimiter, false), req.Continuation, "", req.Count)
+	resp := &b2types.ListFileNamesResponse{Files: files}
+	if next != nil {
+		resp.Continuation = next.name
+	}
+	return resp, nil
+}
+
+func (s *Server) listFileVersions(_ string, body []byte) (interface{}, error) {
+	var req b2types.ListFileVersionsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	files, next := page(b.list(req.Prefix, req.Delimiter, true), req.StartName, req.StartID, req.Count)
+	resp := &b2types.ListFileVersionsResponse{Files: files}
+	if next != nil {
+		resp.NextName = next.name
+		if next.f != nil {
+			resp.NextID = next.f.id
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) file(id string) (*file, error) {
+	f, ok := s.files[id]
+	if !ok || f.action == "start" {
+		return nil, notFound("no such file: %s", id)
+	}
+	return f, nil
+}
+
+func (s *Server) getFileInfo(_ string, body []byte) (interface{}, error) {
+	var req b2types.GetFileInfoRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	f, err := s.file(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return f.reply(), nil
+}
+
+func (s *Server) deleteFileVersion(_ string, body []byte) (interface{}, error) {
+	var req b2types.DeleteFileVersionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	f, err := s.file(req.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if f.name != req.Name {
+		return nil, badRequest("bad_request", "file %s is not named %q", f.id, req.Name)
+	}
+	b := s.buckets[f.bucketID]
+	vs := b.versions[f.name]
+	for i, v := range vs {
+		if v == f {
+			vs = append(vs[:i:i], vs[i+1:]...)
+			break
+		}
+	}
+	if len(vs) == 0 {
+		delete(b.versions, f.name)
+	} else {
+		b.versions[f.name] = vs
+	}
+	delete(s.files, f.id)
+	return &b2types.GetFileInfoResponse{FileID: f.id, Name: f.name}, nil
+}
+
+func (s *Server) hideFile(_ string, body []byte) (interface{}, error) {
+	var req b2types.HideFileRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	vs := b.versions[req.File]
+	if len(vs) == 0 {
+		return nil, notFound("no such file: %s", req.File)
+	}
+	if vs[0].action == "hide" {
+		return nil, badRequest("already_hidden", "file already hidden: %s", req.File)
+	}
+	f := &file{
+		id:       s.nextID("file"),
+		name:     req.File,
+		bucketID: b.id,
+		action:   "hide",
+	}
+	s.addVersion(b, f)
+	return f.reply(), nil
+}
+
+func (s *Server) copyFile(_ string, body []byte) (interface{}, error) {
+	var req b2types.CopyFileRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	src, err := s.file(req.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	bucketID := req.DestBucket
+	if bucketID == "" {
+		bucketID = src.bucketID
+	}
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		id:       s.nextID("file"),
+		name:     req.Name,
+		bucketID: b.id,
+		action:   "upload",
+		ctype:    src.ctype,
+		sha1:     src.sha1,
+		info:     src.info,
+		data:     src.data,
+	}
+	if req.Directive == "REPLACE" {
+		f.ctype = contentType(req.ContentType, req.Name)
+		f.info = req.Info
+	}
+	s.addVersion(b, f)
+	return f.reply(), nil
+}
+
+func (s *Server) startLargeFile(_ string, body []byte) (interface{}, error) {
+	var req b2types.StartLargeFileRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(req.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		id:       s.nextID("file"),
+		name:     req.Name,
+		bucketID: b.id,
+		action:   "start",
+		ctype:    contentType(req.ContentType, req.Name),
+		sha1:     "none",
+		info:     req.Info,
+		stamp:    s.tick(),
+		parts:    make(map[int][]byte),
+	}
+	s.files[f.id] = f
+	s.unfinished = append(s.unfinished, f)
+	return f.reply(), nil
+}
+
+func (s *Server) largeFile(id string) (*file, error) {
+	f, ok := s.files[id]
+	if !ok || f.action != "start" {
+		return nil, badRequest("bad_request", "no such unfinished large file: %s", id)
+	}
+	return f, nil
+}
+
+func (s *Server) getUploadPartURL(base string, body []byte) (interface{}, error) {
+	var req struct {
+		ID string `json:"fileId"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if _, err := s.largeFile(req.ID); err != nil {
+		return nil, err
+	}
+	return &b2types.GetUploadURLResponse{
+		URI:   base + "/b2test/upload_part/" + url.PathEscape(req.ID),
+		Token: uploadToken,
+	}, nil
+}
+
+func (s *Server) uploadPart(rw http.ResponseWriter, r *http.Request, id string) {
+	reply, err := s.part(r, id)
+	if err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	writeJSON(rw, reply)
+}
+
+func (s *Server) part(r *http.Request, id string) (interface{}, error) {
+	if err := checkUploadAuth(r); err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+	if err != nil || n < 1 || n > 10000 {
+		return nil, badRequest("bad_request", "bad part number")
+	}
+	if err := s.capped(r, "storage"); err != nil {
+		return nil, err
+	}
+	if err := s.failUpload(r, fmt.Sprintf("part %s/%d", id, n)); err != nil {
+		return nil, err
+	}
+	data, sum, err := readContent(r)
+	if err != nil {
+		return nil, asAPIError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.largeFile(id)
+	if err != nil {
+		return nil, err
+	}
+	f.parts[n] = data
+	return &b2types.UploadPartResponse{
+		ID:         id,
+		PartNumber: n,
+		Size:       int64(len(data)),
+		SHA1:       sum,
+	}, nil
+}
+
+func (s *Server) listParts(_ string, body []byte) (interface{}, error) {
+	var req b2types.ListPartsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	var nums []int
+	for n := range f.parts {
+		if n >= req.Start {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	count := req.Count
+	if count <= 0 {
+		count = 100
+	}
+	resp := &b2types.ListPartsResponse{}
+	if len(nums) > count {
+		resp.Next = nums[count]
+		nums = nums[:count]
+	}
+	for _, n := range nums {
+		resp.Parts = append(resp.Parts, struct {
+			ID     string `json:"fileId"`
+			Number int    `json:"partNumber"`
+			SHA1   string `json:"contentSha1"`
+			Size   int64  `json:"contentLength"`
+		}{f.id, n, fmt.Sprintf("%x", sha1.Sum(f.parts[n])), int64(len(f.parts[n]))})
+	}
+	return resp, nil
+}
+
+func (s *Server) finishLargeFile(_ string, body []byte) (interface{}, error) {
+	var req b2types.FinishLargeFileRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Hashes) != len(f.parts) {
+		return nil, badRequest("bad_request", "%d parts were uploaded, but %d were given", len(f.parts), len(req.Hashes))
+	}
+	var data []byte
+	for i, h := range req.Hashes {
+		part, ok := f.parts[i+1]
+		if !ok {
+			return nil, badRequest("bad_request", "part %d was not uploaded", i+1)
+		}
+		if fmt.Sprintf("%x", sha1.Sum(part)) != h {
+			return nil, badRequest("bad_request", "part %d has the wrong SHA1", i+1)
+		}
+		if i < len(req.Hashes)-1 && len(part) < minimumPartSize {
+			return nil, badRequest("bad_request", "part %d is smaller than the minimum part size", i+1)
+		}
+		data = append(data, part...)
+	}
+	s.dropUnfinished(f)
+	f.action = "upload"
+	f.data = data
+	f.parts = nil
+	s.addVersion(s.buckets[f.bucketID], f)
+	return f.reply(), nil
+}
+
+func (s *Server) cancelLargeFile(_ string, body []byte) (interface{}, error) {
+	var req b2types.CancelLargeFileRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.dropUnfinished(f)
+	delete(s.files, f.id)
+	return &b2types.GetFileInfoResponse{FileID: f.id, Name: f.name, AccountID: accountID, BucketID: f.bucketID}, nil
+}
+
+func (s *Server) dropUnfinished(f *file) {
+	for i, u := range s.unfinished {
+		if u == f {
+			s.unfinished = append(s.unfinished[:i:i], s.unfinished[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Server) listUnfinishedLargeFiles(_ string, body []byte) (interface{}, error) {
+	var req b2types.ListUnfinishedLargeFilesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if _, err := s.bucket(req.BucketID); err != nil {
+		return nil, err
+	}
+	count := req.Count
+	if count <= 0 {
+		count = 100
+	}
+	resp := &b2types.ListUnfinishedLargeFilesResponse{Files: []b2types.GetFileInfoResponse{}}
+	started := req.Continuation == ""
+	for _, f := range s.unfinished {
+		if f.bucketID != req.BucketID {
+			continue
+		}
+		if !started {
+			if f.id != req.Continuation {
+				continue
+			}
+			started = true
+		}
+		if len(resp.Files) == count {
+			resp.Continuation = f.id
+			break
+		}
+		resp.Files = append(resp.Files, f.reply())
+	}
+	return resp, nil
+}
+
+func (s *Server) downloadByName(rw http.ResponseWriter, r *http.Request) {
+	bucketName, escaped, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/file/"), "/")
+	name, err := url.QueryUnescape(escaped)
+	if !ok || err != nil {
+		writeError(rw, http.StatusBadRequest, "bad_request", "bad file name")
+		return
+	}
+	if err := s.checkAuth(r); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	if err := s.capped(r, "download"); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	s.mu.Lock()
+	var f *file
+	for _, b := range s.buckets {
+		if b.name != bucketName {
+			continue
+		}
+		if vs := b.versions[name]; len(vs) > 0 && vs[0].action == "upload" {
+			f = vs[0]
+		}
+	}
+	s.mu.Unlock()
+	if f == nil {
+		writeError(rw, http.StatusNotFound, "not_found", "file not present: "+name)
+		return
+	}
+	serveFile(rw, r, f)
+}
+
+func (s *Server) downloadByID(rw http.ResponseWriter, r *http.Request) {
+	if err := s.capped(r, "download"); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	s.mu.Lock()
+	f, err := s.file(r.URL.Query().Get("fileId"))
+	s.mu.Unlock()
+	if err == nil && f.action != "upload" {
+		err = notFound("file %s is a hide marker", f.id)
+	}
+	if err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	serveFile(rw, r, f)
+}
+
+// serveFile replies with f's content, or the part of it in r's Range header.
+// The file's data is never changed once it is uploaded, so it may be read
+// without holding the server's lock.
+func serveFile(rw http.ResponseWriter, r *http.Request, f *file) {
+	data := f.data
+	status := http.StatusOK
+	h := rw.Header()
+	if rng := r.Header.Get("Range"); rng != "" {
+		first, last, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			writeError(rw, http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable", "range not satisfiable: "+rng)
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		data = data[first : last+1]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	h.Set("Content-Type", f.ctype)
+	h.Set("X-Bz-File-Id", f.id)
+	h.Set("X-Bz-File-Name", escape(f.name))
+	h.Set("X-Bz-Content-Sha1", f.sha1)
+	h.Set("X-Bz-Upload-Timestamp", strconv.FormatInt(f.stamp, 10))
+	for k, v := range f.info {
+		h.Set("X-Bz-Info-"+escape(k), escape(v))
+	}
+	rw.WriteHeader(status)
+	io.Copy(rw, bytes.NewReader(data))
+}
+
+// parseRange parses a single "bytes=first-last" or "bytes=first-" range of
+// content of the given size.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	a, b, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(a, 10, 64)
+	if err != nil || first < 0 || first >= size {
+		return 0, 0, false
+	}
+	last := size - 1
+	if b != "" {
+		l, err := strconv.ParseInt(b, 10, 64)
+		if err != nil || l < first {
+			return 0, 0, false
+		}
+		if l < last {
+			last = l
+		}
+	}
+	return first, last, true
+}
+
+// escape encodes a file name or info for a header, as B2 does.
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "%2F", "/", -1)
+}
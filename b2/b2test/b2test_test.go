@@ -0,0 +1,464 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kurin/blazer/b2"
+)
+
+func listNames(ctx context.Context, t *testing.T, bucket *b2.Bucket, opts ...b2.ListOption) []string {
+	t.Helper()
+	var names []string
+	iter := bucket.List(ctx, opts...)
+	for iter.Next() {
+		names = append(names, iter.Object().Name())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	return names
+}
+
+func write(ctx context.Context, t *testing.T, bucket *b2.Bucket, name string, data []byte) {
+	t.Helper()
+	w := bucket.Object(name).NewWriter(ctx)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatalf("%s: write: %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s: close: %v", name, err)
+	}
+}
+
+func read(ctx context.Context, t *testing.T, bucket *b2.Bucket, name string) []byte {
+	t.Helper()
+	r := bucket.Object(name).NewReader(ctx)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: read: %v", name, err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewServer().NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"a":         []byte("hello, world"),
+		"dir/b c":   []byte("file with a space"),
+		"dir/d.txt": {},
+	}
+	for name, data := range files {
+		write(ctx, t, bucket, name, data)
+	}
+	if got, want := listNames(ctx, t, bucket), []string{"a", "dir/b c", "dir/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List: got %q, want %q", got, want)
+	}
+	if got, want := listNames(ctx, t, bucket, b2.ListDelimiter("/")), []string{"a", "dir/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List with delimiter: got %q, want %q", got, want)
+	}
+	for name, data := range files {
+		if got := read(ctx, t, bucket, name); !bytes.Equal(got, data) {
+			t.Errorf("%s: read %q, want %q", name, got, data)
+		}
+	}
+
+	attrs, err := bucket.Object("a").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha1.Sum(files["a"])); attrs.SHA1 != want || attrs.Size != int64(len(files["a"])) {
+		t.Errorf("Attrs: got size %d, SHA1 %s; want %d, %s", attrs.Size, attrs.SHA1, len(files["a"]), want)
+	}
+
+	if err := bucket.Object("a").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listNames(ctx, t, bucket), []string{"dir/b c", "dir/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List after delete: got %q, want %q", got, want)
+	}
+	if _, err := bucket.Object("a").Attrs(ctx); !b2.IsNotExist(err) {
+		t.Errorf("Attrs after delete: got %v, want a not-exist error", err)
+	}
+	for _, name := range []string{"dir/b c", "dir/d.txt"} {
+		if err := bucket.Object(name).Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bucket.Delete(ctx); err != nil {
+		t.Errorf("deleting the emptied bucket: %v", err)
+	}
+}
+
+func TestHideAndVersions(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := NewServer().NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(ctx, t, bucket, "x", []byte("one"))
+	write(ctx, t, bucket, "x", []byte("two"))
+	if got := read(ctx, t, bucket, "x"); string(got) != "two" {
+		t.Errorf("read %q, want the newest version", got)
+	}
+	if err := bucket.Object("x").Hide(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := listNames(ctx, t, bucket); len(got) != 0 {
+		t.Errorf("List after hide: got %q, want nothing", got)
+	}
+	if got := listNames(ctx, t, bucket, b2.ListHidden()); len(got) != 3 {
+		t.Errorf("List with hidden: got %q, want three versions", got)
+	}
+	if err := bucket.DeleteAllVersions(ctx, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := listNames(ctx, t, bucket, b2.ListHidden()); len(got) != 0 {
+		t.Errorf("List after deleting every version: got %q, want nothing", got)
+	}
+}
+
+func TestLargeFile(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	client, err := NewServer().NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*minimumPartSize+1234)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	w := bucket.Object("large").NewWriter(ctx)
+	w.ChunkSize = minimumPartSize
+	w.ConcurrentUploads = 2
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(ctx, t, bucket, "large"); !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes back, not the %d written", len(got), len(data))
+	}
+	if got := listNames(ctx, t, bucket, b2.ListUnfinished()); len(got) != 0 {
+		t.Errorf("unfinished large files: got %q, want none", got)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	run := func() (string, time.Time) {
+		client, err := NewServer().NewClient(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket, err := client.NewBucket(ctx, "bucket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(ctx, t, bucket, "file", []byte("data"))
+		attrs, err := bucket.Object("file").Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bucket.Object("file").ID(), attrs.UploadTimestamp
+	}
+	id1, ts1 := run()
+	id2, ts2 := run()
+	if id1 != id2 || !ts1.Equal(ts2) {
+		t.Errorf("two runs differ: %s at %v, and %s at %v", id1, ts1, id2, ts2)
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	client, err := b2.NewClient(ctx, "id", "key", b2.APIBase(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(ctx, t, bucket, "file", []byte("over the network"))
+	if got := read(ctx, t, bucket, "file"); string(got) != "over the network" {
+		t.Errorf("read %q", got)
+	}
+}
+
+func TestTrickyNames(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	client, err := b2.NewClient(ctx, "id", "key", b2.APIBase(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{
+		"a file with spaces",
+		"1+1=2",
+		"a + b",
+		"what?",
+		"#hash",
+		"100%",
+		"%2F not a slash",
+		"dir/sub dir/file",
+		"trailing slash/",
+		"~!$'()*;=:@&,",
+		`"quoted" <angled> \back\slashes\`,
+		"ünïcødé/日本語/🙂",
+	}
+	for _, name := range names {
+		write(ctx, t, bucket, name, []byte(name))
+		if got := read(ctx, t, bucket, name); string(got) != name {
+			t.Errorf("%q: read back %q", name, got)
+		}
+		attrs, err := bucket.Object(name).Attrs(ctx)
+		if err != nil {
+			t.Errorf("%q: Attrs: %v", name, err)
+		} else if attrs.Name != name {
+			t.Errorf("%q: Attrs has name %q", name, attrs.Name)
+		}
+		dst := "copy of " + name
+		if _, err := bucket.Object(name).CopyTo(ctx, bucket, dst); err != nil {
+			t.Errorf("%q: CopyTo: %v", name, err)
+		} else if got := read(ctx, t, bucket, dst); string(got) != name {
+			t.Errorf("%q: read back copy %q", name, got)
+		}
+	}
+	var want []string
+	for _, name := range names {
+		want = append(want, name, "copy of "+name)
+	}
+	sort.Strings(want)
+	if got := listNames(ctx, t, bucket); !reflect.DeepEqual(got, want) {
+		t.Errorf("List: got %q, want %q", got, want)
+	}
+}
+
+func TestFailSomeUploads(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	client, err := NewServer(FailSomeUploads()).NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := []byte("retried")
+	w := bucket.Object("small").NewWriter(ctx)
+	if _, err := w.Write(small); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("small upload: %v", err)
+	}
+	if got := w.UploadStats().URLFetches; got != 2 {
+		t.Errorf("small upload: fetched %d upload URLs, want 2", got)
+	}
+
+	large := make([]byte, 2*minimumPartSize+1)
+	w = bucket.Object("large").NewWriter(ctx)
+	w.ChunkSize = minimumPartSize
+	if _, err := io.Copy(w, bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("large upload: %v", err)
+	}
+	// One part URL to start with, and a new one after each part's failure.
+	if got := w.UploadStats().URLFetches; got != 4 {
+		t.Errorf("large upload: fetched %d part URLs, want 4", got)
+	}
+
+	if got := read(ctx, t, bucket, "small"); !bytes.Equal(got, small) {
+		t.Errorf("small: read %q, want %q", got, small)
+	}
+	if got := read(ctx, t, bucket, "large"); !bytes.Equal(got, large) {
+		t.Errorf("large: read %d bytes back, not the %d written", len(got), len(large))
+	}
+}
+
+func TestExpireSomeAuthTokens(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	client, err := NewServer(ExpireSomeAuthTokens()).NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := client.NewBucket(ctx, "bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("file-%d", i)
+		write(ctx, t, bucket, name, []byte(name))
+		if got := read(ctx, t, bucket, name); string(got) != name {
+			t.Errorf("%s: read %q", name, got)
+		}
+	}
+	if got := listNames(ctx, t, bucket); len(got) != 5 {
+		t.Errorf("List: got %q, want five files", got)
+	}
+}
+
+func TestForceCapExceeded(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		desc  string
+		sopts []ServerOption
+		copts []b2.ClientOption
+	}{
+		{desc: "server option", sopts: []ServerOption{ForceCapExceeded()}},
+		{desc: "client option", copts: []b2.ClientOption{b2.ForceCapExceeded()}},
+	} {
+		client, err := NewServer(tc.sopts...).NewClient(ctx, tc.copts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		bucket, err := client.NewBucket(ctx, "bucket", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		w := bucket.Object("file").NewWriter(ctx)
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if err := w.Close(); !b2.IsCapExceeded(err) {
+			t.Errorf("%s: Close: got %v, want a cap exceeded error", tc.desc, err)
+		}
+	}
+}
+
+// BenchmarkConcurrentUploads writes 16 objects at once over HTTP, with
+// net/http's default connection pooling and with enough idle connections kept
+// for every upload.
+func BenchmarkConcurrentUploads(b *testing.B) {
+	const uploads = 16
+	data := make([]byte, 64<<10)
+	for _, bm := range []struct {
+		name string
+		opts []b2.ClientOption
+	}{
+		{name: "default"},
+		{name: "tuned", opts: []b2.ClientOption{b2.MaxIdleConnsPerHost(uploads)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			ctx := context.Background()
+			ts := httptest.NewServer(NewServer())
+			defer ts.Close()
+			client, err := b2.NewClient(ctx, "id", "key", append(bm.opts, b2.APIBase(ts.URL))...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			bucket, err := client.NewBucket(ctx, "bucket", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(uploads * len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				errs := make(chan error, uploads)
+				for j := 0; j < uploads; j++ {
+					wg.Add(1)
+					go func(j int) {
+						defer wg.Done()
+						_, err := bucket.Upload(ctx, fmt.Sprintf("object-%d", j), bytes.NewReader(data), int64(len(data)))
+						errs <- err
+					}(j)
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				// Keep the server from holding every version written.
+				b.StopTimer()
+				for j := 0; j < uploads; j++ {
+					if err := bucket.Object(fmt.Sprintf("object-%d", j)).Delete(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -16,8 +16,11 @@ package b2
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -28,10 +31,21 @@ type beRootInterface interface {
 	reauth(error) bool
 	transient(error) bool
 	reupload(error) bool
+	retry(attempt int, last time.Duration, err error) (time.Duration, bool)
+	retryUpload(attempt int, last time.Duration, err error) (time.Duration, bool)
+	b2Error(error) error
+	minPartSize() int
+	recommendedPartSize() int
+	s3Endpoint() string
+	clock() clock
+	metrics() *metrics
+	allowed() allowance
+	allow(capability, bucket string) error
 	authorizeAccount(context.Context, string, string, clientOptions) error
-	reauthorizeAccount(context.Context) error
-	createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule) (beBucketInterface, error)
-	listBuckets(context.Context) ([]beBucketInterface, error)
+	authGeneration() int
+	reauthorizeAccount(context.Context, int) error
+	createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *ServerSideEncryption, lock bool, repl *Replication) (beBucketInterface, error)
+	listBuckets(context.Context, string, string, []string) ([]beBucketInterface, error)
 	createKey(context.Context, string, []string, time.Duration, string, string) (beKeyInterface, error)
 	listKeys(context.Context, int, string) ([]beKeyInterface, string, error)
 }
@@ -40,6 +54,12 @@ type beRoot struct {
 	account, key string
 	b2i          b2RootInterface
 	options      clientOptions
+	policy       Backoff // if nil, the default retry behavior is used
+	clk          clock   // if nil, the real clock is used
+	m            metrics
+
+	authMu  sync.Mutex
+	authGen int // incremented on every successful authorization
 }
 
 type beBucketInterface interface {
@@ -47,16 +67,21 @@ type beBucketInterface interface {
 	btype() BucketType
 	attrs() *BucketAttrs
 	id() string
+	revision() int
 	updateBucket(context.Context, *BucketAttrs) error
 	deleteBucket(context.Context) error
 	getUploadURL(context.Context) (beURLInterface, error)
-	startLargeFile(ctx context.Context, name, contentType string, info map[string]string) (beLargeFileInterface, error)
+	startLargeFile(ctx context.Context, name, contentType string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (beLargeFileInterface, error)
 	listFileNames(context.Context, int, string, string, string) ([]beFileInterface, string, error)
 	listFileVersions(context.Context, int, string, string, string, string) ([]beFileInterface, string, string, error)
 	listUnfinishedLargeFiles(context.Context, int, string) ([]beFileInterface, string, error)
-	downloadFileByName(context.Context, string, int64, int64) (beFileReaderInterface, error)
+	downloadFileByName(context.Context, string, int64, int64, *ServerSideEncryption) (beFileReaderInterface, error)
+	downloadFileByID(context.Context, string, int64, int64, *ServerSideEncryption) (beFileReaderInterface, error)
 	hideFile(context.Context, string) (beFileInterface, error)
+	copyFile(ctx context.Context, srcID, name string, replace bool, contentType string, info map[string]string, retention *Retention, srcSSE, dstSSE *ServerSideEncryption) (beFileInterface, error)
 	getDownloadAuthorization(context.Context, string, time.Duration, string) (string, error)
+	getNotificationRules(context.Context) ([]NotificationRule, error)
+	setNotificationRules(context.Context, []NotificationRule) ([]NotificationRule, error)
 	baseURL() string
 	file(string, string) beFileInterface
 }
@@ -67,7 +92,7 @@ type beBucket struct {
 }
 
 type beURLInterface interface {
-	uploadFile(context.Context, readResetter, int, string, string, string, map[string]string) (beFileInterface, error)
+	uploadFile(context.Context, readResetter, int, string, string, string, map[string]string, *ServerSideEncryption, *Retention, bool) (beFileInterface, error)
 }
 
 type beURL struct {
@@ -76,11 +101,15 @@ type beURL struct {
 }
 
 type beFileInterface interface {
+	id() string
 	name() string
 	size() int64
+	sha1() string
 	timestamp() time.Time
 	status() string
 	deleteFileVersion(context.Context) error
+	updateFileRetention(context.Context, *Retention, bool) error
+	updateFileLegalHold(context.Context, bool) error
 	getFileInfo(context.Context) (beFileInfoInterface, error)
 	listParts(context.Context, int, int) ([]beFilePartInterface, int, error)
 	compileParts(int64, map[int]string) beLargeFileInterface
@@ -93,8 +122,12 @@ type beFile struct {
 }
 
 type beLargeFileInterface interface {
+	id() string
+	hashes() map[int]string
 	finishLargeFile(context.Context) (beFileInterface, error)
+	cancelLargeFile(context.Context) error
 	getUploadPartURL(context.Context) (beFileChunkInterface, error)
+	copyPart(ctx context.Context, srcID string, part int, offset, size int64, srcSSE, dstSSE *ServerSideEncryption) error
 }
 
 type beLargeFile struct {
@@ -104,7 +137,7 @@ type beLargeFile struct {
 
 type beFileChunkInterface interface {
 	reload(context.Context) error
-	uploadPart(context.Context, readResetter, string, int, int) (int, error)
+	uploadPart(context.Context, readResetter, string, int, int, *ServerSideEncryption) (int, error)
 }
 
 type beFileChunk struct {
@@ -116,6 +149,10 @@ type beFileReaderInterface interface {
 	io.ReadCloser
 	stats() (int, string, string, map[string]string)
 	id() string
+	name() string
+	size() int64
+	timestamp() time.Time
+	encryption() *ServerSideEncryption
 }
 
 type beFileReader struct {
@@ -125,6 +162,9 @@ type beFileReader struct {
 
 type beFileInfoInterface interface {
 	stats() (string, string, int64, string, map[string]string, string, time.Time)
+	encryption() *ServerSideEncryption
+	retention() *Retention
+	legalHold() bool
 }
 
 type beFilePartInterface interface {
@@ -146,6 +186,9 @@ type beFileInfo struct {
 	info   map[string]string
 	status string
 	stamp  time.Time
+	sse    *ServerSideEncryption
+	ret    *Retention
+	hold   bool
 }
 
 type beKeyInterface interface {
@@ -155,6 +198,8 @@ type beKeyInterface interface {
 	expires() time.Time
 	secret() string
 	id() string
+	bucketID() string
+	prefix() string
 }
 
 type beKey struct {
@@ -163,11 +208,147 @@ type beKey struct {
 }
 
 func (r *beRoot) backoff(err error) time.Duration { return r.b2i.backoff(err) }
-func (r *beRoot) reauth(err error) bool           { return r.b2i.reauth(err) }
-func (r *beRoot) reupload(err error) bool         { return r.b2i.reupload(err) }
-func (r *beRoot) transient(err error) bool        { return r.b2i.transient(err) }
+func (r *beRoot) reauth(err error) bool           { return r.retryKind(err) == RetryReauth }
+func (r *beRoot) reupload(err error) bool         { return r.retryKind(err) == RetryNewUploadURL }
+func (r *beRoot) transient(err error) bool        { return r.retryKind(err) == RetrySame }
+func (r *beRoot) minPartSize() int                { return r.b2i.minPartSize() }
+func (r *beRoot) recommendedPartSize() int        { return r.b2i.recommendedPartSize() }
+func (r *beRoot) s3Endpoint() string              { return r.b2i.s3Endpoint() }
+func (r *beRoot) allowed() allowance              { return r.b2i.allowed() }
+
+func (r *beRoot) metrics() *metrics { return &r.m }
+
+// retryKind classifies err by the recovery it calls for: by err itself, if it
+// is a RetryableError, and otherwise by B2's reply.
+func (r *beRoot) retryKind(err error) RetryKind {
+	if kind, ok := retryKind(err); ok {
+		return kind
+	}
+	switch {
+	case r.b2i.reauth(err):
+		return RetryReauth
+	case r.b2i.reupload(err):
+		return RetryNewUploadURL
+	case r.b2i.transient(err):
+		return RetrySame
+	}
+	return RetryNone
+}
+
+func (r *beRoot) clock() clock {
+	if r.clk == nil {
+		return realClock{}
+	}
+	return r.clk
+}
+
+// retry reports whether a request that failed with err should be attempted
+// again, and how long to wait first.  The last wait, if any, is given.
+func (r *beRoot) retry(attempt int, last time.Duration, err error) (time.Duration, bool) {
+	if r.policy == nil {
+		if !r.transient(err) {
+			return 0, false
+		}
+		if bo := r.backoff(err); bo > 0 {
+			return bo, true
+		}
+		return getBackoff(last), true
+	}
+	if r.reupload(err) {
+		// The caller needs a new upload URL; see retryUpload.
+		return 0, false
+	}
+	if kind, ok := retryKind(err); ok && kind != RetrySame {
+		// The error has said it can't simply be sent again.
+		return 0, false
+	}
+	d, ok := r.policy.Retry(attempt, r.b2i.statusCode(err))
+	if !ok {
+		return 0, false
+	}
+	if bo := r.backoff(err); bo > 0 {
+		d = bo
+	}
+	return d, true
+}
+
+// b2Error returns err as an *Error if it was reported by B2.
+func (r *beRoot) b2Error(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return r.b2i.b2Error(err)
+}
+
+// retryUpload is like retry, for uploads that fail in a way that requires a new
+// upload URL, including parts that exceed a Writer's PartTimeout, and for those
+// that got no reply.
+func (r *beRoot) retryUpload(attempt int, last time.Duration, err error) (time.Duration, bool) {
+	timedOut := errors.Is(err, errPartTimeout)
+	status := r.b2i.statusCode(err)
+	// A request that got no reply, and that the client's policy didn't retry,
+	// is sent again on a new upload URL.
+	restart := r.reupload(err) || (r.policy != nil && status == 0 && r.transient(err))
+	if !restart && !timedOut {
+		return 0, false
+	}
+	if r.policy == nil {
+		if last == 0 {
+			return 15 * time.Millisecond, true
+		}
+		if last*2 > 15*time.Second {
+			return 15 * time.Second, true
+		}
+		return last * 2, true
+	}
+	if eb, ok := r.policy.(*ExponentialBackoff); ok {
+		// B2 expects these to be restarted, whatever their status, and a part
+		// that timed out is retried up to the Writer's UploadAttempts.
+		return eb.wait(attempt)
+	}
+	if timedOut {
+		status = 408 // as B2 replies when it gives up on a request itself
+	}
+	return r.policy.Retry(attempt, status)
+}
+
+// allowance holds the capabilities and restrictions that B2 reports for the key
+// a client authorized with.
+type allowance struct {
+	caps       []string
+	bucketID   string
+	bucketName string
+	prefix     string
+}
+
+// allow returns an error wrapping ErrCapabilityMissing if the client's key
+// cannot use the given capability on the named bucket.  An empty capability or
+// bucket is not checked.  Nothing is checked if B2 reported no capabilities.
+func (r *beRoot) allow(capability, bucket string) error {
+	a := r.b2i.allowed()
+	if bucket != "" && a.bucketName != "" && bucket != a.bucketName {
+		return fmt.Errorf("%w: key is restricted to bucket %s", ErrCapabilityMissing, a.bucketName)
+	}
+	if capability == "" || len(a.caps) == 0 {
+		return nil
+	}
+	for _, c := range a.caps {
+		if c == capability {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrCapabilityMissing, capability)
+}
 
 func (r *beRoot) authorizeAccount(ctx context.Context, account, key string, c clientOptions) error {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	return r.authorize(ctx, account, key, c)
+}
+
+// authorize must be called with authMu held.
+func (r *beRoot) authorize(ctx context.Context, account, key string, c clientOptions) error {
 	f := func() error {
 		if err := r.b2i.authorizeAccount(ctx, account, key, c); err != nil {
 			return err
@@ -175,20 +356,43 @@ func (r *beRoot) authorizeAccount(ctx context.Context, account, key string, c cl
 		r.account = account
 		r.key = key
 		r.options = c
+		r.authGen++
 		return nil
 	}
 	return withBackoff(ctx, r, f)
 }
 
-func (r *beRoot) reauthorizeAccount(ctx context.Context) error {
-	return r.authorizeAccount(ctx, r.account, r.key, r.options)
+func (r *beRoot) authGeneration() int {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	return r.authGen
+}
+
+// reauthorizeAccount fetches a new authorization token, unless the account has
+// been authorized again since generation gen.  Requests that fail concurrently
+// with an expired token therefore wait on a single b2_authorize_account call,
+// instead of each making their own.
+func (r *beRoot) reauthorizeAccount(ctx context.Context, gen int) error {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	if r.authGen != gen {
+		return nil
+	}
+	if err := r.authorize(ctx, r.account, r.key, r.options); err != nil {
+		return err
+	}
+	r.m.reauth()
+	return nil
 }
 
-func (r *beRoot) createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule) (beBucketInterface, error) {
+func (r *beRoot) createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *ServerSideEncryption, lock bool, repl *Replication) (beBucketInterface, error) {
+	if err := r.allow("writeBuckets", name); err != nil {
+		return nil, err
+	}
 	var bi beBucketInterface
 	f := func() error {
 		g := func() error {
-			bucket, err := r.b2i.createBucket(ctx, name, btype, info, rules)
+			bucket, err := r.b2i.createBucket(ctx, name, btype, info, rules, cors, sse, lock, repl)
 			if err != nil {
 				return err
 			}
@@ -206,11 +410,16 @@ func (r *beRoot) createBucket(ctx context.Context, name, btype string, info map[
 	return bi, nil
 }
 
-func (r *beRoot) listBuckets(ctx context.Context) ([]beBucketInterface, error) {
+// listBuckets lists the buckets in the account, or only those with the given
+// ID and name, and of the given types, for each filter that is not empty.
+func (r *beRoot) listBuckets(ctx context.Context, id, name string, types []string) ([]beBucketInterface, error) {
+	if err := r.allow("listBuckets", ""); err != nil {
+		return nil, err
+	}
 	var buckets []beBucketInterface
 	f := func() error {
 		g := func() error {
-			bs, err := r.b2i.listBuckets(ctx)
+			bs, err := r.b2i.listBuckets(ctx, id, name, types)
 			if err != nil {
 				return err
 			}
@@ -231,6 +440,9 @@ func (r *beRoot) listBuckets(ctx context.Context) ([]beBucketInterface, error) {
 }
 
 func (r *beRoot) createKey(ctx context.Context, name string, caps []string, valid time.Duration, bucketID string, prefix string) (beKeyInterface, error) {
+	if err := r.allow("writeKeys", ""); err != nil {
+		return nil, err
+	}
 	var k *beKey
 	f := func() error {
 		g := func() error {
@@ -253,6 +465,9 @@ func (r *beRoot) createKey(ctx context.Context, name string, caps []string, vali
 }
 
 func (r *beRoot) listKeys(ctx context.Context, max int, next string) ([]beKeyInterface, string, error) {
+	if err := r.allow("listKeys", ""); err != nil {
+		return nil, "", err
+	}
 	var keys []beKeyInterface
 	var cur string
 	f := func() error {
@@ -282,8 +497,12 @@ func (b *beBucket) name() string        { return b.b2bucket.name() }
 func (b *beBucket) btype() BucketType   { return BucketType(b.b2bucket.btype()) }
 func (b *beBucket) attrs() *BucketAttrs { return b.b2bucket.attrs() }
 func (b *beBucket) id() string          { return b.b2bucket.id() }
+func (b *beBucket) revision() int       { return b.b2bucket.revision() }
 
 func (b *beBucket) updateBucket(ctx context.Context, attrs *BucketAttrs) error {
+	if err := b.ri.allow("writeBuckets", b.name()); err != nil {
+		return err
+	}
 	f := func() error {
 		g := func() error {
 			return b.b2bucket.updateBucket(ctx, attrs)
@@ -294,6 +513,9 @@ func (b *beBucket) updateBucket(ctx context.Context, attrs *BucketAttrs) error {
 }
 
 func (b *beBucket) deleteBucket(ctx context.Context) error {
+	if err := b.ri.allow("deleteBuckets", b.name()); err != nil {
+		return err
+	}
 	f := func() error {
 		g := func() error {
 			return b.b2bucket.deleteBucket(ctx)
@@ -304,6 +526,9 @@ func (b *beBucket) deleteBucket(ctx context.Context) error {
 }
 
 func (b *beBucket) getUploadURL(ctx context.Context) (beURLInterface, error) {
+	if err := b.ri.allow("writeFiles", b.name()); err != nil {
+		return nil, err
+	}
 	var url beURLInterface
 	f := func() error {
 		g := func() error {
@@ -325,11 +550,14 @@ func (b *beBucket) getUploadURL(ctx context.Context) (beURLInterface, error) {
 	return url, nil
 }
 
-func (b *beBucket) startLargeFile(ctx context.Context, name, ct string, info map[string]string) (beLargeFileInterface, error) {
+func (b *beBucket) startLargeFile(ctx context.Context, name, ct string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (beLargeFileInterface, error) {
+	if err := b.ri.allow("writeFiles", b.name()); err != nil {
+		return nil, err
+	}
 	var file beLargeFileInterface
 	f := func() error {
 		g := func() error {
-			f, err := b.b2bucket.startLargeFile(ctx, name, ct, info)
+			f, err := b.b2bucket.startLargeFile(ctx, name, ct, info, sse, retention, legalHold)
 			if err != nil {
 				return err
 			}
@@ -348,6 +576,9 @@ func (b *beBucket) startLargeFile(ctx context.Context, name, ct string, info map
 }
 
 func (b *beBucket) listFileNames(ctx context.Context, count int, continuation, prefix, delimiter string) ([]beFileInterface, string, error) {
+	if err := b.ri.allow("listFiles", b.name()); err != nil {
+		return nil, "", err
+	}
 	var cont string
 	var files []beFileInterface
 	f := func() error {
@@ -374,6 +605,9 @@ func (b *beBucket) listFileNames(ctx context.Context, count int, continuation, p
 }
 
 func (b *beBucket) listFileVersions(ctx context.Context, count int, nextName, nextID, prefix, delimiter string) ([]beFileInterface, string, string, error) {
+	if err := b.ri.allow("listFiles", b.name()); err != nil {
+		return nil, "", "", err
+	}
 	var name, id string
 	var files []beFileInterface
 	f := func() error {
@@ -401,6 +635,9 @@ func (b *beBucket) listFileVersions(ctx context.Context, count int, nextName, ne
 }
 
 func (b *beBucket) listUnfinishedLargeFiles(ctx context.Context, count int, continuation string) ([]beFileInterface, string, error) {
+	if err := b.ri.allow("listFiles", b.name()); err != nil {
+		return nil, "", err
+	}
 	var cont string
 	var files []beFileInterface
 	f := func() error {
@@ -426,11 +663,26 @@ func (b *beBucket) listUnfinishedLargeFiles(ctx context.Context, count int, cont
 	return files, cont, nil
 }
 
-func (b *beBucket) downloadFileByName(ctx context.Context, name string, offset, size int64) (beFileReaderInterface, error) {
+func (b *beBucket) downloadFileByName(ctx context.Context, name string, offset, size int64, sse *ServerSideEncryption) (beFileReaderInterface, error) {
+	return b.download(ctx, func() (b2FileReaderInterface, error) {
+		return b.b2bucket.downloadFileByName(ctx, name, offset, size, sse)
+	})
+}
+
+func (b *beBucket) downloadFileByID(ctx context.Context, id string, offset, size int64, sse *ServerSideEncryption) (beFileReaderInterface, error) {
+	return b.download(ctx, func() (b2FileReaderInterface, error) {
+		return b.b2bucket.downloadFileByID(ctx, id, offset, size, sse)
+	})
+}
+
+func (b *beBucket) download(ctx context.Context, dl func() (b2FileReaderInterface, error)) (beFileReaderInterface, error) {
+	if err := b.ri.allow("readFiles", b.name()); err != nil {
+		return nil, err
+	}
 	var reader beFileReaderInterface
 	f := func() error {
 		g := func() error {
-			fr, err := b.b2bucket.downloadFileByName(ctx, name, offset, size)
+			fr, err := dl()
 			if err != nil {
 				return err
 			}
@@ -449,6 +701,9 @@ func (b *beBucket) downloadFileByName(ctx context.Context, name string, offset,
 }
 
 func (b *beBucket) hideFile(ctx context.Context, name string) (beFileInterface, error) {
+	if err := b.ri.allow("writeFiles", b.name()); err != nil {
+		return nil, err
+	}
 	var file beFileInterface
 	f := func() error {
 		g := func() error {
@@ -470,7 +725,37 @@ func (b *beBucket) hideFile(ctx context.Context, name string) (beFileInterface,
 	return file, nil
 }
 
+func (b *beBucket) copyFile(ctx context.Context, srcID, name string, replace bool, contentType string, info map[string]string, retention *Retention, srcSSE, dstSSE *ServerSideEncryption) (beFileInterface, error) {
+	for _, c := range []string{"readFiles", "writeFiles"} {
+		if err := b.ri.allow(c, b.name()); err != nil {
+			return nil, err
+		}
+	}
+	var file beFileInterface
+	f := func() error {
+		g := func() error {
+			f, err := b.b2bucket.copyFile(ctx, srcID, name, replace, contentType, info, retention, srcSSE, dstSSE)
+			if err != nil {
+				return err
+			}
+			file = &beFile{
+				b2file: f,
+				ri:     b.ri,
+			}
+			return nil
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	if err := withBackoff(ctx, b.ri, f); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 func (b *beBucket) getDownloadAuthorization(ctx context.Context, p string, v time.Duration, s string) (string, error) {
+	if err := b.ri.allow("shareFiles", b.name()); err != nil {
+		return "", err
+	}
 	var tok string
 	f := func() error {
 		g := func() error {
@@ -489,6 +774,50 @@ func (b *beBucket) getDownloadAuthorization(ctx context.Context, p string, v tim
 	return tok, nil
 }
 
+func (b *beBucket) getNotificationRules(ctx context.Context) ([]NotificationRule, error) {
+	if err := b.ri.allow("readBucketNotifications", b.name()); err != nil {
+		return nil, err
+	}
+	var rules []NotificationRule
+	f := func() error {
+		g := func() error {
+			r, err := b.b2bucket.getNotificationRules(ctx)
+			if err != nil {
+				return err
+			}
+			rules = r
+			return nil
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	if err := withBackoff(ctx, b.ri, f); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (b *beBucket) setNotificationRules(ctx context.Context, rules []NotificationRule) ([]NotificationRule, error) {
+	if err := b.ri.allow("writeBucketNotifications", b.name()); err != nil {
+		return nil, err
+	}
+	var saved []NotificationRule
+	f := func() error {
+		g := func() error {
+			r, err := b.b2bucket.setNotificationRules(ctx, rules)
+			if err != nil {
+				return err
+			}
+			saved = r
+			return nil
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	if err := withBackoff(ctx, b.ri, f); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
 func (b *beBucket) baseURL() string {
 	return b.b2bucket.baseURL()
 }
@@ -500,13 +829,13 @@ func (b *beBucket) file(id, name string) beFileInterface {
 	}
 }
 
-func (b *beURL) uploadFile(ctx context.Context, r readResetter, size int, name, ct, sha1 string, info map[string]string) (beFileInterface, error) {
+func (b *beURL) uploadFile(ctx context.Context, r readResetter, size int, name, ct, sha1 string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (beFileInterface, error) {
 	var file beFileInterface
 	f := func() error {
 		if err := r.Reset(); err != nil {
 			return err
 		}
-		f, err := b.b2url.uploadFile(ctx, r, size, name, ct, sha1, info)
+		f, err := b.b2url.uploadFile(ctx, r, size, name, ct, sha1, info, sse, retention, legalHold)
 		if err != nil {
 			return err
 		}
@@ -524,6 +853,9 @@ func (b *beURL) uploadFile(ctx context.Context, r readResetter, size int, name,
 }
 
 func (b *beFile) deleteFileVersion(ctx context.Context) error {
+	if err := b.ri.allow("deleteFiles", ""); err != nil {
+		return err
+	}
 	f := func() error {
 		g := func() error {
 			return b.b2file.deleteFileVersion(ctx)
@@ -533,10 +865,49 @@ func (b *beFile) deleteFileVersion(ctx context.Context) error {
 	return withBackoff(ctx, b.ri, f)
 }
 
+func (b *beFile) updateFileRetention(ctx context.Context, r *Retention, bypass bool) error {
+	if err := b.ri.allow("writeFileRetentions", ""); err != nil {
+		return err
+	}
+	if bypass {
+		if err := b.ri.allow("bypassGovernance", ""); err != nil {
+			return err
+		}
+	}
+	f := func() error {
+		g := func() error {
+			return b.b2file.updateFileRetention(ctx, r, bypass)
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	return withBackoff(ctx, b.ri, f)
+}
+
+func (b *beFile) updateFileLegalHold(ctx context.Context, on bool) error {
+	if err := b.ri.allow("writeFileLegalHolds", ""); err != nil {
+		return err
+	}
+	f := func() error {
+		g := func() error {
+			return b.b2file.updateFileLegalHold(ctx, on)
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	return withBackoff(ctx, b.ri, f)
+}
+
 func (b *beFile) size() int64 {
 	return b.b2file.size()
 }
 
+func (b *beFile) id() string {
+	return b.b2file.id()
+}
+
+func (b *beFile) sha1() string {
+	return b.b2file.sha1()
+}
+
 func (b *beFile) name() string {
 	return b.b2file.name()
 }
@@ -550,6 +921,9 @@ func (b *beFile) status() string {
 }
 
 func (b *beFile) getFileInfo(ctx context.Context) (beFileInfoInterface, error) {
+	if err := b.ri.allow("readFiles", ""); err != nil {
+		return nil, err
+	}
 	var fileInfo beFileInfoInterface
 	f := func() error {
 		g := func() error {
@@ -566,6 +940,9 @@ func (b *beFile) getFileInfo(ctx context.Context) (beFileInfoInterface, error) {
 				info:   info,
 				status: status,
 				stamp:  stamp,
+				sse:    fi.encryption(),
+				ret:    fi.retention(),
+				hold:   fi.legalHold(),
 			}
 			return nil
 		}
@@ -578,6 +955,9 @@ func (b *beFile) getFileInfo(ctx context.Context) (beFileInfoInterface, error) {
 }
 
 func (b *beFile) listParts(ctx context.Context, next, count int) ([]beFilePartInterface, int, error) {
+	if err := b.ri.allow("writeFiles", ""); err != nil {
+		return nil, 0, err
+	}
 	var fpi []beFilePartInterface
 	var rnxt int
 	f := func() error {
@@ -610,6 +990,9 @@ func (b *beFile) compileParts(size int64, seen map[int]string) beLargeFileInterf
 	}
 }
 
+func (b *beLargeFile) id() string             { return b.b2largeFile.id() }
+func (b *beLargeFile) hashes() map[int]string { return b.b2largeFile.hashes() }
+
 func (b *beLargeFile) getUploadPartURL(ctx context.Context) (beFileChunkInterface, error) {
 	var chunk beFileChunkInterface
 	f := func() error {
@@ -654,6 +1037,26 @@ func (b *beLargeFile) finishLargeFile(ctx context.Context) (beFileInterface, err
 	return file, nil
 }
 
+func (b *beLargeFile) cancelLargeFile(ctx context.Context) error {
+	f := func() error {
+		g := func() error {
+			return b.b2largeFile.cancelLargeFile(ctx)
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	return withBackoff(ctx, b.ri, f)
+}
+
+func (b *beLargeFile) copyPart(ctx context.Context, srcID string, part int, offset, size int64, srcSSE, dstSSE *ServerSideEncryption) error {
+	f := func() error {
+		g := func() error {
+			return b.b2largeFile.copyPart(ctx, srcID, part, offset, size, srcSSE, dstSSE)
+		}
+		return withReauth(ctx, b.ri, g)
+	}
+	return withBackoff(ctx, b.ri, f)
+}
+
 func (b *beFileChunk) reload(ctx context.Context) error {
 	f := func() error {
 		g := func() error {
@@ -664,7 +1067,7 @@ func (b *beFileChunk) reload(ctx context.Context) error {
 	return withBackoff(ctx, b.ri, f)
 }
 
-func (b *beFileChunk) uploadPart(ctx context.Context, r readResetter, sha1 string, size, index int) (int, error) {
+func (b *beFileChunk) uploadPart(ctx context.Context, r readResetter, sha1 string, size, index int, sse *ServerSideEncryption) (int, error) {
 	// no re-auth; pass it back up to the caller so they can get an new upload URI and token
 	// TODO: we should handle that here probably
 	var i int
@@ -672,7 +1075,7 @@ func (b *beFileChunk) uploadPart(ctx context.Context, r readResetter, sha1 strin
 		if err := r.Reset(); err != nil {
 			return err
 		}
-		j, err := b.b2fileChunk.uploadPart(ctx, r, sha1, size, index)
+		j, err := b.b2fileChunk.uploadPart(ctx, r, sha1, size, index, sse)
 		if err != nil {
 			return err
 		}
@@ -697,22 +1100,38 @@ func (b *beFileReader) stats() (int, string, string, map[string]string) {
 	return b.b2fileReader.stats()
 }
 
-func (b *beFileReader) id() string { return b.b2fileReader.id() }
+func (b *beFileReader) id() string                        { return b.b2fileReader.id() }
+func (b *beFileReader) name() string                      { return b.b2fileReader.name() }
+func (b *beFileReader) size() int64                       { return b.b2fileReader.size() }
+func (b *beFileReader) timestamp() time.Time              { return b.b2fileReader.timestamp() }
+func (b *beFileReader) encryption() *ServerSideEncryption { return b.b2fileReader.encryption() }
 
 func (b *beFileInfo) stats() (string, string, int64, string, map[string]string, string, time.Time) {
 	return b.name, b.sha, b.size, b.ct, b.info, b.status, b.stamp
 }
 
+func (b *beFileInfo) encryption() *ServerSideEncryption { return b.sse }
+func (b *beFileInfo) retention() *Retention             { return b.ret }
+func (b *beFileInfo) legalHold() bool                   { return b.hold }
+
 func (b *beFilePart) number() int  { return b.b2filePart.number() }
 func (b *beFilePart) sha1() string { return b.b2filePart.sha1() }
 func (b *beFilePart) size() int64  { return b.b2filePart.size() }
 
-func (b *beKey) del(ctx context.Context) error { return b.k.del(ctx) }
-func (b *beKey) caps() []string                { return b.k.caps() }
-func (b *beKey) name() string                  { return b.k.name() }
-func (b *beKey) expires() time.Time            { return b.k.expires() }
-func (b *beKey) secret() string                { return b.k.secret() }
-func (b *beKey) id() string                    { return b.k.id() }
+func (b *beKey) del(ctx context.Context) error {
+	if err := b.b2i.allow("deleteKeys", ""); err != nil {
+		return err
+	}
+	return b.k.del(ctx)
+}
+
+func (b *beKey) caps() []string     { return b.k.caps() }
+func (b *beKey) name() string       { return b.k.name() }
+func (b *beKey) expires() time.Time { return b.k.expires() }
+func (b *beKey) secret() string     { return b.k.secret() }
+func (b *beKey) id() string         { return b.k.id() }
+func (b *beKey) bucketID() string   { return b.k.bucketID() }
+func (b *beKey) prefix() string     { return b.k.prefix() }
 
 func jitter(d time.Duration) time.Duration {
 	f := float64(d)
@@ -728,33 +1147,58 @@ func getBackoff(d time.Duration) time.Duration {
 	return d*2 + jitter(d*2)
 }
 
-var after = time.After
+// clock is the source of time for retries and backoff.  Tests substitute a
+// fake one, so that they needn't wait in real time.
+type clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// sleep waits on c for d, or until ctx is done.
+func sleep(ctx context.Context, c clock, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.After(d):
+		return nil
+	}
+}
 
 func withBackoff(ctx context.Context, ri beRootInterface, f func() error) error {
 	backoff := 500 * time.Millisecond
-	for {
+	for attempt := 1; ; attempt++ {
 		err := f()
-		if !ri.transient(err) {
-			return err
+		if err == nil {
+			return nil
 		}
-		bo := ri.backoff(err)
-		if bo > 0 {
-			backoff = bo
-		} else {
-			backoff = getBackoff(backoff)
+		d, ok := ri.retry(attempt, backoff, err)
+		if !ok {
+			return ri.b2Error(err)
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-after(backoff):
+		backoff = d
+		// Don't wait past the caller's deadline, which is in real time.
+		if dl, ok := ctx.Deadline(); ok {
+			if left := time.Until(dl); left < d {
+				d = left
+			}
+		}
+		if err := sleep(ctx, ri.clock(), d); err != nil {
+			return err
 		}
+		ri.metrics().retry()
 	}
 }
 
 func withReauth(ctx context.Context, ri beRootInterface, f func() error) error {
+	gen := ri.authGeneration()
 	err := f()
 	if ri.reauth(err) {
-		if err := ri.reauthorizeAccount(ctx); err != nil {
+		if err := ri.reauthorizeAccount(ctx, gen); err != nil {
 			return err
 		}
 		err = f()
@@ -0,0 +1,167 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// A RetryKind says how a request that failed can be recovered.
+type RetryKind int
+
+const (
+	// RetryNone means the request cannot be recovered, and its error is
+	// returned.
+	RetryNone RetryKind = iota
+
+	// RetrySame means the same request can be sent again, after a wait.
+	RetrySame
+
+	// RetryNewUploadURL means an upload must be sent again on a new upload
+	// URL.
+	RetryNewUploadURL
+
+	// RetryReauth means the client must reauthorize before sending the
+	// request again.
+	RetryReauth
+)
+
+// RetryableError is implemented by errors that say for themselves whether, and
+// how, the request that returned them is retried.  Retryable returns false if
+// the request cannot be recovered; otherwise it returns the recovery to make.
+// The client looks for a RetryableError with errors.As, so an error wrapping
+// one is classified by it.  Errors that don't implement it are classified by
+// the reply B2 gave.
+//
+// RetryableError lets code that wraps the client's HTTP transport, or tests,
+// decide how the errors they inject are handled.
+type RetryableError interface {
+	error
+	Retryable() (bool, RetryKind)
+}
+
+// retryKind returns the recovery that err asks for, and false if err is not a
+// RetryableError.
+func retryKind(err error) (RetryKind, bool) {
+	var re RetryableError
+	if !errors.As(err, &re) {
+		return RetryNone, false
+	}
+	ok, kind := re.Retryable()
+	if !ok {
+		return RetryNone, true
+	}
+	return kind, true
+}
+
+// A Backoff decides whether, and after how long, a failed request is retried.
+type Backoff interface {
+	// Retry is called when a request fails.  The attempt counts the requests
+	// made so far, starting at one, and status is the HTTP status code B2
+	// returned, or 0 if the request failed without a reply.  Retry returns
+	// how long to wait before the next attempt, and false if there should be
+	// no next attempt.
+	Retry(attempt, status int) (time.Duration, bool)
+}
+
+// WithBackoff sets the policy used to retry failed requests, including
+// uploads that must be restarted with a new upload URL.  If B2 replies with a
+// Retry-After header, the client waits as long as it asks instead of as long
+// as the policy does.
+//
+// By default, requests that fail with 429, 500, or 503 are retried until their
+// context is done, and uploads are restarted whenever B2 requires it.  An
+// ExponentialBackoff also restarts every upload B2 requires be restarted on a
+// new upload URL, every upload that fails without a reply, and every part that
+// exceeds a Writer's PartTimeout, whatever its Retryable; it only sets how long
+// to wait, and MaxAttempts still applies.
+// Other policies are asked with the status B2 returned.
+func WithBackoff(b Backoff) ClientOption {
+	return func(c *clientOptions) {
+		c.backoff = b
+	}
+}
+
+// ExponentialBackoff is a Backoff that doubles its delay after every attempt.
+type ExponentialBackoff struct {
+	// MaxAttempts is the number of times a request is attempted before giving
+	// up.  If zero, requests are retried until their context is done.
+	MaxAttempts int
+
+	// Base is the delay before the first retry.  If zero, it is one second.
+	Base time.Duration
+
+	// Max caps the delay between attempts.  If zero, it is 30 seconds.
+	Max time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it, in either
+	// direction.  It should be between 0 and 1.
+	Jitter float64
+
+	// Retryable lists the HTTP status codes that are retried.  If nil, 429,
+	// 500, and 503 are retried.  It does not limit which uploads are
+	// restarted on a new upload URL; see WithBackoff.
+	Retryable []int
+}
+
+var defaultRetryable = []int{429, 500, 503}
+
+// Retry implements Backoff.
+func (e *ExponentialBackoff) Retry(attempt, status int) (time.Duration, bool) {
+	if !e.retryable(status) {
+		return 0, false
+	}
+	return e.wait(attempt)
+}
+
+func (e *ExponentialBackoff) retryable(status int) bool {
+	codes := e.Retryable
+	if codes == nil {
+		codes = defaultRetryable
+	}
+	for _, c := range codes {
+		if c == status {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns how long to wait before the given attempt is retried, whatever
+// its status, and false if MaxAttempts have been made.
+func (e *ExponentialBackoff) wait(attempt int) (time.Duration, bool) {
+	if e.MaxAttempts > 0 && attempt >= e.MaxAttempts {
+		return 0, false
+	}
+	d, max := e.Base, e.Max
+	if d <= 0 {
+		d = time.Second
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if e.Jitter > 0 {
+		d += time.Duration(float64(d) * e.Jitter * (2*rand.Float64() - 1))
+	}
+	return d, true
+}
@@ -16,8 +16,10 @@ package b2
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/kurin/blazer/base"
@@ -32,8 +34,14 @@ type b2RootInterface interface {
 	backoff(error) time.Duration
 	reauth(error) bool
 	reupload(error) bool
-	createBucket(context.Context, string, string, map[string]string, []LifecycleRule) (b2BucketInterface, error)
-	listBuckets(context.Context) ([]b2BucketInterface, error)
+	statusCode(error) int
+	b2Error(error) error
+	minPartSize() int
+	recommendedPartSize() int
+	s3Endpoint() string
+	allowed() allowance
+	createBucket(context.Context, string, string, map[string]string, []LifecycleRule, []CORSRule, *ServerSideEncryption, bool, *Replication) (b2BucketInterface, error)
+	listBuckets(context.Context, string, string, []string) ([]b2BucketInterface, error)
 	createKey(context.Context, string, []string, time.Duration, string, string) (b2KeyInterface, error)
 	listKeys(context.Context, int, string) ([]b2KeyInterface, string, error)
 }
@@ -43,54 +51,74 @@ type b2BucketInterface interface {
 	btype() string
 	attrs() *BucketAttrs
 	id() string
+	revision() int
 	updateBucket(context.Context, *BucketAttrs) error
 	deleteBucket(context.Context) error
 	getUploadURL(context.Context) (b2URLInterface, error)
-	startLargeFile(ctx context.Context, name, contentType string, info map[string]string) (b2LargeFileInterface, error)
+	startLargeFile(ctx context.Context, name, contentType string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (b2LargeFileInterface, error)
 	listFileNames(context.Context, int, string, string, string) ([]b2FileInterface, string, error)
 	listFileVersions(context.Context, int, string, string, string, string) ([]b2FileInterface, string, string, error)
 	listUnfinishedLargeFiles(context.Context, int, string) ([]b2FileInterface, string, error)
-	downloadFileByName(context.Context, string, int64, int64) (b2FileReaderInterface, error)
+	downloadFileByName(context.Context, string, int64, int64, *ServerSideEncryption) (b2FileReaderInterface, error)
+	downloadFileByID(context.Context, string, int64, int64, *ServerSideEncryption) (b2FileReaderInterface, error)
 	hideFile(context.Context, string) (b2FileInterface, error)
+	copyFile(ctx context.Context, srcID, name string, replace bool, contentType string, info map[string]string, retention *Retention, srcSSE, dstSSE *ServerSideEncryption) (b2FileInterface, error)
 	getDownloadAuthorization(context.Context, string, time.Duration, string) (string, error)
+	getNotificationRules(context.Context) ([]NotificationRule, error)
+	setNotificationRules(context.Context, []NotificationRule) ([]NotificationRule, error)
 	baseURL() string
 	file(string, string) b2FileInterface
 }
 
 type b2URLInterface interface {
 	reload(context.Context) error
-	uploadFile(context.Context, io.Reader, int, string, string, string, map[string]string) (b2FileInterface, error)
+	uploadFile(context.Context, io.Reader, int, string, string, string, map[string]string, *ServerSideEncryption, *Retention, bool) (b2FileInterface, error)
 }
 
 type b2FileInterface interface {
+	id() string
 	name() string
 	size() int64
+	sha1() string
 	timestamp() time.Time
 	status() string
 	deleteFileVersion(context.Context) error
+	updateFileRetention(context.Context, *Retention, bool) error
+	updateFileLegalHold(context.Context, bool) error
 	getFileInfo(context.Context) (b2FileInfoInterface, error)
 	listParts(context.Context, int, int) ([]b2FilePartInterface, int, error)
 	compileParts(int64, map[int]string) b2LargeFileInterface
 }
 
 type b2LargeFileInterface interface {
+	id() string
+	hashes() map[int]string
 	finishLargeFile(context.Context) (b2FileInterface, error)
+	cancelLargeFile(context.Context) error
 	getUploadPartURL(context.Context) (b2FileChunkInterface, error)
+	copyPart(ctx context.Context, srcID string, part int, offset, size int64, srcSSE, dstSSE *ServerSideEncryption) error
 }
 
 type b2FileChunkInterface interface {
 	reload(context.Context) error
-	uploadPart(context.Context, io.Reader, string, int, int) (int, error)
+	uploadPart(context.Context, io.Reader, string, int, int, *ServerSideEncryption) (int, error)
 }
 
 type b2FileReaderInterface interface {
 	io.ReadCloser
 	stats() (int, string, string, map[string]string)
 	id() string
+	name() string
+	size() int64
+	timestamp() time.Time
+	encryption() *ServerSideEncryption
 }
 
 type b2FileInfoInterface interface {
 	stats() (string, string, int64, string, map[string]string, string, time.Time) // bleck
+	encryption() *ServerSideEncryption
+	retention() *Retention
+	legalHold() bool
 }
 
 type b2FilePartInterface interface {
@@ -106,6 +134,8 @@ type b2KeyInterface interface {
 	expires() time.Time
 	secret() string
 	id() string
+	bucketID() string
+	prefix() string
 }
 
 type b2Root struct {
@@ -150,7 +180,7 @@ type b2Key struct {
 
 func (b *b2Root) authorizeAccount(ctx context.Context, account, key string, c clientOptions) error {
 	var aopts []base.AuthOption
-	ct := &clientTransport{client: c.client}
+	ct := &clientTransport{client: c.client, reqHooks: c.reqHooks, respHooks: c.respHooks, limiter: c.limiter}
 	if c.transport != nil {
 		ct.rt = c.transport
 	}
@@ -167,6 +197,12 @@ func (b *b2Root) authorizeAccount(ctx context.Context, account, key string, c cl
 	if c.apiBase != "" {
 		aopts = append(aopts, base.SetAPIBase(c.apiBase))
 	}
+	if c.apiURL != "" {
+		aopts = append(aopts, base.SetAPIURL(c.apiURL))
+	}
+	if c.logger != nil {
+		aopts = append(aopts, base.Logger(c.logger))
+	}
 	for _, agent := range c.userAgents {
 		aopts = append(aopts, base.UserAgent(agent))
 	}
@@ -201,7 +237,42 @@ func (*b2Root) transient(err error) bool {
 	return base.Action(err) == base.Retry
 }
 
-func (b *b2Root) createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule) (b2BucketInterface, error) {
+func (*b2Root) statusCode(err error) int {
+	code, _ := base.Code(err)
+	return code
+}
+
+func (*b2Root) b2Error(err error) error {
+	code, msg := base.Code(err)
+	if code == 0 {
+		return err
+	}
+	return &Error{Status: code, Code: base.ErrorCode(err), Message: msg, err: err}
+}
+
+func (b *b2Root) minPartSize() int {
+	return b.b.MinPartSize()
+}
+
+func (b *b2Root) recommendedPartSize() int {
+	return b.b.RecommendedPartSize()
+}
+
+func (b *b2Root) s3Endpoint() string {
+	return b.b.S3URL()
+}
+
+func (b *b2Root) allowed() allowance {
+	a := b.b.Allowed()
+	return allowance{
+		caps:       a.Capabilities,
+		bucketID:   a.BucketID,
+		bucketName: a.BucketName,
+		prefix:     a.Prefix,
+	}
+}
+
+func (b *b2Root) createBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *ServerSideEncryption, lock bool, repl *Replication) (b2BucketInterface, error) {
 	var baseRules []base.LifecycleRule
 	for _, rule := range rules {
 		baseRules = append(baseRules, base.LifecycleRule{
@@ -210,15 +281,15 @@ func (b *b2Root) createBucket(ctx context.Context, name, btype string, info map[
 			Prefix:                 rule.Prefix,
 		})
 	}
-	bucket, err := b.b.CreateBucket(ctx, name, btype, info, baseRules)
+	bucket, err := b.b.CreateBucket(ctx, name, btype, info, baseRules, baseCORS(cors), sse.base(), lock, repl.base())
 	if err != nil {
 		return nil, err
 	}
 	return &b2Bucket{bucket}, nil
 }
 
-func (b *b2Root) listBuckets(ctx context.Context) ([]b2BucketInterface, error) {
-	buckets, err := b.b.ListBuckets(ctx)
+func (b *b2Root) listBuckets(ctx context.Context, id, name string, types []string) ([]b2BucketInterface, error) {
+	buckets, err := b.b.ListBucketsFiltered(ctx, id, name, types)
 	if err != nil {
 		return nil, err
 	}
@@ -250,6 +321,18 @@ func (b *b2Bucket) updateBucket(ctx context.Context, attrs *BucketAttrs) error {
 		}
 		b.b.LifecycleRules = rules
 	}
+	if attrs.CORSRules != nil {
+		b.b.CORSRules = baseCORS(attrs.CORSRules)
+	}
+	if attrs.DefaultServerSideEncryption != nil {
+		b.b.DefaultSSE = attrs.DefaultServerSideEncryption.base()
+	}
+	if r := attrs.DefaultRetention; r != nil {
+		b.b.DefaultRetention = &base.DefaultRetention{Mode: r.Mode, Duration: r.Period, Unit: r.Unit}
+	}
+	if attrs.Replication != nil {
+		b.b.Replication = attrs.Replication.base()
+	}
 	newBucket, err := b.b.Update(ctx)
 	if err == nil {
 		b.b = newBucket
@@ -305,15 +388,31 @@ func (b *b2Bucket) attrs() *BucketAttrs {
 			Prefix:                 rule.Prefix,
 		})
 	}
+	var cors []CORSRule
+	for _, rule := range b.b.CORSRules {
+		cors = append(cors, CORSRule(rule))
+	}
+	var defRet *DefaultRetention
+	if r := b.b.DefaultRetention; r != nil {
+		defRet = &DefaultRetention{Mode: r.Mode, Period: r.Duration, Unit: r.Unit}
+	}
 	return &BucketAttrs{
-		LifecycleRules: rules,
-		Info:           b.b.Info,
-		Type:           BucketType(b.b.Type),
+		LifecycleRules:              rules,
+		CORSRules:                   cors,
+		Info:                        b.b.Info,
+		Type:                        BucketType(b.b.Type),
+		DefaultServerSideEncryption: fromBaseEncryption(b.b.DefaultSSE),
+		ObjectLock:                  b.b.FileLock != nil && *b.b.FileLock,
+		DefaultRetention:            defRet,
+		Replication:                 fromBaseReplication(b.b.Replication),
+		lockUnknown:                 b.b.FileLock == nil,
 	}
 }
 
 func (b *b2Bucket) id() string { return b.b.ID }
 
+func (b *b2Bucket) revision() int { return b.b.Revision() }
+
 func (b *b2Bucket) getUploadURL(ctx context.Context) (b2URLInterface, error) {
 	url, err := b.b.GetUploadURL(ctx)
 	if err != nil {
@@ -322,8 +421,8 @@ func (b *b2Bucket) getUploadURL(ctx context.Context) (b2URLInterface, error) {
 	return &b2URL{url}, nil
 }
 
-func (b *b2Bucket) startLargeFile(ctx context.Context, name, ct string, info map[string]string) (b2LargeFileInterface, error) {
-	lf, err := b.b.StartLargeFile(ctx, name, ct, info)
+func (b *b2Bucket) startLargeFile(ctx context.Context, name, ct string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (b2LargeFileInterface, error) {
+	lf, err := b.b.StartLargeFile(ctx, name, ct, info, sse.base(), retention.base(), legalHold)
 	if err != nil {
 		return nil, err
 	}
@@ -366,8 +465,15 @@ func (b *b2Bucket) listUnfinishedLargeFiles(ctx context.Context, count int, cont
 	return files, cont, nil
 }
 
-func (b *b2Bucket) downloadFileByName(ctx context.Context, name string, offset, size int64) (b2FileReaderInterface, error) {
-	fr, err := b.b.DownloadFileByName(ctx, name, offset, size)
+func (b *b2Bucket) downloadFileByName(ctx context.Context, name string, offset, size int64, sse *ServerSideEncryption) (b2FileReaderInterface, error) {
+	return b2FileReaderOrErr(b.b.DownloadFileByName(ctx, name, offset, size, sse.base()))
+}
+
+func (b *b2Bucket) downloadFileByID(ctx context.Context, id string, offset, size int64, sse *ServerSideEncryption) (b2FileReaderInterface, error) {
+	return b2FileReaderOrErr(b.b.DownloadFileByID(ctx, id, offset, size, sse.base()))
+}
+
+func b2FileReaderOrErr(fr *base.FileReader, err error) (b2FileReaderInterface, error) {
 	if err != nil {
 		code, _ := base.Code(err)
 		switch code {
@@ -376,6 +482,9 @@ func (b *b2Bucket) downloadFileByName(ctx context.Context, name string, offset,
 		case http.StatusNotFound:
 			return nil, b2err{err: err, notFoundErr: true}
 		}
+		if isKeyErr(err) {
+			return nil, fmt.Errorf("%v: %w", err, ErrCustomerKey)
+		}
 		return nil, err
 	}
 	return &b2FileReader{fr}, nil
@@ -389,18 +498,75 @@ func (b *b2Bucket) hideFile(ctx context.Context, name string) (b2FileInterface,
 	return &b2File{f}, nil
 }
 
+func (b *b2Bucket) copyFile(ctx context.Context, srcID, name string, replace bool, contentType string, info map[string]string, retention *Retention, srcSSE, dstSSE *ServerSideEncryption) (b2FileInterface, error) {
+	f, err := b.b.CopyFile(ctx, srcID, name, replace, contentType, info, retention.base(), srcSSE.base(), dstSSE.base())
+	if isKeyErr(err) {
+		return nil, fmt.Errorf("%v: %w", err, ErrCustomerKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b2File{f}, nil
+}
+
 func (b *b2Bucket) getDownloadAuthorization(ctx context.Context, p string, v time.Duration, s string) (string, error) {
 	return b.b.GetDownloadAuthorization(ctx, p, v, s)
 }
 
+func (b *b2Bucket) getNotificationRules(ctx context.Context) ([]NotificationRule, error) {
+	rules, err := b.b.GetNotificationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fromBaseNotificationRules(rules), nil
+}
+
+func (b *b2Bucket) setNotificationRules(ctx context.Context, rules []NotificationRule) ([]NotificationRule, error) {
+	var br []base.NotificationRule
+	for _, r := range rules {
+		br = append(br, base.NotificationRule{
+			Name:          r.Name,
+			EventTypes:    r.EventTypes,
+			Prefix:        r.Prefix,
+			Enabled:       !r.Disabled,
+			URL:           r.URL,
+			SigningSecret: r.SigningSecret,
+			CustomHeaders: r.CustomHeaders,
+		})
+	}
+	saved, err := b.b.SetNotificationRules(ctx, br)
+	if err != nil {
+		return nil, err
+	}
+	return fromBaseNotificationRules(saved), nil
+}
+
+func fromBaseNotificationRules(rules []base.NotificationRule) []NotificationRule {
+	var rtn []NotificationRule
+	for _, r := range rules {
+		rtn = append(rtn, NotificationRule{
+			Name:             r.Name,
+			EventTypes:       r.EventTypes,
+			Prefix:           r.Prefix,
+			Disabled:         !r.Enabled,
+			URL:              r.URL,
+			SigningSecret:    r.SigningSecret,
+			CustomHeaders:    r.CustomHeaders,
+			Suspended:        r.Suspended,
+			SuspensionReason: r.SuspensionReason,
+		})
+	}
+	return rtn
+}
+
 func (b *b2Bucket) baseURL() string {
 	return b.b.BaseURL()
 }
 
 func (b *b2Bucket) file(id, name string) b2FileInterface { return &b2File{b.b.File(id, name)} }
 
-func (b *b2URL) uploadFile(ctx context.Context, r io.Reader, size int, name, contentType, sha1 string, info map[string]string) (b2FileInterface, error) {
-	file, err := b.b.UploadFile(ctx, r, size, name, contentType, sha1, info)
+func (b *b2URL) uploadFile(ctx context.Context, r io.Reader, size int, name, contentType, sha1 string, info map[string]string, sse *ServerSideEncryption, retention *Retention, legalHold bool) (b2FileInterface, error) {
+	file, err := b.b.UploadFile(ctx, r, size, name, contentType, sha1, info, sse.base(), retention.base(), legalHold)
 	if err != nil {
 		return nil, err
 	}
@@ -415,6 +581,18 @@ func (b *b2File) deleteFileVersion(ctx context.Context) error {
 	return b.b.DeleteFileVersion(ctx)
 }
 
+func (b *b2File) updateFileRetention(ctx context.Context, r *Retention, bypass bool) error {
+	return b.b.UpdateFileRetention(ctx, r.base(), bypass)
+}
+
+func (b *b2File) updateFileLegalHold(ctx context.Context, on bool) error {
+	return b.b.UpdateFileLegalHold(ctx, on)
+}
+
+func (b *b2File) id() string {
+	return b.b.ID()
+}
+
 func (b *b2File) name() string {
 	return b.b.Name
 }
@@ -423,6 +601,10 @@ func (b *b2File) size() int64 {
 	return b.b.Size
 }
 
+func (b *b2File) sha1() string {
+	return b.b.SHA1
+}
+
 func (b *b2File) timestamp() time.Time {
 	return b.b.Timestamp
 }
@@ -458,6 +640,9 @@ func (b *b2File) compileParts(size int64, seen map[int]string) b2LargeFileInterf
 	return &b2LargeFile{b.b.CompileParts(size, seen)}
 }
 
+func (b *b2LargeFile) id() string             { return b.b.ID() }
+func (b *b2LargeFile) hashes() map[int]string { return b.b.Hashes() }
+
 func (b *b2LargeFile) finishLargeFile(ctx context.Context) (b2FileInterface, error) {
 	f, err := b.b.FinishLargeFile(ctx)
 	if err != nil {
@@ -466,6 +651,18 @@ func (b *b2LargeFile) finishLargeFile(ctx context.Context) (b2FileInterface, err
 	return &b2File{f}, nil
 }
 
+func (b *b2LargeFile) cancelLargeFile(ctx context.Context) error {
+	return b.b.CancelLargeFile(ctx)
+}
+
+func (b *b2LargeFile) copyPart(ctx context.Context, srcID string, part int, offset, size int64, srcSSE, dstSSE *ServerSideEncryption) error {
+	err := b.b.CopyPart(ctx, srcID, part, offset, size, srcSSE.base(), dstSSE.base())
+	if isKeyErr(err) {
+		return fmt.Errorf("%v: %w", err, ErrCustomerKey)
+	}
+	return err
+}
+
 func (b *b2LargeFile) getUploadPartURL(ctx context.Context) (b2FileChunkInterface, error) {
 	c, err := b.b.GetUploadPartURL(ctx)
 	if err != nil {
@@ -478,8 +675,8 @@ func (b *b2FileChunk) reload(ctx context.Context) error {
 	return b.b.Reload(ctx)
 }
 
-func (b *b2FileChunk) uploadPart(ctx context.Context, r io.Reader, sha1 string, size, index int) (int, error) {
-	return b.b.UploadPart(ctx, r, sha1, size, index)
+func (b *b2FileChunk) uploadPart(ctx context.Context, r io.Reader, sha1 string, size, index int, sse *ServerSideEncryption) (int, error) {
+	return b.b.UploadPart(ctx, r, sha1, size, index, sse.base())
 }
 
 func (b *b2FileReader) Read(p []byte) (int, error) {
@@ -494,12 +691,128 @@ func (b *b2FileReader) stats() (int, string, string, map[string]string) {
 	return b.b.ContentLength, b.b.ContentType, b.b.SHA1, b.b.Info
 }
 
-func (b *b2FileReader) id() string { return b.b.ID }
+func (b *b2FileReader) id() string                        { return b.b.ID }
+func (b *b2FileReader) name() string                      { return b.b.Name }
+func (b *b2FileReader) size() int64                       { return b.b.Size }
+func (b *b2FileReader) timestamp() time.Time              { return b.b.Timestamp }
+func (b *b2FileReader) encryption() *ServerSideEncryption { return fromBaseEncryption(b.b.SSE) }
 
 func (b *b2FileInfo) stats() (string, string, int64, string, map[string]string, string, time.Time) {
 	return b.b.Name, b.b.SHA1, b.b.Size, b.b.ContentType, b.b.Info, b.b.Status, b.b.Timestamp
 }
 
+func (b *b2FileInfo) encryption() *ServerSideEncryption {
+	return fromBaseEncryption(b.b.SSE)
+}
+
+func (b *b2FileInfo) retention() *Retention {
+	if b.b.Retention == nil {
+		return nil
+	}
+	return &Retention{Mode: b.b.Retention.Mode, RetainUntil: b.b.Retention.RetainUntil}
+}
+
+func (b *b2FileInfo) legalHold() bool { return b.b.LegalHold }
+
+func (r *Retention) base() *base.Retention {
+	if r == nil {
+		return nil
+	}
+	return &base.Retention{Mode: r.Mode, RetainUntil: r.RetainUntil}
+}
+
+func (e *ServerSideEncryption) base() *base.Encryption {
+	if e == nil {
+		return nil
+	}
+	alg := e.Algorithm
+	if alg == "" {
+		alg = "AES256"
+	}
+	return &base.Encryption{
+		Mode:      e.Mode,
+		Algorithm: alg,
+		Key:       e.Key,
+	}
+}
+
+func (r *Replication) base() *base.Replication {
+	if r == nil {
+		return nil
+	}
+	b := &base.Replication{}
+	if r.AsSource != nil {
+		b.AsSource = &base.ReplicationSource{KeyID: r.AsSource.KeyID}
+		for _, rule := range r.AsSource.Rules {
+			b.AsSource.Rules = append(b.AsSource.Rules, base.ReplicationRule{
+				Name:            rule.Name,
+				DestBucketID:    rule.DestinationBucketID,
+				Prefix:          rule.Prefix,
+				Priority:        rule.Priority,
+				IncludeExisting: rule.IncludeExisting,
+				Enabled:         !rule.Disabled,
+			})
+		}
+	}
+	if r.AsDestination != nil {
+		b.AsDestination = &base.ReplicationDestination{KeyMapping: r.AsDestination.KeyMapping}
+	}
+	return b
+}
+
+func fromBaseReplication(b *base.Replication) *Replication {
+	if b == nil {
+		return nil
+	}
+	r := &Replication{}
+	if b.AsSource != nil {
+		r.AsSource = &ReplicationSource{KeyID: b.AsSource.KeyID}
+		for _, rule := range b.AsSource.Rules {
+			r.AsSource.Rules = append(r.AsSource.Rules, ReplicationRule{
+				Name:                rule.Name,
+				DestinationBucketID: rule.DestBucketID,
+				Prefix:              rule.Prefix,
+				Priority:            rule.Priority,
+				IncludeExisting:     rule.IncludeExisting,
+				Disabled:            !rule.Enabled,
+			})
+		}
+	}
+	if b.AsDestination != nil {
+		r.AsDestination = &ReplicationDestination{KeyMapping: b.AsDestination.KeyMapping}
+	}
+	return r
+}
+
+func baseCORS(rules []CORSRule) []base.CORSRule {
+	var b []base.CORSRule
+	for _, rule := range rules {
+		b = append(b, base.CORSRule(rule))
+	}
+	return b
+}
+
+// isKeyErr reports whether err is B2 refusing access to an SSE-C file because
+// its key was not given or did not match.
+func isKeyErr(err error) bool {
+	code, msg := base.Code(err)
+	switch code {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return strings.Contains(strings.ToLower(msg), "encrypt")
+	}
+	return false
+}
+
+func fromBaseEncryption(e *base.Encryption) *ServerSideEncryption {
+	if e == nil {
+		return nil
+	}
+	return &ServerSideEncryption{
+		Mode:      e.Mode,
+		Algorithm: e.Algorithm,
+	}
+}
+
 func (b *b2FilePart) number() int  { return b.b.Number }
 func (b *b2FilePart) sha1() string { return b.b.SHA1 }
 func (b *b2FilePart) size() int64  { return b.b.Size }
@@ -510,3 +823,9 @@ func (b *b2Key) name() string                  { return b.b.Name }
 func (b *b2Key) expires() time.Time            { return b.b.Expires }
 func (b *b2Key) secret() string                { return b.b.Secret }
 func (b *b2Key) id() string                    { return b.b.ID }
+func (b *b2Key) bucketID() string              { return b.b.BucketID }
+func (b *b2Key) prefix() string                { return b.b.Prefix }
+
+// encodeName percent-encodes an object name as base does for downloads by
+// name, so that URLs built here match those the client fetches.
+func encodeName(name string) string { return base.EncodeName(name) }
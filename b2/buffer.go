@@ -64,18 +64,38 @@ type nonBuffer struct {
 	r    *io.SectionReader
 	size int
 	hsh  hash.Hash
+	sha  string // the caller's hash, if given, sent instead of the trailer
 
 	isEOF bool
 	buf   *strings.Reader
 }
 
-func (nb *nonBuffer) Len() int                      { return nb.size + 40 }
-func (nb *nonBuffer) Hash() string                  { return "hex_digits_at_end" }
 func (nb *nonBuffer) Close() error                  { return nil }
 func (nb *nonBuffer) Reader() (readResetter, error) { return nb, nil }
 func (nb *nonBuffer) Write([]byte) (int, error)     { return 0, errors.New("writes not supported") }
 
+func (nb *nonBuffer) Len() int {
+	if nb.sha != "" {
+		return nb.size
+	}
+	return nb.size + 40
+}
+
+func (nb *nonBuffer) Hash() string {
+	if nb.sha != "" {
+		return nb.sha
+	}
+	return "hex_digits_at_end"
+}
+
+// useHash has nb send sha as its hash, instead of hashing its contents and
+// sending the result after them.  It must be called before nb is read.
+func (nb *nonBuffer) useHash(sha string) { nb.sha = sha }
+
 func (nb *nonBuffer) Read(p []byte) (int, error) {
+	if nb.sha != "" {
+		return nb.r.Read(p)
+	}
 	if nb.isEOF {
 		return nb.buf.Read(p)
 	}
@@ -95,13 +115,17 @@ func (nb *nonBuffer) Reset() error {
 	return err
 }
 
+// A memoryBuffer hashes its contents only when Hash is first called, so that
+// chunks sent with a caller-supplied SHA1 are never hashed at all.
 type memoryBuffer struct {
 	buf *bytes.Buffer
-	hsh hash.Hash
-	w   io.Writer
+	sum string
 	mux sync.Mutex
 }
 
+// bufpool holds the buffers of closed memoryBuffers, shared by all Writers.  A
+// buffer is only returned to the pool when its chunk has been uploaded, so a
+// Writer holds at most ConcurrentUploads+1 buffers at once.
 var bufpool *sync.Pool
 
 func init() {
@@ -109,19 +133,42 @@ func init() {
 	bufpool.New = func() interface{} { return &bytes.Buffer{} }
 }
 
-func newMemoryBuffer() *memoryBuffer {
-	mb := &memoryBuffer{
-		hsh: sha1.New(),
-	}
+// newMemoryBuffer returns a memoryBuffer backed by a pooled buffer.  If size is
+// positive, the buffer is grown to hold size bytes up front, which avoids
+// repeatedly reallocating it as it fills.
+func newMemoryBuffer(size int) *memoryBuffer {
+	mb := &memoryBuffer{}
 	mb.buf = bufpool.Get().(*bytes.Buffer)
-	mb.w = io.MultiWriter(mb.hsh, mb.buf)
+	if size > 0 {
+		// The extra MinRead bytes let readFrom fill the buffer to size without
+		// bytes.Buffer.ReadFrom growing it.
+		mb.buf.Grow(size + bytes.MinRead)
+	}
 	return mb
 }
 
-func (mb *memoryBuffer) Write(p []byte) (int, error)   { return mb.w.Write(p) }
+func (mb *memoryBuffer) Write(p []byte) (int, error) {
+	mb.sum = ""
+	return mb.buf.Write(p)
+}
+
 func (mb *memoryBuffer) Len() int                      { return mb.buf.Len() }
 func (mb *memoryBuffer) Reader() (readResetter, error) { return newResetter(mb.buf.Bytes()), nil }
-func (mb *memoryBuffer) Hash() string                  { return fmt.Sprintf("%x", mb.hsh.Sum(nil)) }
+
+func (mb *memoryBuffer) Hash() string {
+	if mb.sum == "" {
+		mb.sum = fmt.Sprintf("%x", sha1.Sum(mb.buf.Bytes()))
+	}
+	return mb.sum
+}
+
+// readFrom reads up to n bytes from r directly into the buffer.  It stops
+// early, without error, if r returns io.EOF.
+func (mb *memoryBuffer) readFrom(r io.Reader, n int) (int, error) {
+	mb.sum = ""
+	k, err := mb.buf.ReadFrom(io.LimitReader(r, int64(n)))
+	return int(k), err
+}
 
 func (mb *memoryBuffer) Close() error {
 	mb.mux.Lock()
@@ -129,7 +176,7 @@ func (mb *memoryBuffer) Close() error {
 	if mb.buf == nil {
 		return nil
 	}
-	mb.buf.Truncate(0)
+	mb.buf.Reset()
 	bufpool.Put(mb.buf)
 	mb.buf = nil
 	return nil
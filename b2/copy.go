@@ -0,0 +1,216 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"sync"
+)
+
+// maxCopySize is the largest object B2 will copy in a single request.
+const maxCopySize = 5e9
+
+type copyOptions struct {
+	attrs     *Attrs
+	retention *Retention
+	partSize  int64
+	workers   int
+	srcSSE    *ServerSideEncryption
+	dstSSE    *ServerSideEncryption
+}
+
+// A CopyOption changes the behavior of Object.CopyTo.
+type CopyOption func(*copyOptions)
+
+// CopyAttrs gives the copy the content type and info of attrs, instead of those
+// of the source object.  As with WithAttrsOption, attrs.SHA1 and
+// attrs.LastModified are also saved.
+func CopyAttrs(attrs *Attrs) CopyOption {
+	return func(c *copyOptions) {
+		c.attrs = attrs
+	}
+}
+
+// CopyMetadata gives the copy the content type and info given here, instead of
+// those of the source object, as B2's REPLACE metadata directive does.  Unlike
+// CopyAttrs, nothing else is added to info.  An empty contentType is taken as
+// application/octet-stream.  As for a Writer, info may hold at most ten keys;
+// CopyTo checks it before copying anything, and fails with an error wrapping
+// ErrTooManyInfoKeys or ErrInvalidInfoKey if B2 would reject it.
+func CopyMetadata(contentType string, info map[string]string) CopyOption {
+	return func(c *copyOptions) {
+		c.attrs = &Attrs{ContentType: contentType, Info: info}
+	}
+}
+
+// CopyRetention sets the Object Lock retention of the copy.  The destination
+// bucket must have Object Lock enabled; if it doesn't, CopyTo fails with
+// ErrObjectLockDisabled.
+func CopyRetention(r Retention) CopyOption {
+	return func(c *copyOptions) {
+		c.retention = &r
+	}
+}
+
+// CopyPartSize sets the size, in bytes, of the parts in which large objects
+// are copied.  Objects no larger than this are copied with a single request.
+// The default and maximum is 5GB (5e9), which is also the largest object B2
+// will copy in a single request.
+func CopyPartSize(size int64) CopyOption {
+	return func(c *copyOptions) {
+		c.partSize = size
+	}
+}
+
+// CopyConcurrency sets the number of parts of a large object that are copied
+// at once, as Writer.ConcurrentUploads does for uploads.  Values less than 1
+// are equivalent to 1, the default.
+func CopyConcurrency(n int) CopyOption {
+	return func(c *copyOptions) {
+		c.workers = n
+	}
+}
+
+// CopyEncryption sets the encryption used on both sides of the copy.  src
+// must hold the key of a source written with SSEC, and is otherwise ignored.
+// dst sets the encryption of the copy; if nil, the destination bucket's
+// default applies.
+func CopyEncryption(src, dst *ServerSideEncryption) CopyOption {
+	return func(c *copyOptions) {
+		c.srcSSE = src
+		c.dstSSE = dst
+	}
+}
+
+// CopyTo copies o to an object named dstName in dst, which may be o's own
+// bucket.  The data is copied by B2 and is not downloaded.  Unless CopyAttrs
+// or CopyMetadata is given, the copy keeps o's content type and info.
+//
+// Objects larger than 5GB, or than the size given with CopyPartSize, are
+// copied in parts with the large file API; see CopyConcurrency.
+func (o *Object) CopyTo(ctx context.Context, dst *Bucket, dstName string, opts ...CopyOption) (*Object, error) {
+	c := &copyOptions{partSize: maxCopySize}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.partSize <= 0 || c.partSize > maxCopySize {
+		c.partSize = maxCopySize
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	if c.retention != nil {
+		if err := dst.checkObjectLock(dstName); err != nil {
+			return nil, err
+		}
+	}
+	var newInfo map[string]string
+	if c.attrs != nil {
+		newInfo = attrsInfo(c.attrs)
+		if err := validateInfo(dstName, newInfo); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.ensure(ctx); err != nil {
+		return nil, err
+	}
+	fi, err := o.f.getFileInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, _, size, ctype, info, _, _ := fi.stats()
+	if c.attrs != nil {
+		ctype = c.attrs.ContentType
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		info = newInfo
+	}
+	if size <= c.partSize {
+		// B2 requires the metadata to be given when either side uses SSE-C.
+		replace := c.attrs != nil || isSSEC(c.srcSSE) || isSSEC(c.dstSSE) || isSSEC(fi.encryption())
+		if !replace {
+			ctype, info = "", nil
+		}
+		f, err := dst.b.copyFile(ctx, o.f.id(), dstName, replace, ctype, info, c.retention, c.srcSSE, c.dstSSE)
+		if err != nil {
+			return nil, err
+		}
+		return &Object{name: dstName, f: f, b: dst}, nil
+	}
+	lf, err := dst.b.startLargeFile(ctx, dstName, ctype, info, c.dstSSE, c.retention, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.copyParts(ctx, lf, size, c); err != nil {
+		return nil, err
+	}
+	f, err := lf.finishLargeFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{name: dstName, f: f, b: dst}, nil
+}
+
+// copyParts copies size bytes of o into lf, c.partSize bytes at a time, with
+// up to c.workers requests in flight.  It stops at the first error.
+func (o *Object) copyParts(ctx context.Context, lf beLargeFileInterface, size int64, c *copyOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type part struct {
+		n         int
+		off, size int64
+	}
+	ch := make(chan part)
+	errc := make(chan error, c.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range ch {
+				if err := lf.copyPart(ctx, o.f.id(), p.n, p.off, p.size, c.srcSSE, c.dstSSE); err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+feed:
+	for n, off := 1, int64(0); off < size; n, off = n+1, off+c.partSize {
+		p := part{n: n, off: off, size: c.partSize}
+		if size-off < p.size {
+			p.size = size - off
+		}
+		select {
+		case ch <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ch)
+	wg.Wait()
+	close(errc)
+	if err := <-errc; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func isSSEC(e *ServerSideEncryption) bool {
+	return e != nil && e.Mode == SSEC
+}
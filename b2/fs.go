@@ -0,0 +1,278 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS is a read-only view of a bucket that satisfies fs.FS, fs.StatFS, and
+// fs.ReadDirFS, so that buckets can be used with fs.WalkDir, http.FS, and other
+// consumers of the io/fs package.
+//
+// B2 has no directories.  FS treats "/" as the path separator, and presents a
+// directory wherever some object's name continues past a slash.  Objects whose
+// names are not valid fs paths, such as names that begin with a slash or that
+// hold an empty element, are not reachable.  If an object has the same name as
+// a directory, the object is presented and the directory is not.
+type FS struct {
+	ctx context.Context
+	b   *Bucket
+}
+
+// FS returns a view of the bucket.  Every request it makes is made with ctx.
+func (b *Bucket) FS(ctx context.Context) *FS {
+	return &FS{ctx: ctx, b: b}
+}
+
+// Open opens the named object or directory.  Objects are read with a Reader,
+// and can be seeked.
+func (f *FS) Open(name string) (fs.File, error) {
+	fi, o, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &fsDir{fs: f, name: name, info: fi}, nil
+	}
+	return &fsFile{fs: f, o: o, info: fi}, nil
+}
+
+// Stat describes the named object or directory.  For objects, Sys returns the
+// object's *Attrs.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	fi, _, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// ReadDir lists the named directory, sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fi, _, err := f.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.readDir(name)
+}
+
+func (f *FS) stat(op, name string) (*fsInfo, *Object, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fsInfo{name: name}, nil, nil
+	}
+	o, err := f.b.listObject(f.ctx, name)
+	if err == nil {
+		attrs, err := o.Attrs(f.ctx)
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		return &fsInfo{name: name, attrs: attrs}, o, nil
+	}
+	if !IsNotExist(err) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	iter := f.b.List(f.ctx, ListPrefix(name+"/"), ListPageSize(1))
+	if iter.Next() {
+		return &fsInfo{name: name}, nil, nil
+	}
+	if err := iter.Err(); err != nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// readDir lists the entries of a directory that is known to exist.
+func (f *FS) readDir(name string) ([]fs.DirEntry, error) {
+	var pfx string
+	if name != "." {
+		pfx = name + "/"
+	}
+	seen := make(map[string]bool)
+	var ents []fs.DirEntry
+	iter := f.b.List(f.ctx, ListPrefix(pfx), ListDelimiter("/"))
+	for iter.Next() {
+		o := iter.Object()
+		base := strings.TrimPrefix(o.Name(), pfx)
+		dir := strings.HasSuffix(base, "/")
+		base = strings.TrimSuffix(base, "/")
+		if !fs.ValidPath(base) || strings.Contains(base, "/") || seen[base] {
+			continue
+		}
+		fi := &fsInfo{name: pfx + base}
+		if !dir {
+			attrs, err := o.Attrs(f.ctx)
+			if err != nil {
+				return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+			}
+			fi.attrs = attrs
+		}
+		seen[base] = true
+		ents = append(ents, fs.FileInfoToDirEntry(fi))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	return ents, nil
+}
+
+// fsInfo describes an object, or a directory if attrs is nil.
+type fsInfo struct {
+	name  string
+	attrs *Attrs
+}
+
+func (fi *fsInfo) Name() string { return path.Base(fi.name) }
+func (fi *fsInfo) IsDir() bool  { return fi.attrs == nil }
+
+func (fi *fsInfo) Size() int64 {
+	if fi.attrs == nil {
+		return 0
+	}
+	return fi.attrs.Size
+}
+
+func (fi *fsInfo) Mode() fs.FileMode {
+	if fi.attrs == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ModTime is the object's LastModified time if it was given one, and its
+// upload time otherwise.
+func (fi *fsInfo) ModTime() time.Time {
+	if fi.attrs == nil {
+		return time.Time{}
+	}
+	if !fi.attrs.LastModified.IsZero() {
+		return fi.attrs.LastModified
+	}
+	return fi.attrs.UploadTimestamp
+}
+
+func (fi *fsInfo) Sys() interface{} {
+	if fi.attrs == nil {
+		return nil
+	}
+	return fi.attrs
+}
+
+type fsFile struct {
+	fs   *FS
+	o    *Object
+	info *fsInfo
+	r    *Reader // nil until the first Read after opening or seeking
+	off  int64
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		if f.off >= f.info.Size() {
+			return 0, io.EOF
+		}
+		f.r = f.o.NewRangeReader(f.fs.ctx, f.off, -1)
+	}
+	n, err := f.r.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fmt.Errorf("invalid whence %d", whence)}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fmt.Errorf("negative offset %d", offset)}
+	}
+	if offset != f.off && f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	return nil
+}
+
+type fsDir struct {
+	fs   *FS
+	name string
+	info *fsInfo
+	ents []fs.DirEntry // nil until the first ReadDir
+	read int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.ents == nil {
+		ents, err := d.fs.readDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.ents = ents
+		if d.ents == nil {
+			d.ents = []fs.DirEntry{}
+		}
+	}
+	rest := d.ents[d.read:]
+	if n <= 0 {
+		d.read = len(d.ents)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.read += n
+	return rest[:n], nil
+}
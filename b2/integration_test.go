@@ -926,7 +926,7 @@ func TestReauthPreservesOptions(t *testing.T) {
 	defer done()
 
 	first := bucket.r.(*beRoot).options
-	if err := bucket.r.reauthorizeAccount(ctx); err != nil {
+	if err := bucket.r.reauthorizeAccount(ctx, bucket.r.authGeneration()); err != nil {
 		t.Fatalf("reauthorizeAccount: %v", err)
 	}
 	second := bucket.r.(*beRoot).options
@@ -158,7 +158,9 @@ type objectIteratorOptions struct {
 // A ListOption alters the default behavor of List.
 type ListOption func(*objectIteratorOptions)
 
-// ListHidden will include hidden objects in the output.
+// ListHidden will include hidden objects in the output.  Every version of
+// each object is listed, newest first, along with the markers that hide them;
+// Attrs reports which is which, and Object.ID tells versions apart.
 func ListHidden() ListOption {
 	return func(o *objectIteratorOptions) {
 		o.hidden = true
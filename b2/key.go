@@ -51,6 +51,13 @@ func (k *Key) Secret() string { return k.k.secret() }
 // authenticate to B2.
 func (k *Key) ID() string { return k.k.id() }
 
+// BucketID returns the ID of the bucket this key is restricted to.  It is empty
+// for keys that may access every bucket in the account.
+func (k *Key) BucketID() string { return k.k.bucketID() }
+
+// Prefix returns the file name prefix this key is restricted to, if any.
+func (k *Key) Prefix() string { return k.k.prefix() }
+
 type keyOptions struct {
 	caps     []string
 	prefix   string
@@ -20,6 +20,7 @@ import (
 	"math"
 	"net/http"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/kurin/blazer/internal/b2assets"
@@ -133,6 +134,63 @@ func (c *Client) Status() *StatusInfo {
 	return si
 }
 
+// Metrics holds running totals of a client's activity since it was created.
+type Metrics struct {
+	// BytesUploaded counts the object data uploaded successfully.
+	BytesUploaded int64
+
+	// BytesDownloaded counts the object data downloaded, including data that
+	// had to be fetched again.
+	BytesDownloaded int64
+
+	// Parts counts the large file parts uploaded successfully.
+	Parts int64
+
+	// Retries counts requests that were made again after a failure.
+	Retries int64
+
+	// Reauths counts the times the client was authorized again after its
+	// token expired.
+	Reauths int64
+
+	// UploadURLFetches counts the upload URLs, for whole objects and for large
+	// file parts, that Writers asked B2 for, and UploadURLReuses the uploads
+	// that used one that an earlier upload had finished with instead.  B2
+	// allows each URL only one upload at a time, so concurrent uploads need a
+	// URL each; see Writer.UploadStats.
+	UploadURLFetches int64
+	UploadURLReuses  int64
+}
+
+// metrics accumulates a client's Metrics atomically.
+type metrics struct {
+	bytesUp, bytesDown, parts, retries, reauths int64
+	urlFetches, urlReuses                       int64
+}
+
+func (m *metrics) uploaded(n int)     { atomic.AddInt64(&m.bytesUp, int64(n)) }
+func (m *metrics) downloaded(n int64) { atomic.AddInt64(&m.bytesDown, n) }
+func (m *metrics) part()              { atomic.AddInt64(&m.parts, 1) }
+func (m *metrics) retry()             { atomic.AddInt64(&m.retries, 1) }
+func (m *metrics) reauth()            { atomic.AddInt64(&m.reauths, 1) }
+func (m *metrics) urlFetch()          { atomic.AddInt64(&m.urlFetches, 1) }
+func (m *metrics) urlReuse()          { atomic.AddInt64(&m.urlReuses, 1) }
+
+// Metrics returns the client's running totals.
+func (c *Client) Metrics() Metrics {
+	m := c.backend.metrics()
+	return Metrics{
+		BytesUploaded:   atomic.LoadInt64(&m.bytesUp),
+		BytesDownloaded: atomic.LoadInt64(&m.bytesDown),
+		Parts:           atomic.LoadInt64(&m.parts),
+		Retries:         atomic.LoadInt64(&m.retries),
+		Reauths:         atomic.LoadInt64(&m.reauths),
+
+		UploadURLFetches: atomic.LoadInt64(&m.urlFetches),
+		UploadURLReuses:  atomic.LoadInt64(&m.urlReuses),
+	}
+}
+
 func (si *StatusInfo) table() map[string]map[string]int {
 	r := make(map[string]map[string]int)
 	for d, c := range si.RPCs {
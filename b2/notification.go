@@ -0,0 +1,215 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A NotificationRule has B2 send a webhook whenever one of the given events
+// happens to an object in the bucket whose name begins with Prefix.
+type NotificationRule struct {
+	// Name identifies the rule.  It must be unique within the bucket.
+	Name string
+
+	// EventTypes lists the events the rule applies to:
+	//
+	//	b2:ObjectCreated:*, b2:ObjectCreated:Upload,
+	//	b2:ObjectCreated:MultipartUpload, b2:ObjectCreated:Copy,
+	//	b2:ObjectCreated:Replica, b2:ObjectCreated:MultipartReplica,
+	//	b2:ObjectDeleted:*, b2:ObjectDeleted:Delete,
+	//	b2:ObjectDeleted:LifecycleRule, b2:HideMarkerCreated:*,
+	//	b2:HideMarkerCreated:Hide, or b2:HideMarkerCreated:LifecycleRule.
+	//
+	// The "*" forms match every event of their kind.
+	EventTypes []string
+
+	// Prefix limits the rule to objects whose names begin with it.
+	Prefix string
+
+	// URL is the https endpoint the notifications are sent to.
+	URL string
+
+	// SigningSecret, if set, has B2 sign each notification with an HMAC-SHA256
+	// of its body, keyed with the secret, so that the receiver can check that
+	// it came from B2.  B2 requires 32 letters and digits.
+	SigningSecret string
+
+	// CustomHeaders are added to each notification request.
+	CustomHeaders map[string]string
+
+	// Disabled pauses the rule.
+	Disabled bool
+
+	// Suspended is reported by B2 when it has stopped sending the rule's
+	// notifications, such as after repeated failures to deliver them, and
+	// SuspensionReason says why.  They are ignored when rules are set.
+	Suspended        bool
+	SuspensionReason string
+}
+
+// notificationEvents are the event types a NotificationRule may ask for.
+var notificationEvents = map[string]bool{
+	"b2:ObjectCreated:*":                 true,
+	"b2:ObjectCreated:Upload":            true,
+	"b2:ObjectCreated:MultipartUpload":   true,
+	"b2:ObjectCreated:Copy":              true,
+	"b2:ObjectCreated:Replica":           true,
+	"b2:ObjectCreated:MultipartReplica":  true,
+	"b2:ObjectDeleted:*":                 true,
+	"b2:ObjectDeleted:Delete":            true,
+	"b2:ObjectDeleted:LifecycleRule":     true,
+	"b2:HideMarkerCreated:*":             true,
+	"b2:HideMarkerCreated:Hide":          true,
+	"b2:HideMarkerCreated:LifecycleRule": true,
+}
+
+func validateNotificationRules(rules []NotificationRule) error {
+	for _, rule := range rules {
+		if len(rule.EventTypes) == 0 {
+			return fmt.Errorf("notification rule %q: no event types", rule.Name)
+		}
+		for _, ev := range rule.EventTypes {
+			if !notificationEvents[ev] {
+				return fmt.Errorf("notification rule %q: unknown event type %q", rule.Name, ev)
+			}
+		}
+	}
+	return nil
+}
+
+// NotificationRules returns the bucket's event notification rules.
+func (b *Bucket) NotificationRules(ctx context.Context) ([]NotificationRule, error) {
+	return b.b.getNotificationRules(ctx)
+}
+
+// SetNotificationRules replaces the bucket's event notification rules with
+// rules.  Each rule's event types are checked before anything is sent.  B2
+// checks the rest, and may send a test notification to each new URL.
+func (b *Bucket) SetNotificationRules(ctx context.Context, rules []NotificationRule) error {
+	if err := validateNotificationRules(rules); err != nil {
+		return err
+	}
+	_, err := b.b.setNotificationRules(ctx, rules)
+	return err
+}
+
+// ClearNotificationRules removes all of the bucket's event notification rules.
+func (b *Bucket) ClearNotificationRules(ctx context.Context) error {
+	return b.SetNotificationRules(ctx, nil)
+}
+
+// EventSignatureHeader is the header in which B2 sends the signature of a
+// notification from a rule with a SigningSecret.
+const EventSignatureHeader = "X-Bz-Event-Notification-Signature"
+
+// ErrBadSignature is returned by VerifyEventSignature when a notification's
+// signature is missing or does not match its body.
+var ErrBadSignature = errors.New("b2: bad event notification signature")
+
+// VerifyEventSignature checks that body, the body of a notification request,
+// was signed by B2 with secret, the SigningSecret of the rule that sent it.
+// signatureHeader is the value of the request's EventSignatureHeader, of the
+// form "v1=" followed by the hex HMAC-SHA256 of the body.  It returns an error
+// wrapping ErrBadSignature if the signature doesn't match, as it won't if the
+// body was altered.
+//
+// Webhook receivers should verify the body before parsing it with
+// ParseEvents, and should read the body in full, unaltered, to do so.
+func VerifyEventSignature(secret, signatureHeader string, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body) // Hash.Write never returns an error.
+	want := mac.Sum(nil)
+	for _, sig := range strings.Split(signatureHeader, ",") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(sig), "v1=")
+		if !ok {
+			continue
+		}
+		got, err := hex.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		if hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	if signatureHeader == "" {
+		return fmt.Errorf("%w: no signature", ErrBadSignature)
+	}
+	return ErrBadSignature
+}
+
+// An Event is one of the events a notification reports.
+type Event struct {
+	ID         string    // unique to the event, for discarding duplicates
+	Type       string    // one of the event types of NotificationRule
+	Time       time.Time // when the event happened
+	Version    int       // the version of the event's format
+	RuleName   string    // the name of the rule that sent it
+	AccountID  string
+	BucketID   string
+	BucketName string
+	ObjectName string
+	ObjectSize int64
+	ObjectID   string // the ID of the object's version, as returned by Object.ID
+}
+
+// ParseEvents returns the events reported by body, the body of a notification
+// request.  It does not check the body's signature; see VerifyEventSignature.
+func ParseEvents(body []byte) ([]Event, error) {
+	var n struct {
+		Events []struct {
+			AccountID  string `json:"accountId"`
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+			EventID    string `json:"eventId"`
+			Timestamp  int64  `json:"eventTimestamp"`
+			Type       string `json:"eventType"`
+			Version    int    `json:"eventVersion"`
+			RuleName   string `json:"matchedRuleName"`
+			ObjectName string `json:"objectName"`
+			ObjectSize int64  `json:"objectSize"`
+			ObjectID   string `json:"objectVersionId"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("b2: parsing event notification: %w", err)
+	}
+	var events []Event
+	for _, e := range n.Events {
+		events = append(events, Event{
+			ID:         e.EventID,
+			Type:       e.Type,
+			Time:       time.Unix(0, e.Timestamp*1e6),
+			Version:    e.Version,
+			RuleName:   e.RuleName,
+			AccountID:  e.AccountID,
+			BucketID:   e.BucketID,
+			BucketName: e.BucketName,
+			ObjectName: e.ObjectName,
+			ObjectSize: e.ObjectSize,
+			ObjectID:   e.ObjectID,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxPartNumber is the highest part number B2 accepts in a large file.
+const maxPartNumber = 10000
+
+// A PartWriter uploads a large object part by part, for producers that
+// generate regions of an object out of order or in parallel.  Each part is
+// written through its own io.WriteCloser, returned by Part, and parts may be
+// written concurrently and in any order.  Once every part has been written,
+// Finish assembles them into the object.
+//
+// Parts are numbered from 1, and must be contiguous when Finish is called.
+// Every part but the last must be at least the account's minimum part size,
+// 5MB unless B2 says otherwise.
+type PartWriter struct {
+	w    *Writer // holds the object's name, attributes, and encryption
+	file beLargeFileInterface
+
+	mu   sync.Mutex
+	done bool
+}
+
+// NewPartWriter starts a large file upload for o, with the content type, info,
+// and other settings that the given options would apply to a Writer.  The
+// upload is unfinished, and o is not visible, until Finish is called.
+func (o *Object) NewPartWriter(ctx context.Context, opts ...WriterOption) (*PartWriter, error) {
+	w := o.NewWriter(ctx, opts...)
+	w.Resume = false
+	file, err := w.getLargeFile()
+	if err != nil {
+		w.cancel()
+		return nil, err
+	}
+	w.file = file // for its pool of upload part URLs
+	return &PartWriter{w: w, file: file}, nil
+}
+
+// ID returns the ID of the large file being written.
+func (pw *PartWriter) ID() string {
+	return pw.file.id()
+}
+
+// Part returns a writer for the given part number, from 1 to 10000.  The data
+// written to it is held in memory and uploaded when it is closed; Close
+// reports whether the upload succeeded.  Writing the same part number again
+// replaces that part.
+func (pw *PartWriter) Part(n int) (io.WriteCloser, error) {
+	if n < 1 || n > maxPartNumber {
+		return nil, fmt.Errorf("%s: part number %d out of range", pw.w.name, n)
+	}
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.done {
+		return nil, fmt.Errorf("%s: part %d: upload already finished", pw.w.name, n)
+	}
+	return &partBuffer{pw: pw, n: n, buf: newMemoryBuffer(0)}, nil
+}
+
+// Finish assembles the uploaded parts into the object.  Parts still being
+// written are not waited for, so callers must close them all first.
+func (pw *PartWriter) Finish() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.done {
+		return nil
+	}
+	defer pw.w.cancel()
+	f, err := pw.file.finishLargeFile(pw.w.ctx)
+	if err != nil {
+		return err
+	}
+	pw.done = true
+	pw.w.o.f = f
+	return nil
+}
+
+// partBuffer holds the data of one part until it is uploaded.
+type partBuffer struct {
+	pw  *PartWriter
+	n   int
+	buf *memoryBuffer
+
+	closed bool
+}
+
+func (p *partBuffer) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, fmt.Errorf("%s: part %d: write after close", p.pw.w.name, p.n)
+	}
+	return p.buf.Write(b)
+}
+
+func (p *partBuffer) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	defer p.buf.Close()
+	w := p.pw.w
+	r, err := p.buf.Reader()
+	if err != nil {
+		return err
+	}
+	var attempt int
+	var wait time.Duration
+	for {
+		attempt++
+		fc, err := w.getPartURL()
+		if err != nil {
+			return fmt.Errorf("%s: part %d: getting an upload URL: %w", w.name, p.n, err)
+		}
+		n, err := w.uploadPart(fc, r, p.buf.Hash(), p.buf.Len(), p.n)
+		if err == nil {
+			if n == p.buf.Len() {
+				w.putPartURL(fc)
+				break
+			}
+			err = io.ErrShortWrite
+		}
+		d, ok, err := w.retryUpload(attempt, wait, err)
+		if !ok {
+			return fmt.Errorf("%s: part %d: %w", w.name, p.n, err)
+		}
+		wait = d
+		if err := sleep(w.ctx, w.o.b.r.clock(), wait); err != nil {
+			return fmt.Errorf("%s: part %d: %w", w.name, p.n, err)
+		}
+		w.o.b.r.metrics().retry()
+	}
+	w.o.b.r.metrics().part()
+	w.o.b.r.metrics().uploaded(p.buf.Len())
+	return nil
+}
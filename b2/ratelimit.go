@@ -0,0 +1,103 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the client to rps requests per second, on average, with
+// bursts of up to burst requests; a burst less than 1 is taken as 1.  Every
+// HTTP request the client sends counts, including uploads of each part of a
+// large file, downloads, and retries, and waits its turn before it is sent.
+// A request waiting for its turn gives up when its context is done.
+//
+// Spreading requests out this way keeps a busy client from provoking B2 into
+// replying with 429 Too Many Requests.  A rate of zero or less sets no limit.
+func WithRateLimit(rps float64, burst int) ClientOption {
+	return func(c *clientOptions) {
+		c.rateLimit = rps
+		c.rateBurst = burst
+	}
+}
+
+// A rateLimiter is a token bucket.  It holds up to burst tokens, and is
+// refilled at rps tokens a second; each request takes one.  A request that
+// finds none reserves the next to arrive, and waits for it.
+type rateLimiter struct {
+	clk   clock
+	rps   float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64 // negative when tokens are reserved
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter, or nil if rps sets no limit.
+func newRateLimiter(rps float64, burst int, clk clock) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if clk == nil {
+		clk = realClock{}
+	}
+	return &rateLimiter{
+		clk:    clk,
+		rps:    rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clk.Now(),
+	}
+}
+
+// wait takes a token, waiting for one if there are none, or returns ctx's
+// error if it is done first.  A nil limiter never waits.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.clk.Now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rps
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens--
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rps * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	if err := sleep(ctx, l.clk, d); err != nil {
+		// Give the reserved token back to those still waiting.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -16,6 +16,7 @@ package b2
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha1"
 	"errors"
@@ -23,9 +24,8 @@ import (
 	"hash"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/kurin/blazer/internal/blog"
 )
 
 var errNoMoreContent = errors.New("416: out of content")
@@ -35,15 +35,41 @@ type Reader struct {
 	// ConcurrentDownloads is the number of simultaneous downloads to pull from
 	// B2.  Values greater than one will cause B2 to make multiple HTTP requests
 	// for a given file, increasing available bandwidth at the cost of buffering
-	// the downloads in memory.
+	// the downloads in memory.  Bytes are returned in order whatever the
+	// order in which they arrive, so if one download fails, the bytes before
+	// it are returned first, and then a *RangeError describing it.
 	ConcurrentDownloads int
 
 	// ChunkSize is the size to fetch per ConcurrentDownload.  The default is
 	// 10MB.
 	ChunkSize int
 
+	// ServerSideEncryption must hold the key of an object written with SSEC.
+	// Without a matching key, reads fail with an error that wraps
+	// ErrCustomerKey.  It is not needed for other objects.
+	ServerSideEncryption *ServerSideEncryption
+
+	// AutoDecompress, if set, makes Read and WriteTo decompress objects whose
+	// Content-Encoding is gzip, as set by Writer.ContentEncoding, and return
+	// the original bytes.  Other objects are read unchanged.  ReadAt, Verify,
+	// and ReaderStatus still see the stored, compressed bytes, and a Reader
+	// with AutoDecompress set cannot Seek.  It must be set before the first
+	// call to Read.
+	AutoDecompress bool
+
+	// VerifySHA1, if set, makes the Read or WriteTo that reaches the end of the
+	// object compare the SHA1 of the bytes read with the one B2 reports, the
+	// object's content SHA1 or, for a large object, its large_file_sha1, and
+	// fail with an error wrapping ErrSHA1Mismatch if they differ.  Close then
+	// returns the error as well.  Objects without a known SHA1, such as large
+	// objects written without one, and Readers that don't read the whole
+	// object, from its start to its end, are not checked.
+	VerifySHA1 bool
+
 	ctx        context.Context
 	cancel     context.CancelFunc // cancels ctx
+	pcancel    context.CancelFunc // stops the current download threads
+	twg        sync.WaitGroup     // the current download threads
 	o          *Object
 	name       string
 	offset     int64 // the start of the file
@@ -57,10 +83,22 @@ type Reader struct {
 	chunks     map[int]*rchunk
 	vrfy       hash.Hash
 	readOffEnd bool
-	sha1       string
 
-	rmux  sync.Mutex // guards rcond
-	rcond *sync.Cond
+	// The range the Reader was created with, which Seek moves within, and
+	// the position in it at which the current download threads began.
+	ranged bool // rstart and rlen are set
+	rstart int64
+	rlen   int64
+	pos    int64
+
+	rmux     sync.Mutex // guards rcond, id, sha1, and encoding
+	rcond    *sync.Cond
+	id       string // the ID of the file version being read
+	sha1     string
+	encoding string // the object's Content-Encoding
+
+	gz      io.Reader // decompresses the object, with AutoDecompress
+	gzReady bool      // gz has been set up, if it is needed
 
 	emux sync.RWMutex // guards err, believe it or not
 	err  error
@@ -72,12 +110,33 @@ type Reader struct {
 type rchunk struct {
 	bytes.Buffer
 	final bool
+	err   error // the chunk could not be downloaded
 }
 
-// Close frees resources associated with the download.
+// A RangeError reports that part of an object could not be downloaded.  A
+// Reader returns it once every byte before Offset has been returned, and
+// returns none of the bytes from Offset on.
+type RangeError struct {
+	Name   string
+	Offset int64 // where the failed range begins, from the start of the object
+	Length int64 // the length of the range
+	Err    error
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("%s: downloading bytes %d-%d: %v", e.Name, e.Offset, e.Offset+e.Length-1, e.Err)
+}
+
+func (e *RangeError) Unwrap() error { return e.Err }
+
+// Close frees resources associated with the download.  With VerifySHA1, it
+// returns the error reporting a mismatched SHA1, if there was one.
 func (r *Reader) Close() error {
 	r.cancel()
 	r.o.b.c.removeReader(r)
+	if err := r.getErr(); errors.Is(err, ErrSHA1Mismatch) {
+		return err
+	}
 	return nil
 }
 
@@ -104,8 +163,25 @@ func (r *Reader) getErr() error {
 	return r.err
 }
 
-func (r *Reader) thread() {
+// fail records that a download thread could not fetch a chunk, unless the
+// thread was stopped by Seek.  The chunks before it are still read, and the
+// error is returned, as a *RangeError, when the chunk is reached.
+func (r *Reader) fail(ctx context.Context, chunkID int, offset, size int64, err error) {
+	if ctx.Err() == nil || r.ctx.Err() != nil {
+		// The chunk's buffer is abandoned, since a copy cut short by the
+		// context may still be writing to it.
+		buf := &rchunk{err: &RangeError{Name: r.name, Offset: offset, Length: size, Err: err}}
+		r.rmux.Lock()
+		r.chunks[chunkID] = buf
+		r.rmux.Unlock()
+	}
+	r.rcond.Broadcast()
+}
+
+func (r *Reader) thread(ctx context.Context) {
+	r.twg.Add(1)
 	go func() {
+		defer r.twg.Done()
 		for {
 			var buf *rchunk
 			select {
@@ -114,25 +190,25 @@ func (r *Reader) thread() {
 					return
 				}
 				buf = b
-			case <-r.ctx.Done():
+			case <-ctx.Done():
 				return
 			}
 			r.rmux.Lock()
 			chunkID := r.chwid
 			r.chwid++
-			r.rmux.Unlock()
 			offset := int64(chunkID*r.csize) + r.offset
 			size := int64(r.csize)
 			if r.length > 0 {
-				if size > r.length {
+				if size >= r.length {
 					buf.final = true
 					size = r.length
 				}
 				r.length -= size
 			}
+			r.rmux.Unlock()
 			var b backoff
 		redo:
-			fr, err := r.o.b.b.downloadFileByName(r.ctx, r.name, offset, size)
+			fr, err := r.download(ctx, offset, size)
 			if err == errNoMoreContent {
 				// this read generated a 416 so we are entirely past the end of the object
 				r.readOffEnd = true
@@ -144,37 +220,49 @@ func (r *Reader) thread() {
 				return
 			}
 			if err != nil {
-				r.setErr(err)
-				r.rcond.Broadcast()
+				r.fail(ctx, chunkID, offset, size, err)
+				return
+			}
+			if err := r.pin(fr.id()); err != nil {
+				fr.Close()
+				r.fail(ctx, chunkID, offset, size, err)
 				return
 			}
-			rsize, _, sha1, _ := fr.stats()
-			if len(sha1) == 40 && r.sha1 != sha1 {
+			rsize, _, sha1, info := fr.stats()
+			if len(sha1) != 40 {
+				// B2 reports "none" for large files, whose SHA1, if any, is
+				// kept in their info.
+				sha1 = info["large_file_sha1"]
+			}
+			r.rmux.Lock()
+			if len(sha1) == 40 {
 				r.sha1 = sha1
 			}
+			r.encoding = info["b2-content-encoding"]
+			r.rmux.Unlock()
 			mr := &meteredReader{r: noopResetter{fr}, size: int(rsize)}
 			r.smux.Lock()
 			r.smap[chunkID] = mr
 			r.smux.Unlock()
-			i, err := copyContext(r.ctx, buf, mr)
+			i, err := copyContext(ctx, buf, mr)
 			fr.Close()
+			r.o.b.r.metrics().downloaded(i)
 			r.smux.Lock()
 			r.smap[chunkID] = nil
 			r.smux.Unlock()
 			if i < int64(rsize) || err == io.ErrUnexpectedEOF {
 				// Probably the network connection was closed early.  Retry.
-				blog.V(1).Infof("b2 reader %d: got %dB of %dB; retrying after %v", chunkID, i, rsize, b)
-				if err := b.wait(r.ctx); err != nil {
-					r.setErr(err)
-					r.rcond.Broadcast()
+				r.o.b.c.v(1).Infof("b2 reader %d: got %dB of %dB; retrying after %v", chunkID, i, rsize, b)
+				if err := b.wait(ctx, r.o.b.r.clock()); err != nil {
+					r.fail(ctx, chunkID, offset, size, err)
 					return
 				}
+				r.o.b.r.metrics().retry()
 				buf.Reset()
 				goto redo
 			}
 			if err != nil {
-				r.setErr(err)
-				r.rcond.Broadcast()
+				r.fail(ctx, chunkID, offset, size, err)
 				return
 			}
 			r.rmux.Lock()
@@ -185,6 +273,36 @@ func (r *Reader) thread() {
 	}()
 }
 
+// download fetches part of the object.  Once any part has been fetched, the
+// rest are fetched by ID, so that every part comes from the same version of the
+// object even if it is overwritten while being read.
+func (r *Reader) download(ctx context.Context, offset, size int64) (beFileReaderInterface, error) {
+	r.rmux.Lock()
+	id := r.id
+	r.rmux.Unlock()
+	if id == "" {
+		return r.o.b.b.downloadFileByName(ctx, r.name, offset, size, r.ServerSideEncryption)
+	}
+	return r.o.b.b.downloadFileByID(ctx, id, offset, size, r.ServerSideEncryption)
+}
+
+// pin records the ID of the file version being read, and returns an error if
+// a different version has already been seen.
+func (r *Reader) pin(id string) error {
+	if id == "" {
+		return nil
+	}
+	r.rmux.Lock()
+	defer r.rmux.Unlock()
+	if r.id == "" {
+		r.id = id
+	}
+	if r.id != id {
+		return fmt.Errorf("%s: object changed during download", r.name)
+	}
+	return nil
+}
+
 func (r *Reader) curChunk() (*rchunk, error) {
 	ch := make(chan *rchunk)
 	go func() {
@@ -201,7 +319,18 @@ func (r *Reader) curChunk() (*rchunk, error) {
 	}()
 	select {
 	case buf := <-ch:
-		return buf, r.getErr()
+		if err := r.getErr(); err != nil {
+			return buf, err
+		}
+		if buf == nil {
+			return nil, r.ctx.Err()
+		}
+		if buf.err != nil {
+			// Stop the other threads; nothing after this chunk will be read.
+			r.setErr(buf.err)
+			return nil, buf.err
+		}
+		return buf, nil
 	case <-r.ctx.Done():
 		if r.getErr() != nil {
 			return nil, r.getErr()
@@ -211,6 +340,7 @@ func (r *Reader) curChunk() (*rchunk, error) {
 }
 
 func (r *Reader) initFunc() {
+	r.setRange()
 	r.smux.Lock()
 	r.smap = make(map[int]*meteredReader)
 	r.smux.Unlock()
@@ -225,14 +355,60 @@ func (r *Reader) initFunc() {
 	}
 	r.csize = r.ChunkSize
 	r.chbuf = make(chan *rchunk, cr)
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.pcancel = cancel
 	for i := 0; i < cr; i++ {
-		r.thread()
+		r.thread(ctx)
 		r.chbuf <- &rchunk{}
 	}
 	r.vrfy = sha1.New()
 }
 
 func (r *Reader) Read(p []byte) (int, error) {
+	if !r.AutoDecompress {
+		return r.readRaw(p)
+	}
+	if !r.gzReady {
+		if err := r.setupGzip(); err != nil {
+			return 0, err
+		}
+	}
+	if r.gz != nil {
+		return r.gz.Read(p)
+	}
+	return r.readRaw(p)
+}
+
+// setupGzip waits for the first chunk, so that the object's Content-Encoding
+// is known, and if it is gzip sets gz to decompress the stored bytes.
+func (r *Reader) setupGzip() error {
+	if err := r.getErr(); err != nil {
+		return err
+	}
+	r.init.Do(r.initFunc)
+	if _, err := r.curChunk(); err != nil {
+		r.setErrNoCancel(err)
+		return err
+	}
+	r.gzReady = true
+	r.rmux.Lock()
+	enc := r.encoding
+	r.rmux.Unlock()
+	if enc != "gzip" {
+		return nil
+	}
+	gz, err := gzip.NewReader(rawReader{r})
+	if err != nil {
+		err = fmt.Errorf("%s: decompressing: %w", r.name, err)
+		r.setErrNoCancel(err)
+		return err
+	}
+	r.gz = gz
+	return nil
+}
+
+// readRaw reads the object's stored bytes.
+func (r *Reader) readRaw(p []byte) (int, error) {
 	if err := r.getErr(); err != nil {
 		return 0, err
 	}
@@ -248,6 +424,9 @@ func (r *Reader) Read(p []byte) (int, error) {
 	if err == io.EOF {
 		if chunk.final {
 			close(r.chbuf)
+			if verr := r.checkSHA1(); verr != nil {
+				err = verr
+			}
 			r.setErrNoCancel(err)
 			return n, err
 		}
@@ -260,6 +439,202 @@ func (r *Reader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// WriteTo satisfies the io.WriterTo interface.  It writes each chunk to w as
+// soon as it and every chunk before it have been downloaded, without copying
+// it into an intermediate buffer, and returns the number of bytes written.
+// io.Copy uses WriteTo when it is given a Reader.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	if r.AutoDecompress {
+		return io.Copy(onlyWriter{w}, struct{ io.Reader }{r})
+	}
+	if err := r.getErr(); err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return 0, err
+	}
+	r.init.Do(r.initFunc)
+	var total int64
+	for {
+		chunk, err := r.curChunk()
+		if err != nil {
+			r.setErrNoCancel(err)
+			return total, err
+		}
+		if p := chunk.Next(chunk.Len()); len(p) > 0 {
+			n, err := w.Write(p)
+			r.vrfy.Write(p[:n])
+			r.read += n
+			total += int64(n)
+			if err == nil && n < len(p) {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				r.setErr(err)
+				return total, err
+			}
+		}
+		if chunk.final {
+			close(r.chbuf)
+			if err := r.checkSHA1(); err != nil {
+				r.setErrNoCancel(err)
+				return total, err
+			}
+			r.setErrNoCancel(io.EOF)
+			return total, nil
+		}
+		r.chrid++
+		chunk.Reset()
+		r.chbuf <- chunk
+	}
+}
+
+// SetRange limits the Reader to length bytes of the object, beginning at
+// offset.  If length is negative, the rest of the object is read.  A Reader
+// whose range begins at or beyond the end of the object returns io.EOF on its
+// first Read.
+//
+// SetRange must be called before the first call to Read.
+func (r *Reader) SetRange(offset, length int64) {
+	r.offset = offset
+	r.length = length
+}
+
+// setRange records the range the Reader was created with, the first time it is
+// called.
+func (r *Reader) setRange() {
+	if !r.ranged {
+		r.rstart, r.rlen, r.ranged = r.offset, r.length, true
+	}
+}
+
+// Seek satisfies the io.Seeker interface.  It sets the position of the next
+// Read or WriteTo, relative to the Reader's range: offsets are from the start
+// of the range, and io.SeekEnd is the end of the range or, for a Reader
+// without a length, of the object.  Seeking to the end of the object, or past
+// it, is allowed; reads from there return io.EOF.  Seeking before the start of
+// the range is an error.
+//
+// Data already downloaded beyond the current position is discarded, and the
+// next read fetches from the new position with fresh requests, from the same
+// version of the object.  Seeking with io.SeekEnd may ask B2 for the object's
+// size.  Seek must not be called concurrently with Read or WriteTo.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.AutoDecompress {
+		return 0, fmt.Errorf("%s: cannot seek with AutoDecompress", r.name)
+	}
+	if err := r.getErr(); err != nil && err != io.EOF {
+		return 0, err
+	}
+	r.setRange()
+	cur := r.pos + int64(r.read)
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = cur + offset
+	case io.SeekEnd:
+		end, err := r.end()
+		if err != nil {
+			return 0, err
+		}
+		pos = end + offset
+	default:
+		return 0, fmt.Errorf("%s: invalid whence %d", r.name, whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("%s: seek to negative position %d", r.name, pos)
+	}
+	if pos != cur {
+		r.restart(pos)
+	}
+	return pos, nil
+}
+
+// end returns the length of the Reader's range, up to the end of the object.
+func (r *Reader) end() (int64, error) {
+	attrs, err := r.o.Attrs(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	end := attrs.Size - r.rstart
+	if end < 0 {
+		end = 0
+	}
+	if r.rlen >= 0 && r.rlen < end {
+		end = r.rlen
+	}
+	return end, nil
+}
+
+// restart stops any download threads, discarding what they fetched, so that
+// the next read begins at pos within the Reader's range.
+func (r *Reader) restart(pos int64) {
+	if r.pcancel != nil {
+		r.pcancel()
+		r.twg.Wait()
+		r.pcancel = nil
+	}
+	r.rmux.Lock()
+	r.chunks = make(map[int]*rchunk)
+	r.chwid, r.chrid = 0, 0
+	r.offset, r.length = r.rstart+pos, -1
+	if r.rlen >= 0 {
+		r.length = r.rlen - pos
+	}
+	r.rmux.Unlock()
+	r.pos, r.read = pos, 0
+	r.readOffEnd = false
+	r.emux.Lock()
+	r.err = nil
+	if r.rlen >= 0 && r.length <= 0 {
+		r.err = io.EOF
+	}
+	r.emux.Unlock()
+	r.init = sync.Once{}
+}
+
+// ReadAt satisfies the io.ReaderAt interface.  It reads len(p) bytes with a
+// single ranged request, starting at off bytes from the beginning of the
+// object, regardless of the range the Reader was created with.  It is safe to
+// call ReadAt concurrently, including with Read.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%s: negative offset %d", r.name, off)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	fr, err := r.download(r.ctx, off, int64(len(p)))
+	if err == errNoMoreContent {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer fr.Close()
+	if err := r.pin(fr.id()); err != nil {
+		return 0, err
+	}
+	rsize, _, _, _ := fr.stats()
+	if rsize > len(p) {
+		rsize = len(p)
+	}
+	n, err := io.ReadFull(fr, p[:rsize])
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	if n < len(p) {
+		// B2 returned everything to the end of the object.
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 func (r *Reader) status() *ReaderStatus {
 	r.smux.Lock()
 	defer r.smux.Unlock()
@@ -282,7 +657,10 @@ func (r *Reader) status() *ReaderStatus {
 // hash was not sent), this returns (nil, false).
 func (r *Reader) Verify() (error, bool) {
 	got := fmt.Sprintf("%x", r.vrfy.Sum(nil))
-	if r.sha1 == got {
+	r.rmux.Lock()
+	want := r.sha1
+	r.rmux.Unlock()
+	if want == got {
 		return nil, true
 	}
 	// TODO: if the exact length of the file is requested AND the checksum is
@@ -290,12 +668,29 @@ func (r *Reader) Verify() (error, bool) {
 	// because there's no good way that I can tell to determine that we've hit
 	// the end of the file without reading off the end.  Consider reading N+1
 	// bytes at the very end to close this hole.
-	if r.offset > 0 || !r.readOffEnd || len(r.sha1) != 40 {
+	if r.offset > 0 || !r.readOffEnd || len(want) != 40 {
 		return nil, false
 	}
-	return fmt.Errorf("bad hash: got %v, want %v", got, r.sha1), true
+	return fmt.Errorf("bad hash: got %v, want %v: %w", got, want, ErrSHA1Mismatch), true
+}
+
+// checkSHA1 verifies the object once it has been read to the end, if
+// VerifySHA1 is set.
+func (r *Reader) checkSHA1() error {
+	if !r.VerifySHA1 {
+		return nil
+	}
+	if err, ok := r.Verify(); ok && err != nil {
+		return fmt.Errorf("%s: %w", r.name, err)
+	}
+	return nil
 }
 
+// rawReader reads a Reader's stored bytes, bypassing AutoDecompress.
+type rawReader struct{ r *Reader }
+
+func (rr rawReader) Read(p []byte) (int, error) { return rr.r.readRaw(p) }
+
 // strip a writer of any non-Write methods
 type onlyWriter struct{ w io.Writer }
 
@@ -328,21 +723,213 @@ func (noopResetter) Reset() error { return nil }
 
 type backoff time.Duration
 
-func (b *backoff) wait(ctx context.Context) error {
+func (b *backoff) wait(ctx context.Context, c clock) error {
 	if *b == 0 {
 		*b = backoff(time.Millisecond)
 	}
-	select {
-	case <-time.After(time.Duration(*b)):
-		if time.Duration(*b) < time.Second*10 {
-			*b <<= 1
-		}
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+	if err := sleep(ctx, c, time.Duration(*b)); err != nil {
+		return err
 	}
+	if time.Duration(*b) < time.Second*10 {
+		*b <<= 1
+	}
+	return nil
 }
 
 func (b backoff) String() string {
 	return time.Duration(b).String()
 }
+
+type downloadOptions struct {
+	offset, length int64
+	sse            *ServerSideEncryption
+	workers        int
+	chunkSize      int64
+	progress       *DownloadProgress
+}
+
+// A DownloadOption changes the behavior of Bucket.DownloadByName or
+// Object.DownloadTo.
+type DownloadOption func(*downloadOptions)
+
+// DownloadRange downloads length bytes of the object, beginning at offset.  If
+// length is zero, the object is read to its end.
+func DownloadRange(offset, length int64) DownloadOption {
+	return func(d *downloadOptions) {
+		d.offset = offset
+		d.length = length
+	}
+}
+
+// DownloadEncryption supplies the key of an object written with SSEC.
+func DownloadEncryption(sse *ServerSideEncryption) DownloadOption {
+	return func(d *downloadOptions) {
+		d.sse = sse
+	}
+}
+
+// DownloadConcurrency sets the number of ranges Object.DownloadTo fetches at
+// once.  Values less than 1 are equivalent to 1, the default.  It is ignored
+// by DownloadByName.
+func DownloadConcurrency(n int) DownloadOption {
+	return func(d *downloadOptions) {
+		d.workers = n
+	}
+}
+
+// DownloadChunkSize sets the size, in bytes, of the ranges in which
+// Object.DownloadTo fetches the object.  The default is 10MB, as for Reader.
+// It is ignored by DownloadByName.
+func DownloadChunkSize(size int64) DownloadOption {
+	return func(d *downloadOptions) {
+		d.chunkSize = size
+	}
+}
+
+// DownloadByName fetches the named object with a single request, returning its
+// contents along with its attributes.  Attrs.Size is the size of the whole
+// object, even when only a range is downloaded.  The body is read under ctx,
+// and must be closed.
+//
+// Unlike Reader, DownloadByName does not retry if the download is interrupted,
+// nor does it verify the object's SHA1.
+func (b *Bucket) DownloadByName(ctx context.Context, name string, opts ...DownloadOption) (io.ReadCloser, *Attrs, error) {
+	var do downloadOptions
+	for _, o := range opts {
+		o(&do)
+	}
+	fr, err := b.b.downloadFileByName(ctx, name, do.offset, do.length, do.sse)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, ct, sha, info := fr.stats()
+	attrs, err := newAttrs(fr.name(), sha, fr.size(), ct, info, "upload", fr.timestamp(), fr.encryption())
+	if err != nil {
+		fr.Close()
+		return nil, nil, err
+	}
+	return fr, attrs, nil
+}
+
+// DownloadTo downloads o into w, writing each byte at its offset in the object,
+// and returns the number of bytes written.  The object is fetched in ranges,
+// as many at once as DownloadConcurrency allows, and each range is written as
+// it arrives, in no particular order; w must therefore allow concurrent calls
+// to WriteAt, as an *os.File does.  With DownloadRange, only that part of the
+// object is fetched, and only that part of w is written, which suits resuming
+// an interrupted download into a preallocated file.
+//
+// Every range is fetched from the same version of the object.  If a range
+// cannot be downloaded, the others are cancelled, and DownloadTo returns a
+// *RangeError describing it, along with the number of bytes written until
+// then, which may come from any of the ranges.  Like DownloadByName, it does
+// not verify the object's SHA1.  To resume a download that fails, see
+// DownloadResume.
+func (o *Object) DownloadTo(ctx context.Context, w io.WriterAt, opts ...DownloadOption) (int64, error) {
+	do := downloadOptions{workers: 1, chunkSize: 1e7}
+	for _, opt := range opts {
+		opt(&do)
+	}
+	if do.workers < 1 {
+		do.workers = 1
+	}
+	if do.chunkSize < 1 {
+		do.chunkSize = 1e7
+	}
+	if err := o.ensure(ctx); err != nil {
+		return 0, err
+	}
+	fi, err := o.f.getFileInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	_, _, end, _, _, _, _ := fi.stats()
+	if do.length > 0 && do.offset+do.length < end {
+		end = do.offset + do.length
+	}
+
+	if do.progress != nil {
+		w = &progressWriter{w: w, p: do.progress}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type span struct {
+		off, size int64
+	}
+	var n int64
+	ch := make(chan span)
+	errc := make(chan error, do.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < do.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range ch {
+				if err := o.downloadRange(ctx, w, s.off, s.size, do.sse, &n); err != nil {
+					errc <- &RangeError{Name: o.name, Offset: s.off, Length: s.size, Err: err}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+feed:
+	for off := do.offset; off < end; off += do.chunkSize {
+		size := do.chunkSize
+		if end-off < size {
+			size = end - off
+		}
+		gaps := []ByteRange{{Offset: off, Length: size}}
+		if do.progress != nil {
+			gaps = do.progress.missing(off, size)
+		}
+		for _, g := range gaps {
+			select {
+			case ch <- span{off: g.Offset, size: g.Length}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(ch)
+	wg.Wait()
+	close(errc)
+	if err := <-errc; err != nil {
+		return atomic.LoadInt64(&n), err
+	}
+	return atomic.LoadInt64(&n), ctx.Err()
+}
+
+// downloadRange writes size bytes of o, beginning at off, to w at the same
+// offset, adding the bytes it writes to n.  As with Reader, a download that
+// ends early is retried, from where it stopped.
+func (o *Object) downloadRange(ctx context.Context, w io.WriterAt, off, size int64, sse *ServerSideEncryption, n *int64) error {
+	var b backoff
+	for {
+		fr, err := o.b.b.downloadFileByID(ctx, o.f.id(), off, size, sse)
+		if err != nil {
+			return err
+		}
+		rsize, _, _, _ := fr.stats()
+		i, err := io.Copy(io.NewOffsetWriter(w, off), fr)
+		fr.Close()
+		o.b.r.metrics().downloaded(i)
+		atomic.AddInt64(n, i)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if err == nil && i >= int64(rsize) {
+			return nil
+		}
+		// Probably the network connection was closed early.  Retry.
+		o.b.c.v(1).Infof("b2 download %s at %d: got %dB of %dB; retrying after %v", o.name, off, i, rsize, b)
+		if err := b.wait(ctx, o.b.r.clock()); err != nil {
+			return err
+		}
+		o.b.r.metrics().retry()
+		off += i
+		size -= i
+	}
+}
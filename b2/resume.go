@@ -0,0 +1,147 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// A ByteRange is Length bytes of an object, beginning at Offset.
+type ByteRange struct {
+	Offset, Length int64
+}
+
+func (r ByteRange) end() int64 { return r.Offset + r.Length }
+
+// A DownloadProgress records the bytes of an object that Object.DownloadTo
+// has written, so that a download that is interrupted, even by a crash, can be
+// resumed without fetching them again.  It is safe for concurrent use; callers
+// may persist its Offset or its Done ranges while the download is running.
+type DownloadProgress struct {
+	mu   sync.Mutex
+	done []ByteRange // sorted, with no two touching
+}
+
+// NewDownloadProgress returns a DownloadProgress recording that the given
+// ranges, such as those persisted from an earlier download, have already been
+// written.
+func NewDownloadProgress(done ...ByteRange) *DownloadProgress {
+	p := &DownloadProgress{}
+	for _, r := range done {
+		p.add(r.Offset, r.Length)
+	}
+	return p
+}
+
+// Done returns the ranges written so far, in order, with adjacent and
+// overlapping ranges merged.
+func (p *DownloadProgress) Done() []ByteRange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ByteRange(nil), p.done...)
+}
+
+// Offset returns the end of the first range Done reports, or zero if there is
+// none.  For a download from the start of an object, every byte before Offset
+// has been written.  It is enough to persist only Offset, and to resume with
+// NewDownloadProgress(ByteRange{Length: offset}), at the cost of fetching
+// again any bytes that were written beyond it.
+func (p *DownloadProgress) Offset() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.done) == 0 {
+		return 0
+	}
+	return p.done[0].end()
+}
+
+// add records that size bytes from off have been written.
+func (p *DownloadProgress) add(off, size int64) {
+	if size <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := ByteRange{Offset: off, Length: size}
+	// Find the first range that r touches or precedes, and fold into r every
+	// range it touches.
+	i := sort.Search(len(p.done), func(i int) bool { return p.done[i].end() >= r.Offset })
+	j := i
+	for ; j < len(p.done) && p.done[j].Offset <= r.end(); j++ {
+		if p.done[j].Offset < r.Offset {
+			r.Length += r.Offset - p.done[j].Offset
+			r.Offset = p.done[j].Offset
+		}
+		if e := p.done[j].end(); e > r.end() {
+			r.Length = e - r.Offset
+		}
+	}
+	p.done = append(p.done[:i], append([]ByteRange{r}, p.done[j:]...)...)
+}
+
+// missing returns the parts of the size bytes from off that have not been
+// written.
+func (p *DownloadProgress) missing(off, size int64) []ByteRange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var gaps []ByteRange
+	cur, end := off, off+size
+	for _, r := range p.done {
+		if r.end() <= cur {
+			continue
+		}
+		if r.Offset >= end {
+			break
+		}
+		if r.Offset > cur {
+			gaps = append(gaps, ByteRange{Offset: cur, Length: r.Offset - cur})
+		}
+		cur = r.end()
+	}
+	if cur < end {
+		gaps = append(gaps, ByteRange{Offset: cur, Length: end - cur})
+	}
+	return gaps
+}
+
+// DownloadResume has Object.DownloadTo skip the bytes p records as written,
+// fetching only the rest with ranged requests, and record in p every byte it
+// writes, as soon as it is written.  The count DownloadTo returns includes
+// only the bytes written by that call.
+//
+// To resume after a failure, call DownloadTo again with the same p, or, after a
+// crash, with one made by NewDownloadProgress from the persisted ranges, and
+// the same w.  Resuming is only correct if the object has not changed in the
+// meantime; callers may compare its Attrs, such as its SHA1 or upload time,
+// with those of the first attempt.
+func DownloadResume(p *DownloadProgress) DownloadOption {
+	return func(d *downloadOptions) {
+		d.progress = p
+	}
+}
+
+// progressWriter records in p each write to w.
+type progressWriter struct {
+	w io.WriterAt
+	p *DownloadProgress
+}
+
+func (pw *progressWriter) WriteAt(b []byte, off int64) (int, error) {
+	n, err := pw.w.WriteAt(b, off)
+	pw.p.add(off, int64(n))
+	return n, err
+}
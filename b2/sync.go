@@ -0,0 +1,234 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// syncWorkers is the default number of files Sync checks and uploads at once.
+const syncWorkers = 4
+
+type syncOptions struct {
+	prefix  string
+	delete  bool
+	workers int
+	wopts   []WriterOption
+}
+
+// A SyncOption changes the behavior of Bucket.Sync.
+type SyncOption func(*syncOptions)
+
+// SyncPrefix mirrors the directory under prefix, so that the file a/b is
+// written as the object prefix + "a/b".  Only objects under prefix are
+// compared with the directory, or deleted with SyncDelete.  A prefix meant as
+// a directory should end in "/".
+func SyncPrefix(prefix string) SyncOption {
+	return func(s *syncOptions) {
+		s.prefix = prefix
+	}
+}
+
+// SyncDelete has Sync delete every version of objects under the prefix that
+// have no matching local file, with DeleteAllVersions.
+func SyncDelete() SyncOption {
+	return func(s *syncOptions) {
+		s.delete = true
+	}
+}
+
+// SyncConcurrency sets the number of files that are hashed and uploaded at
+// once.  Values less than 1 are equivalent to 1; the default is 4.
+func SyncConcurrency(n int) SyncOption {
+	return func(s *syncOptions) {
+		s.workers = n
+	}
+}
+
+// SyncWriterOptions applies opts to the Writer of each uploaded file.
+func SyncWriterOptions(opts ...WriterOption) SyncOption {
+	return func(s *syncOptions) {
+		s.wopts = append(s.wopts, opts...)
+	}
+}
+
+// SyncResult describes what Sync did.
+type SyncResult struct {
+	Uploaded  []string // names of the objects written, sorted
+	Deleted   []string // names of the objects deleted, sorted
+	Unchanged int      // the number of files that matched their objects
+}
+
+// Sync mirrors the regular files under the local directory dir to the bucket.
+// Each file is written as an object named by its slash-separated path relative
+// to dir, unless the object already exists with the same size and SHA1, in
+// which case it is left alone.  Files are hashed and uploaded concurrently;
+// see SyncConcurrency.  Uploaded objects record the file's modification time
+// as their LastModified, and a large object stores its SHA1 so that the next
+// Sync can compare it.  Symbolic links and other irregular files are skipped.
+//
+// If any file fails to upload or delete, Sync returns the errors joined
+// together, after trying the rest, along with a SyncResult describing what it
+// did.
+func (b *Bucket) Sync(ctx context.Context, dir string, opts ...SyncOption) (*SyncResult, error) {
+	s := &syncOptions{workers: syncWorkers}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.workers < 1 {
+		s.workers = 1
+	}
+
+	remote := make(map[string]*Object)
+	iter := b.List(ctx, ListPrefix(s.prefix))
+	for iter.Next() {
+		o := iter.Object()
+		remote[o.Name()] = o
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]string) // object name to file path
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		local[s.prefix+filepath.ToSlash(rel)] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SyncResult{}
+	var mu sync.Mutex // guards res and errs
+	var errs []error
+	record := func(name string, uploaded bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		case uploaded:
+			res.Uploaded = append(res.Uploaded, name)
+		default:
+			res.Unchanged++
+		}
+	}
+
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range ch {
+				uploaded, err := b.syncFile(ctx, name, local[name], remote[name], s.wopts)
+				record(name, uploaded, err)
+			}
+		}()
+	}
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			record(name, false, err)
+			break
+		}
+		ch <- name
+	}
+	close(ch)
+	wg.Wait()
+
+	if s.delete {
+		for name := range remote {
+			if _, ok := local[name]; ok {
+				continue
+			}
+			if err := b.DeleteAllVersions(ctx, name); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			res.Deleted = append(res.Deleted, name)
+		}
+	}
+	sort.Strings(res.Uploaded)
+	sort.Strings(res.Deleted)
+	return res, errors.Join(errs...)
+}
+
+// syncFile uploads the file at p as the named object, unless o, which may be
+// nil, already holds the same content.  It reports whether it uploaded.
+func (b *Bucket) syncFile(ctx context.Context, name, p string, o *Object, wopts []WriterOption) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if o != nil {
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			return false, err
+		}
+		if attrs.Size == fi.Size() && len(attrs.SHA1) == 40 {
+			h := sha1.New()
+			if _, err := io.Copy(h, f); err != nil {
+				return false, err
+			}
+			if fmt.Sprintf("%x", h.Sum(nil)) == attrs.SHA1 {
+				return false, nil
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+		}
+	}
+	w := b.Object(name).NewWriter(ctx, wopts...)
+	w.LargeFileSHA1 = true
+	w.LastModified = fi.ModTime()
+	if _, err := w.ReadFrom(f); err != nil {
+		w.Abort(ctx)
+		return false, err
+	}
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+	b.c.v(2).Infof("sync: uploaded %s as %s", p, name)
+	return true, nil
+}
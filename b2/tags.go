@@ -0,0 +1,78 @@
+// Copyright 2018, Google
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package b2
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TagsInfoKey is the Info key in which an object's tags are stored, as a
+// comma-separated list.  Commas and percent signs within a tag are
+// percent-encoded.  All of an object's tags share the one key, so that they
+// count only once against the limit of ten.
+const TagsInfoKey = "tags"
+
+// SetTags has the Writer store tags with the object, in its TagsInfoKey info
+// key, replacing any given with WithAttrs.  Empty tags are dropped, and if
+// none remain, no tags are stored.  Tags may hold any characters, but B2
+// limits the total size of an object's info.  SetTags must be called before
+// the first Write.
+func (w *Writer) SetTags(tags []string) {
+	w.tags = encodeTags(tags)
+	w.tagsSet = true
+}
+
+// Tags returns the tags the object was written with; see Writer.SetTags.
+func (o *Object) Tags(ctx context.Context) ([]string, error) {
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := decodeTags(attrs.Info[TagsInfoKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.name, err)
+	}
+	return tags, nil
+}
+
+var tagEscaper = strings.NewReplacer("%", "%25", ",", "%2C")
+
+func encodeTags(tags []string) string {
+	var enc []string
+	for _, tag := range tags {
+		if tag != "" {
+			enc = append(enc, tagEscaper.Replace(tag))
+		}
+	}
+	return strings.Join(enc, ",")
+}
+
+func decodeTags(v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var tags []string
+	for _, enc := range strings.Split(v, ",") {
+		tag, err := url.PathUnescape(enc)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tags %q: %w", v, err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
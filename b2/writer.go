@@ -15,23 +15,36 @@
 package b2
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/kurin/blazer/internal/blog"
+	"unicode/utf8"
 )
 
 // Writer writes data into Backblaze.  It automatically switches to the large
-// file API if the file exceeds ChunkSize bytes.  Due to that and other
+// file API if the file exceeds ChunkSize bytes, unless configured otherwise
+// with UseLargeFile or LargeFileThreshold.  Due to that and other
 // Backblaze API details, there is a large buffer.
 //
 // Changes to public Writer attributes must be made before the first call to
 // Write.
+//
+// Write, ReadFrom, Flush, and Close may be called from different goroutines;
+// each call waits for any other to return, so a Close made while a Write is in
+// progress finishes the object with everything that Write wrote.  Calls made
+// after Close fail with ErrWriterClosed.  Concurrent Writes are not
+// interleaved, but their order is not defined.
 type Writer struct {
 	// ConcurrentUploads is number of different threads sending data concurrently
 	// to Backblaze for large files.  This can increase performance greatly, as
@@ -46,11 +59,56 @@ type Writer struct {
 
 	// ChunkSize is the size, in bytes, of each individual part, when writing
 	// large files, and also when determining whether to upload a file normally
-	// or when to split it into parts.  The default is 100M (1e8)  The minimum is
-	// 5M (5e6); values less than this are not an error, but will fail.  The
-	// maximum is 5GB (5e9).
+	// or when to split it into parts.  The default is the part size B2
+	// recommends on authorization (currently 100M, 1e8), or 1e8 if it
+	// recommends none.  The minimum is the smallest part size B2 reports on
+	// authorization (currently 5M); values less than this, including the
+	// recommended size, are raised to the minimum.  The maximum is 5GB (5e9).
+	//
+	// Each concurrent upload holds a buffer of ChunkSize bytes, so lowering it
+	// reduces the memory footprint of a Writer.
 	ChunkSize int
 
+	// UseLargeFile, if set, overrides the Writer's choice of whether to use the
+	// large file API.  If true, every object is sent with the large file API,
+	// even if it is smaller than ChunkSize.  If false, the large file API is
+	// never used, and the entire object is buffered and sent in one request;
+	// B2 limits such objects to 5GB.
+	UseLargeFile *bool
+
+	// LargeFileThreshold is the size, in bytes, above which objects are sent
+	// with the large file API.  The default is ChunkSize.  If it is greater than
+	// ChunkSize, the first part of a large file holds LargeFileThreshold bytes;
+	// since nothing is uploaded until that first part is full,
+	// ConcurrentUploads has no effect on objects smaller than the threshold.
+	// It is ignored if UseLargeFile is set.
+	LargeFileThreshold int64
+
+	// LargeFileSHA1, if true, has the Writer compute the SHA1 of a large
+	// object's entire content and store it as the large_file_sha1 info key,
+	// which B2 reports as the object's SHA1; the per-part hashes B2 checks do
+	// not give one.  B2 accepts file info only when a large file is started,
+	// before its parts are sent, so the hash can be computed only when
+	// ReadFrom is given an io.ReadSeeker, which is read through once to hash
+	// it before the upload begins.  LargeFileSHA1 has no effect on other
+	// uploads, or if a SHA1 was given with WithAttrs.
+	LargeFileSHA1 bool
+
+	// SHA1, if set, is the hex-encoded SHA1 of the object's entire content,
+	// for callers that already know it.  An object sent in one request is
+	// sent with this hash instead of one the Writer computes, and B2 rejects
+	// the upload, with an error wrapping ErrSHA1Mismatch, if it doesn't match
+	// the bytes received.  A large object instead stores it as its
+	// large_file_sha1, unless one was given with WithAttrs.
+	SHA1 string
+
+	// PartSHA1s, if set, holds the hex-encoded SHA1s of a large object's
+	// parts, by part number, for callers that already know them; parts whose
+	// hash is given are not hashed by the Writer.  Every part holds ChunkSize
+	// bytes, except the first, which holds LargeFileThreshold bytes if that is
+	// larger, and the last.  As with SHA1, B2 rejects parts that don't match.
+	PartSHA1s map[int]string
+
 	// UseFileBuffer controls whether to use an in-memory buffer (the default) or
 	// scratch space on the file system.  If this is true, b2 will save chunks in
 	// FileBufferDir.
@@ -60,8 +118,114 @@ type Writer struct {
 	// blank, os.TempDir() is used.
 	FileBufferDir string
 
+	// UploadAttempts is the number of times each part of a large file, or the
+	// whole of a small one, is sent before the Writer gives up, when B2 keeps
+	// asking for it to be sent again.  The default is 10.  The client's Backoff
+	// may give up sooner.
+	UploadAttempts int
+
+	// DisableRetry, if true, has the Writer send each part of a large file,
+	// or the whole of a small one, only once, and fail with the error B2
+	// returns, rather than fetch a new upload URL and send it again.  It is
+	// for callers that retry whole uploads themselves, and overrides
+	// UploadAttempts.  Other requests, such as those for upload URLs, are
+	// still retried as the client's Backoff allows.
+	DisableRetry bool
+
+	// PartTimeout, if positive, limits how long each attempt to send a part of
+	// a large file may take.  A part that takes longer is sent again, on a new
+	// upload URL, as if B2 had timed out the request itself; it counts as an
+	// attempt toward UploadAttempts.  A client ExponentialBackoff sets only how
+	// long to wait before it is sent again, whatever its Retryable, while other
+	// Backoffs see it as a 408.  Without it, a stalled part holds up the upload
+	// until the Writer's context is done.
+	PartTimeout time.Duration
+
+	// OnProgress, if set, is called each time data is successfully sent to B2,
+	// with the number of bytes uploaded so far and the total size of the
+	// object.  The total is -1 if it is not known, which is generally the case
+	// for large files written with Write.  Calls are serialized, but may be made
+	// from different goroutines.
+	OnProgress func(complete, total int64)
+
+	// ContentDisposition, ContentLanguage, ContentEncoding, CacheControl, and
+	// Expires, if set, are saved with the object, and B2 returns them as the
+	// corresponding headers when the object is downloaded.  Each is stored as
+	// a "b2-*" key in the object's Info, and so counts against the limit of
+	// ten keys.  ContentEncoding describes data already encoded by the caller,
+	// such as "gzip"; to have the Writer compress the data, set Compress.
+	ContentDisposition string
+	ContentLanguage    string
+	ContentEncoding    string
+	CacheControl       string
+	Expires            time.Time
+
+	// Compress, if true, has the Writer gzip the data as it is written and
+	// store the object with a ContentEncoding of "gzip", overriding any other.
+	// B2 stores, and hashes, the compressed bytes, so the object's size and
+	// SHA1 are those of the compressed data, and SHA1 and PartSHA1s, if given,
+	// must describe it too.  A Reader with AutoDecompress set returns the
+	// original bytes.  With Compress, ReadFrom buffers its input even if it is
+	// an io.ReadSeeker.
+	Compress bool
+
+	// AutoContentType, if true, has the Writer choose the content type of an
+	// object written without one, instead of application/octet-stream.  The
+	// type is taken from the extension of the object's name, if it has a known
+	// one, and otherwise from the first 512 bytes of the data, as
+	// http.DetectContentType sees them.  The data isn't examined if Compress
+	// is set, nor for a PartWriter, which starts before any data is written.
+	AutoContentType bool
+
+	// LastModified, if set, is saved as the object's src_last_modified_millis
+	// info key, which B2 and its tools take as the modification time of the
+	// object's source, such as a file being migrated.  It is returned as the
+	// LastModified field of the object's Attrs, to the millisecond, and
+	// overrides any LastModified given with WithAttrs.
+	LastModified time.Time
+
+	// ServerSideEncryption, if set, asks B2 to encrypt the object at rest.  If
+	// nil, the bucket's default encryption setting applies.  Objects written
+	// with SSEC can only be read by a Reader given the same key.
+	ServerSideEncryption *ServerSideEncryption
+
+	// Retention and LegalHold, if set, place the object under Object Lock, so
+	// that it cannot be deleted or overwritten until its retention expires or
+	// the hold is lifted.  The bucket must have Object Lock enabled; if it
+	// doesn't, the Writer fails with ErrObjectLockDisabled.
+	Retention *Retention
+	LegalHold bool
+
+	// KeepVersions, if positive, has Close delete older versions of the
+	// object once it is written, so that only the newest KeepVersions
+	// versions, including the new one, remain; see Bucket.PruneVersions.  If
+	// pruning fails, Close returns the error, though the object was written.
+	KeepVersions int
+
+	// ExpectedSize, if positive, is the number of bytes the caller means to
+	// write.  If Close finds that a different number was written, as when the
+	// source was cut short, it fails with an error wrapping ErrSizeMismatch
+	// instead of finishing the object, and cancels any large file begun.
+	// ReadFrom given an io.ReadSeeker checks its size before sending any of
+	// it.  With Compress, the bytes counted are those given to the Writer, not
+	// the compressed ones.
+	ExpectedSize int64
+
+	// IfNotExists, if true, has the Writer fail with an error wrapping
+	// ErrAlreadyExists, and write nothing, if the object already has a current
+	// version: one that is uploaded and not hidden.  B2 has no conditional
+	// upload, so the Writer lists the object just before it begins the upload,
+	// when the first part of a large object is full or, for other objects, at
+	// Close.  This narrows the race with other writers but does not close it:
+	// an object written by someone else between the check and the end of the
+	// upload is overwritten, becoming an older version.  A large file resumed
+	// with Resume is not checked again.
+	IfNotExists bool
+
 	contentType string
 	info        map[string]string
+	tags        string // encoded, as set by SetTags
+	tagsSet     bool
 
 	csize       int
 	ctx         context.Context
@@ -72,9 +236,19 @@ type Writer struct {
 	once        sync.Once
 	done        sync.Once
 	file        beLargeFileInterface
+	resumeID    string
 	seen        map[int]string
 	everStarted bool
 	newBuffer   func() (writeBuffer, error)
+	pending     sync.WaitGroup // parts sent to threads but not yet uploaded
+
+	umux  sync.Mutex
+	purls []beFileChunkInterface // idle upload part URLs
+
+	wmux    sync.Mutex   // serializes Write, ReadFrom, Flush, and Close
+	closed  bool         // Close has been called; guarded by wmux
+	gz      *gzip.Writer // compresses writes, with Compress
+	written int64        // bytes given to Write and ReadFrom
 
 	o    *Object
 	name string
@@ -87,6 +261,15 @@ type Writer struct {
 
 	smux sync.RWMutex
 	smap map[int]*meteredReader
+	sums map[int]string
+
+	pmux  sync.Mutex
+	pdone int64
+	ptot  int64
+
+	// Counted for UploadStats.
+	urlFetches, urlReuses int64
+	tparts                []int64 // parts sent by each upload thread
 }
 
 type chunk struct {
@@ -101,7 +284,7 @@ func (w *Writer) setErr(err error) {
 	w.emux.Lock()
 	defer w.emux.Unlock()
 	if w.err == nil {
-		blog.V(1).Infof("error writing %s: %v", w.name, err)
+		w.o.b.c.v(1).Errorf("error writing %s: %v", w.name, err)
 		w.err = err
 		w.cancel()
 	}
@@ -113,82 +296,230 @@ func (w *Writer) getErr() error {
 	return w.err
 }
 
+func (w *Writer) progress(n int64) {
+	if w.OnProgress == nil {
+		return
+	}
+	w.pmux.Lock()
+	defer w.pmux.Unlock()
+	w.pdone += n
+	w.OnProgress(w.pdone, w.ptot)
+}
+
 func (w *Writer) registerChunk(id int, r *meteredReader) {
 	w.smux.Lock()
 	w.smap[id] = r
 	w.smux.Unlock()
 }
 
+// recordHash notes the locally computed hash of a part, to be checked against
+// B2's before the large file is finished.
+func (w *Writer) recordHash(id int, sha string) {
+	if sha == "hex_digits_at_end" {
+		// B2 checks these itself.
+		return
+	}
+	w.smux.Lock()
+	w.sums[id] = sha
+	w.smux.Unlock()
+}
+
+func (w *Writer) verifyHashes() error {
+	w.smux.RLock()
+	defer w.smux.RUnlock()
+	got := w.file.hashes()
+	for id, sha := range w.sums {
+		if got[id] != sha {
+			return fmt.Errorf("%s: part %d: B2 reported %q, want %q: %w", w.name, id, got[id], sha, ErrSHA1Mismatch)
+		}
+	}
+	return nil
+}
+
 func (w *Writer) completeChunk(id int) {
 	w.smux.Lock()
 	w.smap[id] = nil
 	w.smux.Unlock()
 }
 
+// completePart marks a part of a large file as no longer in flight, whether or
+// not it was uploaded.
+func (w *Writer) completePart(id int) {
+	w.completeChunk(id)
+	w.pending.Done()
+}
+
 var gid int32
 
-func (w *Writer) thread() {
+// partSHA1 returns the SHA1 to send with chunk: the one given in PartSHA1s, if
+// any, or else the hash of its buffer.
+func (w *Writer) partSHA1(chunk chunk) string {
+	if sha, ok := w.PartSHA1s[chunk.id]; ok {
+		if nb, ok := chunk.buf.(*nonBuffer); ok {
+			nb.useHash(sha)
+		}
+		return sha
+	}
+	return chunk.buf.Hash()
+}
+
+// getPartURL returns an idle upload part URL for the large file, or a new one if
+// none is idle.  B2 allows each URL only one upload at a time, so a URL is
+// borrowed by one part upload until it is returned with putPartURL.
+func (w *Writer) getPartURL() (beFileChunkInterface, error) {
+	w.umux.Lock()
+	if n := len(w.purls); n > 0 {
+		fc := w.purls[n-1]
+		w.purls = w.purls[:n-1]
+		w.umux.Unlock()
+		w.countURL(true)
+		return fc, nil
+	}
+	w.umux.Unlock()
+	return w.newPartURL()
+}
+
+// newPartURL asks B2 for a new upload part URL.
+func (w *Writer) newPartURL() (beFileChunkInterface, error) {
+	w.countURL(false)
+	return w.file.getUploadPartURL(w.ctx)
+}
+
+// countURL records the use of an upload URL that was reused, or else fetched,
+// for the client's Metrics and the Writer's UploadStats.
+func (w *Writer) countURL(reused bool) {
+	if reused {
+		atomic.AddInt64(&w.urlReuses, 1)
+		w.o.b.r.metrics().urlReuse()
+		return
+	}
+	atomic.AddInt64(&w.urlFetches, 1)
+	w.o.b.r.metrics().urlFetch()
+}
+
+// putPartURL returns a URL that has just uploaded a part to the idle pool.
+// URLs that failed are dropped instead, since B2 may have given up on them.
+func (w *Writer) putPartURL(fc beFileChunkInterface) {
+	w.umux.Lock()
+	defer w.umux.Unlock()
+	w.purls = append(w.purls, fc)
+}
+
+// errPartTimeout is wrapped by the errors of part uploads that exceed a
+// Writer's PartTimeout.
+var errPartTimeout = errors.New("part upload timed out")
+
+// uploadPart sends one attempt at part id with fc, abandoning it after
+// PartTimeout, if one is set.
+func (w *Writer) uploadPart(fc beFileChunkInterface, r readResetter, sha string, size, id int) (int, error) {
+	ctx := w.ctx
+	if w.PartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(w.ctx, w.PartTimeout)
+		defer cancel()
+	}
+	n, err := fc.uploadPart(ctx, r, sha, size, id, w.ServerSideEncryption)
+	if err != nil && w.ctx.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("no reply within %v: %w", w.PartTimeout, errPartTimeout)
+	}
+	return n, err
+}
+
+// rejectedSHA1 reports whether err is B2 refusing an upload because the data
+// it received didn't match the SHA1 sent with it.
+func rejectedSHA1(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == 400 && strings.Contains(strings.ToLower(e.Message), "sha1")
+}
+
+// thread starts upload thread ti.
+func (w *Writer) thread(ti int) {
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
 		id := atomic.AddInt32(&gid, 1)
-		fc, err := w.file.getUploadPartURL(w.ctx)
-		if err != nil {
-			w.setErr(err)
-			return
-		}
 		for {
 			chunk, ok := <-w.ready
 			if !ok {
 				return
 			}
-			if sha, ok := w.seen[chunk.id]; ok {
-				if sha != chunk.buf.Hash() {
+			sha := w.partSHA1(chunk)
+			if seen, ok := w.seen[chunk.id]; ok {
+				if seen != sha {
 					w.setErr(errors.New("resumable upload was requested, but chunks don't match"))
+					w.completePart(chunk.id)
+					chunk.buf.Close() // TODO: log error
 					return
 				}
+				w.progress(chunkSize(chunk.buf))
 				chunk.buf.Close()
-				w.completeChunk(chunk.id)
-				blog.V(2).Infof("skipping chunk %d", chunk.id)
+				w.completePart(chunk.id)
+				w.o.b.c.v(2).Infof("skipping chunk %d", chunk.id)
 				continue
 			}
-			blog.V(2).Infof("thread %d handling chunk %d", id, chunk.id)
+			w.o.b.c.v(2).Infof("thread %d handling chunk %d", id, chunk.id)
+			fc, err := w.getPartURL()
+			if err != nil {
+				w.setErr(fmt.Errorf("%s: part %d: getting an upload URL: %w", w.name, chunk.id, err))
+				w.completePart(chunk.id)
+				chunk.buf.Close() // TODO: log error
+				return
+			}
 			r, err := chunk.buf.Reader()
 			if err != nil {
-				w.setErr(err)
+				w.setErr(fmt.Errorf("%s: part %d: %w", w.name, chunk.id, err))
+				w.completePart(chunk.id)
+				chunk.buf.Close() // TODO: log error
 				return
 			}
 			mr := &meteredReader{r: r, size: chunk.buf.Len()}
 			w.registerChunk(chunk.id, mr)
-			sleep := time.Millisecond * 15
+			var attempt int
+			var wait time.Duration
 		redo:
-			n, err := fc.uploadPart(w.ctx, mr, chunk.buf.Hash(), chunk.buf.Len(), chunk.id)
+			attempt++
+			n, err := w.uploadPart(fc, mr, sha, chunk.buf.Len(), chunk.id)
 			if n != chunk.buf.Len() || err != nil {
-				if w.o.b.r.reupload(err) {
-					time.Sleep(sleep)
-					sleep *= 2
-					if sleep > time.Second*15 {
-						sleep = time.Second * 15
+				d, ok, err := w.retryUpload(attempt, wait, err)
+				if ok {
+					wait = d
+					if err := sleep(w.ctx, w.o.b.r.clock(), wait); err != nil {
+						w.setErr(fmt.Errorf("%s: part %d: %w", w.name, chunk.id, err))
+						w.completePart(chunk.id)
+						chunk.buf.Close() // TODO: log error
+						return
 					}
-					blog.V(1).Infof("b2 writer: wrote %d of %d: error: %v; retrying", n, chunk.buf.Len(), err)
-					f, err := w.file.getUploadPartURL(w.ctx)
+					w.o.b.r.metrics().retry()
+					w.o.b.c.v(1).Infof("b2 writer: wrote %d of %d: error: %v; retrying", n, chunk.buf.Len(), err)
+					f, err := w.newPartURL()
 					if err != nil {
-						w.setErr(err)
-						w.completeChunk(chunk.id)
+						w.setErr(fmt.Errorf("%s: part %d: getting an upload URL: %w", w.name, chunk.id, err))
+						w.completePart(chunk.id)
 						chunk.buf.Close() // TODO: log error
 						return
 					}
 					fc = f
 					goto redo
 				}
+				if rejectedSHA1(err) {
+					err = fmt.Errorf("%s: part %d: B2 rejected SHA1 %q: %w", w.name, chunk.id, sha, ErrSHA1Mismatch)
+				} else {
+					err = fmt.Errorf("%s: part %d: %w", w.name, chunk.id, err)
+				}
 				w.setErr(err)
-				w.completeChunk(chunk.id)
+				w.completePart(chunk.id)
 				chunk.buf.Close() // TODO: log error
 				return
 			}
-			w.completeChunk(chunk.id)
+			w.putPartURL(fc)
+			w.recordHash(chunk.id, sha)
+			atomic.AddInt64(&w.tparts[ti], 1)
+			w.o.b.r.metrics().part()
+			w.o.b.r.metrics().uploaded(chunk.buf.Len())
+			w.progress(chunkSize(chunk.buf))
+			w.completePart(chunk.id)
 			chunk.buf.Close() // TODO: log error
-			blog.V(2).Infof("chunk %d handled", chunk.id)
+			w.o.b.c.v(2).Infof("chunk %d handled", chunk.id)
 		}
 	}()
 }
@@ -198,14 +529,23 @@ func (w *Writer) init() {
 		w.everStarted = true
 		w.smux.Lock()
 		w.smap = make(map[int]*meteredReader)
+		w.sums = make(map[int]string)
 		w.smux.Unlock()
 		w.o.b.c.addWriter(w)
+		w.ptot = -1
 		w.csize = w.ChunkSize
+		if w.csize == 0 {
+			w.csize = w.o.b.r.recommendedPartSize()
+		}
 		if w.csize == 0 {
 			w.csize = 1e8
 		}
+		if min := w.o.b.r.minPartSize(); w.csize < min {
+			w.o.b.c.v(1).Infof("b2 writer: chunk size %d is below the minimum part size; using %d", w.csize, min)
+			w.csize = min
+		}
 		if w.newBuffer == nil {
-			w.newBuffer = func() (writeBuffer, error) { return newMemoryBuffer(), nil }
+			w.newBuffer = func() (writeBuffer, error) { return newMemoryBuffer(w.bufferSize()), nil }
 			if w.UseFileBuffer {
 				w.newBuffer = func() (writeBuffer, error) { return newFileBuffer(w.FileBufferDir) }
 			}
@@ -219,57 +559,281 @@ func (w *Writer) init() {
 	})
 }
 
+// ErrWriterClosed is returned by a Writer's Write, ReadFrom, and Flush methods
+// after Close has been called.
+var ErrWriterClosed = errors.New("b2: write to closed Writer")
+
 // Write satisfies the io.Writer interface.
 func (w *Writer) Write(p []byte) (int, error) {
+	w.wmux.Lock()
+	defer w.wmux.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("%s: %w", w.name, ErrWriterClosed)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.Compress {
+		if err := w.getErr(); err != nil {
+			return 0, err
+		}
+		n, err := w.gzipWriter().Write(p)
+		w.written += int64(n)
+		return n, err
+	}
+	n, err := w.write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// gzipWriter returns the gzip.Writer that compresses the data written to w,
+// creating it on first use.
+func (w *Writer) gzipWriter() *gzip.Writer {
+	if w.gz == nil {
+		w.gz = gzip.NewWriter(rawWriter{w})
+	}
+	return w.gz
+}
+
+// rawWriter writes to a Writer's buffers, bypassing Compress.
+type rawWriter struct{ w *Writer }
+
+func (rw rawWriter) Write(p []byte) (int, error) { return rw.w.write(p) }
+
+// write buffers p, sending each part as it fills.
+func (w *Writer) write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 	w.init()
-	if err := w.getErr(); err != nil {
-		return 0, err
+	var n int
+	for len(p) > 0 {
+		if err := w.getErr(); err != nil {
+			return n, err
+		}
+		// Don't wait for the buffer to fill before noticing cancellation.
+		if err := w.ctx.Err(); err != nil {
+			w.setErr(err)
+			return n, err
+		}
+		// Buffer p if it doesn't fill the chunk.  Otherwise fill the chunk and
+		// send it; if that used all of p, as when len(p) == left, we're done.
+		left := w.partLimit(w.cidx+1) - w.w.Len()
+		if len(p) < left {
+			i, err := w.w.Write(p)
+			w.setErr(err)
+			return n + i, err
+		}
+		i, err := w.w.Write(p[:left])
+		n += i
+		if err != nil {
+			w.setErr(err)
+			return n, err
+		}
+		if err := w.sendChunk(); err != nil {
+			w.setErr(err)
+			return n, w.getErr()
+		}
+		p = p[left:]
+	}
+	return n, nil
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// partLimit returns the number of bytes the Writer buffers for the given part
+// before sending it.
+func (w *Writer) partLimit(id int) int {
+	if w.UseLargeFile != nil {
+		if !*w.UseLargeFile {
+			return maxInt
+		}
+		return w.csize
 	}
-	left := w.csize - w.w.Len()
-	if len(p) < left {
-		return w.w.Write(p)
+	if id == 1 && w.LargeFileThreshold > int64(w.csize) {
+		return int(w.LargeFileThreshold)
 	}
-	i, err := w.w.Write(p[:left])
-	if err != nil {
-		w.setErr(err)
-		return i, err
+	return w.csize
+}
+
+// bufferSize returns the number of bytes to reserve for the next buffer.  The
+// first buffer may hold a small object, so it is left to grow as needed.
+func (w *Writer) bufferSize() int {
+	if w.cidx == 0 && (w.UseLargeFile == nil || !*w.UseLargeFile) {
+		return 0
 	}
-	if err := w.sendChunk(); err != nil {
-		w.setErr(err)
-		return i, w.getErr()
+	return w.partLimit(w.cidx + 1)
+}
+
+// useLargeFile reports whether an object of the given size, which is smaller
+// than its first part, should nevertheless be sent with the large file API.
+func (w *Writer) useLargeFile(size int64) bool {
+	if size == 0 {
+		return false
+	}
+	if w.UseLargeFile != nil {
+		return *w.UseLargeFile
+	}
+	return w.LargeFileThreshold > 0 && size > w.LargeFileThreshold
+}
+
+// contentEncoding returns the Content-Encoding to save with the object.
+func (w *Writer) contentEncoding() string {
+	if w.Compress {
+		return "gzip"
+	}
+	return w.ContentEncoding
+}
+
+// fileInfo returns the info to save with the object.
+func (w *Writer) fileInfo() (map[string]string, error) {
+	info := make(map[string]string)
+	for k, v := range w.info {
+		info[k] = v
+	}
+	hdrs := map[string]string{
+		"b2-content-disposition": w.ContentDisposition,
+		"b2-content-language":    w.ContentLanguage,
+		"b2-content-encoding":    w.contentEncoding(),
+		"b2-cache-control":       w.CacheControl,
+	}
+	if !w.Expires.IsZero() {
+		hdrs["b2-expires"] = w.Expires.UTC().Format(http.TimeFormat)
+	}
+	for k, v := range hdrs {
+		if v != "" {
+			info[k] = v
+		}
+	}
+	if !w.LastModified.IsZero() {
+		info["src_last_modified_millis"] = fmt.Sprintf("%d", w.LastModified.UnixNano()/1e6)
+	}
+	if w.tagsSet {
+		delete(info, TagsInfoKey)
+		if w.tags != "" {
+			info[TagsInfoKey] = w.tags
+		}
 	}
-	k, err := w.Write(p[left:])
+	if err := validateInfo(w.name, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+var infoKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,50}$`)
+
+func validateInfo(name string, info map[string]string) error {
+	if len(info) > 10 {
+		return fmt.Errorf("%s: %d info keys: %w", name, len(info), ErrTooManyInfoKeys)
+	}
+	for k := range info {
+		if !infoKey.MatchString(k) {
+			return fmt.Errorf("%s: %q: %w", name, k, ErrInvalidInfoKey)
+		}
+	}
+	return nil
+}
+
+// Validate checks, without uploading anything, that the Writer's object could
+// be written as configured: that its name, content type, info, SHA1, and
+// Object Lock settings are acceptable to B2, and that the bucket exists and
+// the client may upload to it.  The last is found by asking B2 for an upload
+// URL, which the Writer keeps for the upload itself.  Validate should be
+// called after the Writer is configured and before the first Write.
+//
+// Validate cannot catch every failure; B2 may still reject the upload, for
+// instance if the account's storage cap is reached.
+func (w *Writer) Validate(ctx context.Context) error {
+	if err := validateName(w.name); err != nil {
+		return err
+	}
+	if ct := w.contentType; ct != "" && ct != "b2/x-auto" {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			return fmt.Errorf("%s: content type %q: %w", w.name, ct, err)
+		}
+	}
+	if _, err := w.fileInfo(); err != nil {
+		return err
+	}
+	if w.SHA1 != "" && !sha1Hex.MatchString(w.SHA1) {
+		return fmt.Errorf("%s: %q is not a hex SHA1", w.name, w.SHA1)
+	}
+	if r := w.Retention; r != nil {
+		if r.Mode != Governance && r.Mode != Compliance {
+			return fmt.Errorf("%s: unknown retention mode %q", w.name, r.Mode)
+		}
+		if !r.RetainUntil.After(w.o.b.r.clock().Now()) {
+			return fmt.Errorf("%s: retention until %v has already passed", w.name, r.RetainUntil)
+		}
+	}
+	if w.Retention != nil || w.LegalHold {
+		if err := w.o.b.checkObjectLock(w.name); err != nil {
+			return err
+		}
+	}
+	w.countURL(false)
+	u, err := w.o.b.b.getUploadURL(ctx)
 	if err != nil {
-		w.setErr(err)
+		return err
 	}
-	return i + k, err
+	w.o.b.urlPool.put(u)
+	return nil
+}
+
+var sha1Hex = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// validateName returns an error wrapping ErrInvalidObjectName if B2 would not
+// accept name.
+func validateName(name string) error {
+	if name == "" || len(name) > 1024 || !utf8.ValidString(name) {
+		return fmt.Errorf("%q: %w", name, ErrInvalidObjectName)
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("%q: %w", name, ErrInvalidObjectName)
+		}
+	}
+	return nil
 }
 
 func (w *Writer) getUploadURL(ctx context.Context) (beURLInterface, error) {
 	u := w.o.b.urlPool.get()
 	if u == nil {
+		w.countURL(false)
 		return w.o.b.b.getUploadURL(w.ctx)
 	}
-
+	w.countURL(true)
 	return u, nil
 }
 
 func (w *Writer) simpleWriteFile() error {
-	ue, err := w.getUploadURL(w.ctx)
+	info, err := w.fileInfo()
 	if err != nil {
 		return err
 	}
+	if w.Retention != nil || w.LegalHold {
+		if err := w.o.b.checkObjectLock(w.name); err != nil {
+			return err
+		}
+	}
+	if err := w.checkNotExists(); err != nil {
+		return err
+	}
+	ue, err := w.getUploadURL(w.ctx)
+	if err != nil {
+		return fmt.Errorf("%s: getting an upload URL: %w", w.name, err)
+	}
 	// This defer needs to be in a func() so that we put whatever the value of ue
 	// is at function exit.
 	defer func() { w.o.b.urlPool.put(ue) }()
 	sha1 := w.w.Hash()
-	ctype := w.contentType
-	if ctype == "" {
-		ctype = "application/octet-stream"
+	if w.SHA1 != "" {
+		if nb, ok := w.w.(*nonBuffer); ok {
+			nb.useHash(w.SHA1)
+		}
+		sha1 = w.SHA1
 	}
+	ctype := w.ctype(w.w)
 	r, err := w.w.Reader()
 	if err != nil {
 		return err
@@ -277,47 +841,161 @@ func (w *Writer) simpleWriteFile() error {
 	mr := &meteredReader{r: r, size: w.w.Len()}
 	w.registerChunk(1, mr)
 	defer w.completeChunk(1)
+	var attempt int
+	var wait time.Duration
 redo:
-	f, err := ue.uploadFile(w.ctx, mr, int(w.w.Len()), w.name, ctype, sha1, w.info)
+	attempt++
+	f, err := ue.uploadFile(w.ctx, mr, int(w.w.Len()), w.name, ctype, sha1, info, w.ServerSideEncryption, w.Retention, w.LegalHold)
 	if err != nil {
-		if w.o.b.r.reupload(err) {
-			blog.V(2).Infof("b2 writer: %v; retrying", err)
+		d, ok, err := w.retryUpload(attempt, wait, err)
+		if ok {
+			wait = d
+			if err := sleep(w.ctx, w.o.b.r.clock(), wait); err != nil {
+				return fmt.Errorf("%s: %w", w.name, err)
+			}
+			w.o.b.r.metrics().retry()
+			w.o.b.c.v(2).Infof("b2 writer: %v; retrying", err)
+			w.countURL(false)
 			u, err := w.o.b.b.getUploadURL(w.ctx)
 			if err != nil {
-				return err
+				return fmt.Errorf("%s: getting an upload URL: %w", w.name, err)
 			}
 			ue = u
 			goto redo
 		}
-		return err
+		if rejectedSHA1(err) {
+			return fmt.Errorf("%s: B2 rejected SHA1 %q: %w", w.name, sha1, ErrSHA1Mismatch)
+		}
+		return fmt.Errorf("%s: uploading: %w", w.name, err)
+	}
+	if got := f.sha1(); got != "" && sha1 != "hex_digits_at_end" && got != sha1 {
+		return fmt.Errorf("%s: B2 reported %q, want %q: %w", w.name, got, sha1, ErrSHA1Mismatch)
 	}
 	w.o.f = f
+	w.o.b.r.metrics().uploaded(w.w.Len())
+	w.pmux.Lock()
+	w.ptot = chunkSize(w.w)
+	w.pmux.Unlock()
+	w.progress(chunkSize(w.w))
 	return nil
 }
 
+const defaultUploadAttempts = 10
+
+// retryUpload is like beRoot.retryUpload, but gives up after UploadAttempts
+// attempts, returning an error that says so, or at once with DisableRetry.
+// Callers add the object's name and the part to the error.
+func (w *Writer) retryUpload(attempt int, last time.Duration, err error) (time.Duration, bool, error) {
+	if w.DisableRetry {
+		return 0, false, err
+	}
+	d, ok := w.o.b.r.retryUpload(attempt, last, err)
+	max := w.UploadAttempts
+	if max < 1 {
+		max = defaultUploadAttempts
+	}
+	if !ok || attempt < max {
+		return d, ok, err
+	}
+	return 0, false, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+}
+
+// ctype returns the content type to send the object with.  With
+// AutoContentType, an unset type may be sniffed from the first chunk, wb,
+// which may be nil.
+func (w *Writer) ctype(wb writeBuffer) string {
+	if w.contentType != "" {
+		return w.contentType
+	}
+	if w.AutoContentType {
+		if ct := mime.TypeByExtension(path.Ext(w.name)); ct != "" {
+			return ct
+		}
+		if wb != nil && !w.Compress {
+			ct, err := sniffContentType(wb)
+			if err != nil {
+				w.o.b.c.v(1).Infof("%s: detecting content type: %v", w.name, err)
+			}
+			if ct != "" {
+				return ct
+			}
+		}
+	}
+	return "application/octet-stream"
+}
+
+// sniffContentType returns the content type of the data at the start of wb,
+// or "" if it has none.  wb's Reader is reset afterward, so that the chunk is
+// sent from its beginning.
+func sniffContentType(wb writeBuffer) (string, error) {
+	n := chunkSize(wb)
+	if n == 0 {
+		return "", nil
+	}
+	if n > 512 {
+		n = 512
+	}
+	r, err := wb.Reader()
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, n)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	if err := r.Reset(); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head), nil
+}
+
 func (w *Writer) getLargeFile() (beLargeFileInterface, error) {
+	if w.resumeID != "" {
+		return w.resumeLargeFile(w.o.b.b.file(w.resumeID, w.name))
+	}
 	if !w.Resume {
-		ctype := w.contentType
-		if ctype == "" {
-			ctype = "application/octet-stream"
+		info, err := w.fileInfo()
+		if err != nil {
+			return nil, err
+		}
+		if w.Retention != nil || w.LegalHold {
+			if err := w.o.b.checkObjectLock(w.name); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.checkNotExists(); err != nil {
+			return nil, err
+		}
+		if _, ok := info["large_file_sha1"]; !ok && w.SHA1 != "" {
+			info["large_file_sha1"] = w.SHA1
+		}
+		lf, err := w.o.b.b.startLargeFile(w.ctx, w.name, w.ctype(w.w), info, w.ServerSideEncryption, w.Retention, w.LegalHold)
+		if err != nil {
+			return nil, fmt.Errorf("%s: starting large file: %w", w.name, err)
 		}
-		return w.o.b.b.startLargeFile(w.ctx, w.name, ctype, w.info)
+		return lf, nil
 	}
+	cur := &Cursor{name: w.name}
+	objs, _, err := w.o.b.ListObjects(w.ctx, 1, cur)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(objs) < 1 || objs[0].name != w.name {
+		w.Resume = false
+		return w.getLargeFile()
+	}
+	return w.resumeLargeFile(objs[0].f)
+}
+
+// resumeLargeFile discovers which parts of fi have already been uploaded, so
+// that they are not sent again.
+func (w *Writer) resumeLargeFile(fi beFileInterface) (beLargeFileInterface, error) {
 	next := 1
 	seen := make(map[int]string)
+	sizes := make(map[int]int64)
 	var size int64
-	var fi beFileInterface
+	var last int
 	for {
-		cur := &Cursor{name: w.name}
-		objs, _, err := w.o.b.ListObjects(w.ctx, 1, cur)
-		if err != nil {
-			return nil, err
-		}
-		if len(objs) < 1 || objs[0].name != w.name {
-			w.Resume = false
-			return w.getLargeFile()
-		}
-		fi = objs[0].f
 		parts, n, err := fi.listParts(w.ctx, next, 100)
 		if err != nil {
 			return nil, err
@@ -325,7 +1003,11 @@ func (w *Writer) getLargeFile() (beLargeFileInterface, error) {
 		next = n
 		for _, p := range parts {
 			seen[p.number()] = p.sha1()
+			sizes[p.number()] = p.size()
 			size += p.size()
+			if p.number() > last {
+				last = p.number()
+			}
 		}
 		if len(parts) == 0 {
 			break
@@ -334,6 +1016,14 @@ func (w *Writer) getLargeFile() (beLargeFileInterface, error) {
 			break
 		}
 	}
+	// Every part but the last must be exactly one chunk; if it isn't, this
+	// Writer would split the data differently from the original upload.
+	for id, sz := range sizes {
+		want := int64(w.partLimit(id))
+		if sz > want || (id != last && sz != want) {
+			return nil, fmt.Errorf("resumable upload was requested, but part %d is %d bytes and should be %d", id, sz, want)
+		}
+	}
 	w.seen = make(map[int]string) // copy the map
 	for id, sha := range seen {
 		w.seen[id] = sha
@@ -354,19 +1044,22 @@ func (w *Writer) sendChunk() error {
 		if w.ConcurrentUploads < 1 {
 			w.ConcurrentUploads = 1
 		}
+		w.tparts = make([]int64, w.ConcurrentUploads)
 		for i := 0; i < w.ConcurrentUploads; i++ {
-			w.thread()
+			w.thread(i)
 		}
 	})
 	if err != nil {
 		return err
 	}
+	w.pending.Add(1)
 	select {
 	case w.ready <- chunk{
 		id:  w.cidx + 1,
 		buf: w.w,
 	}:
 	case <-w.ctx.Done():
+		w.pending.Done()
 		return w.ctx.Err()
 	}
 	w.cidx++
@@ -378,6 +1071,48 @@ func (w *Writer) sendChunk() error {
 	return nil
 }
 
+// Flush sends the data written so far as a part of a large file, even if it
+// is smaller than ChunkSize, and waits until B2 has every part sent so far.
+// It starts a large file if none has been started.  The object does not exist
+// until Close is called.
+//
+// B2 requires every part but the last to hold at least the minimum part size
+// (currently 5MB), so Flush does nothing if less than that has been written
+// since the last part was sent.  It also does nothing if UseLargeFile is
+// false.  Because flushed parts are smaller than ChunkSize, an upload that
+// has been flushed cannot be resumed.
+//
+// Flush must not be mixed with ReadFrom.
+func (w *Writer) Flush() error {
+	w.wmux.Lock()
+	defer w.wmux.Unlock()
+	if w.closed {
+		return fmt.Errorf("%s: %w", w.name, ErrWriterClosed)
+	}
+	w.init()
+	if err := w.getErr(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			w.setErr(err)
+			return w.getErr()
+		}
+	}
+	if w.UseLargeFile != nil && !*w.UseLargeFile {
+		return nil
+	}
+	if w.w.Len() < w.o.b.r.minPartSize() {
+		return nil
+	}
+	if err := w.sendChunk(); err != nil {
+		w.setErr(err)
+		return err
+	}
+	w.pending.Wait()
+	return w.getErr()
+}
+
 // ReadFrom reads all of r into w, returning the first error or no error if r
 // returns io.EOF.  If r is also an io.Seeker, ReadFrom will stream r directly
 // over the wire instead of buffering it locally.  This reduces memory usage.
@@ -388,18 +1123,36 @@ func (w *Writer) sendChunk() error {
 //
 // Note that io.Copy will automatically choose to use ReadFrom.
 //
-// ReadFrom currently doesn't handle w.Resume; if w.Resume is true, ReadFrom
-// will act as if r is not an io.Seeker.
+// ReadFrom currently doesn't handle resumed uploads; if w.Resume is true, or
+// w was returned by Bucket.ResumeWriter, ReadFrom will act as if r is not an
+// io.Seeker.
 func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.Compress {
+		// Each Write holds the lock in turn.
+		return io.Copy(onlyWriter{w}, r)
+	}
+	w.wmux.Lock()
+	defer w.wmux.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("%s: %w", w.name, ErrWriterClosed)
+	}
 	rs, ok := r.(io.ReadSeeker)
-	if !ok || w.Resume {
-		return copyContext(w.ctx, w, r)
+	if !ok || w.Resume || w.resumeID != "" {
+		n, err := w.readFrom(r)
+		w.written += n
+		return n, err
 	}
-	blog.V(2).Info("streaming without buffer")
+	w.o.b.c.v(2).Info("streaming without buffer")
 	size, err := rs.Seek(0, io.SeekEnd)
 	if err != nil {
 		return 0, err
 	}
+	if w.ExpectedSize > 0 && w.written+size != w.ExpectedSize {
+		err := w.sizeMismatch(w.written + size)
+		w.setErr(err)
+		return 0, err
+	}
+	w.written += size
 	var ra io.ReaderAt
 	if rat, ok := r.(io.ReaderAt); ok {
 		ra = rat
@@ -413,11 +1166,11 @@ func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
 		if left <= 0 {
 			// We're done sending real chunks; send empty chunks from now on so that
 			// Close() works.
-			w.newBuffer = func() (writeBuffer, error) { return newMemoryBuffer(), nil }
-			w.w = newMemoryBuffer()
+			w.newBuffer = func() (writeBuffer, error) { return newMemoryBuffer(0), nil }
+			w.w = newMemoryBuffer(0)
 			return nil, io.EOF
 		}
-		csize := int64(w.csize)
+		csize := int64(w.partLimit(w.cidx + 1))
 		if left < csize {
 			csize = left
 		}
@@ -427,7 +1180,14 @@ func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
 		return nb, nil
 	}
 	w.init()
-	if size < int64(w.csize) {
+	w.ptot = size
+	if w.LargeFileSHA1 && (size >= int64(w.partLimit(1)) || w.useLargeFile(size)) {
+		if err := w.hashContent(ra, size); err != nil {
+			w.setErr(err)
+			return 0, err
+		}
+	}
+	if size < int64(w.partLimit(1)) {
 		// the magic happens on w.Close()
 		return size, nil
 	}
@@ -441,10 +1201,97 @@ func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
 	}
 }
 
+// hashContent records the SHA1 of the first size bytes of ra as the object's
+// large_file_sha1, unless one was given, either with WithAttrs or as SHA1.
+func (w *Writer) hashContent(ra io.ReaderAt, size int64) error {
+	if _, ok := w.info["large_file_sha1"]; ok || w.SHA1 != "" {
+		return nil
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(ra, 0, size)); err != nil {
+		return err
+	}
+	if w.info == nil {
+		w.info = make(map[string]string)
+	}
+	w.info["large_file_sha1"] = fmt.Sprintf("%x", h.Sum(nil))
+	return nil
+}
+
+// readFrom reads r into w's buffers, sending each part as it fills.  Unlike
+// Write, it reads straight into in-memory buffers, without copying through an
+// intermediate one.
+func (w *Writer) readFrom(r io.Reader) (int64, error) {
+	w.init()
+	var n int64
+	for {
+		if err := w.getErr(); err != nil {
+			return n, err
+		}
+		if err := w.ctx.Err(); err != nil {
+			w.setErr(err)
+			return n, err
+		}
+		left := w.partLimit(w.cidx+1) - w.w.Len()
+		k, err := w.fill(r, left)
+		n += int64(k)
+		if err != nil {
+			w.setErr(err)
+			return n, err
+		}
+		if k < left {
+			return n, nil
+		}
+		if err := w.sendChunk(); err != nil {
+			w.setErr(err)
+			return n, w.getErr()
+		}
+	}
+}
+
+// fill reads up to size bytes from r into the current buffer, stopping early
+// if r is exhausted.
+func (w *Writer) fill(r io.Reader, size int) (int, error) {
+	if mb, ok := w.w.(*memoryBuffer); ok {
+		return mb.readFrom(r, size)
+	}
+	n, err := io.CopyN(w.w, r, int64(size))
+	if err == io.EOF {
+		err = nil
+	}
+	return int(n), err
+}
+
 // Close satisfies the io.Closer interface.  It is critical to check the return
 // value of Close for all writers.
+//
+// Once Close has returned nil, the Object the Writer was made from refers to
+// the new file: its ID method returns the file's ID, and its Attrs method
+// returns the file's size, SHA1, and other attributes as B2 reported them on
+// upload, without making another request.
 func (w *Writer) Close() error {
+	w.wmux.Lock()
+	defer w.wmux.Unlock()
+	w.closed = true
 	w.done.Do(func() {
+		defer func() {
+			if w.KeepVersions > 0 && w.getErr() == nil {
+				w.setErr(w.o.b.PruneVersions(w.ctx, w.name, w.KeepVersions))
+			}
+		}()
+		if w.Compress && w.getErr() == nil {
+			// Write the gzip trailer, and a header for an empty object, into the
+			// last part.
+			w.setErr(w.gzipWriter().Close())
+		}
+		if w.ExpectedSize > 0 && w.written != w.ExpectedSize {
+			w.setErr(w.sizeMismatch(w.written))
+			// w.ctx is cancelled, but the large file must still be.
+			if err := w.discard(context.WithoutCancel(w.ctx)); err != nil {
+				w.o.b.c.v(1).Infof("close %s: %v", w.name, err)
+			}
+			return
+		}
 		if !w.everStarted {
 			w.init()
 			w.setErr(w.simpleWriteFile())
@@ -454,10 +1301,10 @@ func (w *Writer) Close() error {
 		defer func() {
 			if err := w.w.Close(); err != nil {
 				// this is non-fatal, but alarming
-				blog.V(1).Infof("close %s: %v", w.name, err)
+				w.o.b.c.v(1).Infof("close %s: %v", w.name, err)
 			}
 		}()
-		if w.cidx == 0 {
+		if w.cidx == 0 && !w.useLargeFile(chunkSize(w.w)) {
 			w.setErr(w.simpleWriteFile())
 			return
 		}
@@ -469,9 +1316,13 @@ func (w *Writer) Close() error {
 		}
 		close(w.ready)
 		w.wg.Wait()
+		if err := w.verifyHashes(); err != nil {
+			w.setErr(err)
+			return
+		}
 		f, err := w.file.finishLargeFile(w.ctx)
 		if err != nil {
-			w.setErr(err)
+			w.setErr(fmt.Errorf("%s: finishing large file: %w", w.name, err))
 			return
 		}
 		w.o.f = f
@@ -479,23 +1330,231 @@ func (w *Writer) Close() error {
 	return w.getErr()
 }
 
+// ErrAlreadyExists is returned by a Writer with IfNotExists set when the object
+// it would write already exists.
+var ErrAlreadyExists = errors.New("b2: object already exists")
+
+// checkNotExists returns an error wrapping ErrAlreadyExists if IfNotExists is
+// set and the object has a current version.
+func (w *Writer) checkNotExists() error {
+	if !w.IfNotExists {
+		return nil
+	}
+	_, err := w.o.b.listObject(w.ctx, w.name)
+	if IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w", w.name, ErrAlreadyExists)
+}
+
+// ErrAborted is returned by a Writer's methods after Abort has been called.
+var ErrAborted = errors.New("b2: upload aborted")
+
+// Abort abandons the upload.  It stops any part uploads in progress and, if a
+// large file was started, cancels it with B2, which discards the parts sent so
+// far.  The object is not written.  Afterward, Write and Close return
+// ErrAborted.  Abort returns an error only if cancelling the large file failed,
+// in which case it remains among the bucket's unfinished large files.
+//
+// Abort must not be called concurrently with Write or Close; to interrupt a
+// Write that is blocked, cancel its context first.  Abort does nothing if the
+// Writer has already been closed.
+func (w *Writer) Abort(ctx context.Context) error {
+	var err error
+	w.done.Do(func() {
+		w.emux.Lock()
+		w.err = ErrAborted
+		w.emux.Unlock()
+		err = w.discard(ctx)
+	})
+	return err
+}
+
+// discard stops the upload and, if a large file was started, cancels it.
+func (w *Writer) discard(ctx context.Context) error {
+	w.cancel()
+	if !w.everStarted {
+		return nil
+	}
+	w.o.b.c.removeWriter(w)
+	if w.file != nil {
+		close(w.ready)
+		w.wg.Wait()
+	}
+	if err := w.w.Close(); err != nil {
+		w.o.b.c.v(1).Infof("close %s: %v", w.name, err)
+	}
+	if w.file != nil {
+		return w.file.cancelLargeFile(ctx)
+	}
+	return nil
+}
+
+// ErrSizeMismatch is returned when the number of bytes written to a Writer
+// differs from its ExpectedSize.  Use errors.Is to test for it.
+var ErrSizeMismatch = errors.New("b2: size mismatch")
+
+func (w *Writer) sizeMismatch(got int64) error {
+	return fmt.Errorf("%s: wrote %d bytes, want %d: %w", w.name, got, w.ExpectedSize, ErrSizeMismatch)
+}
+
+// UploadStats describes how a Writer used upload URLs, to help tune
+// ConcurrentUploads.  B2 allows each upload URL one upload at a time, and a
+// Writer keeps the URLs its uploads finish with for the next ones, so a
+// Writer whose threads are kept busy fetches about one URL per thread.
+type UploadStats struct {
+	// URLFetches counts the upload URLs the Writer asked B2 for, including
+	// those fetched to send a part again after a failure.
+	URLFetches int64
+
+	// URLReuses counts the uploads that used a URL an earlier one had
+	// finished with.
+	URLReuses int64
+
+	// ThreadParts holds the number of large file parts each of the Writer's
+	// ConcurrentUploads threads sent.  It is empty if the object was not
+	// sent as a large file.
+	ThreadParts []int64
+}
+
+// UploadStats returns the Writer's upload URL statistics.  Its totals are
+// final once Close returns.
+//
+// UploadStats must not be called concurrently with Write or Close.
+func (w *Writer) UploadStats() UploadStats {
+	us := UploadStats{
+		URLFetches: atomic.LoadInt64(&w.urlFetches),
+		URLReuses:  atomic.LoadInt64(&w.urlReuses),
+	}
+	for i := range w.tparts {
+		us.ThreadParts = append(us.ThreadParts, atomic.LoadInt64(&w.tparts[i]))
+	}
+	return us
+}
+
+// FileID returns the ID of the large file being written, or "" if the Writer
+// has not yet begun a large file upload.  The ID can be saved and passed to
+// Bucket.ResumeWriter to continue an interrupted upload.
+//
+// FileID must not be called concurrently with Write or Close.
+func (w *Writer) FileID() string {
+	if w.file == nil {
+		return ""
+	}
+	return w.file.id()
+}
+
+// UploadedParts returns the parts of a large file that B2 has acknowledged, as
+// a mapping of part numbers to SHA1 strings.  It returns nil if the Writer has
+// not yet begun a large file upload.
+//
+// UploadedParts must not be called concurrently with Write or Close.
+func (w *Writer) UploadedParts() map[int]string {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.hashes()
+}
+
+// PartHashes returns the SHA1s that B2 acknowledged for the parts of a large
+// file, in part order, as they were given to B2 to finish the file.  Callers
+// can compare them with those B2 lists for the file's parts.  It returns nil
+// if the object was not written as a large file.  The slice has an empty
+// string for any part that was not uploaded, which is possible only before
+// Close.
+//
+// PartHashes must not be called concurrently with Write or Close.
+func (w *Writer) PartHashes() []string {
+	if w.file == nil {
+		return nil
+	}
+	got := w.file.hashes()
+	var n int
+	for id := range got {
+		if id > n {
+			n = id
+		}
+	}
+	shas := make([]string, n)
+	for id, sha := range got {
+		shas[id-1] = sha
+	}
+	return shas
+}
+
+// ResumeWriter returns a Writer that continues the unfinished large file upload
+// identified by fileID, such as one returned by a previous Writer's FileID
+// method.  Parts that B2 already has are not sent again.
+//
+// Callers must write the entire object, from the beginning, to the returned
+// Writer, and must use the same ChunkSize as the original upload.
+func (b *Bucket) ResumeWriter(ctx context.Context, name, fileID string, opts ...WriterOption) *Writer {
+	w := b.Object(name).NewWriter(ctx, opts...)
+	w.resumeID = fileID
+	return w
+}
+
+// Upload writes the size bytes read from r as the named object, with a Writer
+// configured by opts, and returns the object.  As with any Writer, an object no
+// larger than ChunkSize, or LargeFileThreshold if that is set, is buffered and
+// sent in a single request, and a larger one is sent in parts as a large file.
+// If r is an io.ReadSeeker, it is streamed without buffering, as ReadFrom
+// does.
+//
+// r must hold exactly size bytes.  If it holds more or fewer, Upload fails
+// with an error wrapping ErrSizeMismatch and the object is not written.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader, size int64, opts ...WriterOption) (*Object, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%s: negative size %d", name, size)
+	}
+	o := b.Object(name)
+	w := o.NewWriter(ctx, opts...)
+	w.ExpectedSize = size
+	if _, ok := r.(io.ReadSeeker); !ok {
+		// Read one byte more than expected, to catch a source that is too long.
+		r = io.LimitReader(r, size+1)
+	}
+	n, err := w.ReadFrom(r)
+	if err == nil && n != size {
+		// ExpectedSize can't say that an object should be empty.
+		err = w.sizeMismatch(n)
+	}
+	if err != nil {
+		w.Abort(ctx)
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // WithAttrs sets the writable attributes of the resulting file to given
 // values.  WithAttrs must be called before the first call to Write.
 //
 // DEPRECATED: Use WithAttrsOption instead.
 func (w *Writer) WithAttrs(attrs *Attrs) *Writer {
 	w.contentType = attrs.ContentType
-	w.info = make(map[string]string)
+	w.info = attrsInfo(attrs)
+	return w
+}
+
+// attrsInfo returns the info to save for an object with the given attributes.
+func attrsInfo(attrs *Attrs) map[string]string {
+	info := make(map[string]string)
 	for k, v := range attrs.Info {
-		w.info[k] = v
+		info[k] = v
 	}
-	if len(w.info) < 10 && attrs.SHA1 != "" {
-		w.info["large_file_sha1"] = attrs.SHA1
+	if len(info) < 10 && attrs.SHA1 != "" {
+		info["large_file_sha1"] = attrs.SHA1
 	}
-	if len(w.info) < 10 && !attrs.LastModified.IsZero() {
-		w.info["src_last_modified_millis"] = fmt.Sprintf("%d", attrs.LastModified.UnixNano()/1e6)
+	if len(info) < 10 && !attrs.LastModified.IsZero() {
+		info["src_last_modified_millis"] = fmt.Sprintf("%d", attrs.LastModified.UnixNano()/1e6)
 	}
-	return w
+	return info
 }
 
 // A WriterOption sets Writer-specific behavior.
@@ -532,6 +1591,14 @@ func (w *Writer) status() *WriterStatus {
 	return ws
 }
 
+// chunkSize returns the number of object bytes held by wb.
+func chunkSize(wb writeBuffer) int64 {
+	if nb, ok := wb.(*nonBuffer); ok {
+		return int64(nb.size)
+	}
+	return int64(wb.Len())
+}
+
 type meteredReader struct {
 	read int64
 	size int
@@ -14,22 +14,21 @@
 
 // Package base provides a very low-level interface on top of the B2 v1 API.
 // It is not intended to be used directly.
-//
-// It currently lacks support for the following APIs:
-//
-// b2_download_file_by_id
 package base
 
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -48,8 +47,9 @@ const (
 type b2err struct {
 	msg    string
 	method string
-	retry  int
+	retry  time.Duration
 	code   int
+	b2code string // B2's name for the error, e.g. "bad_bucket_id"
 }
 
 func (e b2err) Error() string {
@@ -61,8 +61,8 @@ func (e b2err) Error() string {
 
 // Action checks an error and returns a recommended course of action.
 func Action(err error) ErrAction {
-	e, ok := err.(b2err)
-	if !ok {
+	var e b2err
+	if !errors.As(err, &e) {
 		return Punt
 	}
 	if e.retry > 0 {
@@ -100,13 +100,23 @@ type ErrAction int
 
 // Code returns the error code and message.
 func Code(err error) (int, string) {
-	e, ok := err.(b2err)
-	if !ok {
+	var e b2err
+	if !errors.As(err, &e) {
 		return 0, ""
 	}
 	return e.code, e.msg
 }
 
+// ErrorCode returns the name B2 gave the error, such as "bad_bucket_id" or
+// "duplicate_bucket_name", or "" if B2 didn't give one.
+func ErrorCode(err error) string {
+	var e b2err
+	if !errors.As(err, &e) {
+		return ""
+	}
+	return e.b2code
+}
+
 const (
 	// ReAuthenticate indicates that the B2 account authentication tokens have
 	// expired, and should be refreshed with a new call to AuthorizeAccount.
@@ -126,13 +136,13 @@ const (
 	Punt
 )
 
-func mkErr(resp *http.Response) error {
+func (o *b2Options) mkErr(resp *http.Response) error {
 	data, err := ioutil.ReadAll(resp.Body)
 	var msgBody string
 	if err != nil {
 		msgBody = fmt.Sprintf("couldn't read message body: %v", err)
 	}
-	logResponse(resp, data)
+	o.logResponse(resp, data)
 	msg := &b2types.ErrorMessage{}
 	if err := json.Unmarshal(data, msg); err != nil {
 		if msgBody != "" {
@@ -142,38 +152,61 @@ func mkErr(resp *http.Response) error {
 	if msgBody == "" {
 		msgBody = msg.Msg
 	}
-	var retryAfter int
-	retry := resp.Header.Get("Retry-After")
-	if retry != "" {
-		r, err := strconv.ParseInt(retry, 10, 64)
-		if err != nil {
-			r = 0
-			blog.V(1).Infof("couldn't parse retry-after header %q: %v", retry, err)
-		}
-		retryAfter = int(r)
+	retryAfter, err := parseRetryAfter(resp.Header)
+	if err != nil {
+		o.v(1).Infof("couldn't parse retry-after header %q: %v", resp.Header.Get("Retry-After"), err)
 	}
 	return b2err{
 		msg:    msgBody,
 		retry:  retryAfter,
 		code:   resp.StatusCode,
+		b2code: msg.Code,
 		method: resp.Request.Header.Get("X-Blazer-Method"),
 	}
 }
 
+// parseRetryAfter returns the wait requested by a Retry-After header, which may
+// be given in seconds or as an HTTP date.  Dates are measured from the reply's
+// Date header, so that the wait doesn't depend on the local clock.
+func parseRetryAfter(h http.Header) (time.Duration, error) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, nil
+	}
+	if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if s < 0 {
+			return 0, fmt.Errorf("negative delay")
+		}
+		return time.Duration(s) * time.Second, nil
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, err
+	}
+	now, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		now = time.Now()
+	}
+	if !t.After(now) {
+		return 0, nil
+	}
+	return t.Sub(now), nil
+}
+
 // Backoff returns an appropriate amount of time to wait, given an error, if
 // any was returned by the server.  If the return value is 0, but Action
 // indicates Retry, the user should implement their own exponential backoff,
 // beginning with one second.
 func Backoff(err error) time.Duration {
-	e, ok := err.(b2err)
-	if !ok {
+	var e b2err
+	if !errors.As(err, &e) {
 		return 0
 	}
-	return time.Duration(e.retry) * time.Second
+	return e.retry
 }
 
-func logRequest(req *http.Request, args []byte) {
-	if !blog.V(2) {
+func (o *b2Options) logRequest(req *http.Request, args []byte) {
+	if !o.v(2).Enabled() {
 		return
 	}
 	var headers []string
@@ -181,21 +214,28 @@ func logRequest(req *http.Request, args []byte) {
 		if k == "Authorization" || k == "X-Blazer-Method" {
 			continue
 		}
+		if k == sseKeyHeader {
+			v = []string{"[redacted]"}
+		}
 		headers = append(headers, fmt.Sprintf("%s: %s", k, strings.Join(v, ",")))
 	}
 	hstr := strings.Join(headers, ";")
 	method := req.Header.Get("X-Blazer-Method")
 	if args != nil {
-		blog.V(2).Infof(">> %s uri: %v headers: {%s} args: (%s)", method, req.URL, hstr, string(args))
+		args = keyRegexp.ReplaceAll(args, []byte(`"customerKey":"[redacted]"`))
+		o.v(2).Infof(">> %s uri: %v headers: {%s} args: (%s)", method, req.URL, hstr, string(args))
 		return
 	}
-	blog.V(2).Infof(">> %s uri: %v {%s} (no args)", method, req.URL, hstr)
+	o.v(2).Infof(">> %s uri: %v {%s} (no args)", method, req.URL, hstr)
 }
 
-var authRegexp = regexp.MustCompile(`"authorizationToken": ".[^"]*"`)
+var (
+	authRegexp = regexp.MustCompile(`"authorizationToken": ".[^"]*"`)
+	keyRegexp  = regexp.MustCompile(`"customerKey":"[^"]*"`)
+)
 
-func logResponse(resp *http.Response, reply []byte) {
-	if !blog.V(2) {
+func (o *b2Options) logResponse(resp *http.Response, reply []byte) {
+	if !o.v(2).Enabled() {
 		return
 	}
 	var headers []string
@@ -207,10 +247,10 @@ func logResponse(resp *http.Response, reply []byte) {
 	id := resp.Request.Header.Get("X-Blazer-Request-ID")
 	if reply != nil {
 		safe := string(authRegexp.ReplaceAll(reply, []byte(`"authorizationToken": "[redacted]"`)))
-		blog.V(2).Infof("<< %s (%s) %s {%s} (%s)", method, id, resp.Status, hstr, safe)
+		o.v(2).Infof("<< %s (%s) %s {%s} (%s)", method, id, resp.Status, hstr, safe)
 		return
 	}
-	blog.V(2).Infof("<< %s (%s) %s {%s} (no reply)", method, id, resp.Status, hstr)
+	o.v(2).Infof("<< %s (%s) %s {%s} (no reply)", method, id, resp.Status, hstr)
 }
 
 func millitime(t int64) time.Time {
@@ -223,7 +263,13 @@ type b2Options struct {
 	expireTokens    bool
 	capExceeded     bool
 	apiBase         string
+	apiURL          string
 	userAgent       string
+	logger          blog.Logger
+}
+
+func (o *b2Options) v(level int32) blog.Verbose {
+	return blog.LV(o.logger, level)
 }
 
 func (o *b2Options) addHeaders(req *http.Request) {
@@ -266,10 +312,19 @@ type B2 struct {
 	authToken   string
 	apiURI      string
 	downloadURI string
-	minPartSize int
+	s3URI       string
+	partSize    int
+	absMinPart  int
 	opts        *b2Options
-	bucket      string // restricted to this bucket if present
-	pfx         string // restricted to objects with this prefix if present
+	allowed     Allowance
+}
+
+// Allowance describes what the key used to authorize an account may do.
+type Allowance struct {
+	Capabilities []string
+	BucketID     string // restricted to this bucket if present
+	BucketName   string
+	Prefix       string // restricted to objects with this prefix if present
 }
 
 // Update replaces the B2 object with a new one, in-place.
@@ -278,8 +333,34 @@ func (b *B2) Update(n *B2) {
 	b.authToken = n.authToken
 	b.apiURI = n.apiURI
 	b.downloadURI = n.downloadURI
-	b.minPartSize = n.minPartSize
+	b.s3URI = n.s3URI
+	b.partSize = n.partSize
+	b.absMinPart = n.absMinPart
 	b.opts = n.opts
+	b.allowed = n.allowed
+}
+
+// Allowed returns the capabilities and restrictions of the key that authorized
+// this account.
+func (b *B2) Allowed() Allowance {
+	return b.allowed
+}
+
+// S3URL returns the URL of the account's S3-compatible API.
+func (b *B2) S3URL() string {
+	return b.s3URI
+}
+
+// MinPartSize returns the smallest size, in bytes, that B2 will accept for
+// any part of a large file other than the last.
+func (b *B2) MinPartSize() int {
+	return b.absMinPart
+}
+
+// RecommendedPartSize returns the part size, in bytes, that B2 recommends for
+// large files.
+func (b *B2) RecommendedPartSize() int {
+	return b.partSize
 }
 
 type httpReply struct {
@@ -287,9 +368,9 @@ type httpReply struct {
 	err  error
 }
 
-func makeNetRequest(ctx context.Context, req *http.Request, rt http.RoundTripper) (*http.Response, error) {
+func (o *b2Options) makeNetRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
 	req = req.WithContext(ctx)
-	resp, err := rt.RoundTrip(req)
+	resp, err := o.getTransport().RoundTrip(req)
 	switch err {
 	case nil:
 		return resp, nil
@@ -297,10 +378,10 @@ func makeNetRequest(ctx context.Context, req *http.Request, rt http.RoundTripper
 		return nil, err
 	default:
 		method := req.Header.Get("X-Blazer-Method")
-		blog.V(2).Infof(">> %s uri: %v err: %v", method, req.URL, err)
+		o.v(2).Infof(">> %s uri: %v err: %v", method, req.URL, err)
 		return nil, b2err{
 			msg:   err.Error(),
-			retry: 1,
+			retry: time.Second,
 		}
 	}
 }
@@ -380,7 +461,10 @@ func (o *b2Options) makeRequest(ctx context.Context, method, verb, uri string, b
 	}
 	req.ContentLength = body.getSize()
 	for k, v := range headers {
-		if strings.HasPrefix(k, "X-Bz-Info") || strings.HasPrefix(k, "X-Bz-File-Name") {
+		switch {
+		case strings.HasPrefix(k, "X-Bz-File-Name"):
+			v = EncodeName(v)
+		case strings.HasPrefix(k, "X-Bz-Info"):
 			v = escape(v)
 		}
 		req.Header.Set(k, v)
@@ -388,14 +472,14 @@ func (o *b2Options) makeRequest(ctx context.Context, method, verb, uri string, b
 	req.Header.Set("X-Blazer-Request-ID", fmt.Sprintf("%d", atomic.AddInt64(&reqID, 1)))
 	req.Header.Set("X-Blazer-Method", method)
 	o.addHeaders(req)
-	logRequest(req, args)
-	resp, err := makeNetRequest(ctx, req, o.getTransport())
+	o.logRequest(req, args)
+	resp, err := o.makeNetRequest(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return mkErr(resp)
+		return o.mkErr(resp)
 	}
 	var replyArgs []byte
 	if b2resp != nil {
@@ -409,17 +493,18 @@ func (o *b2Options) makeRequest(ctx context.Context, method, verb, uri string, b
 	} else {
 		ra, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			blog.V(1).Infof("%s: couldn't read response: %v", method, err)
+			o.v(1).Infof("%s: couldn't read response: %v", method, err)
 		}
 		replyArgs = ra
 	}
-	logResponse(resp, replyArgs)
+	o.logResponse(resp, replyArgs)
 	return nil
 }
 
-// AuthorizeAccount wraps b2_authorize_account.
-func AuthorizeAccount(ctx context.Context, account, key string, opts ...AuthOption) (*B2, error) {
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", account, key)))
+// AuthorizeAccount wraps b2_authorize_account.  The keyID is either the account
+// ID, for the master key, or the ID of an application key.
+func AuthorizeAccount(ctx context.Context, keyID, key string, opts ...AuthOption) (*B2, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", keyID, key)))
 	b2resp := &b2types.AuthorizeAccountResponse{}
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Basic %s", auth),
@@ -431,15 +516,24 @@ func AuthorizeAccount(ctx context.Context, account, key string, opts ...AuthOpti
 	if err := b2opts.makeRequest(ctx, "b2_authorize_account", "GET", b2opts.getAPIBase()+b2types.V1api+"b2_authorize_account", nil, b2resp, headers, nil); err != nil {
 		return nil, err
 	}
+	if b2opts.apiURL != "" {
+		b2resp.URI = b2opts.apiURL
+	}
 	return &B2{
 		accountID:   b2resp.AccountID,
 		authToken:   b2resp.AuthToken,
 		apiURI:      b2resp.URI,
 		downloadURI: b2resp.DownloadURI,
-		minPartSize: b2resp.PartSize,
-		bucket:      b2resp.Allowed.Bucket,
-		pfx:         b2resp.Allowed.Prefix,
+		s3URI:       b2resp.S3URI,
+		partSize:    b2resp.PartSize,
+		absMinPart:  b2resp.AbsMinPartSize,
 		opts:        b2opts,
+		allowed: Allowance{
+			Capabilities: b2resp.Allowed.Capabilities,
+			BucketID:     b2resp.Allowed.Bucket,
+			BucketName:   b2resp.Allowed.BucketName,
+			Prefix:       b2resp.Allowed.Prefix,
+		},
 	}, nil
 }
 
@@ -466,6 +560,14 @@ func Transport(rt http.RoundTripper) AuthOption {
 	}
 }
 
+// Logger returns an AuthOption that sends diagnostic output to l instead of the
+// standard log package.
+func Logger(l blog.Logger) AuthOption {
+	return func(o *b2Options) {
+		o.logger = l
+	}
+}
+
 // FailSomeUploads requests intermittent upload failures from the B2 service.
 // This is mostly useful for testing.
 func FailSomeUploads() AuthOption {
@@ -498,14 +600,120 @@ func SetAPIBase(url string) AuthOption {
 	}
 }
 
+// SetAPIURL returns an AuthOption that sends API requests made after
+// authorization to the given URL, instead of the one B2 returns.
+func SetAPIURL(url string) AuthOption {
+	return func(o *b2Options) {
+		o.apiURL = url
+	}
+}
+
 type LifecycleRule struct {
 	Prefix                 string
 	DaysNewUntilHidden     int
 	DaysHiddenUntilDeleted int
 }
 
-// CreateBucket wraps b2_create_bucket.
-func (b *B2) CreateBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule) (*Bucket, error) {
+// CORSRule is a bucket's CORS rule, which allows browsers to access it from
+// the listed origins.
+type CORSRule struct {
+	Name              string
+	AllowedOrigins    []string
+	AllowedOperations []string
+	AllowedHeaders    []string
+	ExposeHeaders     []string
+	MaxAgeSeconds     int
+}
+
+func corsToB2(rules []CORSRule) []b2types.CORSRule {
+	b2rules := []b2types.CORSRule{}
+	for _, rule := range rules {
+		b2rules = append(b2rules, b2types.CORSRule(rule))
+	}
+	return b2rules
+}
+
+func corsFromB2(b2rules []b2types.CORSRule) []CORSRule {
+	var rules []CORSRule
+	for _, rule := range b2rules {
+		rules = append(rules, CORSRule(rule))
+	}
+	return rules
+}
+
+// Replication is a bucket's replication configuration.  A bucket may be a
+// source of replication, a destination, or both.
+type Replication struct {
+	AsSource      *ReplicationSource
+	AsDestination *ReplicationDestination
+}
+
+// ReplicationSource lists the rules by which a bucket's files are replicated,
+// and the key used to read them.
+type ReplicationSource struct {
+	Rules []ReplicationRule
+	KeyID string
+}
+
+// ReplicationRule replicates files with a given prefix to another bucket.
+type ReplicationRule struct {
+	Name            string
+	DestBucketID    string
+	Prefix          string
+	Priority        int
+	IncludeExisting bool
+	Enabled         bool
+}
+
+// ReplicationDestination maps the IDs of keys in source accounts to the keys
+// used to write files to the destination bucket.
+type ReplicationDestination struct {
+	KeyMapping map[string]string
+}
+
+func (r *Replication) b2types() *b2types.ReplicationConfiguration {
+	if r == nil {
+		return nil
+	}
+	c := &b2types.ReplicationConfiguration{}
+	if r.AsSource != nil {
+		c.AsSource = &b2types.ReplicationSource{
+			Rules: []b2types.ReplicationRule{},
+			KeyID: r.AsSource.KeyID,
+		}
+		for _, rule := range r.AsSource.Rules {
+			c.AsSource.Rules = append(c.AsSource.Rules, b2types.ReplicationRule(rule))
+		}
+	}
+	if r.AsDestination != nil {
+		c.AsDestination = &b2types.ReplicationDestination{KeyMapping: r.AsDestination.KeyMapping}
+	}
+	return c
+}
+
+func replication(r *b2types.BucketReplication) *Replication {
+	if r == nil || !r.Authorized || r.Value == nil {
+		return nil
+	}
+	if r.Value.AsSource == nil && r.Value.AsDestination == nil {
+		return nil
+	}
+	repl := &Replication{}
+	if src := r.Value.AsSource; src != nil {
+		repl.AsSource = &ReplicationSource{KeyID: src.KeyID}
+		for _, rule := range src.Rules {
+			repl.AsSource.Rules = append(repl.AsSource.Rules, ReplicationRule(rule))
+		}
+	}
+	if dst := r.Value.AsDestination; dst != nil {
+		repl.AsDestination = &ReplicationDestination{KeyMapping: dst.KeyMapping}
+	}
+	return repl
+}
+
+// CreateBucket wraps b2_create_bucket.  If fileLock is true, Object Lock is
+// enabled on the bucket; it cannot be disabled later.
+func (b *B2) CreateBucket(ctx context.Context, name, btype string, info map[string]string, rules []LifecycleRule, cors []CORSRule, sse *Encryption, fileLock bool, repl *Replication) (*Bucket, error) {
 	if btype != "allPublic" {
 		btype = "allPrivate"
 	}
@@ -518,11 +726,17 @@ func (b *B2) CreateBucket(ctx context.Context, name, btype string, info map[stri
 		})
 	}
 	b2req := &b2types.CreateBucketRequest{
-		AccountID:      b.accountID,
-		Name:           name,
-		Type:           btype,
-		Info:           info,
-		LifecycleRules: b2rules,
+		AccountID:       b.accountID,
+		Name:            name,
+		Type:            btype,
+		Info:            info,
+		LifecycleRules:  b2rules,
+		DefaultSSE:      sse.b2types(),
+		FileLockEnabled: fileLock,
+		Replication:     repl.b2types(),
+	}
+	if len(cors) > 0 {
+		b2req.CORSRules = corsToB2(cors)
 	}
 	b2resp := &b2types.CreateBucketResponse{}
 	headers := map[string]string{
@@ -540,12 +754,17 @@ func (b *B2) CreateBucket(ctx context.Context, name, btype string, info map[stri
 		})
 	}
 	return &Bucket{
-		Name:           name,
-		Info:           b2resp.Info,
-		LifecycleRules: respRules,
-		ID:             b2resp.BucketID,
-		rev:            b2resp.Revision,
-		b2:             b,
+		Name:             name,
+		Info:             b2resp.Info,
+		LifecycleRules:   respRules,
+		CORSRules:        corsFromB2(b2resp.CORSRules),
+		DefaultSSE:       bucketEncryption(b2resp.DefaultSSE),
+		FileLock:         fileLockEnabled(b2resp.FileLock),
+		DefaultRetention: defaultRetention(b2resp.FileLock),
+		Replication:      replication(b2resp.Replication),
+		ID:               b2resp.BucketID,
+		rev:              b2resp.Revision,
+		b2:               b,
 	}, nil
 }
 
@@ -567,14 +786,27 @@ type Bucket struct {
 	Type           string
 	Info           map[string]string
 	LifecycleRules []LifecycleRule
-	ID             string
-	rev            int
-	b2             *B2
-}
-
-// Update wraps b2_update_bucket.
+	CORSRules      []CORSRule
+	DefaultSSE     *Encryption
+	// FileLock reports whether Object Lock is enabled on the bucket.  It is
+	// nil if the key may not read the bucket's Object Lock configuration.
+	FileLock *bool
+	// DefaultRetention is the retention given to files uploaded without one.
+	// In an update, a DefaultRetention with no Mode removes the default.
+	DefaultRetention *DefaultRetention
+	// Replication is nil if the bucket is not replicated, or if the key may
+	// not read its replication configuration.  In an update, an empty
+	// Replication removes the configuration.
+	Replication *Replication
+	ID          string
+	rev         int
+	b2          *B2
+}
+
+// Update wraps b2_update_bucket.  The bucket's info, lifecycle rules, and CORS
+// rules are always sent, so that empty values clear them.
 func (b *Bucket) Update(ctx context.Context) (*Bucket, error) {
-	var rules []b2types.LifecycleRule
+	rules := []b2types.LifecycleRule{}
 	for _, rule := range b.LifecycleRules {
 		rules = append(rules, b2types.LifecycleRule{
 			DaysNewUntilHidden:     rule.DaysNewUntilHidden,
@@ -586,14 +818,21 @@ func (b *Bucket) Update(ctx context.Context) (*Bucket, error) {
 		AccountID: b.b2.accountID,
 		BucketID:  b.ID,
 		// Name:           b.Name,
-		Type:           b.Type,
-		Info:           b.Info,
-		LifecycleRules: rules,
-		IfRevisionIs:   b.rev,
+		Type:             b.Type,
+		Info:             b.Info,
+		LifecycleRules:   rules,
+		CORSRules:        corsToB2(b.CORSRules),
+		IfRevisionIs:     b.rev,
+		DefaultSSE:       b.DefaultSSE.b2types(),
+		DefaultRetention: b.DefaultRetention.b2types(),
+		Replication:      b.Replication.b2types(),
 	}
 	headers := map[string]string{
 		"Authorization": b.b2.authToken,
 	}
+	if b2req.Info == nil {
+		b2req.Info = map[string]string{}
+	}
 	b2resp := &b2types.UpdateBucketResponse{}
 	if err := b.b2.opts.makeRequest(ctx, "b2_update_bucket", "POST", b.b2.apiURI+b2types.V1api+"b2_update_bucket", b2req, b2resp, headers, nil); err != nil {
 		return nil, err
@@ -607,12 +846,18 @@ func (b *Bucket) Update(ctx context.Context) (*Bucket, error) {
 		})
 	}
 	return &Bucket{
-		Name:           b.Name,
-		Type:           b2resp.Type,
-		Info:           b2resp.Info,
-		LifecycleRules: respRules,
-		ID:             b2resp.BucketID,
-		b2:             b.b2,
+		Name:             b.Name,
+		Type:             b2resp.Type,
+		Info:             b2resp.Info,
+		LifecycleRules:   respRules,
+		CORSRules:        corsFromB2(b2resp.CORSRules),
+		DefaultSSE:       bucketEncryption(b2resp.DefaultSSE),
+		FileLock:         fileLockEnabled(b2resp.FileLock),
+		DefaultRetention: defaultRetention(b2resp.FileLock),
+		Replication:      replication(b2resp.Replication),
+		ID:               b2resp.BucketID,
+		rev:              b2resp.Revision,
+		b2:               b.b2,
 	}, nil
 }
 
@@ -621,11 +866,133 @@ func (b *Bucket) BaseURL() string {
 	return b.b2.downloadURI
 }
 
+// Revision returns the bucket's revision.  Update sends it as ifRevisionIs.
+func (b *Bucket) Revision() int {
+	return b.rev
+}
+
+// NotificationRule is one of a bucket's event notification rules, which send
+// a webhook to URL when the given events happen to files with the given
+// prefix.
+type NotificationRule struct {
+	Name          string
+	EventTypes    []string
+	Prefix        string
+	Enabled       bool
+	URL           string
+	SigningSecret string
+	CustomHeaders map[string]string
+
+	// Set by B2 when it stops sending a rule's notifications.
+	Suspended        bool
+	SuspensionReason string
+}
+
+func (r NotificationRule) b2types() b2types.NotificationRule {
+	rule := b2types.NotificationRule{
+		Name:             r.Name,
+		EventTypes:       r.EventTypes,
+		ObjectNamePrefix: r.Prefix,
+		IsEnabled:        r.Enabled,
+		Target: b2types.NotificationTarget{
+			TargetType:    "webhook",
+			URL:           r.URL,
+			SigningSecret: r.SigningSecret,
+		},
+	}
+	for k, v := range r.CustomHeaders {
+		rule.Target.CustomHeaders = append(rule.Target.CustomHeaders, b2types.NotificationHeader{Name: k, Value: v})
+	}
+	sort.Slice(rule.Target.CustomHeaders, func(i, j int) bool {
+		return rule.Target.CustomHeaders[i].Name < rule.Target.CustomHeaders[j].Name
+	})
+	return rule
+}
+
+func notificationRules(rules []b2types.NotificationRule) []NotificationRule {
+	var rtn []NotificationRule
+	for _, r := range rules {
+		rule := NotificationRule{
+			Name:             r.Name,
+			EventTypes:       r.EventTypes,
+			Prefix:           r.ObjectNamePrefix,
+			Enabled:          r.IsEnabled,
+			URL:              r.Target.URL,
+			SigningSecret:    r.Target.SigningSecret,
+			Suspended:        r.IsSuspended,
+			SuspensionReason: r.SuspensionReason,
+		}
+		for _, h := range r.Target.CustomHeaders {
+			if rule.CustomHeaders == nil {
+				rule.CustomHeaders = make(map[string]string)
+			}
+			rule.CustomHeaders[h.Name] = h.Value
+		}
+		rtn = append(rtn, rule)
+	}
+	return rtn
+}
+
+// GetNotificationRules wraps b2_get_bucket_notification_rules.
+func (b *Bucket) GetNotificationRules(ctx context.Context) ([]NotificationRule, error) {
+	b2req := &b2types.GetBucketNotificationRulesRequest{
+		BucketID: b.ID,
+	}
+	b2resp := &b2types.BucketNotificationRulesResponse{}
+	headers := map[string]string{
+		"Authorization": b.b2.authToken,
+	}
+	if err := b.b2.opts.makeRequest(ctx, "b2_get_bucket_notification_rules", "POST", b.b2.apiURI+b2types.V3api+"b2_get_bucket_notification_rules", b2req, b2resp, headers, nil); err != nil {
+		return nil, err
+	}
+	return notificationRules(b2resp.Rules), nil
+}
+
+// SetNotificationRules wraps b2_set_bucket_notification_rules.  The given
+// rules replace all of the bucket's rules; an empty list removes them.  It
+// returns the rules as B2 saved them.
+func (b *Bucket) SetNotificationRules(ctx context.Context, rules []NotificationRule) ([]NotificationRule, error) {
+	b2req := &b2types.SetBucketNotificationRulesRequest{
+		BucketID: b.ID,
+		Rules:    []b2types.NotificationRule{},
+	}
+	for _, r := range rules {
+		b2req.Rules = append(b2req.Rules, r.b2types())
+	}
+	b2resp := &b2types.BucketNotificationRulesResponse{}
+	headers := map[string]string{
+		"Authorization": b.b2.authToken,
+	}
+	if err := b.b2.opts.makeRequest(ctx, "b2_set_bucket_notification_rules", "POST", b.b2.apiURI+b2types.V3api+"b2_set_bucket_notification_rules", b2req, b2resp, headers, nil); err != nil {
+		return nil, err
+	}
+	return notificationRules(b2resp.Rules), nil
+}
+
 // ListBuckets wraps b2_list_buckets.
 func (b *B2) ListBuckets(ctx context.Context) ([]*Bucket, error) {
+	return b.ListBucketsByName(ctx, "")
+}
+
+// ListBucketsByName wraps b2_list_buckets, asking only for the named bucket.
+// The result is empty if there is no such bucket.  An empty name lists every
+// bucket, as ListBuckets does.
+func (b *B2) ListBucketsByName(ctx context.Context, name string) ([]*Bucket, error) {
+	return b.ListBucketsFiltered(ctx, "", name, nil)
+}
+
+// ListBucketsFiltered wraps b2_list_buckets, asking only for the buckets that
+// have the given ID and name and are of one of the given types.  Empty filters
+// match every bucket.  A key restricted to one bucket lists only that bucket.
+func (b *B2) ListBucketsFiltered(ctx context.Context, id, name string, types []string) ([]*Bucket, error) {
+	if id == "" {
+		id = b.allowed.BucketID
+	}
 	b2req := &b2types.ListBucketsRequest{
 		AccountID: b.accountID,
-		Bucket:    b.bucket,
+		Bucket:    id,
+		Name:      name,
+		Types:     types,
 	}
 	b2resp := &b2types.ListBucketsResponse{}
 	headers := map[string]string{
@@ -645,13 +1012,18 @@ func (b *B2) ListBuckets(ctx context.Context) ([]*Bucket, error) {
 			})
 		}
 		buckets = append(buckets, &Bucket{
-			Name:           bucket.Name,
-			Type:           bucket.Type,
-			Info:           bucket.Info,
-			LifecycleRules: rules,
-			ID:             bucket.BucketID,
-			rev:            bucket.Revision,
-			b2:             b,
+			Name:             bucket.Name,
+			Type:             bucket.Type,
+			Info:             bucket.Info,
+			LifecycleRules:   rules,
+			CORSRules:        corsFromB2(bucket.CORSRules),
+			DefaultSSE:       bucketEncryption(bucket.DefaultSSE),
+			FileLock:         fileLockEnabled(bucket.FileLock),
+			DefaultRetention: defaultRetention(bucket.FileLock),
+			Replication:      replication(bucket.Replication),
+			ID:               bucket.BucketID,
+			rev:              bucket.Revision,
+			b2:               b,
 		})
 	}
 	return buckets, nil
@@ -704,18 +1076,26 @@ type File struct {
 	Status    string
 	Timestamp time.Time
 	Info      *FileInfo
+	SHA1      string // as reported by B2 on upload; "none" for large files
 	id        string
 	b2        *B2
 }
 
+// B2 reports the SHA1 of data whose hash was sent after the content as
+// "unverified:<hash>".
+func trimUnverified(sha1 string) string {
+	return strings.TrimPrefix(sha1, "unverified:")
+}
+
 // File returns a bare File struct, but with the appropriate id and b2
 // interfaces.
 func (b *Bucket) File(id, name string) *File {
 	return &File{id: id, b2: b.b2, Name: name}
 }
 
-// UploadFile wraps b2_upload_file.
-func (url *URL) UploadFile(ctx context.Context, r io.Reader, size int, name, contentType, sha1 string, info map[string]string) (*File, error) {
+// UploadFile wraps b2_upload_file.  If retention is not nil, or legalHold is
+// true, the file is placed under Object Lock.
+func (url *URL) UploadFile(ctx context.Context, r io.Reader, size int, name, contentType, sha1 string, info map[string]string, sse *Encryption, retention *Retention, legalHold bool) (*File, error) {
 	headers := map[string]string{
 		"Authorization":     url.token,
 		"X-Bz-File-Name":    name,
@@ -723,6 +1103,11 @@ func (url *URL) UploadFile(ctx context.Context, r io.Reader, size int, name, con
 		"Content-Length":    fmt.Sprintf("%d", size),
 		"X-Bz-Content-Sha1": sha1,
 	}
+	sse.addHeaders(headers)
+	retention.addHeaders(headers)
+	if legalHold {
+		headers["X-Bz-File-Legal-Hold"] = "on"
+	}
 	for k, v := range info {
 		headers[fmt.Sprintf("X-Bz-Info-%s", k)] = v
 	}
@@ -730,14 +1115,26 @@ func (url *URL) UploadFile(ctx context.Context, r io.Reader, size int, name, con
 	if err := url.b2.opts.makeRequest(ctx, "b2_upload_file", "POST", url.uri, nil, b2resp, headers, &requestBody{body: r, size: int64(size)}); err != nil {
 		return nil, err
 	}
-	return &File{
+	b2resp.SHA1 = trimUnverified(b2resp.SHA1)
+	f := &File{
 		Name:      name,
 		Size:      int64(size),
 		Timestamp: millitime(b2resp.Timestamp),
 		Status:    b2resp.Action,
+		SHA1:      b2resp.SHA1,
 		id:        b2resp.FileID,
 		b2:        url.b2,
-	}, nil
+	}
+	if b2resp.Name != "" {
+		// B2 replies with all of the file's info, so it needn't be fetched.
+		f.Info = newFileInfo((*b2types.GetFileInfoResponse)(b2resp), f.Size)
+	}
+	return f, nil
+}
+
+// ID returns the file's ID.
+func (f *File) ID() string {
+	return f.id
 }
 
 // DeleteFileVersion wraps b2_delete_file_version.
@@ -752,6 +1149,55 @@ func (f *File) DeleteFileVersion(ctx context.Context) error {
 	return f.b2.opts.makeRequest(ctx, "b2_delete_file_version", "POST", f.b2.apiURI+b2types.V1api+"b2_delete_file_version", b2req, nil, headers, nil)
 }
 
+// UpdateFileRetention wraps b2_update_file_retention.  A nil retention removes
+// the file's retention.  bypassGovernance allows a governance mode retention
+// to be shortened or removed.
+func (f *File) UpdateFileRetention(ctx context.Context, retention *Retention, bypassGovernance bool) error {
+	b2req := &b2types.UpdateFileRetentionRequest{
+		Name:             f.Name,
+		ID:               f.id,
+		BypassGovernance: bypassGovernance,
+	}
+	if retention != nil {
+		until := retention.RetainUntil.UnixNano() / 1e6
+		b2req.Retention = b2types.UpdateRetention{Mode: &retention.Mode, RetainUntil: &until}
+	}
+	b2resp := &b2types.UpdateFileRetentionResponse{}
+	headers := map[string]string{
+		"Authorization": f.b2.authToken,
+	}
+	if err := f.b2.opts.makeRequest(ctx, "b2_update_file_retention", "POST", f.b2.apiURI+b2types.V1api+"b2_update_file_retention", b2req, b2resp, headers, nil); err != nil {
+		return err
+	}
+	if f.Info != nil {
+		f.Info.Retention = retention
+	}
+	return nil
+}
+
+// UpdateFileLegalHold wraps b2_update_file_legal_hold.
+func (f *File) UpdateFileLegalHold(ctx context.Context, on bool) error {
+	b2req := &b2types.UpdateFileLegalHoldRequest{
+		Name:      f.Name,
+		ID:        f.id,
+		LegalHold: "off",
+	}
+	if on {
+		b2req.LegalHold = "on"
+	}
+	b2resp := &b2types.UpdateFileLegalHoldResponse{}
+	headers := map[string]string{
+		"Authorization": f.b2.authToken,
+	}
+	if err := f.b2.opts.makeRequest(ctx, "b2_update_file_legal_hold", "POST", f.b2.apiURI+b2types.V1api+"b2_update_file_legal_hold", b2req, b2resp, headers, nil); err != nil {
+		return err
+	}
+	if f.Info != nil {
+		f.Info.LegalHold = on
+	}
+	return nil
+}
+
 // LargeFile holds information necessary to implement B2 large file support.
 type LargeFile struct {
 	id string
@@ -762,13 +1208,19 @@ type LargeFile struct {
 	hashes map[int]string
 }
 
-// StartLargeFile wraps b2_start_large_file.
-func (b *Bucket) StartLargeFile(ctx context.Context, name, contentType string, info map[string]string) (*LargeFile, error) {
+// StartLargeFile wraps b2_start_large_file.  If retention is not nil, or
+// legalHold is true, the file is placed under Object Lock when it is finished.
+func (b *Bucket) StartLargeFile(ctx context.Context, name, contentType string, info map[string]string, sse *Encryption, retention *Retention, legalHold bool) (*LargeFile, error) {
 	b2req := &b2types.StartLargeFileRequest{
 		BucketID:    b.ID,
 		Name:        name,
 		ContentType: contentType,
 		Info:        info,
+		SSE:         sse.b2types(),
+		Retention:   retention.b2types(),
+	}
+	if legalHold {
+		b2req.LegalHold = "on"
 	}
 	b2resp := &b2types.StartLargeFileResponse{}
 	headers := map[string]string{
@@ -784,6 +1236,22 @@ func (b *Bucket) StartLargeFile(ctx context.Context, name, contentType string, i
 	}, nil
 }
 
+// ID returns the large file's ID.
+func (l *LargeFile) ID() string {
+	return l.id
+}
+
+// Hashes returns a mapping of completed part numbers to SHA1 strings.
+func (l *LargeFile) Hashes() map[int]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := make(map[int]string)
+	for k, v := range l.hashes {
+		h[k] = v
+	}
+	return h
+}
+
 // CancelLargeFile wraps b2_cancel_large_file.
 func (l *LargeFile) CancelLargeFile(ctx context.Context) error {
 	b2req := &b2types.CancelLargeFileRequest{
@@ -890,23 +1358,31 @@ func (fc *FileChunk) Reload(ctx context.Context) error {
 }
 
 // UploadPart wraps b2_upload_part.
-func (fc *FileChunk) UploadPart(ctx context.Context, r io.Reader, sha1 string, size, index int) (int, error) {
+func (fc *FileChunk) UploadPart(ctx context.Context, r io.Reader, sha1 string, size, index int, sse *Encryption) (int, error) {
 	headers := map[string]string{
 		"Authorization":     fc.token,
 		"X-Bz-Part-Number":  fmt.Sprintf("%d", index),
 		"Content-Length":    fmt.Sprintf("%d", size),
 		"X-Bz-Content-Sha1": sha1,
 	}
+	if sse != nil && sse.Mode == SSEC {
+		sse.addHeaders(headers)
+	}
 	if sha1 == "hex_digits_at_end" {
 		r = &keepFinalBytes{r: r, remain: size}
 	}
-	if err := fc.file.b2.opts.makeRequest(ctx, "b2_upload_part", "POST", fc.url, nil, nil, headers, &requestBody{body: r, size: int64(size)}); err != nil {
+	b2resp := &b2types.UploadPartResponse{}
+	if err := fc.file.b2.opts.makeRequest(ctx, "b2_upload_part", "POST", fc.url, nil, b2resp, headers, &requestBody{body: r, size: int64(size)}); err != nil {
 		return 0, err
 	}
 	fc.file.mu.Lock()
 	if sha1 == "hex_digits_at_end" {
 		sha1 = string(r.(*keepFinalBytes).sha[:])
 	}
+	if sha := trimUnverified(b2resp.SHA1); sha != "" {
+		// Record what B2 has, which is what b2_finish_large_file will check.
+		sha1 = sha
+	}
 	fc.file.hashes[index] = sha1
 	fc.file.size += int64(size)
 	fc.file.mu.Unlock()
@@ -934,14 +1410,20 @@ func (l *LargeFile) FinishLargeFile(ctx context.Context) (*File, error) {
 	if err := l.b2.opts.makeRequest(ctx, "b2_finish_large_file", "POST", l.b2.apiURI+b2types.V1api+"b2_finish_large_file", b2req, b2resp, headers, nil); err != nil {
 		return nil, err
 	}
-	return &File{
+	f := &File{
 		Name:      b2resp.Name,
 		Size:      l.size,
 		Timestamp: millitime(b2resp.Timestamp),
 		Status:    b2resp.Action,
+		SHA1:      b2resp.SHA1,
 		id:        b2resp.FileID,
 		b2:        l.b2,
-	}, nil
+	}
+	if b2resp.Name != "" {
+		// B2 replies with all of the file's info, so it needn't be fetched.
+		f.Info = newFileInfo((*b2types.GetFileInfoResponse)(b2resp), f.Size)
+	}
+	return f, nil
 }
 
 // ListUnfinishedLargeFiles wraps b2_list_unfinished_large_files.
@@ -980,7 +1462,7 @@ func (b *Bucket) ListUnfinishedLargeFiles(ctx context.Context, count int, contin
 // ListFileNames wraps b2_list_file_names.
 func (b *Bucket) ListFileNames(ctx context.Context, count int, continuation, prefix, delimiter string) ([]*File, string, error) {
 	if prefix == "" {
-		prefix = b.b2.pfx
+		prefix = b.b2.allowed.Prefix
 	}
 	b2req := &b2types.ListFileNamesRequest{
 		Count:        count,
@@ -1023,7 +1505,7 @@ func (b *Bucket) ListFileNames(ctx context.Context, count int, continuation, pre
 // ListFileVersions wraps b2_list_file_versions.
 func (b *Bucket) ListFileVersions(ctx context.Context, count int, startName, startID, prefix, delimiter string) ([]*File, string, string, error) {
 	if prefix == "" {
-		prefix = b.b2.pfx
+		prefix = b.b2.allowed.Prefix
 	}
 	b2req := &b2types.ListFileVersionsRequest{
 		BucketID:  b.ID,
@@ -1084,11 +1566,15 @@ func (b *Bucket) GetDownloadAuthorization(ctx context.Context, prefix string, va
 // FileReader is an io.ReadCloser that downloads a file from B2.
 type FileReader struct {
 	io.ReadCloser
-	ContentLength int
+	ContentLength int // The length of the body, which may be a range.
 	ContentType   string
 	SHA1          string
 	ID            string
 	Info          map[string]string
+	Name          string
+	Size          int64 // The length of the whole file.
+	Timestamp     time.Time
+	SSE           *Encryption // nil if the file is not encrypted
 }
 
 func mkRange(offset, size int64) string {
@@ -1102,29 +1588,48 @@ func mkRange(offset, size int64) string {
 }
 
 // DownloadFileByName wraps b2_download_file_by_name.
-func (b *Bucket) DownloadFileByName(ctx context.Context, name string, offset, size int64) (*FileReader, error) {
-	uri := fmt.Sprintf("%s/file/%s/%s", b.b2.downloadURI, b.Name, escape(name))
+func (b *Bucket) DownloadFileByName(ctx context.Context, name string, offset, size int64, sse *Encryption) (*FileReader, error) {
+	uri := fmt.Sprintf("%s/file/%s/%s", b.b2.downloadURI, b.Name, EncodeName(name))
+	return b.b2.download(ctx, "b2_download_file_by_name", uri, offset, size, sse)
+}
+
+// DownloadFileByID wraps b2_download_file_by_id.
+func (b *Bucket) DownloadFileByID(ctx context.Context, id string, offset, size int64, sse *Encryption) (*FileReader, error) {
+	uri := fmt.Sprintf("%s%sb2_download_file_by_id?fileId=%s", b.b2.downloadURI, b2types.V1api, escape(id))
+	return b.b2.download(ctx, "b2_download_file_by_id", uri, offset, size, sse)
+}
+
+// download fetches a file from uri.  Only an SSE-C sse is sent; B2 decrypts
+// other files without being asked.
+func (b *B2) download(ctx context.Context, method, uri string, offset, size int64, sse *Encryption) (*FileReader, error) {
 	req, err := http.NewRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", b.b2.authToken)
+	req.Header.Set("Authorization", b.authToken)
 	req.Header.Set("X-Blazer-Request-ID", fmt.Sprintf("%d", atomic.AddInt64(&reqID, 1)))
-	req.Header.Set("X-Blazer-Method", "b2_download_file_by_name")
-	b.b2.opts.addHeaders(req)
+	req.Header.Set("X-Blazer-Method", method)
+	b.opts.addHeaders(req)
 	rng := mkRange(offset, size)
 	if rng != "" {
 		req.Header.Set("Range", rng)
 	}
-	logRequest(req, nil)
-	resp, err := makeNetRequest(ctx, req, b.b2.opts.getTransport())
+	if sse != nil && sse.Mode == SSEC {
+		headers := make(map[string]string)
+		sse.addHeaders(headers)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+	}
+	b.opts.logRequest(req, nil)
+	resp, err := b.opts.makeNetRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	logResponse(resp, nil)
+	b.opts.logResponse(resp, nil)
 	if resp.StatusCode != 200 && resp.StatusCode != 206 {
 		defer resp.Body.Close()
-		return nil, mkErr(resp)
+		return nil, b.opts.mkErr(resp)
 	}
 	clen, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
@@ -1141,6 +1646,8 @@ func (b *Bucket) DownloadFileByName(ctx context.Context, name string, offset, si
 			resp.Body.Close()
 			return nil, err
 		}
+		// B2 stores info names in lower case; net/http capitalizes them.
+		name = strings.ToLower(name)
 		val, err := unescape(resp.Header.Get(key))
 		if err != nil {
 			resp.Body.Close()
@@ -1148,9 +1655,42 @@ func (b *Bucket) DownloadFileByName(ctx context.Context, name string, offset, si
 		}
 		info[name] = val
 	}
+	// B2 sends an object's b2-content-encoding as its Content-Encoding.
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && info["b2-content-encoding"] == "" {
+		info["b2-content-encoding"] = ce
+	}
 	sha1 := resp.Header.Get("X-Bz-Content-Sha1")
-	if sha1 == "none" && info["Large_file_sha1"] != "" {
-		sha1 = info["Large_file_sha1"]
+	if sha1 == "none" && info["large_file_sha1"] != "" {
+		sha1 = info["large_file_sha1"]
+	}
+	name, err := unescape(resp.Header.Get("X-Bz-File-Name"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	total := clen
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		// Content-Range: bytes <first>-<last>/<size>
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				total = n
+			}
+		}
+	}
+	var stamp time.Time
+	if ts := resp.Header.Get("X-Bz-Upload-Timestamp"); ts != "" {
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		stamp = millitime(ms)
+	}
+	var enc *Encryption
+	if alg := resp.Header.Get("X-Bz-Server-Side-Encryption-Customer-Algorithm"); alg != "" {
+		enc = &Encryption{Mode: SSEC, Algorithm: alg}
+	} else if alg := resp.Header.Get("X-Bz-Server-Side-Encryption"); alg != "" {
+		enc = &Encryption{Mode: SSEB2, Algorithm: alg}
 	}
 	return &FileReader{
 		ReadCloser:    resp.Body,
@@ -1159,9 +1699,226 @@ func (b *Bucket) DownloadFileByName(ctx context.Context, name string, offset, si
 		ContentType:   resp.Header.Get("Content-Type"),
 		ContentLength: int(clen),
 		Info:          info,
+		Name:          name,
+		Size:          total,
+		Timestamp:     stamp,
+		SSE:           enc,
+	}, nil
+}
+
+// Encryption modes.
+const (
+	SSEB2 = "SSE-B2" // B2 manages the keys.
+	SSEC  = "SSE-C"  // The caller supplies the key with each request.
+)
+
+const sseKeyHeader = "X-Bz-Server-Side-Encryption-Customer-Key"
+
+// Encryption describes the server-side encryption of a file, or the default
+// for a bucket.
+type Encryption struct {
+	Mode      string // e.g. SSEB2
+	Algorithm string // e.g. "AES256"
+	Key       []byte // For SSEC, the raw key.
+}
+
+func (e *Encryption) b2types() *b2types.ServerSideEncryption {
+	if e == nil {
+		return nil
+	}
+	s := &b2types.ServerSideEncryption{
+		Mode:      e.Mode,
+		Algorithm: e.Algorithm,
+	}
+	if e.Mode == SSEC {
+		s.CustomerKey, s.CustomerKeyMD5 = e.encodedKey()
+	}
+	return s
+}
+
+// encodedKey returns the base64 encodings of the key and of its MD5 sum, as B2
+// expects them.
+func (e *Encryption) encodedKey() (string, string) {
+	sum := md5.Sum(e.Key)
+	return base64.StdEncoding.EncodeToString(e.Key), base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// addHeaders adds the upload or download headers for e to headers.
+func (e *Encryption) addHeaders(headers map[string]string) {
+	if e == nil {
+		return
+	}
+	switch e.Mode {
+	case SSEB2:
+		headers["X-Bz-Server-Side-Encryption"] = e.Algorithm
+	case SSEC:
+		key, sum := e.encodedKey()
+		headers["X-Bz-Server-Side-Encryption-Customer-Algorithm"] = e.Algorithm
+		headers[sseKeyHeader] = key
+		headers[sseKeyHeader+"-Md5"] = sum
+	}
+}
+
+func encryption(s *b2types.ServerSideEncryption) *Encryption {
+	if s == nil || s.Mode == "" {
+		return nil
+	}
+	return &Encryption{
+		Mode:      s.Mode,
+		Algorithm: s.Algorithm,
+	}
+}
+
+func bucketEncryption(s *b2types.BucketServerSideEncryption) *Encryption {
+	if s == nil {
+		return nil
+	}
+	return encryption(s.Value)
+}
+
+// DefaultRetention is a bucket's default Object Lock retention.
+type DefaultRetention struct {
+	Mode     string // "governance" or "compliance"
+	Duration int
+	Unit     string // "days" or "years"
+}
+
+func (r *DefaultRetention) b2types() *b2types.DefaultRetention {
+	if r == nil {
+		return nil
+	}
+	if r.Mode == "" {
+		return &b2types.DefaultRetention{}
+	}
+	mode := r.Mode
+	return &b2types.DefaultRetention{
+		Mode:   &mode,
+		Period: &b2types.RetentionPeriod{Duration: r.Duration, Unit: r.Unit},
+	}
+}
+
+func defaultRetention(c *b2types.FileLockConfiguration) *DefaultRetention {
+	if c == nil || !c.Authorized || c.Value == nil {
+		return nil
+	}
+	r := c.Value.DefaultRetention
+	if r == nil || r.Mode == nil || *r.Mode == "" || r.Period == nil {
+		return nil
+	}
+	return &DefaultRetention{Mode: *r.Mode, Duration: r.Period.Duration, Unit: r.Period.Unit}
+}
+
+func fileLockEnabled(c *b2types.FileLockConfiguration) *bool {
+	if c == nil || !c.Authorized || c.Value == nil {
+		return nil
+	}
+	enabled := c.Value.Enabled
+	return &enabled
+}
+
+// Retention is the Object Lock retention setting of a file.
+type Retention struct {
+	Mode        string // "governance" or "compliance"
+	RetainUntil time.Time
+}
+
+func (r *Retention) b2types() *b2types.Retention {
+	if r == nil {
+		return nil
+	}
+	return &b2types.Retention{
+		Mode:        r.Mode,
+		RetainUntil: r.RetainUntil.UnixNano() / 1e6,
+	}
+}
+
+func (r *Retention) addHeaders(headers map[string]string) {
+	if r == nil {
+		return
+	}
+	headers["X-Bz-File-Retention-Mode"] = r.Mode
+	headers["X-Bz-File-Retention-Retain-Until-Timestamp"] = fmt.Sprintf("%d", r.RetainUntil.UnixNano()/1e6)
+}
+
+func retention(r *b2types.FileRetention) *Retention {
+	if r == nil || r.Value == nil || r.Value.Mode == "" {
+		return nil
+	}
+	return &Retention{
+		Mode:        r.Value.Mode,
+		RetainUntil: millitime(r.Value.RetainUntil),
+	}
+}
+
+// CopyFile wraps b2_copy_file.  The copy is named name in bucket b.  If
+// replace is false, the copy keeps the content type and info of the source,
+// and contentType and info must be empty.  srcSSE must hold the key of an
+// SSE-C source; dstSSE sets the encryption of the copy.
+func (b *Bucket) CopyFile(ctx context.Context, srcID, name string, replace bool, contentType string, info map[string]string, retention *Retention, srcSSE, dstSSE *Encryption) (*File, error) {
+	b2req := &b2types.CopyFileRequest{
+		SourceID:    srcID,
+		DestBucket:  b.ID,
+		Name:        name,
+		Directive:   "COPY",
+		ContentType: contentType,
+		Info:        info,
+		Retention:   retention.b2types(),
+		DestSSE:     dstSSE.b2types(),
+	}
+	if srcSSE != nil && srcSSE.Mode == SSEC {
+		b2req.SourceSSE = srcSSE.b2types()
+	}
+	if replace {
+		b2req.Directive = "REPLACE"
+	}
+	b2resp := &b2types.CopyFileResponse{}
+	headers := map[string]string{
+		"Authorization": b.b2.authToken,
+	}
+	if err := b.b2.opts.makeRequest(ctx, "b2_copy_file", "POST", b.b2.apiURI+b2types.V1api+"b2_copy_file", b2req, b2resp, headers, nil); err != nil {
+		return nil, err
+	}
+	return &File{
+		Name:      b2resp.Name,
+		Size:      b2resp.Size,
+		Timestamp: millitime(b2resp.Timestamp),
+		Status:    b2resp.Action,
+		SHA1:      b2resp.SHA1,
+		id:        b2resp.FileID,
+		b2:        b.b2,
 	}, nil
 }
 
+// CopyPart wraps b2_copy_part.  It copies size bytes of the source file,
+// starting at offset, into the given part of l.  srcSSE and dstSSE are needed
+// only for SSE-C files.
+func (l *LargeFile) CopyPart(ctx context.Context, srcID string, part int, offset, size int64, srcSSE, dstSSE *Encryption) error {
+	b2req := &b2types.CopyPartRequest{
+		SourceID:    srcID,
+		LargeFileID: l.id,
+		PartNumber:  part,
+		Range:       mkRange(offset, size),
+	}
+	if srcSSE != nil && srcSSE.Mode == SSEC {
+		b2req.SourceSSE = srcSSE.b2types()
+	}
+	if dstSSE != nil && dstSSE.Mode == SSEC {
+		b2req.DestSSE = dstSSE.b2types()
+	}
+	b2resp := &b2types.CopyPartResponse{}
+	headers := map[string]string{
+		"Authorization": l.b2.authToken,
+	}
+	if err := l.b2.opts.makeRequest(ctx, "b2_copy_part", "POST", l.b2.apiURI+b2types.V1api+"b2_copy_part", b2req, b2resp, headers, nil); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.hashes[part] = b2resp.SHA1
+	l.size += b2resp.Size
+	l.mu.Unlock()
+	return nil
+}
+
 // HideFile wraps b2_hide_file.
 func (b *Bucket) HideFile(ctx context.Context, name string) (*File, error) {
 	b2req := &b2types.HideFileRequest{
@@ -1193,6 +1950,9 @@ type FileInfo struct {
 	Info        map[string]string
 	Status      string
 	Timestamp   time.Time
+	SSE         *Encryption
+	Retention   *Retention // nil if the file has none, or it may not be read
+	LegalHold   bool
 }
 
 // GetFileInfo wraps b2_get_file_info.
@@ -1210,16 +1970,24 @@ func (f *File) GetFileInfo(ctx context.Context) (*FileInfo, error) {
 	f.Status = b2resp.Action
 	f.Name = b2resp.Name
 	f.Timestamp = millitime(b2resp.Timestamp)
-	f.Info = &FileInfo{
+	f.Info = newFileInfo(b2resp, b2resp.Size)
+	return f.Info, nil
+}
+
+// newFileInfo returns the info B2 reported for a file of the given size.
+func newFileInfo(b2resp *b2types.GetFileInfoResponse, size int64) *FileInfo {
+	return &FileInfo{
 		Name:        b2resp.Name,
 		SHA1:        b2resp.SHA1,
-		Size:        b2resp.Size,
+		Size:        size,
 		ContentType: b2resp.ContentType,
 		Info:        b2resp.Info,
 		Status:      b2resp.Action,
 		Timestamp:   millitime(b2resp.Timestamp),
+		SSE:         encryption(b2resp.SSE),
+		Retention:   retention(b2resp.Retention),
+		LegalHold:   b2resp.LegalHold != nil && b2resp.LegalHold.Value == "on",
 	}
-	return f.Info, nil
 }
 
 // Key is a B2 application key.
@@ -1228,10 +1996,28 @@ type Key struct {
 	Secret       string
 	Name         string
 	Capabilities []string
-	Expires      time.Time
+	Expires      time.Time // zero if the key does not expire
+	BucketID     string
+	Prefix       string
 	b2           *B2
 }
 
+func (b *B2) key(k *b2types.Key) *Key {
+	key := &Key{
+		Name:         k.Name,
+		ID:           k.ID,
+		Secret:       k.Secret,
+		Capabilities: k.Capabilities,
+		BucketID:     k.BucketID,
+		Prefix:       k.Prefix,
+		b2:           b,
+	}
+	if k.Expires != 0 {
+		key.Expires = millitime(k.Expires)
+	}
+	return key
+}
+
 // CreateKey wraps b2_create_key.
 func (b *B2) CreateKey(ctx context.Context, name string, caps []string, valid time.Duration, bucketID string, prefix string) (*Key, error) {
 	b2req := &b2types.CreateKeyRequest{
@@ -1249,14 +2035,7 @@ func (b *B2) CreateKey(ctx context.Context, name string, caps []string, valid ti
 	if err := b.opts.makeRequest(ctx, "b2_create_key", "POST", b.apiURI+b2types.V1api+"b2_create_key", b2req, b2resp, headers, nil); err != nil {
 		return nil, err
 	}
-	return &Key{
-		Name:         b2resp.Name,
-		ID:           b2resp.ID,
-		Secret:       b2resp.Secret,
-		Capabilities: b2resp.Capabilities,
-		Expires:      millitime(b2resp.Expires),
-		b2:           b,
-	}, nil
+	return b.key((*b2types.Key)(b2resp)), nil
 }
 
 // Delete wraps b2_delete_key.
@@ -1281,18 +2060,12 @@ func (b *B2) ListKeys(ctx context.Context, max int, next string) ([]*Key, string
 		"Authorization": b.authToken,
 	}
 	b2resp := &b2types.ListKeysResponse{}
-	if err := b.opts.makeRequest(ctx, "b2_create_key", "POST", b.apiURI+b2types.V1api+"b2_create_key", b2req, b2resp, headers, nil); err != nil {
+	if err := b.opts.makeRequest(ctx, "b2_list_keys", "POST", b.apiURI+b2types.V1api+"b2_list_keys", b2req, b2resp, headers, nil); err != nil {
 		return nil, "", err
 	}
 	var keys []*Key
-	for _, key := range b2resp.Keys {
-		keys = append(keys, &Key{
-			Name:         key.Name,
-			ID:           key.ID,
-			Capabilities: key.Capabilities,
-			Expires:      millitime(key.Expires),
-			b2:           b,
-		})
+	for i := range b2resp.Keys {
+		keys = append(keys, b.key(&b2resp.Keys[i]))
 	}
 	return keys, b2resp.Next, nil
 }
@@ -73,7 +73,7 @@ func TestStorage(t *testing.T) {
 		},
 	}
 	bname := id + "-" + bucketName
-	bucket, err := b2.CreateBucket(ctx, bname, "", m, rules)
+	bucket, err := b2.CreateBucket(ctx, bname, "", m, rules, nil, nil, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,7 +142,7 @@ func TestStorage(t *testing.T) {
 		"one": "1",
 		"two": "2",
 	}
-	file, err := ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, smallInfoMap)
+	file, err := ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, smallInfoMap, nil, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -159,7 +159,7 @@ func TestStorage(t *testing.T) {
 		"one_billion":  "1e9",
 		"two_trillion": "2eSomething, I guess 2e12",
 	}
-	lf, err := bucket.StartLargeFile(ctx, largeFileName, "application/octet-stream", largeInfoMap)
+	lf, err := bucket.StartLargeFile(ctx, largeFileName, "application/octet-stream", largeInfoMap, nil, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -180,7 +180,7 @@ func TestStorage(t *testing.T) {
 		if _, err := io.Copy(w, r); err != nil {
 			t.Error(err)
 		}
-		if _, err := fc.UploadPart(ctx, buf, fmt.Sprintf("%x", hash.Sum(nil)), buf.Len(), i+1); err != nil {
+		if _, err := fc.UploadPart(ctx, buf, fmt.Sprintf("%x", hash.Sum(nil)), buf.Len(), i+1, nil); err != nil {
 			t.Error(err)
 		}
 	}
@@ -209,7 +209,7 @@ func TestStorage(t *testing.T) {
 		}
 	}()
 
-	clf, err := bucket.StartLargeFile(ctx, largeFileName, "application/octet-stream", nil)
+	clf, err := bucket.StartLargeFile(ctx, largeFileName, "application/octet-stream", nil, nil, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -229,7 +229,7 @@ func TestStorage(t *testing.T) {
 	}
 
 	// b2_download_file_by_name
-	fr, err := bucket.DownloadFileByName(ctx, smallFileName, 0, 0)
+	fr, err := bucket.DownloadFileByName(ctx, smallFileName, 0, 0, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -292,7 +292,7 @@ func TestUploadAuthAfterConnectionHang(t *testing.T) {
 		t.Fatal(err)
 	}
 	bname := id + "-" + bucketName
-	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil)
+	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil, nil, nil, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -316,7 +316,7 @@ func TestUploadAuthAfterConnectionHang(t *testing.T) {
 	smallSHA1 := fmt.Sprintf("%x", hash.Sum(nil))
 
 	go func() {
-		ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil)
+		ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil, nil, nil, false)
 	}()
 
 	<-hung
@@ -328,7 +328,7 @@ func TestUploadAuthAfterConnectionHang(t *testing.T) {
 	if _, err := io.Copy(buf, smallFile); err != nil {
 		t.Error(err)
 	}
-	file, err := ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil)
+	file, err := ue.UploadFile(ctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil, nil, nil, false)
 	if err == nil {
 		t.Error("expected an error, got none")
 		if err := file.DeleteFileVersion(ctx); err != nil {
@@ -355,7 +355,7 @@ func TestCancelledContextCancelsHTTPRequest(t *testing.T) {
 		t.Fatal(err)
 	}
 	bname := id + "-" + bucketName
-	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil)
+	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil, nil, nil, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -382,7 +382,7 @@ func TestCancelledContextCancelsHTTPRequest(t *testing.T) {
 		time.Sleep(1)
 		cancel()
 	}()
-	if _, err := ue.UploadFile(cctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil); err != context.Canceled {
+	if _, err := ue.UploadFile(cctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil, nil, nil, false); err != context.Canceled {
 		t.Errorf("expected canceled context, but got %v", err)
 	}
 }
@@ -401,7 +401,7 @@ func TestDeadlineExceededContextCancelsHTTPRequest(t *testing.T) {
 		t.Fatal(err)
 	}
 	bname := id + "-" + bucketName
-	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil)
+	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil, nil, nil, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -425,7 +425,7 @@ func TestDeadlineExceededContextCancelsHTTPRequest(t *testing.T) {
 	smallSHA1 := fmt.Sprintf("%x", hash.Sum(nil))
 	cctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	if _, err := ue.UploadFile(cctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil); err != context.DeadlineExceeded {
+	if _, err := ue.UploadFile(cctx, buf, buf.Len(), smallFileName, "application/octet-stream", smallSHA1, nil, nil, nil, false); err != context.DeadlineExceeded {
 		t.Errorf("expected deadline exceeded error, but got %v", err)
 	}
 }
@@ -561,6 +561,9 @@ func TestEscapes(t *testing.T) {
 		if !(en == tc.Full || en == tc.Min) {
 			t.Errorf("encode %q: got %q, want %q or %q", tc.Raw, en, tc.Min, tc.Full)
 		}
+		if en := EncodeName(tc.Raw); !(en == tc.Full || en == tc.Min) {
+			t.Errorf("EncodeName(%q): got %q, want %q or %q", tc.Raw, en, tc.Min, tc.Full)
+		}
 
 		m, err := unescape(tc.Min)
 		if err != nil {
@@ -598,7 +601,7 @@ func TestUploadDownloadFilenameEscaping(t *testing.T) {
 
 	// b2_create_bucket
 	bname := id + "-" + bucketName
-	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil)
+	bucket, err := b2.CreateBucket(ctx, bname, "", nil, nil, nil, nil, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -625,7 +628,7 @@ func TestUploadDownloadFilenameEscaping(t *testing.T) {
 		t.Error(err)
 	}
 	smallSHA1 := fmt.Sprintf("%x", hash.Sum(nil))
-	file, err := ue.UploadFile(ctx, buf, buf.Len(), filename, "application/octet-stream", smallSHA1, nil)
+	file, err := ue.UploadFile(ctx, buf, buf.Len(), filename, "application/octet-stream", smallSHA1, nil, nil, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -638,7 +641,7 @@ func TestUploadDownloadFilenameEscaping(t *testing.T) {
 	}()
 
 	// b2_download_file_by_name
-	fr, err := bucket.DownloadFileByName(ctx, filename, 0, 0)
+	fr, err := bucket.DownloadFileByName(ctx, filename, 0, 0, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -15,10 +15,29 @@
 package base
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
 )
 
+// EncodeName percent-encodes a file name as B2 requires in download URLs and
+// in the X-Bz-File-Name header.  Slashes, letters, digits, and the characters
+// B2 leaves unencoded, -._~!$'()*;=:@, are kept; every other byte is encoded.
+// Spaces become %20 rather than +, and plus signs %2B, so that the result is
+// read the same by B2 and as a URL path.
+func EncodeName(name string) string {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("/-._~!$'()*;=:@", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
 func escape(s string) string {
 	return strings.Replace(url.QueryEscape(s), "%2F", "/", -1)
 }
@@ -20,6 +20,7 @@ package b2types
 
 const (
 	V1api = "/b2api/v1/"
+	V3api = "/b2api/v3/" // for calls that have no v1 form
 )
 
 type ErrorMessage struct {
@@ -33,6 +34,7 @@ type AuthorizeAccountResponse struct {
 	AuthToken      string    `json:"authorizationToken"`
 	URI            string    `json:"apiUrl"`
 	DownloadURI    string    `json:"downloadUrl"`
+	S3URI          string    `json:"s3ApiUrl"`
 	MinPartSize    int       `json:"minimumPartSize"`
 	PartSize       int       `json:"recommendedPartSize"`
 	AbsMinPartSize int       `json:"absoluteMinimumPartSize"`
@@ -42,6 +44,7 @@ type AuthorizeAccountResponse struct {
 type Allowance struct {
 	Capabilities []string `json:"capabilities"`
 	Bucket       string   `json:"bucketId"`
+	BucketName   string   `json:"bucketName"`
 	Prefix       string   `json:"namePrefix"`
 }
 
@@ -51,21 +54,100 @@ type LifecycleRule struct {
 	Prefix                 string `json:"fileNamePrefix"`
 }
 
+type CORSRule struct {
+	Name              string   `json:"corsRuleName"`
+	AllowedOrigins    []string `json:"allowedOrigins"`
+	AllowedOperations []string `json:"allowedOperations"`
+	AllowedHeaders    []string `json:"allowedHeaders,omitempty"`
+	ExposeHeaders     []string `json:"exposeHeaders,omitempty"`
+	MaxAgeSeconds     int      `json:"maxAgeSeconds"`
+}
+
+type ServerSideEncryption struct {
+	Mode           string `json:"mode,omitempty"`
+	Algorithm      string `json:"algorithm,omitempty"`
+	CustomerKey    string `json:"customerKey,omitempty"`
+	CustomerKeyMD5 string `json:"customerKeyMd5,omitempty"`
+}
+
+type BucketServerSideEncryption struct {
+	Authorized bool                  `json:"isClientAuthorizedToRead"`
+	Value      *ServerSideEncryption `json:"value"`
+}
+
+type FileLockConfiguration struct {
+	Authorized bool           `json:"isClientAuthorizedToRead"`
+	Value      *FileLockValue `json:"value"`
+}
+
+type FileLockValue struct {
+	Enabled          bool              `json:"isFileLockEnabled"`
+	DefaultRetention *DefaultRetention `json:"defaultRetention,omitempty"`
+}
+
+// DefaultRetention has a nil Mode and Period when the bucket has no default.
+type DefaultRetention struct {
+	Mode   *string          `json:"mode"`
+	Period *RetentionPeriod `json:"period"`
+}
+
+type RetentionPeriod struct {
+	Duration int    `json:"duration"`
+	Unit     string `json:"unit"`
+}
+
+type BucketReplication struct {
+	Authorized bool                      `json:"isClientAuthorizedToRead"`
+	Value      *ReplicationConfiguration `json:"value"`
+}
+
+type ReplicationConfiguration struct {
+	AsSource      *ReplicationSource      `json:"asReplicationSource,omitempty"`
+	AsDestination *ReplicationDestination `json:"asReplicationDestination,omitempty"`
+}
+
+type ReplicationSource struct {
+	Rules []ReplicationRule `json:"replicationRules"`
+	KeyID string            `json:"sourceApplicationKeyId"`
+}
+
+type ReplicationRule struct {
+	Name            string `json:"replicationRuleName"`
+	DestBucketID    string `json:"destinationBucketId"`
+	Prefix          string `json:"fileNamePrefix"`
+	Priority        int    `json:"priority"`
+	IncludeExisting bool   `json:"includeExistingFiles"`
+	Enabled         bool   `json:"isEnabled"`
+}
+
+type ReplicationDestination struct {
+	KeyMapping map[string]string `json:"sourceToDestinationKeyMapping"`
+}
+
 type CreateBucketRequest struct {
-	AccountID      string            `json:"accountId"`
-	Name           string            `json:"bucketName"`
-	Type           string            `json:"bucketType"`
-	Info           map[string]string `json:"bucketInfo"`
-	LifecycleRules []LifecycleRule   `json:"lifecycleRules"`
+	AccountID       string                `json:"accountId"`
+	Name            string                `json:"bucketName"`
+	Type            string                `json:"bucketType"`
+	Info            map[string]string     `json:"bucketInfo"`
+	LifecycleRules  []LifecycleRule       `json:"lifecycleRules"`
+	CORSRules       []CORSRule            `json:"corsRules,omitempty"`
+	DefaultSSE      *ServerSideEncryption `json:"defaultServerSideEncryption,omitempty"`
+	FileLockEnabled bool                  `json:"fileLockEnabled,omitempty"`
+
+	Replication *ReplicationConfiguration `json:"replicationConfiguration,omitempty"`
 }
 
 type CreateBucketResponse struct {
-	BucketID       string            `json:"bucketId"`
-	Name           string            `json:"bucketName"`
-	Type           string            `json:"bucketType"`
-	Info           map[string]string `json:"bucketInfo"`
-	LifecycleRules []LifecycleRule   `json:"lifecycleRules"`
-	Revision       int               `json:"revision"`
+	BucketID       string                      `json:"bucketId"`
+	Name           string                      `json:"bucketName"`
+	Type           string                      `json:"bucketType"`
+	Info           map[string]string           `json:"bucketInfo"`
+	LifecycleRules []LifecycleRule             `json:"lifecycleRules"`
+	CORSRules      []CORSRule                  `json:"corsRules"`
+	Revision       int                         `json:"revision"`
+	DefaultSSE     *BucketServerSideEncryption `json:"defaultServerSideEncryption,omitempty"`
+	FileLock       *FileLockConfiguration      `json:"fileLockConfiguration,omitempty"`
+	Replication    *BucketReplication          `json:"replicationConfiguration,omitempty"`
 }
 
 type DeleteBucketRequest struct {
@@ -74,8 +156,10 @@ type DeleteBucketRequest struct {
 }
 
 type ListBucketsRequest struct {
-	AccountID string `json:"accountId"`
-	Bucket    string `json:"bucketId,omitempty"`
+	AccountID string   `json:"accountId"`
+	Bucket    string   `json:"bucketId,omitempty"`
+	Name      string   `json:"bucketName,omitempty"`
+	Types     []string `json:"bucketTypes,omitempty"`
 }
 
 type ListBucketsResponse struct {
@@ -86,9 +170,15 @@ type UpdateBucketRequest struct {
 	AccountID      string            `json:"accountId"`
 	BucketID       string            `json:"bucketId"`
 	Type           string            `json:"bucketType,omitempty"`
-	Info           map[string]string `json:"bucketInfo,omitempty"`
-	LifecycleRules []LifecycleRule   `json:"lifecycleRules,omitempty"`
+	Info           map[string]string `json:"bucketInfo"`
+	LifecycleRules []LifecycleRule   `json:"lifecycleRules"`
+	CORSRules      []CORSRule        `json:"corsRules"`
 	IfRevisionIs   int               `json:"ifRevisionIs,omitempty"`
+
+	DefaultSSE       *ServerSideEncryption `json:"defaultServerSideEncryption,omitempty"`
+	DefaultRetention *DefaultRetention     `json:"defaultRetention,omitempty"`
+
+	Replication *ReplicationConfiguration `json:"replicationConfiguration,omitempty"`
 }
 
 type UpdateBucketResponse CreateBucketResponse
@@ -110,10 +200,13 @@ type DeleteFileVersionRequest struct {
 }
 
 type StartLargeFileRequest struct {
-	BucketID    string            `json:"bucketId"`
-	Name        string            `json:"fileName"`
-	ContentType string            `json:"contentType"`
-	Info        map[string]string `json:"fileInfo,omitempty"`
+	BucketID    string                `json:"bucketId"`
+	Name        string                `json:"fileName"`
+	ContentType string                `json:"contentType"`
+	Info        map[string]string     `json:"fileInfo,omitempty"`
+	SSE         *ServerSideEncryption `json:"serverSideEncryption,omitempty"`
+	Retention   *Retention            `json:"fileRetention,omitempty"`
+	LegalHold   string                `json:"legalHold,omitempty"`
 }
 
 type StartLargeFileResponse struct {
@@ -149,17 +242,19 @@ type getUploadPartURLResponse struct {
 	Token string `json:"authorizationToken"`
 }
 
+type UploadPartResponse struct {
+	ID         string `json:"fileId"`
+	PartNumber int    `json:"partNumber"`
+	Size       int64  `json:"contentLength"`
+	SHA1       string `json:"contentSha1"`
+}
+
 type FinishLargeFileRequest struct {
 	ID     string   `json:"fileId"`
 	Hashes []string `json:"partSha1Array"`
 }
 
-type FinishLargeFileResponse struct {
-	Name      string `json:"fileName"`
-	FileID    string `json:"fileId"`
-	Timestamp int64  `json:"uploadTimestamp"`
-	Action    string `json:"action"`
-}
+type FinishLargeFileResponse GetFileInfoResponse
 
 type ListFileNamesRequest struct {
 	BucketID     string `json:"bucketId"`
@@ -189,6 +284,36 @@ type ListFileVersionsResponse struct {
 	Files    []GetFileInfoResponse `json:"files"`
 }
 
+type Retention struct {
+	Mode        string `json:"mode,omitempty"`
+	RetainUntil int64  `json:"retainUntilTimestamp,omitempty"`
+}
+
+type CopyFileRequest struct {
+	SourceID    string                `json:"sourceFileId"`
+	DestBucket  string                `json:"destinationBucketId,omitempty"`
+	Name        string                `json:"fileName"`
+	Directive   string                `json:"metadataDirective,omitempty"`
+	ContentType string                `json:"contentType,omitempty"`
+	Info        map[string]string     `json:"fileInfo,omitempty"`
+	Retention   *Retention            `json:"fileRetention,omitempty"`
+	SourceSSE   *ServerSideEncryption `json:"sourceServerSideEncryption,omitempty"`
+	DestSSE     *ServerSideEncryption `json:"destinationServerSideEncryption,omitempty"`
+}
+
+type CopyFileResponse GetFileInfoResponse
+
+type CopyPartRequest struct {
+	SourceID    string                `json:"sourceFileId"`
+	LargeFileID string                `json:"largeFileId"`
+	PartNumber  int                   `json:"partNumber"`
+	Range       string                `json:"range,omitempty"`
+	SourceSSE   *ServerSideEncryption `json:"sourceServerSideEncryption,omitempty"`
+	DestSSE     *ServerSideEncryption `json:"destinationServerSideEncryption,omitempty"`
+}
+
+type CopyPartResponse UploadPartResponse
+
 type HideFileRequest struct {
 	BucketID string `json:"bucketId"`
 	File     string `json:"fileName"`
@@ -215,6 +340,88 @@ type GetFileInfoResponse struct {
 	Info        map[string]string `json:"fileInfo,omitempty"`
 	Action      string            `json:"action,omitempty"`
 	Timestamp   int64             `json:"uploadTimestamp,omitempty"`
+
+	SSE       *ServerSideEncryption `json:"serverSideEncryption,omitempty"`
+	Retention *FileRetention        `json:"fileRetention,omitempty"`
+	LegalHold *LegalHold            `json:"legalHold,omitempty"`
+}
+
+type FileRetention struct {
+	Authorized bool       `json:"isClientAuthorizedToRead"`
+	Value      *Retention `json:"value"`
+}
+
+type LegalHold struct {
+	Authorized bool   `json:"isClientAuthorizedToRead"`
+	Value      string `json:"value"`
+}
+
+// UpdateRetention has nil fields when the retention is being removed, which
+// B2 wants sent as nulls.
+type UpdateRetention struct {
+	Mode        *string `json:"mode"`
+	RetainUntil *int64  `json:"retainUntilTimestamp"`
+}
+
+type UpdateFileRetentionRequest struct {
+	Name             string          `json:"fileName"`
+	ID               string          `json:"fileId"`
+	Retention        UpdateRetention `json:"fileRetention"`
+	BypassGovernance bool            `json:"bypassGovernance,omitempty"`
+}
+
+type UpdateFileRetentionResponse struct {
+	ID        string    `json:"fileId"`
+	Name      string    `json:"fileName"`
+	Retention Retention `json:"fileRetention"`
+}
+
+type UpdateFileLegalHoldRequest struct {
+	Name      string `json:"fileName"`
+	ID        string `json:"fileId"`
+	LegalHold string `json:"legalHold"`
+}
+
+type UpdateFileLegalHoldResponse struct {
+	ID        string `json:"fileId"`
+	Name      string `json:"fileName"`
+	LegalHold string `json:"legalHold"`
+}
+
+type NotificationHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NotificationTarget struct {
+	TargetType    string               `json:"targetType"`
+	URL           string               `json:"url"`
+	SigningSecret string               `json:"hmacSha256SigningSecret,omitempty"`
+	CustomHeaders []NotificationHeader `json:"customHeaders,omitempty"`
+}
+
+type NotificationRule struct {
+	Name             string             `json:"name"`
+	EventTypes       []string           `json:"eventTypes"`
+	ObjectNamePrefix string             `json:"objectNamePrefix"`
+	IsEnabled        bool               `json:"isEnabled"`
+	Target           NotificationTarget `json:"targetConfiguration"`
+	IsSuspended      bool               `json:"isSuspended,omitempty"`
+	SuspensionReason string             `json:"suspensionReason,omitempty"`
+}
+
+type GetBucketNotificationRulesRequest struct {
+	BucketID string `json:"bucketId"`
+}
+
+type SetBucketNotificationRulesRequest struct {
+	BucketID string             `json:"bucketId"`
+	Rules    []NotificationRule `json:"eventNotificationRules"`
+}
+
+type BucketNotificationRulesResponse struct {
+	BucketID string             `json:"bucketId"`
+	Rules    []NotificationRule `json:"eventNotificationRules"`
 }
 
 type GetDownloadAuthorizationRequest struct {
@@ -19,6 +19,7 @@
 package blog
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
@@ -26,7 +27,28 @@ import (
 
 var level int32
 
-type Verbose bool
+// A Logger receives log messages.  V reports whether messages at the given
+// verbosity level should be logged.
+type Logger interface {
+	V(level int) bool
+	Info(args ...interface{})
+	Error(args ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) V(target int) bool      { return int32(target) <= level }
+func (stdLogger) Info(a ...interface{})  { log.Print(a...) }
+func (stdLogger) Error(a ...interface{}) { log.Print(a...) }
+
+// Std is the default Logger.  It writes to the standard log package, and its
+// verbosity is set by the B2_LOG_LEVEL environment variable.
+var Std Logger = stdLogger{}
+
+type Verbose struct {
+	l  Logger
+	on bool
+}
 
 func init() {
 	lvl := os.Getenv("B2_LOG_LEVEL")
@@ -37,18 +59,38 @@ func init() {
 	level = int32(i)
 }
 
+// Enabled reports whether messages at this verbosity will be logged.
+func (v Verbose) Enabled() bool {
+	return v.on
+}
+
 func (v Verbose) Info(a ...interface{}) {
-	if v {
-		log.Print(a...)
+	if v.on {
+		v.l.Info(a...)
 	}
 }
 
 func (v Verbose) Infof(format string, a ...interface{}) {
-	if v {
-		log.Printf(format, a...)
+	if v.on {
+		v.l.Info(fmt.Sprintf(format, a...))
+	}
+}
+
+func (v Verbose) Errorf(format string, a ...interface{}) {
+	if v.on {
+		v.l.Error(fmt.Sprintf(format, a...))
 	}
 }
 
+// V returns a Verbose that logs to Std.
 func V(target int32) Verbose {
-	return Verbose(target <= level)
+	return LV(nil, target)
+}
+
+// LV returns a Verbose that logs to l, or to Std if l is nil.
+func LV(l Logger, target int32) Verbose {
+	if l == nil {
+		l = Std
+	}
+	return Verbose{l: l, on: l.V(int(target))}
 }